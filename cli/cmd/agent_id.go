@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+	"github.com/drata/drata-agent-cli/internal/datastore"
+	"github.com/drata/drata-agent-cli/internal/osquery"
+)
+
+var agentIDCmd = &cobra.Command{
+	Use:   "agent-id",
+	Short: "Show the stable agent ID",
+	Long: `Show this device's agent ID, a stable identifier sent with every request
+so that Drata can correlate the device across registrations.
+
+The ID is derived from the most stable identifier the device has: the
+hardware serial, board serial, MAC address, or, when none is usable, the
+device UUID. It is an unsalted hash of that identifier, so it does not show
+the identifier, but anyone who can guess the identifier can confirm it.
+Once derived, it is stored and kept when other identifiers change, and
+derived again, at registration or a sync, only when the identifier it came
+from changes.
+
+Example:
+  drata-agent agent-id
+  drata-agent agent-id --json`,
+	Args: cobra.NoArgs,
+	RunE: runAgentID,
+}
+
+var agentIDJSON bool
+
+func init() {
+	rootCmd.AddCommand(agentIDCmd)
+	agentIDCmd.Flags().BoolVar(&agentIDJSON, "json", false, "Output as JSON")
+}
+
+func runAgentID(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Initialize data store
+	ds, err := openDataStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize data store: %w", err)
+	}
+
+	// Derive an ID only if no registration or sync has stored one yet
+	id, source := ds.GetAgentID()
+	if id == "" {
+		osq, err := newOsqueryClient(cfg, false)
+		if err != nil {
+			return fmt.Errorf("failed to initialize osquery: %w", err)
+		}
+		identifiers, err := osq.GetAgentDeviceIdentifiers()
+		if err != nil {
+			identifiers = nil
+		}
+		if id, source, err = ensureAgentID(ds, identifiers); err != nil {
+			return err
+		}
+	}
+	if id == "" {
+		return fmt.Errorf("no identifier is available to derive an agent ID from; register the agent to give it a device UUID")
+	}
+
+	if agentIDJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(map[string]string{"agentId": id, "source": source})
+	}
+	fmt.Printf("Agent ID: %s\n", id)
+	fmt.Printf("Derived from: %s\n", source)
+	return nil
+}
+
+// ensureAgentID returns the stored agent ID, deriving and storing one from
+// identifiers, which registration or collection already gathered, when
+// there is none. A stored ID is kept when identifiers other than the one it
+// was derived from change, or when that identifier is blank; it is
+// replaced when the identifier itself has changed, as after a hardware
+// replacement or when the data store was copied from another machine.
+func ensureAgentID(ds *datastore.DataStore, identifiers *osquery.AgentDeviceIdentifiers) (id, source string, err error) {
+	stored, storedSource := ds.GetAgentID()
+
+	id, source = osquery.DeriveAgentID(identifiers, ds.GetUUID())
+	if id == "" || (stored != "" && (source != storedSource || id == stored)) {
+		return stored, storedSource, nil
+	}
+
+	if stored != "" {
+		log.Printf("The %s this device's agent ID was derived from has changed; agent ID %s replaces %s", storedSource, id, stored)
+	}
+	if err := ds.SetAgentID(id, source); err != nil {
+		return "", "", fmt.Errorf("failed to store agent ID: %w", err)
+	}
+	return id, source, nil
+}
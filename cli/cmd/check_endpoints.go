@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/api"
+	"github.com/drata/drata-agent-cli/internal/config"
+)
+
+var checkEndpointsCmd = &cobra.Command{
+	Use:   "check-endpoints",
+	Short: "Check connectivity to the API endpoint of every region",
+	Long: `Connect to the production API endpoint of every region, and to the
+configured endpoint when it is none of them, and report whether each is
+reachable, with the time taken for DNS, the TLS handshake, and the first
+response. An unreachable endpoint shows the step that failed: dns,
+connect, tls, or http.
+
+This is a network diagnostic: it does not need the agent to be
+registered and sends no credentials. The client certificate and proxy
+settings used for syncs apply. The endpoints are checked at the same
+time, each for up to --timeout.
+
+Exits with an error when the endpoint the agent is configured to use is
+unreachable.
+
+Example:
+  drata-agent check-endpoints
+  drata-agent check-endpoints --json --timeout 5s`,
+	Args: cobra.NoArgs,
+	RunE: runCheckEndpoints,
+}
+
+var (
+	checkEndpointsJSON    bool
+	checkEndpointsTimeout time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(checkEndpointsCmd)
+	checkEndpointsCmd.Flags().BoolVar(&checkEndpointsJSON, "json", false, "Output as JSON")
+	checkEndpointsCmd.Flags().DurationVar(&checkEndpointsTimeout, "timeout", 10*time.Second, "How long to wait for each endpoint")
+}
+
+func runCheckEndpoints(cmd *cobra.Command, args []string) error {
+	if checkEndpointsTimeout <= 0 {
+		return fmt.Errorf("--timeout must be positive")
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	checks, err := api.CheckEndpoints(context.Background(), cfg, checkEndpointsTimeout)
+	if err != nil {
+		return err
+	}
+
+	if checkEndpointsJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(checks); err != nil {
+			return err
+		}
+	} else {
+		printEndpointChecks(checks)
+	}
+
+	for _, check := range checks {
+		if check.Current && !check.Reachable {
+			return fmt.Errorf("the configured endpoint %s is unreachable", check.URL)
+		}
+	}
+	return nil
+}
+
+// printEndpointChecks prints a table of the endpoint checks, marking the
+// endpoint the agent uses with an asterisk.
+func printEndpointChecks(checks []api.EndpointCheck) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REGION\tENDPOINT\tSTATUS\tLATENCY\tDETAIL")
+	for _, check := range checks {
+		name := check.Name
+		if check.Current {
+			name += " *"
+		}
+		status := fmt.Sprintf("%s reachable", markOK)
+		detail := fmt.Sprintf("HTTP %d", check.StatusCode)
+		if !check.Reachable {
+			status = fmt.Sprintf("%s unreachable", markFailed)
+			detail = fmt.Sprintf("%s failed: %s", check.FailedStep, check.Error)
+		}
+		latency := fmt.Sprintf("%d ms", check.LatencyMs)
+		if check.DNSMs != nil {
+			latency += fmt.Sprintf(" (DNS %d ms", *check.DNSMs)
+			if check.TLSMs != nil {
+				latency += fmt.Sprintf(", TLS %d ms", *check.TLSMs)
+			}
+			latency += ")"
+		} else if check.TLSMs != nil {
+			latency += fmt.Sprintf(" (TLS %d ms)", *check.TLSMs)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, check.URL, status, latency, detail)
+	}
+	w.Flush()
+	fmt.Println()
+	fmt.Println("* the endpoint this agent is configured to use")
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+	"github.com/drata/drata-agent-cli/internal/osquery"
+)
+
+// collectWorkerCmd is the child process that collects when collect_as_user
+// is set. It is started by collectSystemInfo, not run by hand.
+var collectWorkerCmd = &cobra.Command{
+	Use:    "collect-worker",
+	Short:  "Collect system information for a privilege-separated sync",
+	Hidden: true,
+	Long: `Read a collection request as JSON on stdin, collect system information,
+and write the collected payload as JSON to stdout.
+
+The sync and daemon commands start this command as collect_as_user when
+they run as root, so that osquery and every command the checks run do so
+without root. It has no access to the access token and sends nothing.
+With --verbose, its verbose output goes to stderr.`,
+	Args: cobra.NoArgs,
+	RunE: runCollectWorker,
+}
+
+var verboseCollectWorker bool
+
+// collectWorkerGrace is how long past the collection timeout a
+// collect-worker has to send what it collected before it is killed.
+const collectWorkerGrace = 30 * time.Second
+
+func init() {
+	collectWorkerCmd.Flags().BoolVarP(&verboseCollectWorker, "verbose", "v", false, "Show verbose output on stderr")
+	rootCmd.AddCommand(collectWorkerCmd)
+}
+
+// collectRequest is what the parent sends a collect-worker: the effective
+// configuration, as the child cannot read root's config file, the checks
+// to run, the MAC selection and the collection timeout, and the desktop
+// user the parent found, as the child cannot find their session without
+// root.
+type collectRequest struct {
+	Config             *config.Config       `json:"config"`
+	Filter             osquery.CheckFilter  `json:"filter"`
+	MacSelection       osquery.MacSelection `json:"macSelection"`
+	Timeout            time.Duration        `json:"timeout,omitempty"`
+	DesktopUser        string               `json:"desktopUser,omitempty"`
+	DesktopUnavailable string               `json:"desktopUnavailable,omitempty"`
+}
+
+// collectResponse is what a collect-worker sends back. EmptyChecks and
+// UnavailableChecks are not part of the payload's JSON, so they are sent
+// alongside.
+type collectResponse struct {
+	Result            *osquery.QueryResult `json:"result"`
+	EmptyChecks       []string             `json:"emptyChecks,omitempty"`
+	UnavailableChecks map[string]string    `json:"unavailableChecks,omitempty"`
+}
+
+func runCollectWorker(cmd *cobra.Command, args []string) error {
+	var request collectRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&request); err != nil || request.Config == nil {
+		return fmt.Errorf("invalid collection request: %v", err)
+	}
+	cfg := request.Config
+
+	// stdout carries the payload, so verbose output goes to stderr
+	payload := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = payload }()
+
+	osq, err := newOsqueryClient(cfg, verboseCollectWorker)
+	if err != nil {
+		return fmt.Errorf("failed to initialize osquery: %w", err)
+	}
+	osq.SetCheckFilter(request.Filter)
+	osq.SetMacSelection(request.MacSelection)
+	osq.SetCollectionTimeout(request.Timeout)
+	osq.SetDesktopSession(request.DesktopUser, request.DesktopUnavailable)
+
+	queryResult, err := osq.GetSystemInfo(cfg.Version)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(payload).Encode(collectResponse{
+		Result:            queryResult,
+		EmptyChecks:       queryResult.EmptyChecks,
+		UnavailableChecks: queryResult.UnavailableChecks,
+	})
+}
+
+// collectSystemInfo collects system information with osq. When
+// collect_as_user is set and the agent runs as root, it collects in a
+// collect-worker child process running as that user instead, with osq's
+// check filter, and reads the payload back over a pipe. The child stops
+// when osq's context is cancelled.
+func collectSystemInfo(cfg *config.Config, osq *osquery.Client) (*osquery.QueryResult, error) {
+	if cfg.CollectAsUser == "" || os.Geteuid() != 0 {
+		return osq.GetSystemInfo(cfg.Version)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the agent executable: %w", err)
+	}
+	desktopUser, desktopUnavailable := osq.DesktopSession()
+	request, err := json.Marshal(collectRequest{
+		Config:             collectorConfig(cfg),
+		Filter:             osq.CheckFilter(),
+		MacSelection:       osq.MacSelection(),
+		Timeout:            osq.CollectionTimeout(),
+		DesktopUser:        desktopUser,
+		DesktopUnavailable: desktopUnavailable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode collection request: %w", err)
+	}
+
+	args := []string{collectWorkerCmd.Name()}
+	if osq.IsVerbose() {
+		args = append(args, "--verbose")
+	}
+	// The child stops at the timeout itself and sends what it collected;
+	// it is killed only if it has not finished well after that
+	ctx := osq.Context()
+	if timeout := osq.CollectionTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout+collectWorkerGrace)
+		defer cancel()
+	}
+	child := exec.CommandContext(ctx, executable, args...)
+	if err := runAsUser(child, cfg.CollectAsUser); err != nil {
+		return nil, err
+	}
+	var stdout bytes.Buffer
+	child.Stdin = bytes.NewReader(request)
+	child.Stdout = &stdout
+	child.Stderr = os.Stderr
+	if err := child.Run(); err != nil {
+		return nil, fmt.Errorf("collection as %s failed: %w", cfg.CollectAsUser, err)
+	}
+
+	var response collectResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil || response.Result == nil {
+		return nil, fmt.Errorf("invalid payload from collection as %s: %v", cfg.CollectAsUser, err)
+	}
+	response.Result.EmptyChecks = response.EmptyChecks
+	response.Result.UnavailableChecks = response.UnavailableChecks
+	return response.Result, nil
+}
+
+// collectorConfig returns the configuration a collect-worker is sent: cfg
+// without the settings only sending needs, as the mirror's headers may hold
+// credentials that collect_as_user must not see.
+func collectorConfig(cfg *config.Config) *config.Config {
+	childCfg := *cfg
+	childCfg.MacIncludeInactiveInterfaces = cfg.MacIncludeInactiveInterfaces || includeInactiveInterfaces
+	childCfg.MirrorEndpoint = ""
+	childCfg.MirrorHeaders = nil
+	childCfg.ClientCertPath = ""
+	childCfg.ClientKeyPath = ""
+	childCfg.CloneTokenFile = ""
+	return &childCfg
+}
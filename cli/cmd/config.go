@@ -1,13 +1,17 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 
 	"github.com/drata/drata-agent-cli/internal/config"
+	"github.com/drata/drata-agent-cli/internal/osquery"
 )
 
 var configCmd = &cobra.Command{
@@ -17,24 +21,96 @@ var configCmd = &cobra.Command{
 
 Configuration file location: $HOME/.drata-agent/config.yaml
 
+Settings in the managed configuration file (/etc/drata-agent/managed.yaml on
+Linux, /Library/Preferences/drata-agent/managed.yaml on macOS,
+%ProgramData%\Drata Agent\managed.yaml on Windows) take precedence and
+cannot be changed locally.
+
 Available configuration options:
 - region: Drata region (NA, EU, APAC)
 - target_env: Target environment (LOCAL, DEV, QA, PROD)
+- api_base_url: API URL to use instead of the region and environment default (empty for default)
+- region_failover: Comma-separated regions whose hosts are tried in order when the region's host is unreachable
+- default_region: Region used when region is not set (empty for the built-in default)
+- client_cert_path: Absolute path to a PEM client certificate for mutual TLS (empty for none)
+- client_key_path: Absolute path to the PEM private key of client_cert_path
+- token_storage: Where the access and refresh tokens are kept at rest (file, keyring)
 - sync_interval_hours: Sync interval in hours
 - min_hours_since_last_sync: Minimum hours between syncs
 - min_minutes_between_syncs: Minimum minutes between sync attempts
+- sync_attempts: Times to attempt a sync before giving up
+- connection_failure_threshold: Consecutive sync attempts that fail to reach Drata before the sync state shows Error
+- sync_retry_wait_seconds: Seconds to wait before the first retry, doubling after each attempt
+- heartbeat_when_throttled: Send a minimal sync of the device identifiers when the daemon skips a sync because the last one was recent (true/false)
+- heartbeat_interval_minutes: Minutes between heartbeats the daemon sends between full syncs, up to 59 (0 to disable)
+- max_retries: Times to retry a sync or registration request after a server error or unreachable host, or a sync after a connection reset, within each sync attempt
+- skip_unchanged_syncs: Have the daemon skip uploading a payload unchanged since the last upload, uploading at least daily (true/false)
+- sync_on_start: Have the daemon sync shortly after it starts, not only on its schedule (true/false)
+- max_runtime: Duration after which the daemon exits for its supervisor to restart it, such as 24h (empty to disable)
+- initial_sync_delay_min_seconds: Shortest delay before the daemon's first sync
+- initial_sync_delay_max_seconds: Longest delay before the daemon's first sync, picked per device
+- boot_sync_delay_max_seconds: Longest delay before the first sync shortly after boot (0 to disable)
+- boot_uptime_threshold_minutes: Uptime below which the daemon treats the machine as just booted
+- sync_window: Daily ranges in which the daemon runs scheduled syncs, such as 19:00-07:00,12:00-13:00 (empty for any time)
+- sync_window_timezone: IANA time zone of sync_window, such as Europe/London (empty for local time)
 - osquery_path: Path to osquery binary (empty for auto-detect)
+- osquery_prefer: Which osquery to auto-detect first (vendored, system, path)
+- expected_osquery_version: osquery version to warn about differing from (empty for the bundled version, any to disable)
+- osquery_flags: Space-separated extra osquery flags, e.g. "--disable_events"
+- osquery_flagfile: Absolute path to an osquery flag file (empty for none)
+- collect_as_user: User to collect as in a child process when running as root (empty to collect in-process; not on Windows)
+- mac_interface_allowlist: Comma-separated interface name prefixes to take the MAC from, in priority order
+- mac_interface_denylist: Comma-separated interface name prefixes never used for the MAC
+- mac_include_inactive_interfaces: Also consider interfaces without an address (true/false)
+- mac_randomization: Handling of randomized MACs that change between networks (prefer_stable, exclude, allow)
+- require_identifiers: Refuse to register a device with no serial, device name, or asset tag (true/false)
+- device_name: Name for this device sent with registration and syncs, such as a CMDB name
+- asset_tag: Asset tag sent with registration and syncs
+- enabled_checks: Comma-separated checks to collect (empty for all default checks)
+- disabled_checks: Comma-separated checks never collected
+- critical_checks: Comma-separated checks that must produce data
+- required_apps: Comma-separated apps reported as installed or missing in appPolicy
+- prohibited_apps: Comma-separated apps reported as installed or absent in appPolicy
+- app_match: How app names are matched in appPolicy (substring, exact)
+- sanctioned_remote_access: Comma-separated remote-access tools reported as sanctioned in remoteAccess
+- prohibited_remote_access: Comma-separated remote-access tools reported as prohibited in remoteAccess
+- collect_processes: Report running executables in the opt-in processSnapshot check (true/false)
+- process_snapshot_max_rows: Most executables reported in processSnapshot
+- process_snapshot_hashes: Add each executable's SHA-256 to processSnapshot (true/false)
+- fail_on_missing_critical: Fail syncs when a critical check produces no data (true/false)
+- os_eol_online_lookup: Refresh OS end-of-life dates from endoflife.date (true/false)
+- collection_budget_seconds: Stop starting checks after this many seconds and sync what was collected (0 to disable)
+- max_field_bytes: Truncate collected values larger than this many bytes (0 to disable)
+- max_query_output_bytes: Fail osquery queries and commands whose output exceeds this many bytes (0 to disable)
+- wsl_behavior: Behavior under Windows Subsystem for Linux (mark, refuse, collect)
+- on_clone: Daemon behavior on a cloned image (reregister, warn, ignore)
+- clone_token_file: Absolute path to a fresh magic link token for on_clone reregister (empty for none)
+- virtual_not_applicable: Report disk encryption, firewall and screen lock as not applicable in a detected container or VM (true/false)
+- pre_sync_hook: Absolute path to an executable that must exit 0 for a sync to run
+- sign_payloads: Sign requests to Drata with a per-device key pinned at registration (true/false)
+- mirror_endpoint: URL that also receives every sync payload (empty to disable)
+- mirror_headers: "Name: value" header, or a JSON array of them, sent to the mirror
+- mirror_only: Send sync payloads to the mirror instead of Drata (true/false)
+- system_log: Sync audit events written to syslog or the Windows Event Log (off, outcomes, summary)
+- otel_endpoint: OTLP/HTTP traces URL to export sync traces to (empty to disable)
 
 Example:
   drata-agent config show
   drata-agent config set region EU
-  drata-agent config set sync_interval_hours 4`,
+  drata-agent config set sync_interval_hours 4
+  drata-agent config export --output fleet.yaml
+  drata-agent config import fleet.yaml`,
 }
 
 var configShowCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show current configuration",
-	RunE:  runConfigShow,
+	Long: `Show the effective configuration.
+
+Settings in the managed configuration file deployed by your organization
+override all others and cannot be changed locally. Use --show-source to see
+which settings are managed.`,
+	RunE: runConfigShow,
 }
 
 var configSetCmd = &cobra.Command{
@@ -52,16 +128,52 @@ var configPathCmd = &cobra.Command{
 
 var configInitCmd = &cobra.Command{
 	Use:   "init",
-	Short: "Initialize configuration file with defaults",
+	Short: "Create a configuration file in which every setting has its default",
 	RunE:  runConfigInit,
 }
 
+var configExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the effective configuration as portable YAML or JSON",
+	Long: `Write the current effective configuration to stdout or a file.
+
+The output contains only user-configurable settings and can be applied
+to other machines with 'drata-agent config import'. mirror_headers values
+are redacted; importing keeps the importing machine's values for them.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigExport,
+}
+
+var configImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Validate and apply a configuration file",
+	Long: `Replace the local configuration with the settings in a YAML or JSON file.
+
+Every setting is validated before anything is written. Settings missing
+from the file are reset to their defaults. A file with unknown keys or any
+invalid value is rejected and the local configuration is left unchanged.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runConfigImport,
+}
+
+var (
+	exportFormat string
+	exportOutput string
+	showSource   bool
+)
+
 func init() {
 	rootCmd.AddCommand(configCmd)
 	configCmd.AddCommand(configShowCmd)
 	configCmd.AddCommand(configSetCmd)
 	configCmd.AddCommand(configPathCmd)
 	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(configExportCmd)
+	configCmd.AddCommand(configImportCmd)
+
+	configShowCmd.Flags().BoolVar(&showSource, "show-source", false, "Show where each setting comes from (default, user, env, managed)")
+	configExportCmd.Flags().StringVar(&exportFormat, "format", "yaml", "Output format (yaml, json)")
+	configExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Write to this file instead of stdout")
 }
 
 func runConfigShow(cmd *cobra.Command, args []string) error {
@@ -72,15 +184,146 @@ func runConfigShow(cmd *cobra.Command, args []string) error {
 
 	fmt.Println("Current Configuration")
 	fmt.Println("=====================")
-	fmt.Printf("region: %s\n", cfg.Region)
-	fmt.Printf("target_env: %s\n", cfg.TargetEnv)
-	fmt.Printf("sync_interval_hours: %d\n", cfg.SyncIntervalHours)
-	fmt.Printf("min_hours_since_last_sync: %d\n", cfg.MinHoursSinceLastSync)
-	fmt.Printf("min_minutes_between_syncs: %d\n", cfg.MinMinutesBetweenSyncs)
+	show := func(key, value string) {
+		if showSource {
+			fmt.Printf("%s: %s [%s]\n", key, value, cfg.Source(key))
+			return
+		}
+		fmt.Printf("%s: %s\n", key, value)
+	}
+	show("region", string(cfg.Region))
+	show("target_env", string(cfg.TargetEnv))
+	if cfg.APIBaseURL != "" {
+		show("api_base_url", cfg.APIBaseURL)
+	} else {
+		show("api_base_url", "(region default)")
+	}
+	show("region_failover", strings.Join(cfg.RegionFailover, ","))
+	if cfg.DefaultRegion != "" {
+		show("default_region", string(cfg.DefaultRegion))
+	} else {
+		show("default_region", fmt.Sprintf("(built-in: %s)", config.DefaultConfig().Region))
+	}
+	if cfg.ClientCertPath != "" {
+		show("client_cert_path", cfg.ClientCertPath)
+	} else {
+		show("client_cert_path", "(none)")
+	}
+	if cfg.ClientKeyPath != "" {
+		show("client_key_path", cfg.ClientKeyPath)
+	} else {
+		show("client_key_path", "(none)")
+	}
+	show("token_storage", string(cfg.TokenStorage))
+	show("sync_interval_hours", fmt.Sprintf("%d", cfg.SyncIntervalHours))
+	show("min_hours_since_last_sync", fmt.Sprintf("%d", cfg.MinHoursSinceLastSync))
+	show("min_minutes_between_syncs", fmt.Sprintf("%d", cfg.MinMinutesBetweenSyncs))
+	show("sync_attempts", fmt.Sprintf("%d", cfg.SyncAttempts))
+	show("connection_failure_threshold", fmt.Sprintf("%d", cfg.ConnectionFailureThreshold))
+	show("sync_retry_wait_seconds", fmt.Sprintf("%d", cfg.SyncRetryWaitSeconds))
+	show("heartbeat_when_throttled", fmt.Sprintf("%t", cfg.HeartbeatWhenThrottled))
+	show("heartbeat_interval_minutes", fmt.Sprintf("%d", cfg.HeartbeatIntervalMinutes))
+	show("max_retries", fmt.Sprintf("%d", cfg.MaxRetries))
+	show("skip_unchanged_syncs", fmt.Sprintf("%t", cfg.SkipUnchangedSyncs))
+	show("sync_on_start", fmt.Sprintf("%t", cfg.SyncOnStart))
+	show("initial_sync_delay_min_seconds", fmt.Sprintf("%d", cfg.InitialSyncDelayMinSeconds))
+	show("initial_sync_delay_max_seconds", fmt.Sprintf("%d", cfg.InitialSyncDelayMaxSeconds))
+	show("boot_sync_delay_max_seconds", fmt.Sprintf("%d", cfg.BootSyncDelayMaxSeconds))
+	show("boot_uptime_threshold_minutes", fmt.Sprintf("%d", cfg.BootUptimeThresholdMinutes))
+	if cfg.MaxRuntime != "" {
+		show("max_runtime", cfg.MaxRuntime)
+	} else {
+		show("max_runtime", "(unlimited)")
+	}
+	if cfg.SyncWindow != "" {
+		show("sync_window", cfg.SyncWindow)
+	} else {
+		show("sync_window", "(any time)")
+	}
+	if cfg.SyncWindowTimezone != "" {
+		show("sync_window_timezone", cfg.SyncWindowTimezone)
+	} else {
+		show("sync_window_timezone", "(local)")
+	}
 	if cfg.OsqueryPath != "" {
-		fmt.Printf("osquery_path: %s\n", cfg.OsqueryPath)
+		show("osquery_path", cfg.OsqueryPath)
+	} else {
+		show("osquery_path", "(auto-detect)")
+	}
+	show("osquery_prefer", string(cfg.OsqueryPrefer))
+	if cfg.ExpectedOsqueryVersion != "" {
+		show("expected_osquery_version", cfg.ExpectedOsqueryVersion)
+	} else {
+		show("expected_osquery_version", fmt.Sprintf("(bundled: %s)", osquery.BundledVersion))
+	}
+	show("osquery_flags", strings.Join(cfg.OsqueryFlags, " "))
+	if cfg.OsqueryFlagfile != "" {
+		show("osquery_flagfile", cfg.OsqueryFlagfile)
+	} else {
+		show("osquery_flagfile", "(none)")
+	}
+	if cfg.CollectAsUser != "" {
+		show("collect_as_user", cfg.CollectAsUser)
+	} else {
+		show("collect_as_user", "(in-process)")
+	}
+	if len(cfg.MacInterfaceAllowlist) > 0 {
+		show("mac_interface_allowlist", strings.Join(cfg.MacInterfaceAllowlist, ","))
+	} else {
+		show("mac_interface_allowlist", "(platform default)")
+	}
+	show("mac_interface_denylist", strings.Join(cfg.MacInterfaceDenylist, ","))
+	show("mac_include_inactive_interfaces", fmt.Sprintf("%t", cfg.MacIncludeInactiveInterfaces))
+	show("mac_randomization", string(cfg.MacRandomization))
+	show("require_identifiers", fmt.Sprintf("%t", cfg.RequireIdentifiers))
+	show("device_name", cfg.DeviceName)
+	show("asset_tag", cfg.AssetTag)
+	if len(cfg.EnabledChecks) > 0 {
+		show("enabled_checks", strings.Join(cfg.EnabledChecks, ","))
+	} else {
+		show("enabled_checks", "(all)")
+	}
+	show("disabled_checks", strings.Join(cfg.DisabledChecks, ","))
+	show("critical_checks", strings.Join(cfg.CriticalChecks, ","))
+	show("required_apps", strings.Join(cfg.RequiredApps, ","))
+	show("prohibited_apps", strings.Join(cfg.ProhibitedApps, ","))
+	show("app_match", string(cfg.AppMatch))
+	show("sanctioned_remote_access", strings.Join(cfg.SanctionedRemoteAccess, ","))
+	show("prohibited_remote_access", strings.Join(cfg.ProhibitedRemoteAccess, ","))
+	show("collect_processes", fmt.Sprintf("%t", cfg.CollectProcesses))
+	show("process_snapshot_max_rows", fmt.Sprintf("%d", cfg.ProcessSnapshotMaxRows))
+	show("process_snapshot_hashes", fmt.Sprintf("%t", cfg.ProcessSnapshotHashes))
+	show("fail_on_missing_critical", fmt.Sprintf("%t", cfg.FailOnMissingCritical))
+	show("os_eol_online_lookup", fmt.Sprintf("%t", cfg.OSEOLOnlineLookup))
+	show("collection_budget_seconds", fmt.Sprintf("%d", cfg.CollectionBudgetSeconds))
+	show("max_field_bytes", fmt.Sprintf("%d", cfg.MaxFieldBytes))
+	show("max_query_output_bytes", fmt.Sprintf("%d", cfg.MaxQueryOutputBytes))
+	show("wsl_behavior", string(cfg.WSLBehavior))
+	show("on_clone", string(cfg.OnClone))
+	if cfg.CloneTokenFile != "" {
+		show("clone_token_file", cfg.CloneTokenFile)
+	} else {
+		show("clone_token_file", "(none)")
+	}
+	show("virtual_not_applicable", fmt.Sprintf("%t", cfg.VirtualNotApplicable))
+	if cfg.PreSyncHook != "" {
+		show("pre_sync_hook", cfg.PreSyncHook)
+	} else {
+		show("pre_sync_hook", "(none)")
+	}
+	show("sign_payloads", fmt.Sprintf("%t", cfg.SignPayloads))
+	if cfg.MirrorEndpoint != "" {
+		show("mirror_endpoint", cfg.MirrorEndpoint)
+	} else {
+		show("mirror_endpoint", "(disabled)")
+	}
+	show("mirror_headers", strings.Join(config.RedactHeaders(cfg.MirrorHeaders), "; "))
+	show("mirror_only", fmt.Sprintf("%t", cfg.MirrorOnly))
+	show("system_log", string(cfg.SystemLog))
+	if cfg.OtelEndpoint != "" {
+		show("otel_endpoint", cfg.OtelEndpoint)
 	} else {
-		fmt.Println("osquery_path: (auto-detect)")
+		show("otel_endpoint", "(disabled)")
 	}
 	fmt.Printf("version: %s\n", cfg.Version)
 
@@ -91,53 +334,27 @@ func runConfigSet(cmd *cobra.Command, args []string) error {
 	key := args[0]
 	value := args[1]
 
-	cfg, err := config.Load()
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
-	switch key {
-	case "region":
-		region, err := config.ParseRegion(value)
-		if err != nil {
+	if key == "enabled_checks" || key == "disabled_checks" || key == "critical_checks" {
+		if err := osquery.ValidateCheckNames(config.ParseList(value)); err != nil {
 			return err
 		}
-		cfg.Region = region
-	case "target_env":
-		env, err := config.ParseTargetEnv(value)
-		if err != nil {
+	}
+	if key == "osquery_flags" {
+		if err := osquery.ValidateFlags(strings.Fields(value)); err != nil {
 			return err
 		}
-		cfg.TargetEnv = env
-	case "sync_interval_hours":
-		var interval int
-		if _, err := fmt.Sscanf(value, "%d", &interval); err != nil || interval < 1 {
-			return fmt.Errorf("sync_interval_hours must be a positive integer")
-		}
-		cfg.SyncIntervalHours = interval
-	case "min_hours_since_last_sync":
-		var hours int
-		if _, err := fmt.Sscanf(value, "%d", &hours); err != nil || hours < 0 {
-			return fmt.Errorf("min_hours_since_last_sync must be a non-negative integer")
-		}
-		cfg.MinHoursSinceLastSync = hours
-	case "min_minutes_between_syncs":
-		var minutes int
-		if _, err := fmt.Sscanf(value, "%d", &minutes); err != nil || minutes < 0 {
-			return fmt.Errorf("min_minutes_between_syncs must be a non-negative integer")
+	}
+	if key == "osquery_flagfile" {
+		if err := osquery.ValidateFlagfile(value); err != nil {
+			return err
 		}
-		cfg.MinMinutesBetweenSyncs = minutes
-	case "osquery_path":
-		cfg.OsqueryPath = value
-	default:
-		return fmt.Errorf("unknown configuration key: %s", key)
 	}
 
-	if err := cfg.Save(); err != nil {
-		return fmt.Errorf("failed to save config: %w", err)
+	if err := config.UpdateSetting(key, value); err != nil {
+		return err
 	}
 
-	fmt.Printf("✓ Set %s = %s\n", key, value)
+	fmt.Printf("%s Set %s = %s\n", markOK, key, value)
 	return nil
 }
 
@@ -165,6 +382,67 @@ func runConfigInit(cmd *cobra.Command, args []string) error {
 	}
 
 	configPath := filepath.Join(homeDir, ".drata-agent", "config.yaml")
-	fmt.Printf("✓ Configuration initialized at: %s\n", configPath)
+	fmt.Printf("%s Configuration initialized at: %s\n", markOK, configPath)
+	return nil
+}
+
+func runConfigExport(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Header values usually carry credentials, so they are redacted;
+	// importing restores them from the importing device's configuration
+	settings := cfg.Settings()
+	settings["mirror_headers"] = config.RedactHeaders(cfg.MirrorHeaders)
+
+	var data []byte
+	switch strings.ToLower(exportFormat) {
+	case "yaml", "yml":
+		data, err = yaml.Marshal(settings)
+	case "json":
+		data, err = json.MarshalIndent(settings, "", "  ")
+		data = append(data, '\n')
+	default:
+		return fmt.Errorf("invalid format: %s (valid: yaml, json)", exportFormat)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	if exportOutput == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.WriteFile(exportOutput, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", exportOutput, err)
+	}
+	fmt.Printf("%s Configuration exported to: %s\n", markOK, exportOutput)
+	return nil
+}
+
+func runConfigImport(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("refusing to import %s: %w", args[0], err)
+	}
+	if err := osquery.ValidateFlags(cfg.OsqueryFlags); err != nil {
+		return fmt.Errorf("refusing to import %s: %w", args[0], err)
+	}
+	current, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if cfg.MirrorHeaders, err = config.RestoreRedactedHeaders(cfg.MirrorHeaders, current.MirrorHeaders); err != nil {
+		return fmt.Errorf("refusing to import %s: %w", args[0], err)
+	}
+
+	if err := cfg.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("%s Configuration imported from: %s\n", markOK, args[0])
 	return nil
 }
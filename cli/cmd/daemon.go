@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -13,8 +15,11 @@ import (
 	"github.com/drata/drata-agent-cli/internal/api"
 	"github.com/drata/drata-agent-cli/internal/config"
 	"github.com/drata/drata-agent-cli/internal/datastore"
+	"github.com/drata/drata-agent-cli/internal/hook"
+	"github.com/drata/drata-agent-cli/internal/logging"
 	"github.com/drata/drata-agent-cli/internal/osquery"
 	"github.com/drata/drata-agent-cli/internal/scheduler"
+	"github.com/drata/drata-agent-cli/internal/telemetry"
 )
 
 var daemonCmd = &cobra.Command{
@@ -30,17 +35,40 @@ Configuration can be set via:
 - Environment variables: DRATA_SYNC_INTERVAL_HOURS, etc.
 - Command line flags
 
+The first sync runs 10 to 60 seconds after the daemon starts, at a point
+picked per device (initial_sync_delay_min_seconds and
+initial_sync_delay_max_seconds); earlier versions always waited 10 seconds.
+
+Use --check-only to verify that the daemon would start (configuration valid,
+agent registered, osquery available, schedule computable) without running it.
+
+Use --max-runtime to have the daemon exit cleanly after running for the given
+duration, so a supervisor such as systemd restarts it with a fresh process.
+
+Set collect_as_user to collect in a child process without root while the
+daemon keeps the token and uploads; see 'drata-agent sync --help'.
+
 Example:
   drata-agent daemon
-  drata-agent daemon --interval 4`,
+  drata-agent daemon --interval 4
+  drata-agent daemon --check-only
+  drata-agent daemon --max-runtime 24h`,
 	RunE: runDaemon,
 }
 
+// errorLogWindow is how often a repeated sync error is summarized in the log.
+const errorLogWindow = 24 * time.Hour
+
 var syncInterval int
+var checkOnly bool
+var maxRuntimeFlag time.Duration
 
 func init() {
 	rootCmd.AddCommand(daemonCmd)
 	daemonCmd.Flags().IntVarP(&syncInterval, "interval", "i", 0, "Sync interval in hours (default: 2)")
+	daemonCmd.Flags().BoolVar(&checkOnly, "check-only", false, "Validate daemon startup and exit without running")
+	daemonCmd.Flags().DurationVar(&maxRuntimeFlag, "max-runtime", 0, "Exit after running this long so a supervisor restarts the daemon (default: max_runtime)")
+	daemonCmd.Flags().DurationVar(&collectTimeout, "collect-timeout", 0, collectTimeoutFlagUsage)
 }
 
 func runDaemon(cmd *cobra.Command, args []string) error {
@@ -52,11 +80,47 @@ func runDaemon(cmd *cobra.Command, args []string) error {
 
 	// Override sync interval if provided
 	if syncInterval > 0 {
+		if err := cfg.CheckNotManaged("sync_interval_hours"); err != nil {
+			return err
+		}
 		cfg.SyncIntervalHours = syncInterval
 	}
 
+	// Override max runtime if provided
+	if maxRuntimeFlag > 0 {
+		if err := cfg.CheckNotManaged("max_runtime"); err != nil {
+			return err
+		}
+		cfg.MaxRuntime = maxRuntimeFlag.String()
+	}
+	if err := applyCollectTimeout(cfg, collectTimeout); err != nil {
+		return err
+	}
+	maxRuntime, err := config.ParseMaxRuntime(cfg.MaxRuntime)
+	if err != nil {
+		return fmt.Errorf("--max-runtime: %w", err)
+	}
+	window, err := config.ParseSyncWindow(cfg.SyncWindow, cfg.SyncWindowTimezone)
+	if err != nil {
+		return err
+	}
+
+	if checkOnly {
+		return runDaemonCheck(cfg)
+	}
+
+	// Set up tracing, if configured
+	shutdownTracing, err := telemetry.Setup(cfg.OtelEndpoint, cfg.Version)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer shutdownTracing()
+
+	// Write sync audit events to the system log, if configured
+	defer openSystemLog(cfg)()
+
 	// Initialize data store
-	ds, err := datastore.New()
+	ds, err := openDataStore(cfg)
 	if err != nil {
 		return fmt.Errorf("failed to initialize data store: %w", err)
 	}
@@ -67,22 +131,59 @@ func runDaemon(cmd *cobra.Command, args []string) error {
 	}
 
 	// Initialize osquery client
-	osq, err := osquery.NewClient(cfg.OsqueryPath)
+	osq, err := newOsqueryClient(cfg, false)
 	if err != nil {
 		return fmt.Errorf("failed to initialize osquery: %w", err)
 	}
+	osq.SetCollectionTimeout(collectTimeout)
+	keepRegisteredMac(osq, ds)
 
 	// Initialize API client
-	apiClient := api.NewClient(cfg, ds)
+	apiClient, err := api.NewClient(cfg, ds)
+	if err != nil {
+		return fmt.Errorf("failed to initialize API client: %w", err)
+	}
+
+	// Handle a data store copied from another machine
+	if err := handleClonedImage(cfg, ds, osq); err != nil {
+		return err
+	}
+
+	// Record the start, so the next start can tell whether this run ended
+	// cleanly, and report how the previous run ended
+	if previous, err := ds.RecordDaemonStart(); err != nil {
+		log.Printf("Warning: failed to record daemon start: %v", err)
+	} else if previous != nil {
+		logPreviousShutdown(previous)
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if err := ds.RecordDaemonShutdown(datastore.ShutdownCrash, fmt.Sprintf("panic: %v", recovered)); err != nil {
+				log.Printf("Warning: failed to record daemon shutdown: %v", err)
+			}
+			panic(recovered)
+		}
+	}()
 
 	// Create scheduler
 	sched := scheduler.NewScheduler()
 
-	// Define sync action
-	syncAction := func() {
+	// Define sync action. Repeated identical errors, such as during a long
+	// network outage, are collapsed so they do not flood the logs.
+	// A panic in a collector fails only this sync, leaving the daemon
+	// running for the next scheduled attempt.
+	errorLog := logging.NewDeduplicator(errorLogWindow)
+	syncAction := scheduler.Recover("sync", func() {
 		if err := performSync(cfg, ds, osq, apiClient); err != nil {
-			log.Printf("Sync error: %v", err)
+			errorLog.Printf("Sync error: %v", err)
+			return
 		}
+		errorLog.Reset()
+	}, func(recovered interface{}) {
+		recordSyncError(cfg, ds, syncStepOther, fmt.Errorf("unexpected panic: %v", recovered))
+	})
+	if window != nil {
+		syncAction = deferOutsideWindow(window, ds, sched, syncAction)
 	}
 
 	// Schedule periodic sync
@@ -90,38 +191,182 @@ func runDaemon(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to schedule sync: %w", err)
 	}
 
+	// Schedule heartbeats between syncs. They run as the sync job so they
+	// never overlap a sync.
+	if cfg.HeartbeatIntervalMinutes > 0 {
+		heartbeatAction := func() {
+			sched.RunJobNow("sync", func() {
+				performHeartbeat(cfg, ds, osq, apiClient)
+			})
+		}
+		if err := sched.ScheduleJobWithMinutes("heartbeat", cfg.HeartbeatIntervalMinutes, heartbeatAction); err != nil {
+			return fmt.Errorf("failed to schedule heartbeat: %w", err)
+		}
+	}
+
 	// Start scheduler
 	sched.Start()
 
 	fmt.Printf("Drata Agent daemon started\n")
 	fmt.Printf("Version: %s\n", cfg.Version)
 	fmt.Printf("Sync interval: every %d hours\n", cfg.SyncIntervalHours)
+	if window != nil {
+		fmt.Printf("Sync window: %s\n", cfg.SyncWindow)
+	}
+	if cfg.HeartbeatIntervalMinutes > 0 {
+		fmt.Printf("Heartbeat interval: every %d minutes\n", cfg.HeartbeatIntervalMinutes)
+	}
 	fmt.Printf("Press Ctrl+C to stop\n")
 	fmt.Println()
 
-	// Run initial sync after a short delay
-	go func() {
-		time.Sleep(10 * time.Second)
-		log.Println("Running initial sync...")
-		syncAction()
-	}()
+	// Exit after max_runtime so a supervisor starts a fresh process, which
+	// bounds the effect of slow leaks in long-lived processes
+	var maxRuntimeReached <-chan time.Time
+	if maxRuntime > 0 {
+		exitAt := time.Now().Add(maxRuntime)
+		log.Printf("Daemon will exit after running for %s, at %s, so its supervisor restarts it", maxRuntime, exitAt.Format(time.RFC3339))
+		maxRuntimeReached = time.After(maxRuntime)
+	}
+
+	// Run initial sync after a delay spread across devices, unless the
+	// daemon is stopping or sync_on_start is off
+	stopping := make(chan struct{})
+	var initialSync sync.WaitGroup
+	if cfg.SyncOnStart {
+		initialDelay := initialSyncDelay(cfg, ds.GetUUID(), osq)
+		log.Printf("Initial sync in %s", initialDelay)
+		initialSync.Add(1)
+		go func() {
+			defer initialSync.Done()
+			select {
+			case <-time.After(initialDelay):
+			case <-stopping:
+				return
+			}
+			log.Println("Running initial sync...")
+			sched.RunJobNow("sync", syncAction)
+		}()
+	} else {
+		if next, err := scheduler.NextRunForInterval(cfg.SyncIntervalHours, time.Now()); err == nil {
+			log.Printf("sync_on_start is off; the first sync is the scheduled one at %s", next.Format(time.RFC3339))
+		} else {
+			log.Println("sync_on_start is off; the first sync is the scheduled one")
+		}
+	}
 
 	// Handle shutdown signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	<-sigChan
-	fmt.Println("\nShutting down...")
+	var reason, detail string
+	select {
+	case sig := <-sigChan:
+		fmt.Println("\nShutting down...")
+		reason, detail = datastore.ShutdownSignal, signalName(sig)
+	case <-maxRuntimeReached:
+		log.Printf("Maximum runtime of %s reached, shutting down...", maxRuntime)
+		reason, detail = datastore.ShutdownSelfExit, "max_runtime reached"
+	}
+	close(stopping)
 
-	// Stop scheduler
+	// Stop scheduler and wait for any in-flight sync to finish
 	ctx := sched.Stop()
 	<-ctx.Done()
+	initialSync.Wait()
+
+	if err := ds.RecordDaemonShutdown(reason, detail); err != nil {
+		log.Printf("Warning: failed to record daemon shutdown: %v", err)
+	}
 
 	fmt.Println("Daemon stopped")
 	return nil
 }
 
-func performSync(cfg *config.Config, ds *datastore.DataStore, osq *osquery.Client, apiClient *api.Client) error {
+// logPreviousShutdown logs how the daemon's previous run ended, so a daemon
+// that keeps crashing can be told apart from one that was stopped.
+func logPreviousShutdown(previous *datastore.ShutdownRecord) {
+	if previous.Reason == datastore.ShutdownCrash {
+		log.Printf("Warning: the previous daemon run, started at %s, did not shut down cleanly (%s)", previous.StartedAt, previous.Detail)
+		return
+	}
+	log.Printf("The previous daemon run shut down cleanly at %s (%s: %s)", previous.At, previous.Reason, previous.Detail)
+}
+
+// signalName returns the conventional name of a shutdown signal.
+func signalName(sig os.Signal) string {
+	switch sig {
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	default:
+		return sig.String()
+	}
+}
+
+// runDaemonCheck performs the daemon's startup validation, prints a
+// readiness summary, and returns an error if the daemon would not start.
+func runDaemonCheck(cfg *config.Config) error {
+	fmt.Println("Daemon Readiness Check")
+	fmt.Println("======================")
+
+	failures := 0
+	report := func(name string, err error, detail string) {
+		if err != nil {
+			failures++
+			fmt.Printf("%s %s: %v\n", markFailed, name, err)
+			return
+		}
+		fmt.Printf("%s %s: %s\n", markOK, name, detail)
+	}
+
+	report("Configuration", cfg.Validate(), "valid")
+
+	if cfg.ClientCertPath != "" {
+		_, err := api.LoadClientCertificate(cfg.ClientCertPath, cfg.ClientKeyPath)
+		report("Client certificate", err, "loaded")
+	}
+
+	ds, err := openDataStore(cfg)
+	report("Data store", err, "readable")
+
+	if ds != nil {
+		var regErr error
+		if !ds.IsRegistered() {
+			regErr = fmt.Errorf("agent is not registered. Use 'drata-agent register' first")
+		}
+		report("Registration", regErr, "registered")
+	}
+
+	osq, err := newOsqueryClient(cfg, false)
+	if err == nil {
+		if _, queryErr := osq.RunQuery("SELECT version FROM osquery_info"); queryErr != nil {
+			err = fmt.Errorf("osquery found but not working: %w", queryErr)
+		}
+	}
+	report("osquery", err, "available")
+
+	if osq != nil {
+		// Version drift is a warning, not a failure
+		if drift := osqueryVersionDrift(cfg, osq); drift != "" {
+			fmt.Printf("Warning: %s\n", drift)
+		}
+		report("Environment", checkWSL(cfg, osq), "supported")
+	}
+
+	nextRun, err := scheduler.NextRunForInterval(cfg.SyncIntervalHours, time.Now())
+	report("Schedule", err, fmt.Sprintf("every %d hours, next run at %s", cfg.SyncIntervalHours, nextRun.Format(time.RFC1123)))
+
+	fmt.Println()
+	if failures > 0 {
+		return fmt.Errorf("daemon is not ready: %d check(s) failed", failures)
+	}
+
+	fmt.Println("Daemon is ready to start.")
+	return nil
+}
+
+func performSync(cfg *config.Config, ds *datastore.DataStore, osq *osquery.Client, apiClient *api.Client) (err error) {
 	// Check sync throttling
 	if ds.GetSyncState() == datastore.SyncStateRunning {
 		log.Println("Sync already in progress, skipping")
@@ -131,15 +376,66 @@ func performSync(cfg *config.Config, ds *datastore.DataStore, osq *osquery.Clien
 	minutesSinceLastAttempt := ds.MinutesSinceLastAttempt()
 	if minutesSinceLastAttempt >= 0 && minutesSinceLastAttempt < cfg.MinMinutesBetweenSyncs {
 		log.Printf("Last sync attempt was %d minutes ago, skipping (min: %d)", minutesSinceLastAttempt, cfg.MinMinutesBetweenSyncs)
+		recordSkip(ds, fmt.Sprintf("last sync attempt was %d minutes ago", minutesSinceLastAttempt))
 		return nil
 	}
 
 	hoursSinceLastSuccess := ds.HoursSinceLastSuccess()
 	if hoursSinceLastSuccess >= 0 && hoursSinceLastSuccess < cfg.MinHoursSinceLastSync {
 		log.Printf("Last successful sync was %d hours ago, skipping (min: %d)", hoursSinceLastSuccess, cfg.MinHoursSinceLastSync)
+		recordSkip(ds, fmt.Sprintf("last successful sync was %d hours ago", hoursSinceLastSuccess))
+		if cfg.HeartbeatWhenThrottled {
+			sendHeartbeat(cfg, osq, apiClient)
+		}
+		return nil
+	}
+
+	// Refuse to sync under WSL, if configured
+	if err := checkWSL(cfg, osq); err != nil {
+		return err
+	}
+
+	// Let the pre-sync hook veto the sync
+	if reason := hook.RunPreSync(cfg.PreSyncHook, hook.PreSyncContext{
+		Platform:     string(osq.GetPlatform()),
+		AgentVersion: cfg.Version,
+	}); reason != "" {
+		log.Printf("Sync skipped: %s", reason)
+		recordSkip(ds, reason)
 		return nil
 	}
 
+	// Trace the sync
+	ctx, span := startSyncSpan(osq, false)
+	defer func() { endSyncSpan(span, err) }()
+	osq = osq.WithContext(ctx)
+	apiClient = apiClient.WithContext(ctx)
+
+	log.Println("Starting sync...")
+	log.Printf("Using osquery: %s", describeOsquery(osq))
+	if drift := osqueryVersionDrift(cfg, osq); drift != "" {
+		log.Printf("Warning: %s", drift)
+	}
+
+	report := func(attempt, attempts int, err error) {
+		if err != nil && attempts > 1 {
+			log.Printf("Sync attempt %d/%d failed: %v", attempt, attempts, err)
+		}
+	}
+	err = runWithRetries(configRetryPolicy(cfg), report, func() error {
+		return attemptDaemonSync(cfg, ds, osq, apiClient)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s Sync completed successfully", markOK.on(os.Stderr))
+	return nil
+}
+
+// attemptDaemonSync makes a single attempt to collect system information
+// and send it to Drata.
+func attemptDaemonSync(cfg *config.Config, ds *datastore.DataStore, osq *osquery.Client, apiClient *api.Client) error {
 	// Set sync state
 	if err := ds.SetSyncState(datastore.SyncStateRunning); err != nil {
 		return fmt.Errorf("failed to update sync state: %w", err)
@@ -148,38 +444,169 @@ func performSync(cfg *config.Config, ds *datastore.DataStore, osq *osquery.Clien
 		return fmt.Errorf("failed to update last sync attempted: %w", err)
 	}
 
-	log.Println("Starting sync...")
-
 	// Get initialization data if needed
 	if !ds.IsInitDataReady() {
 		log.Println("Fetching initialization data...")
 		if _, err := apiClient.GetInitData(); err != nil {
-			ds.SetSyncState(datastore.SyncStateError)
+			recordSyncError(cfg, ds, syncStepInit, err)
 			return fmt.Errorf("failed to get init data: %w", err)
 		}
 	}
 
 	// Collect system information
 	log.Println("Collecting system information...")
-	queryResult, err := osq.GetSystemInfo(cfg.Version)
+	queryResult, err := collectSystemInfo(cfg, osq)
 	if err != nil {
-		ds.SetSyncState(datastore.SyncStateError)
+		recordSyncError(cfg, ds, syncStepCollect, err)
 		return fmt.Errorf("failed to collect system info: %w", err)
 	}
+	// Scheduled syncs are not manual runs
+	queryResult.ManualRun = false
+	recordCollection(ds, queryResult)
+	if _, _, err := ensureAgentID(ds, queryResult.DeviceIdentifiers()); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if queryResult.Partial {
+		log.Printf("Warning: collection budget spent, skipped checks: %s", strings.Join(queryResult.SkippedChecks, ", "))
+	}
+	if summary := incompleteSummary(queryResult.IncompleteChecks(), false); summary != "" {
+		log.Printf("Warning: %s (see 'drata-agent status --verbose')", summary)
+	}
+	if clockUnsynchronized(queryResult) {
+		log.Println("Warning: the system clock is not synchronized with its time source, so sync scheduling and throttling may be off")
+	}
+	if err := checkCriticalChecks(cfg, osq, queryResult); err != nil {
+		recordSyncError(cfg, ds, syncStepCollect, err)
+		return err
+	}
 
-	// Send to Drata
-	log.Println("Sending data to Drata...")
-	_, err = apiClient.Sync(queryResult)
-	if err != nil {
-		ds.SetSyncState(datastore.SyncStateError)
-		return fmt.Errorf("failed to sync: %w", err)
+	// Skip uploading a payload Drata already has, if configured
+	if cfg.SkipUnchangedSyncs {
+		if hash, err := queryResult.ContentHash(); err != nil {
+			log.Printf("Warning: %v", err)
+		} else if payloadUnchanged(ds, hash) {
+			log.Println("No changes since the last upload, skipping upload")
+			recordSkip(ds, "no changes since the last upload")
+			if err := ds.SetSyncState(datastore.SyncStateSuccess); err != nil {
+				return fmt.Errorf("failed to update sync state: %w", err)
+			}
+			return nil
+		}
+	}
+
+	// Send to Drata and any mirror
+	if err := uploadPayload(cfg, apiClient, queryResult, log.Printf); err != nil {
+		recordSyncError(cfg, ds, syncStepUpload, err)
+		return err
 	}
+	recordPayload(ds, queryResult)
+	recordUpload(ds, queryResult)
+	recordSyncSuccess(queryResult)
 
 	// Update sync state
 	if err := ds.SetSyncState(datastore.SyncStateSuccess); err != nil {
 		return fmt.Errorf("failed to update sync state: %w", err)
 	}
 
-	log.Println("✓ Sync completed successfully")
 	return nil
 }
+
+// heartbeatChecks are the checks a heartbeat collects: the device's
+// identifiers, which are cheap to read.
+var heartbeatChecks = []string{"hwSerial", "systemInfo", "macAddress"}
+
+// sendHeartbeat sends a minimal sync of freshly collected device
+// identifiers, so that Drata's last checked time for the device advances
+// while full syncs are throttled. Only those sections are sent, so the
+// results of the last full sync stay in place in Drata. Failures are
+// logged but do not count as a failed sync.
+func sendHeartbeat(cfg *config.Config, osq *osquery.Client, apiClient *api.Client) {
+	if cfg.MirrorOnly {
+		return
+	}
+
+	log.Println("Sending heartbeat...")
+	heartbeat := osq.WithContext(osq.Context())
+	heartbeat.SetCheckFilter(osquery.CheckFilter{
+		Enabled:  heartbeatChecks,
+		Disabled: osq.CheckFilter().Disabled,
+	})
+	queryResult, err := collectSystemInfo(cfg, heartbeat)
+	if err != nil {
+		log.Printf("Warning: heartbeat failed: failed to collect device identifiers: %v", err)
+		return
+	}
+	queryResult.ManualRun = false
+	if err := apiClient.Heartbeat(queryResult); err != nil {
+		log.Printf("Warning: heartbeat failed: %v", err)
+		return
+	}
+	log.Println("Heartbeat sent")
+}
+
+// performHeartbeat sends a scheduled heartbeat unless a full sync already
+// reported the device within the heartbeat interval. Nothing is sent before
+// the first successful sync or while the last sync failed, so a device
+// whose collection is broken still goes stale in Drata.
+func performHeartbeat(cfg *config.Config, ds *datastore.DataStore, osq *osquery.Client, apiClient *api.Client) {
+	switch ds.GetSyncState() {
+	case datastore.SyncStateRunning:
+		log.Println("Sync in progress, skipping heartbeat")
+		return
+	case datastore.SyncStateError:
+		log.Println("Last sync failed, skipping heartbeat")
+		return
+	}
+
+	minutesSinceLastSuccess := ds.MinutesSinceLastSuccess()
+	if minutesSinceLastSuccess < 0 {
+		log.Println("No successful sync yet, skipping heartbeat")
+		return
+	}
+	if minutesSinceLastSuccess < cfg.HeartbeatIntervalMinutes {
+		return
+	}
+
+	sendHeartbeat(cfg, osq, apiClient)
+}
+
+// deferOutsideWindow wraps a scheduled sync so that, outside the sync
+// window, it is recorded as skipped and run once when the window next
+// opens instead. The deferred sync runs as sched's sync job, so it never
+// overlaps another sync and is dropped when the daemon stops. Repeated
+// runs outside the window share one deferred sync.
+func deferOutsideWindow(window *config.SyncWindow, ds *datastore.DataStore, sched *scheduler.Scheduler, action func()) func() {
+	return func() {
+		now := time.Now()
+		if window.Contains(now) {
+			action()
+			return
+		}
+
+		next := window.NextOpen(now)
+		log.Printf("Outside the sync window, deferring sync to %s", next.Format(time.RFC3339))
+		recordSkip(ds, fmt.Sprintf("outside sync window; deferred to %s", next.Format(time.RFC3339)))
+		sched.RunOnceAt("sync", next, action)
+	}
+}
+
+// initialSyncDelay returns how long the daemon waits before its first sync:
+// a point between initial_sync_delay_min_seconds and
+// initial_sync_delay_max_seconds, widened to boot_sync_delay_max_seconds
+// when the machine booted less than boot_uptime_threshold_minutes ago. The
+// point is picked from the device UUID, so devices started together, as
+// after a fleet-wide reboot, spread their first syncs across the range.
+func initialSyncDelay(cfg *config.Config, deviceID string, osq *osquery.Client) time.Duration {
+	minSeconds := cfg.InitialSyncDelayMinSeconds
+	maxSeconds := cfg.InitialSyncDelayMaxSeconds
+	if cfg.BootSyncDelayMaxSeconds > maxSeconds {
+		threshold := time.Duration(cfg.BootUptimeThresholdMinutes) * time.Minute
+		if uptime, err := osq.Uptime(); err != nil {
+			log.Printf("Warning: failed to read uptime, not applying boot_sync_delay_max_seconds: %v", err)
+		} else if uptime < threshold {
+			log.Printf("Machine booted %s ago, spreading the initial sync over up to %ds", uptime.Round(time.Second), cfg.BootSyncDelayMaxSeconds)
+			maxSeconds = cfg.BootSyncDelayMaxSeconds
+		}
+	}
+	return scheduler.SpreadDelay(deviceID, minSeconds, maxSeconds)
+}
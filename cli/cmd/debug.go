@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+	"github.com/drata/drata-agent-cli/internal/osquery"
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "Show environment facts for support",
+	Long: `Show the osquery version, OS information, and device identifiers
+without the rest of the status output, for support requests and bug
+reports.
+
+Nothing is redacted: the output includes hardware serials and the MAC
+address.
+
+Example:
+  drata-agent debug
+  drata-agent debug --json`,
+	Args: cobra.NoArgs,
+	RunE: runDebug,
+}
+
+var debugJSON bool
+
+func init() {
+	rootCmd.AddCommand(debugCmd)
+	debugCmd.Flags().BoolVar(&debugJSON, "json", false, "Output as JSON")
+}
+
+// debugOutput is the JSON form of the debug command's output. Facts that
+// could not be collected are null, so the set of keys is always the same.
+type debugOutput struct {
+	AgentVersion      string           `json:"agentVersion"`
+	Platform          osquery.Platform `json:"platform"`
+	OsqueryPath       string           `json:"osqueryPath"`
+	Osquery           interface{}      `json:"osquery"`
+	OS                interface{}      `json:"os"`
+	DeviceIdentifiers interface{}      `json:"deviceIdentifiers"`
+}
+
+func runDebug(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Initialize osquery client
+	osq, err := newOsqueryClient(cfg, false)
+	if err != nil {
+		return fmt.Errorf("failed to initialize osquery: %w", err)
+	}
+
+	debugInfo, err := osq.GetDebugInfo()
+	if err != nil {
+		return fmt.Errorf("failed to get debug info: %w", err)
+	}
+	output := debugOutput{
+		AgentVersion:      cfg.Version,
+		Platform:          osq.GetPlatform(),
+		OsqueryPath:       osq.BinaryPath(),
+		Osquery:           debugInfo["osquery"],
+		OS:                debugInfo["os"],
+		DeviceIdentifiers: debugInfo["system_info"],
+	}
+
+	if debugJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(output)
+	}
+
+	fmt.Printf("Agent Version: %s\n", output.AgentVersion)
+	fmt.Printf("Platform: %s\n", output.Platform)
+	fmt.Printf("osquery Path: %s\n", output.OsqueryPath)
+	fmt.Printf("osquery: %s\n", formatDiffValue(output.Osquery))
+	fmt.Printf("OS: %s\n", formatDiffValue(output.OS))
+	fmt.Printf("Device Identifiers: %s\n", formatDiffValue(output.DeviceIdentifiers))
+	return nil
+}
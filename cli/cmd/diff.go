@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/datastore"
+	"github.com/drata/drata-agent-cli/internal/diff"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what changed between the last two syncs",
+	Long: `Show a field-level diff between the data sent by the last two
+successful full syncs, for example a newly installed application or a
+changed screen lock delay.
+
+List entries are compared regardless of order and reported as added (+)
+or removed (-); other fields that changed are shown as old -> new (~).
+
+Example:
+  drata-agent diff
+  drata-agent diff --json`,
+	Args: cobra.NoArgs,
+	RunE: runDiff,
+}
+
+var diffJSON bool
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().BoolVar(&diffJSON, "json", false, "Output the diff as JSON")
+}
+
+// diffOutput is the JSON form of the diff command's output.
+type diffOutput struct {
+	From    string        `json:"from"`
+	To      string        `json:"to"`
+	Changes []diff.Change `json:"changes"`
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	// Initialize data store
+	ds, err := datastore.New()
+	if err != nil {
+		return fmt.Errorf("failed to initialize data store: %w", err)
+	}
+
+	history := ds.GetPayloadHistory()
+	if len(history) < 2 {
+		return fmt.Errorf("need two successful syncs to compare, found %d", len(history))
+	}
+	previous, latest := history[len(history)-2], history[len(history)-1]
+
+	changes := diff.Compare(previous.RawQueryResults, latest.RawQueryResults)
+	if changes == nil {
+		changes = []diff.Change{}
+	}
+
+	if diffJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diffOutput{From: previous.SyncedAt, To: latest.SyncedAt, Changes: changes})
+	}
+
+	fmt.Printf("Changes from sync at %s to sync at %s\n", previous.SyncedAt, latest.SyncedAt)
+	if len(changes) == 0 {
+		fmt.Println("No changes.")
+		return nil
+	}
+	for _, change := range changes {
+		switch change.Kind {
+		case diff.Added:
+			fmt.Printf("+ %s: %s\n", change.Path, formatDiffValue(change.New))
+		case diff.Removed:
+			fmt.Printf("- %s: %s\n", change.Path, formatDiffValue(change.Old))
+		default:
+			fmt.Printf("~ %s: %s -> %s\n", change.Path, formatDiffValue(change.Old), formatDiffValue(change.New))
+		}
+	}
+	return nil
+}
+
+// formatDiffValue renders a payload value compactly on one line.
+func formatDiffValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,497 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/drata/drata-agent-cli/internal/api"
+	"github.com/drata/drata-agent-cli/internal/auditlog"
+	"github.com/drata/drata-agent-cli/internal/config"
+	"github.com/drata/drata-agent-cli/internal/datastore"
+	"github.com/drata/drata-agent-cli/internal/osquery"
+	"github.com/drata/drata-agent-cli/internal/telemetry"
+)
+
+// newOsqueryClient creates an osquery client configured from cfg and the
+// global flags.
+func newOsqueryClient(cfg *config.Config, verbose bool) (*osquery.Client, error) {
+	if err := osquery.ValidateCheckNames(append(append(append([]string{}, cfg.EnabledChecks...), cfg.DisabledChecks...), cfg.CriticalChecks...)); err != nil {
+		return nil, err
+	}
+	if err := osquery.ValidateFlags(cfg.OsqueryFlags); err != nil {
+		return nil, err
+	}
+	if err := osquery.ValidateFlagfile(cfg.OsqueryFlagfile); err != nil {
+		return nil, err
+	}
+
+	osq, err := osquery.NewClientWithPreference(cfg.OsqueryPath, verbose, osquery.BinaryPreference(cfg.OsqueryPrefer))
+	if err != nil {
+		return nil, err
+	}
+
+	osq.SetMacSelection(osquery.MacSelection{
+		Allowlist:       cfg.MacInterfaceAllowlist,
+		Denylist:        cfg.MacInterfaceDenylist,
+		IncludeInactive: cfg.MacIncludeInactiveInterfaces || includeInactiveInterfaces,
+		Randomized:      osquery.RandomizedMacHandling(cfg.MacRandomization),
+	})
+	osq.SetDeviceLabels(osquery.DeviceLabels{
+		Name:     cfg.DeviceName,
+		AssetTag: cfg.AssetTag,
+	})
+	osq.SetAppPolicy(osquery.AppPolicy{
+		Required:   cfg.RequiredApps,
+		Prohibited: cfg.ProhibitedApps,
+		ExactMatch: cfg.AppMatch == config.AppMatchExact,
+	})
+	osq.SetRemoteAccessPolicy(osquery.RemoteAccessPolicy{
+		Sanctioned: cfg.SanctionedRemoteAccess,
+		Prohibited: cfg.ProhibitedRemoteAccess,
+	})
+	osq.SetProcessSnapshot(osquery.ProcessSnapshot{
+		MaxRows: cfg.ProcessSnapshotMaxRows,
+		Hashes:  cfg.ProcessSnapshotHashes,
+	})
+	var optIn []string
+	if cfg.CollectProcesses {
+		optIn = append(optIn, "processSnapshot")
+	}
+	osq.SetCheckFilter(osquery.CheckFilter{
+		Enabled:  cfg.EnabledChecks,
+		Disabled: cfg.DisabledChecks,
+		OptIn:    optIn,
+	})
+	osq.SetFlags(cfg.OsqueryFlags, cfg.OsqueryFlagfile)
+	osq.SetOnlineEOLLookup(cfg.OSEOLOnlineLookup)
+	osq.SetCollectionBudget(time.Duration(cfg.CollectionBudgetSeconds) * time.Second)
+	osq.SetMaxFieldBytes(cfg.MaxFieldBytes)
+	osq.SetMaxOutputBytes(cfg.MaxQueryOutputBytes)
+	osq.SetMarkWSLNotApplicable(cfg.WSLBehavior == config.WSLBehaviorMark)
+	osq.SetMarkVirtualNotApplicable(cfg.VirtualNotApplicable)
+
+	return osq, nil
+}
+
+// keepRegisteredMac has osq report the MAC address ds records the device
+// registered with while an interface still has it, so that a change in how
+// the MAC is selected does not change a registered device's identity;
+// refresh-identifiers registers a newly selected one. A device registered
+// before its MAC was recorded selects it as the agent did then.
+func keepRegisteredMac(osq *osquery.Client, ds *datastore.DataStore) {
+	if !ds.IsRegistered() {
+		return
+	}
+	selection := osq.MacSelection()
+	if identifiers := ds.GetRegisteredIdentifiers(); identifiers != nil {
+		selection.Registered = identifiers.MacAddress
+	} else {
+		selection.Legacy = true
+	}
+	osq.SetMacSelection(selection)
+}
+
+// checkCriticalChecks fails closed when fail_on_missing_critical is set and
+// a critical check contributed nothing to result, so an incomplete
+// compliance picture is never uploaded.
+func checkCriticalChecks(cfg *config.Config, osq *osquery.Client, result *osquery.QueryResult) error {
+	if !cfg.FailOnMissingCritical {
+		return nil
+	}
+	if missing := osq.MissingChecks(result, cfg.CriticalChecks); len(missing) > 0 {
+		return fmt.Errorf("critical checks produced no data: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// uploadPayload sends queryResult to Drata and, when mirror_endpoint is set,
+// to the mirror as well. A mirror failure is only logged, unless
+// mirror_only is set, in which case the payload goes to the mirror alone.
+func uploadPayload(cfg *config.Config, apiClient *api.Client, queryResult *osquery.QueryResult, logf func(format string, v ...interface{})) error {
+	if cfg.MirrorOnly {
+		logf("Sending data to %s...", cfg.MirrorEndpoint)
+		return apiClient.Mirror(queryResult)
+	}
+
+	logf("Sending data to Drata...")
+	if _, err := apiClient.Sync(queryResult); err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+	if cfg.MirrorEndpoint != "" {
+		if err := apiClient.Mirror(queryResult); err != nil {
+			logf("Warning: %v", err)
+		}
+	}
+	return nil
+}
+
+// applyEndpointOverride points cfg at endpoint for this invocation only,
+// announcing the override so it is not forgotten. An empty endpoint leaves
+// cfg unchanged.
+func applyEndpointOverride(cfg *config.Config, endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	if err := config.ValidateEndpointURL(endpoint); err != nil {
+		return fmt.Errorf("--endpoint %w", err)
+	}
+	if err := cfg.CheckNotManaged("api_base_url"); err != nil {
+		return err
+	}
+
+	cfg.APIBaseURL = endpoint
+	fmt.Fprintf(os.Stderr, "WARNING: using override API endpoint %s for this run only\n", cfg.APIHostURL())
+	return nil
+}
+
+// applyCollectTimeout sets the collection budget from --collect-timeout for
+// this invocation only, rounded up to whole seconds, so that no check
+// starts after it. The deadline that cancels a check in progress is set on
+// the osquery client with SetCollectionTimeout. A zero timeout leaves cfg
+// unchanged.
+func applyCollectTimeout(cfg *config.Config, timeout time.Duration) error {
+	if timeout == 0 {
+		return nil
+	}
+	if timeout < 0 {
+		return fmt.Errorf("--collect-timeout must not be negative")
+	}
+	if err := cfg.CheckNotManaged("collection_budget_seconds"); err != nil {
+		return err
+	}
+	cfg.CollectionBudgetSeconds = int((timeout + time.Second - 1) / time.Second)
+	return nil
+}
+
+// describeOsquery returns the osqueryi binary and version in use, for logs.
+func describeOsquery(osq *osquery.Client) string {
+	version := osq.BinaryVersion()
+	if version == "" {
+		version = "unknown version"
+	}
+	return fmt.Sprintf("%s (%s)", osq.BinaryPath(), version)
+}
+
+// openDataStore opens the data store, keeping the access and refresh tokens
+// in the OS keyring when token_storage is keyring. Where the keyring cannot
+// be used, as on headless Linux or for a daemon running as a service
+// account, it fails rather than run without the tokens it was told to keep
+// there.
+func openDataStore(cfg *config.Config) (*datastore.DataStore, error) {
+	ds, err := datastore.New()
+	if err != nil || cfg.TokenStorage != config.TokenStorageKeyring {
+		return ds, err
+	}
+
+	keyring, err := datastore.SystemKeyring()
+	if err == nil {
+		err = ds.UseKeyring(keyring)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token_storage is keyring, but %w. Run 'drata-agent config set token_storage file' to keep the tokens in app-data.json, then register again if they were already moved to the keyring", err)
+	}
+	return ds, nil
+}
+
+// osqueryVersionDrift returns a warning when the osquery in use is not the
+// expected version, by default the bundled one, since collectors may behave
+// differently on other versions. It returns an empty string when the
+// version matches or expected_osquery_version is any.
+func osqueryVersionDrift(cfg *config.Config, osq *osquery.Client) string {
+	expected := cfg.ExpectedOsqueryVersion
+	if expected == "" {
+		expected = osquery.BundledVersion
+	}
+	if expected == config.AnyOsqueryVersion {
+		return ""
+	}
+
+	version := osq.BinaryVersion()
+	if version == "" {
+		return fmt.Sprintf("the version of osquery at %s could not be determined; expected %s", osq.BinaryPath(), expected)
+	}
+	if version != expected {
+		return fmt.Sprintf("osquery %s at %s differs from the expected %s; some checks may be missing or behave differently", version, osq.BinaryPath(), expected)
+	}
+	return ""
+}
+
+// checkWSL returns an error if the agent is running under WSL and is
+// configured to refuse syncing there.
+func checkWSL(cfg *config.Config, osq *osquery.Client) error {
+	if cfg.WSLBehavior == config.WSLBehaviorRefuse && osq.IsWSL() {
+		return fmt.Errorf("running under Windows Subsystem for Linux, where most checks reflect the Linux VM rather than the Windows host. Install the Drata Agent on the Windows host instead")
+	}
+	return nil
+}
+
+// recordSkip persists the reason a sync was skipped so it shows in status.
+func recordSkip(ds *datastore.DataStore, reason string) {
+	if err := ds.SetLastSkip(reason); err != nil {
+		log.Printf("Warning: failed to record skip reason: %v", err)
+	}
+	if err := systemLog.SyncSkipped(reason); err != nil {
+		log.Printf("Warning: failed to write to the system log: %v", err)
+	}
+}
+
+// systemLog receives sync audit events when system_log is enabled. It is
+// nil, discarding them, otherwise.
+var systemLog *auditlog.Logger
+
+// openSystemLog opens systemLog as configured by system_log and returns a
+// function that closes it. A system log that cannot be opened is warned
+// about and left disabled, since audit events are not worth failing a sync.
+func openSystemLog(cfg *config.Config) func() {
+	if cfg.SystemLog == "" || cfg.SystemLog == config.SystemLogOff {
+		return func() {}
+	}
+	logger, err := auditlog.Open(cfg.Version, cfg.SystemLog == config.SystemLogSummary)
+	if err != nil {
+		log.Printf("Warning: failed to open the system log: %v", err)
+		return func() {}
+	}
+	systemLog = logger
+	return func() {
+		logger.Close()
+		systemLog = nil
+	}
+}
+
+// recordSyncSuccess writes a successful sync of queryResult to the system
+// log.
+func recordSyncSuccess(queryResult *osquery.QueryResult) {
+	if err := systemLog.SyncSucceeded(queryResult); err != nil {
+		log.Printf("Warning: failed to write to the system log: %v", err)
+	}
+}
+
+// Sync steps recorded with a failed sync.
+const (
+	syncStepInit    = "init"
+	syncStepCollect = "collect"
+	syncStepUpload  = "upload"
+	// syncStepOther is for failures not tied to one step, such as a panic.
+	syncStepOther = "sync"
+)
+
+// recordSyncError marks the sync as failed at step and persists err so
+// status can show why. API errors also record the HTTP status and code.
+// Failures to reach Drata at all only defer the sync until
+// connection_failure_threshold of them happen in a row.
+func recordSyncError(cfg *config.Config, ds *datastore.DataStore, step string, err error) {
+	syncErr := datastore.SyncError{Step: step, Message: err.Error()}
+	var apiErr *api.APIError
+	if errors.As(err, &apiErr) {
+		syncErr.StatusCode = apiErr.StatusCode
+		syncErr.Code = apiErr.Code
+	}
+	record := ds.SetSyncError
+	if api.IsConnectionError(err) {
+		record = func(syncErr datastore.SyncError) error {
+			return ds.SetSyncConnectionError(syncErr, cfg.ConnectionFailureThreshold)
+		}
+	}
+	if err := record(syncErr); err != nil {
+		log.Printf("Warning: failed to record sync error: %v", err)
+	}
+	if logErr := systemLog.SyncFailed(step, err); logErr != nil {
+		log.Printf("Warning: failed to write to the system log: %v", logErr)
+	}
+}
+
+// recordCollection keeps how long collection and each check took, and
+// which checks were incomplete, so 'status --verbose' can show the slowest
+// and incomplete checks.
+func recordCollection(ds *datastore.DataStore, queryResult *osquery.QueryResult) {
+	err := ds.SetLastCollection(datastore.CollectionTiming{
+		TotalMs:    queryResult.CollectionMs,
+		BudgetMs:   queryResult.CollectionBudgetMs,
+		CheckMs:    queryResult.CheckDurationsMs,
+		Incomplete: queryResult.IncompleteChecks(),
+	})
+	if err != nil {
+		log.Printf("Warning: failed to record collection timing: %v", err)
+	}
+}
+
+// incompleteSummary returns a line counting and naming the checks in
+// incomplete, such as "2 checks incomplete: diskEncryption, screenLock",
+// or "" if there are none. With details, each check's reason follows on
+// its own line.
+func incompleteSummary(incomplete map[string]string, details bool) string {
+	if len(incomplete) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(incomplete))
+	for name := range incomplete {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	noun := "checks"
+	if len(names) == 1 {
+		noun = "check"
+	}
+	summary := fmt.Sprintf("%d %s incomplete: %s", len(names), noun, strings.Join(names, ", "))
+	if details {
+		for _, name := range names {
+			summary += fmt.Sprintf("\n  %s: %s", name, incomplete[name])
+		}
+	}
+	return summary
+}
+
+// recordPayload keeps the uploaded payload so 'drata-agent diff' can
+// compare it with the previous one. A partial payload, cut short by the
+// collection budget, is not kept, as the checks it lacks would show as
+// removed.
+func recordPayload(ds *datastore.DataStore, queryResult *osquery.QueryResult) {
+	if queryResult.Partial {
+		return
+	}
+	if err := ds.RecordPayload(queryResult.SchemaVersion, queryResult.RawQueryResults); err != nil {
+		log.Printf("Warning: failed to record payload: %v", err)
+	}
+}
+
+// clockUnsynchronized reports whether the timeSyncStatus check found the
+// clock out of sync with its time source.
+func clockUnsynchronized(queryResult *osquery.QueryResult) bool {
+	status, ok := queryResult.RawQueryResults["timeSyncStatus"].(map[string]interface{})
+	return ok && status["synchronized"] == false
+}
+
+// unchangedUploadMaxAge is how long skip_unchanged_syncs skips uploading an
+// unchanged payload before uploading it anyway.
+const unchangedUploadMaxAge = 24 * time.Hour
+
+// payloadUnchanged reports whether hash is that of the last payload
+// uploaded in full, and that upload is less than unchangedUploadMaxAge old.
+func payloadUnchanged(ds *datastore.DataStore, hash string) bool {
+	upload := ds.GetLastUpload()
+	if upload == nil || upload.Hash != hash {
+		return false
+	}
+	uploadedAt, err := time.Parse(time.RFC3339, upload.UploadedAt)
+	return err == nil && time.Since(uploadedAt) < unchangedUploadMaxAge
+}
+
+// recordUpload keeps the hash of a payload uploaded in full, so
+// skip_unchanged_syncs can tell when the next one is unchanged.
+func recordUpload(ds *datastore.DataStore, queryResult *osquery.QueryResult) {
+	hash, err := queryResult.ContentHash()
+	if err == nil {
+		err = ds.SetLastUpload(hash)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to record upload: %v", err)
+	}
+}
+
+// startSyncSpan starts the root span for a sync.
+func startSyncSpan(osq *osquery.Client, forced bool) (context.Context, trace.Span) {
+	return telemetry.StartSpan(context.Background(), "sync",
+		attribute.String("platform", string(osq.GetPlatform())),
+		attribute.Bool("sync.forced", forced),
+	)
+}
+
+// endSyncSpan records the sync result on span and ends it.
+func endSyncSpan(span trace.Span, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	span.SetAttributes(attribute.String("sync.result", result))
+	telemetry.EndSpan(span, err)
+}
+
+// syncRetryPolicy controls how many times a sync is attempted and how long
+// to wait between attempts.
+type syncRetryPolicy struct {
+	Attempts int
+	// Wait is the delay before the second attempt; it doubles for each
+	// attempt after that.
+	Wait time.Duration
+}
+
+// configRetryPolicy returns the retry policy configured in cfg.
+func configRetryPolicy(cfg *config.Config) syncRetryPolicy {
+	return syncRetryPolicy{
+		Attempts: cfg.SyncAttempts,
+		Wait:     time.Duration(cfg.SyncRetryWaitSeconds) * time.Second,
+	}
+}
+
+// runWithRetries runs attempt until it succeeds or the policy's attempts are
+// used up, reporting each attempt's outcome. Authentication failures are
+// not retried.
+func runWithRetries(policy syncRetryPolicy, report func(attempt, attempts int, err error), attempt func() error) error {
+	attempts := policy.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	wait := policy.Wait
+	for i := 1; i <= attempts; i++ {
+		err = attempt()
+		report(i, attempts, err)
+		if err == nil || api.IsAuthError(err) {
+			return err
+		}
+
+		if i < attempts {
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+
+	if attempts > 1 {
+		return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+	}
+	return err
+}
+
+// mark is a status symbol printed before a result, with an ASCII fallback
+// for logs, CI output and consoles that cannot render Unicode.
+type mark struct {
+	unicode string
+	ascii   string
+}
+
+var (
+	markOK      = mark{"✓", "[OK]"}
+	markFailed  = mark{"✗", "[FAIL]"}
+	markPending = mark{"⋯", "..."}
+)
+
+// String returns the symbol for standard output.
+func (m mark) String() string {
+	return m.on(os.Stdout)
+}
+
+// on returns the symbol for output written to f: ASCII unless f is a
+// terminal and neither --no-color nor NO_COLOR is set.
+func (m mark) on(f *os.File) string {
+	if noColor || os.Getenv("NO_COLOR") != "" || !isTerminal(f) {
+		return m.ascii
+	}
+	return m.unicode
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+)
+
+var identifiersCmd = &cobra.Command{
+	Use:   "identifiers",
+	Short: "Show the device identifiers sent at registration",
+	Long: `Collect and print the device identifiers that registration sends to
+Drata: the hardware and board serials, the MAC address, and any configured
+device name and asset tag.
+
+Only the osquery queries registration depends on are run. Nothing is sent
+over the network and no agent state is changed, so this is safe to run when
+registration fails, for example to confirm that a VM reports a serial.
+
+Example:
+  drata-agent identifiers
+  drata-agent identifiers --json`,
+	Args: cobra.NoArgs,
+	RunE: runIdentifiers,
+}
+
+var identifiersJSON bool
+
+func init() {
+	rootCmd.AddCommand(identifiersCmd)
+	identifiersCmd.Flags().BoolVar(&identifiersJSON, "json", false, "Output as JSON, exactly as sent at registration")
+}
+
+func runIdentifiers(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Initialize osquery client
+	osq, err := newOsqueryClient(cfg, false)
+	if err != nil {
+		return fmt.Errorf("failed to initialize osquery: %w", err)
+	}
+
+	identifiers, err := osq.GetAgentDeviceIdentifiers()
+	if err != nil {
+		return fmt.Errorf("failed to get device identifiers: %w", err)
+	}
+
+	if identifiersJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(identifiers)
+	}
+
+	printIdentifier("Hardware Serial", identifiers.HWSerial.HardwareSerial)
+	printIdentifier("Board Serial", identifiers.HWSerial.BoardSerial)
+	printIdentifier("MAC Address", identifiers.MacAddress.Mac)
+	if identifiers.DeviceName != "" {
+		fmt.Printf("Device Name: %s\n", identifiers.DeviceName)
+	}
+	if identifiers.AssetTag != "" {
+		fmt.Printf("Asset Tag: %s\n", identifiers.AssetTag)
+	}
+	if deviceSerial(identifiers) == "" {
+		fmt.Println()
+		fmt.Println("No serial was found. This is common on VMs whose hypervisor does not")
+		fmt.Println("expose one; Drata may not be able to tell this device apart from others.")
+	}
+	return nil
+}
+
+// printIdentifier prints one identifier, marking it failed when empty.
+func printIdentifier(name, value string) {
+	if value == "" {
+		fmt.Printf("%s %s: (empty)\n", markFailed, name)
+		return
+	}
+	fmt.Printf("%s %s: %s\n", markOK, name, value)
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+	"github.com/drata/drata-agent-cli/internal/osquery"
+)
+
+var osqueryInfoCmd = &cobra.Command{
+	Use:   "osquery-info",
+	Short: "Show which osquery binary the agent uses and where it searched",
+	Long: `Print every location searched for osqueryi, in the order given by
+osquery_prefer, what was found at each, and the binary selected with its
+version and source: osquery_path (config), a copy shipped with the agent
+(vendored), a system-wide installation (system), or the PATH lookup (PATH).
+
+Each existing binary up to the selected one is run once, as the search
+does. When osquery_path is set, the search is skipped and only the
+configured binary is run.
+
+Exits with an error when no working osqueryi is found.
+
+Example:
+  drata-agent osquery-info
+  drata-agent osquery-info --json`,
+	Args: cobra.NoArgs,
+	RunE: runOsqueryInfo,
+}
+
+var osqueryInfoJSON bool
+
+func init() {
+	rootCmd.AddCommand(osqueryInfoCmd)
+	osqueryInfoCmd.Flags().BoolVar(&osqueryInfoJSON, "json", false, "Output as JSON")
+}
+
+func runOsqueryInfo(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	prefer := osquery.BinaryPreference(cfg.OsqueryPrefer)
+	if prefer == "" {
+		prefer = osquery.PreferVendored
+	}
+	discovery := osquery.DiscoverBinary(cfg.OsqueryPath, prefer)
+
+	if osqueryInfoJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(discovery); err != nil {
+			return err
+		}
+	} else {
+		printBinaryDiscovery(discovery)
+	}
+
+	switch {
+	case discovery.Selected == "":
+		return fmt.Errorf("no working %s found", discovery.BinaryName)
+	case discovery.SelectedError != "":
+		return fmt.Errorf("osquery_path %s cannot run a query: %s", discovery.Selected, discovery.SelectedError)
+	}
+	return nil
+}
+
+// printBinaryDiscovery prints the selected osqueryi followed by the
+// numbered search order.
+func printBinaryDiscovery(d osquery.BinaryDiscovery) {
+	if d.Selected == "" {
+		fmt.Println("osquery Binary: none found")
+	} else {
+		fmt.Printf("osquery Binary: %s\n", d.Selected)
+		if d.SelectedVersion != "" {
+			fmt.Printf("Version: %s\n", d.SelectedVersion)
+		}
+		fmt.Printf("Source: %s\n", d.SelectedSource)
+		if d.SelectedError != "" {
+			fmt.Printf("Error: %s\n", d.SelectedError)
+		}
+	}
+	fmt.Printf("Minimum Version: %s\n", d.MinVersion)
+
+	fmt.Println()
+	if d.ConfiguredPath != "" {
+		fmt.Println("Search Order (skipped, osquery_path is set):")
+	} else {
+		fmt.Printf("Search Order (osquery_prefer: %s):\n", d.Preference)
+	}
+	for i, c := range d.Candidates {
+		status := c.Status
+		switch {
+		case c.Version != "":
+			status += ", " + c.Version
+		case c.Error != "":
+			status += ": " + c.Error
+		}
+		fmt.Printf("  %d. %s [%s] %s\n", i+1, c.Path, c.Source, status)
+	}
+	if !d.PathLookupFound {
+		fmt.Printf("  PATH lookup for '%s': not found\n", d.BinaryName)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// runAsUser sets child to run as the named user, with that user's groups,
+// home directory, and a minimal environment, from the root directory.
+func runAsUser(child *exec.Cmd, name string) error {
+	account, err := user.Lookup(name)
+	if err != nil {
+		return fmt.Errorf("collect_as_user: %w", err)
+	}
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("collect_as_user: invalid uid %q for %s", account.Uid, name)
+	}
+	gid, err := strconv.ParseUint(account.Gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("collect_as_user: invalid gid %q for %s", account.Gid, name)
+	}
+	if uid == 0 {
+		return fmt.Errorf("collect_as_user: %s is root", name)
+	}
+	var groups []uint32
+	if ids, err := account.GroupIds(); err == nil {
+		for _, id := range ids {
+			if group, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(group))
+			}
+		}
+	}
+
+	child.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups},
+	}
+	child.Dir = "/"
+	child.Env = []string{
+		"HOME=" + account.HomeDir,
+		"USER=" + account.Username,
+		"LOGNAME=" + account.Username,
+		"PATH=" + os.Getenv("PATH"),
+	}
+	return nil
+}
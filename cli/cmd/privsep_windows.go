@@ -0,0 +1,13 @@
+//go:build windows
+
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// runAsUser fails: collecting as another user is not supported on Windows.
+func runAsUser(child *exec.Cmd, name string) error {
+	return fmt.Errorf("collect_as_user is not supported on Windows")
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/api"
+	"github.com/drata/drata-agent-cli/internal/config"
+	"github.com/drata/drata-agent-cli/internal/datastore"
+	"github.com/drata/drata-agent-cli/internal/osquery"
+)
+
+var refreshIdentifiersCmd = &cobra.Command{
+	Use:   "refresh-identifiers",
+	Short: "Update the device identifiers registered with Drata",
+	Long: `Collect the device identifiers again and send them to Drata with the
+current registration, so the device record follows hardware changes such
+as a new network card, a motherboard swap, or MAC randomization being
+turned on or off.
+
+The device keeps its UUID, access token, and sync history; nothing is
+unregistered. The identifiers that changed since the last registration
+are listed before they are sent.
+
+Example:
+  drata-agent refresh-identifiers`,
+	Args: cobra.NoArgs,
+	RunE: runRefreshIdentifiers,
+}
+
+func init() {
+	rootCmd.AddCommand(refreshIdentifiersCmd)
+}
+
+func runRefreshIdentifiers(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Initialize data store
+	ds, err := openDataStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize data store: %w", err)
+	}
+	if !ds.IsRegistered() {
+		return fmt.Errorf("agent is not registered. Run 'drata-agent register' first")
+	}
+
+	// Initialize osquery client
+	osq, err := newOsqueryClient(cfg, false)
+	if err != nil {
+		return fmt.Errorf("failed to initialize osquery: %w", err)
+	}
+	identifiers, err := osq.GetAgentDeviceIdentifiers()
+	if err != nil {
+		return fmt.Errorf("failed to get device identifiers: %w", err)
+	}
+	if err := checkIdentifiers(cfg, identifiers); err != nil {
+		return err
+	}
+
+	current := registeredIdentifiers(identifiers)
+	if previous := ds.GetRegisteredIdentifiers(); previous == nil {
+		fmt.Println("The identifiers registered before were not recorded; sending the current ones:")
+		printIdentifierChanges(datastore.DeviceIdentifiers{}, current, true)
+	} else if *previous == current {
+		fmt.Println("No identifiers changed since the last registration; sending them again.")
+	} else {
+		fmt.Println("Identifiers changed since the last registration:")
+		printIdentifierChanges(*previous, current, false)
+	}
+
+	// Initialize API client
+	apiClient, err := api.NewClient(cfg, ds)
+	if err != nil {
+		return fmt.Errorf("failed to initialize API client: %w", err)
+	}
+	if _, err := apiClient.Register(identifiers); err != nil {
+		return fmt.Errorf("failed to update device identifiers: %w", err)
+	}
+	if err := recordRegisteredIdentifiers(ds, identifiers); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Device identifiers updated in Drata.\n", markOK)
+	return nil
+}
+
+// registeredIdentifiers returns identifiers in the form recorded in the
+// data store.
+func registeredIdentifiers(identifiers *osquery.AgentDeviceIdentifiers) datastore.DeviceIdentifiers {
+	return datastore.DeviceIdentifiers{
+		HardwareSerial: identifiers.HWSerial.HardwareSerial,
+		BoardSerial:    identifiers.HWSerial.BoardSerial,
+		MacAddress:     identifiers.MacAddress.Mac,
+		DeviceName:     identifiers.DeviceName,
+		AssetTag:       identifiers.AssetTag,
+	}
+}
+
+// recordRegisteredIdentifiers records the identifiers the device was just
+// registered with, and its serial for recognizing a cloned image.
+func recordRegisteredIdentifiers(ds *datastore.DataStore, identifiers *osquery.AgentDeviceIdentifiers) error {
+	if err := ds.SetRegisteredSerial(deviceSerial(identifiers)); err != nil {
+		return fmt.Errorf("failed to record hardware serial: %w", err)
+	}
+	if err := ds.SetRegisteredIdentifiers(registeredIdentifiers(identifiers)); err != nil {
+		return fmt.Errorf("failed to record device identifiers: %w", err)
+	}
+	return nil
+}
+
+// printIdentifierChanges prints each identifier that differs between
+// previous and current as "old -> new", or every identifier when all is
+// set.
+func printIdentifierChanges(previous, current datastore.DeviceIdentifiers, all bool) {
+	fields := []struct {
+		name              string
+		previous, current string
+	}{
+		{"Hardware Serial", previous.HardwareSerial, current.HardwareSerial},
+		{"Board Serial", previous.BoardSerial, current.BoardSerial},
+		{"MAC Address", previous.MacAddress, current.MacAddress},
+		{"Device Name", previous.DeviceName, current.DeviceName},
+		{"Asset Tag", previous.AssetTag, current.AssetTag},
+	}
+	for _, field := range fields {
+		switch {
+		case all:
+			fmt.Printf("  %s: %s\n", field.name, identifierValue(field.current))
+		case field.previous != field.current:
+			fmt.Printf("  %s: %s -> %s\n", field.name, identifierValue(field.previous), identifierValue(field.current))
+		}
+	}
+}
+
+// identifierValue returns value, or "(none)" when it is empty.
+func identifierValue(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
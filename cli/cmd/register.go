@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"net/url"
+	"os"
 	"strings"
 
 	"github.com/google/uuid"
@@ -24,25 +26,41 @@ To get a registration token:
 3. Click "Register Drata Agent"
 4. Copy the token from the magic link URL
 
+The whole magic link (auth-drata-agent://...) is also accepted, in which case
+the region is taken from the link unless --region is given. Without either,
+the region setting is used, then default_region, then the region built
+into the agent, which is NA unless the build changed it.
+
+Use --device-name to give the device a name, such as its CMDB name, that is
+sent with registration and every sync. The name is saved as device_name.
+
+Use --validate to check, without using up the single-use token, that the
+token is well formed, that the region matches the magic link, that the API
+endpoint is reachable, and that this device can be registered.
+
 Example:
-  drata-agent register YOUR_TOKEN --region NA`,
+  drata-agent register YOUR_TOKEN --region NA
+  drata-agent register YOUR_TOKEN --device-name ops-laptop-42
+  drata-agent register YOUR_TOKEN --region EU --validate`,
 	Args: cobra.ExactArgs(1),
 	RunE: runRegister,
 }
 
 func init() {
 	rootCmd.AddCommand(registerCmd)
-	registerCmd.Flags().StringP("region", "r", "NA", "Drata region (NA, EU, APAC)")
+	registerCmd.Flags().StringP("region", "r", "", "Drata region (NA, EU, APAC) (default: region or default_region)")
+	registerCmd.Flags().StringVar(&endpointOverride, "endpoint", "", endpointFlagUsage)
+	registerCmd.Flags().StringVar(&registerDeviceName, "device-name", "", "Name for this device sent with registration and syncs (saved as device_name)")
+	registerCmd.Flags().BoolVar(&registerValidate, "validate", false, "Check that registration is likely to succeed without using the token")
 }
 
-func runRegister(cmd *cobra.Command, args []string) error {
-	token := args[0]
+var registerDeviceName string
+var registerValidate bool
 
-	// Get region from flag
-	regionStr, _ := cmd.Flags().GetString("region")
-	region, err := config.ParseRegion(regionStr)
-	if err != nil {
-		return err
+func runRegister(cmd *cobra.Command, args []string) error {
+	token, linkRegion, tokenErr := parseRegistrationToken(args[0])
+	if tokenErr != nil && !registerValidate {
+		return tokenErr
 	}
 
 	// Load configuration
@@ -50,6 +68,26 @@ func runRegister(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+
+	// Get region from flag, or from the magic link if the flag is not
+	// given, or else the configured or default region
+	region := cfg.Region
+	if cmd.Flags().Changed("region") {
+		regionStr, _ := cmd.Flags().GetString("region")
+		if region, err = config.ParseRegion(regionStr); err != nil {
+			return err
+		}
+	} else if linkRegion != "" {
+		region = linkRegion
+	}
+
+	// Managed settings win over flags; an explicit conflicting flag is an error
+	if cfg.IsManaged("region") {
+		if cmd.Flags().Changed("region") && region != cfg.Region {
+			return cfg.CheckNotManaged("region")
+		}
+		region = cfg.Region
+	}
 	cfg.Region = region
 
 	// If environment flag is set, use it
@@ -58,11 +96,38 @@ func runRegister(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
+		if env != cfg.TargetEnv {
+			if err := cfg.CheckNotManaged("target_env"); err != nil {
+				return err
+			}
+		}
 		cfg.TargetEnv = env
 	}
+	if err := applyEndpointOverride(cfg, endpointOverride); err != nil {
+		return err
+	}
+	if registerDeviceName != "" {
+		if err := cfg.CheckNotManaged("device_name"); err != nil {
+			return err
+		}
+		if err := config.ValidateDeviceLabel(registerDeviceName); err != nil {
+			return fmt.Errorf("--device-name %w", err)
+		}
+		cfg.DeviceName = registerDeviceName
+	}
+
+	// A token registered in another region than its link's is rejected,
+	// and cannot be used again
+	regionErr := checkLinkRegion(region, linkRegion)
+	if registerValidate {
+		return runRegisterValidate(cfg, tokenErr, regionErr, linkRegion)
+	}
+	if regionErr != nil {
+		return regionErr
+	}
 
 	// Initialize data store
-	ds, err := datastore.New()
+	ds, err := openDataStore(cfg)
 	if err != nil {
 		return fmt.Errorf("failed to initialize data store: %w", err)
 	}
@@ -82,16 +147,57 @@ func runRegister(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Save the device name first, so a device is never registered under a
+	// name that later syncs would not send. If registration then fails, the
+	// name stays saved for the next attempt.
+	if registerDeviceName != "" {
+		if err := config.UpdateSetting("device_name", registerDeviceName); err != nil {
+			return fmt.Errorf("failed to save device name: %w", err)
+		}
+	}
+	if err := registerDevice(cfg, ds, token); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Agent registered successfully!\n", markOK)
+	fmt.Println()
+	fmt.Println("You can now run 'drata-agent sync' to sync your system information.")
+	fmt.Println("To run periodic syncs, use 'drata-agent daemon'.")
+
+	return nil
+}
+
+// registerDevice authenticates with the magic link token and registers this
+// device under the UUID and region in ds, recording the identifiers it was
+// registered with so that hardware changes and cloned images can be
+// recognized later.
+func registerDevice(cfg *config.Config, ds *datastore.DataStore, token string) error {
 	// Initialize osquery client
-	osq, err := osquery.NewClient(cfg.OsqueryPath)
+	osq, err := newOsqueryClient(cfg, false)
 	if err != nil {
 		return fmt.Errorf("failed to initialize osquery: %w", err)
 	}
 
+	// Get device identifiers before authenticating, so a device that cannot
+	// be registered does not use up the token
+	identifiers, err := osq.GetAgentDeviceIdentifiers()
+	if err != nil {
+		return fmt.Errorf("failed to get device identifiers: %w", err)
+	}
+	if err := checkIdentifiers(cfg, identifiers); err != nil {
+		return err
+	}
+	if _, _, err := ensureAgentID(ds, identifiers); err != nil {
+		return err
+	}
+
 	// Initialize API client
-	apiClient := api.NewClient(cfg, ds)
+	apiClient, err := api.NewClient(cfg, ds)
+	if err != nil {
+		return fmt.Errorf("failed to initialize API client: %w", err)
+	}
 
-	fmt.Printf("Registering agent with Drata (%s region)...\n", region)
+	fmt.Printf("Registering agent with Drata (%s region)...\n", ds.GetRegion())
 
 	// Authenticate with magic link
 	user, err := apiClient.LoginWithMagicLink(token)
@@ -101,39 +207,140 @@ func runRegister(cmd *cobra.Command, args []string) error {
 
 	fmt.Printf("Authenticated as: %s %s (%s)\n", user.FirstName, user.LastName, user.Email)
 
-	// Get device identifiers
-	identifiers, err := osq.GetAgentDeviceIdentifiers()
-	if err != nil {
-		return fmt.Errorf("failed to get device identifiers: %w", err)
-	}
-
 	// Register device
 	_, err = apiClient.Register(identifiers)
 	if err != nil {
 		return fmt.Errorf("registration failed: %w", err)
 	}
 
-	// Set app version
+	// Set app version and the identifiers registered with
 	if err := ds.SetAppVersion(cfg.Version); err != nil {
 		return fmt.Errorf("failed to set app version: %w", err)
 	}
+	return recordRegisteredIdentifiers(ds, identifiers)
+}
+
+// magicLinkScheme is the scheme of the magic link the Drata web app opens
+// the desktop agent with; its query carries the token and region.
+const magicLinkScheme = "auth-drata-agent://"
+
+// parseRegistrationToken returns the token in arg, which may be the token
+// itself or the whole magic link, and the region the link names, if any. It
+// fails for a token with characters that cannot appear in one, as when a
+// web page URL is pasted instead.
+func parseRegistrationToken(arg string) (token string, linkRegion config.Region, err error) {
+	token = strings.TrimSpace(arg)
+	if rawArgs, ok := strings.CutPrefix(token, magicLinkScheme); ok {
+		// Some environments add a trailing slash to the link
+		values, err := url.ParseQuery(strings.TrimSuffix(rawArgs, "/"))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid magic link: %w", err)
+		}
+		token = values.Get("token")
+		if token == "" {
+			return "", "", fmt.Errorf("the magic link has no token")
+		}
+		if name := values.Get("region"); name != "" {
+			if linkRegion, err = config.ParseRegion(name); err != nil {
+				return "", "", fmt.Errorf("the magic link names an unknown region: %w", err)
+			}
+		}
+	}
+
+	if token == "" {
+		return "", "", fmt.Errorf("the registration token is empty")
+	}
+	if strings.ContainsAny(token, " \t\r\n/?#&:") {
+		return "", "", fmt.Errorf("the registration token contains characters that a token cannot; copy only the token, or the whole %s link", magicLinkScheme)
+	}
+	return token, linkRegion, nil
+}
+
+// checkLinkRegion fails when the magic link names a region other than the
+// one being registered in.
+func checkLinkRegion(region, linkRegion config.Region) error {
+	if linkRegion == "" || linkRegion == region {
+		return nil
+	}
+	return fmt.Errorf("the magic link is for the %s region, but registration would use %s; register with --region %s", linkRegion, region, linkRegion)
+}
+
+// runRegisterValidate reports whether registering with cfg is likely to
+// succeed, without sending the token: the magic link is single use, so
+// only the server's answer to a real registration can confirm the token.
+func runRegisterValidate(cfg *config.Config, tokenErr, regionErr error, linkRegion config.Region) error {
+	fmt.Println("Registration Check")
+	fmt.Println("==================")
+
+	failures := 0
+	report := func(name string, err error, detail string) {
+		if err != nil {
+			failures++
+			fmt.Printf("%s %s: %v\n", markFailed, name, err)
+			return
+		}
+		fmt.Printf("%s %s: %s\n", markOK, name, detail)
+	}
+
+	report("Token", tokenErr, "well formed")
+
+	regionDetail := fmt.Sprintf("%s (the token alone does not say which region it is for; paste the whole magic link to check)", cfg.Region)
+	if linkRegion != "" {
+		regionDetail = fmt.Sprintf("%s, matching the magic link", cfg.Region)
+	}
+	report("Region", regionErr, regionDetail)
+
+	report("Endpoint", nil, cfg.APIHostURL())
+
+	ds, err := openDataStore(cfg)
+	if err == nil && ds.IsRegistered() {
+		err = fmt.Errorf("agent is already registered. Use 'drata-agent unregister' first")
+	}
+	report("Registration", err, "not registered yet")
+
+	if ds != nil {
+		apiClient, err := api.NewClient(cfg, ds)
+		status := 0
+		if err == nil {
+			status, err = apiClient.Ping()
+		}
+		report("Connectivity", err, fmt.Sprintf("reached (HTTP %d)", status))
+	}
+
+	osq, err := newOsqueryClient(cfg, false)
+	var identifiers *osquery.AgentDeviceIdentifiers
+	if err == nil {
+		identifiers, err = osq.GetAgentDeviceIdentifiers()
+	}
+	if err == nil {
+		err = checkIdentifiers(cfg, identifiers)
+	}
+	report("Device identifiers", err, "available")
 
-	fmt.Println("✓ Agent registered successfully!")
 	fmt.Println()
-	fmt.Println("You can now run 'drata-agent sync' to sync your system information.")
-	fmt.Println("To run periodic syncs, use 'drata-agent daemon'.")
+	if failures > 0 {
+		return fmt.Errorf("registration would likely fail: %d check(s) failed", failures)
+	}
 
+	fmt.Println("Registration is likely to succeed. The token has not been used.")
 	return nil
 }
 
-func parseRegionFromToken(token string) config.Region {
-	// Token might contain region information
-	tokenLower := strings.ToLower(token)
-	if strings.Contains(tokenLower, "eu.") || strings.Contains(tokenLower, "/eu/") {
-		return config.RegionEU
+// checkIdentifiers handles a device that reports no hardware or board
+// serial, as many cloud VMs do, and has no device name or asset tag. Such
+// devices are told apart in Drata only by their MAC address, so
+// registration is refused under require_identifiers and warned about
+// otherwise.
+func checkIdentifiers(cfg *config.Config, identifiers *osquery.AgentDeviceIdentifiers) error {
+	if deviceSerial(identifiers) != "" || identifiers.DeviceName != "" || identifiers.AssetTag != "" {
+		return nil
+	}
+	if !cfg.RequireIdentifiers {
+		fmt.Fprintln(os.Stderr, "Warning: no hardware or board serial was found; this device will be identified by its MAC address only")
+		return nil
 	}
-	if strings.Contains(tokenLower, "apac.") || strings.Contains(tokenLower, "/apac/") {
-		return config.RegionAPAC
+	if identifiers.MacAddress.Mac == "" {
+		return fmt.Errorf("no hardware serial, board serial, or MAC address was found, and require_identifiers is set; give this device a name with --device-name or asset_tag, or run 'drata-agent identifiers' to see what this device reports")
 	}
-	return config.RegionNA
+	return fmt.Errorf("no hardware or board serial was found, and require_identifiers is set; give this device a name with --device-name or asset_tag, register it by its MAC address %s alone with 'drata-agent config set require_identifiers false', or configure the hypervisor to expose a serial. Run 'drata-agent identifiers' to see what this device reports", identifiers.MacAddress.Mac)
 }
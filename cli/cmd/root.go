@@ -4,6 +4,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -14,6 +15,17 @@ var (
 	region    string
 	targetEnv string
 
+	includeInactiveInterfaces bool
+
+	// endpointOverride is the --endpoint flag of commands that call the API
+	endpointOverride string
+
+	// collectTimeout is the --collect-timeout flag of commands that collect
+	collectTimeout time.Duration
+
+	// noColor forces plain ASCII status symbols
+	noColor bool
+
 	rootCmd = &cobra.Command{
 		Use:   "drata-agent",
 		Short: "Drata Agent CLI - Compliance monitoring agent",
@@ -29,6 +41,12 @@ For more information, visit https://help.drata.com/`,
 	}
 )
 
+// endpointFlagUsage describes the --endpoint flag.
+const endpointFlagUsage = "Use this API URL for this run only, overriding api_base_url, region, and environment"
+
+// collectTimeoutFlagUsage describes the --collect-timeout flag.
+const collectTimeoutFlagUsage = "Stop collecting after this long, such as 30s, cancelling the check in progress and syncing what was collected"
+
 // Execute runs the root command.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -42,4 +60,6 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.drata-agent/config.yaml)")
 	rootCmd.PersistentFlags().StringVar(&region, "region", "", "Drata region (NA, EU, APAC)")
 	rootCmd.PersistentFlags().StringVar(&targetEnv, "env", "", "Target environment (LOCAL, DEV, QA, PROD)")
+	rootCmd.PersistentFlags().BoolVar(&includeInactiveInterfaces, "include-inactive-interfaces", false, "Consider interfaces without an address when selecting the device MAC address")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Print ASCII status symbols instead of Unicode (also set by NO_COLOR)")
 }
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+	"github.com/drata/drata-agent-cli/internal/datastore"
+	"github.com/drata/drata-agent-cli/internal/osquery"
+)
+
+var rotateUUIDCmd = &cobra.Command{
+	Use:   "rotate-uuid",
+	Short: "Generate a new device UUID",
+	Long: `Replace this device's UUID with a freshly generated one.
+
+Use this when several machines share a UUID, for example after cloning a VM
+template on which the agent was already registered. The new identity may
+create a new device record in Drata.
+
+With --register, the device is registered again under the new UUID using a
+magic link token, so the server associates the new identity.
+
+Example:
+  drata-agent rotate-uuid
+  drata-agent rotate-uuid --register YOUR_TOKEN`,
+	RunE: runRotateUUID,
+}
+
+var confirmRotateUUID bool
+var rotateRegisterToken string
+
+func init() {
+	rootCmd.AddCommand(rotateUUIDCmd)
+	rotateUUIDCmd.Flags().BoolVarP(&confirmRotateUUID, "yes", "y", false, "Skip confirmation prompt")
+	rotateUUIDCmd.Flags().StringVar(&rotateRegisterToken, "register", "", "Re-register under the new UUID with this magic link token")
+}
+
+func runRotateUUID(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Initialize data store
+	ds, err := openDataStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize data store: %w", err)
+	}
+
+	fmt.Println("Warning: rotating the device UUID may create a new device record in Drata.")
+
+	// Confirm rotation
+	if !confirmRotateUUID {
+		fmt.Print("Are you sure you want to rotate the UUID? [y/N]: ")
+
+		var response string
+		if _, err := fmt.Scanln(&response); err != nil || (response != "y" && response != "Y" && response != "yes" && response != "Yes") {
+			fmt.Println("UUID rotation cancelled.")
+			return nil
+		}
+	}
+
+	oldUUID := ds.GetUUID()
+	newUUID := uuid.New().String()
+	if err := ds.SetUUID(newUUID); err != nil {
+		return fmt.Errorf("failed to set UUID: %w", err)
+	}
+	if oldUUID != "" {
+		fmt.Printf("%s Rotated device UUID: %s -> %s\n", markOK, oldUUID, newUUID)
+	} else {
+		fmt.Printf("%s Generated device UUID: %s\n", markOK, newUUID)
+	}
+
+	if rotateRegisterToken != "" {
+		if ds.GetRegion() == "" {
+			if err := ds.SetRegion(cfg.Region); err != nil {
+				return fmt.Errorf("failed to set region: %w", err)
+			}
+		}
+		if err := registerDevice(cfg, ds, rotateRegisterToken); err != nil {
+			return err
+		}
+		fmt.Printf("%s Agent re-registered under the new UUID.\n", markOK)
+		return nil
+	}
+
+	// This machine now has its own identity, so its serial is no longer a
+	// sign of a cloned image
+	if osq, err := newOsqueryClient(cfg, false); err == nil {
+		if identifiers, err := osq.GetAgentDeviceIdentifiers(); err == nil && deviceSerial(identifiers) != "" {
+			if err := ds.SetRegisteredSerial(deviceSerial(identifiers)); err != nil {
+				return fmt.Errorf("failed to record hardware serial: %w", err)
+			}
+		}
+	}
+
+	if ds.IsRegistered() {
+		fmt.Println()
+		fmt.Println("To have Drata associate the new UUID with this device, re-register:")
+		fmt.Println("  drata-agent rotate-uuid --register YOUR_TOKEN")
+	}
+	return nil
+}
+
+// deviceSerial returns the serial that identifies this machine's hardware,
+// preferring the hardware serial over the board serial.
+func deviceSerial(identifiers *osquery.AgentDeviceIdentifiers) string {
+	if identifiers.HWSerial.HardwareSerial != "" {
+		return identifiers.HWSerial.HardwareSerial
+	}
+	return identifiers.HWSerial.BoardSerial
+}
+
+// detectClonedImage reports whether this machine's serial differs from the
+// one recorded at registration, which suggests the agent's data was copied
+// from another machine, such as a VM template, and returns the current
+// serial. Devices registered before serials were recorded have the current
+// serial recorded instead.
+func detectClonedImage(ds *datastore.DataStore, osq *osquery.Client) (cloned bool, current string) {
+	identifiers, err := osq.GetAgentDeviceIdentifiers()
+	if err != nil {
+		return false, ""
+	}
+	current = deviceSerial(identifiers)
+	if current == "" {
+		return false, ""
+	}
+
+	registered := ds.GetRegisteredSerial()
+	if registered == "" {
+		_ = ds.SetRegisteredSerial(current)
+		return false, current
+	}
+	return registered != current, current
+}
+
+// handleClonedImage applies the on_clone behavior at daemon start. A serial
+// also changes when the motherboard is replaced, so the warning names both
+// causes. With reregister, the machine is registered under a new UUID with
+// the fresh magic link token in clone_token_file, never the inherited
+// credentials; without a token, or if registration fails, the inherited
+// UUID is restored and the daemon carries on with a warning.
+func handleClonedImage(cfg *config.Config, ds *datastore.DataStore, osq *osquery.Client) error {
+	if cfg.OnClone == config.CloneBehaviorIgnore {
+		return nil
+	}
+	cloned, current := detectClonedImage(ds, osq)
+	if !cloned {
+		return nil
+	}
+
+	log.Printf("Warning: hardware serial %s does not match %s recorded at registration; this looks like a cloned image sharing device UUID %s, or a machine whose motherboard was replaced", current, ds.GetRegisteredSerial(), ds.GetUUID())
+	if cfg.OnClone != config.CloneBehaviorReregister {
+		log.Println("Run 'drata-agent rotate-uuid --register YOUR_TOKEN' to give this machine its own identity")
+		return nil
+	}
+
+	token, err := readCloneToken(cfg)
+	if err != nil {
+		log.Printf("Warning: cannot re-register: %v; run 'drata-agent rotate-uuid --register YOUR_TOKEN' to give this machine its own identity", err)
+		return nil
+	}
+
+	oldUUID := ds.GetUUID()
+	newUUID := uuid.New().String()
+	log.Printf("Re-registering under new device UUID %s...", newUUID)
+	if err := ds.SetUUID(newUUID); err != nil {
+		return fmt.Errorf("failed to set UUID: %w", err)
+	}
+	if err := registerDevice(cfg, ds, token); err != nil {
+		if restoreErr := ds.SetUUID(oldUUID); restoreErr != nil {
+			return fmt.Errorf("re-registration failed: %v; failed to restore device UUID %s: %w", err, oldUUID, restoreErr)
+		}
+		log.Printf("Warning: re-registration failed: %v; keeping device UUID %s, run 'drata-agent rotate-uuid --register YOUR_TOKEN' to give this machine its own identity", err, oldUUID)
+		return nil
+	}
+	log.Printf("%s Re-registered cloned image under its own identity", markOK.on(os.Stderr))
+	return nil
+}
+
+// readCloneToken returns the magic link token in clone_token_file.
+func readCloneToken(cfg *config.Config) (string, error) {
+	if cfg.CloneTokenFile == "" {
+		return "", fmt.Errorf("clone_token_file is not set")
+	}
+	data, err := os.ReadFile(cfg.CloneTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read clone_token_file: %w", err)
+	}
+	token, _, err := parseRegistrationToken(string(data))
+	if err != nil {
+		return "", fmt.Errorf("invalid token in %s: %w", cfg.CloneTokenFile, err)
+	}
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", cfg.CloneTokenFile)
+	}
+	return token, nil
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+)
+
+var showComplianceCmd = &cobra.Command{
+	Use:   "show-compliance",
+	Short: "Show the compliance data Drata last returned",
+	Long: `Show the compliance data stored from Drata's last response, such as
+the match lists and verdicts, without syncing again. This helps when the
+dashboard and the device disagree.
+
+By default, the size and top-level fields are shown. Use --pretty to list
+every value by its path, or --json to print the data exactly as stored.
+The data is Drata's own response and is shown unredacted; it may be
+large.
+
+Example:
+  drata-agent show-compliance
+  drata-agent show-compliance --pretty
+  drata-agent show-compliance --json > compliance.json`,
+	Args: cobra.NoArgs,
+	RunE: runShowCompliance,
+}
+
+var showComplianceJSON bool
+var showCompliancePretty bool
+
+func init() {
+	rootCmd.AddCommand(showComplianceCmd)
+	showComplianceCmd.Flags().BoolVar(&showComplianceJSON, "json", false, "Print the stored compliance data as JSON, as stored")
+	showComplianceCmd.Flags().BoolVar(&showCompliancePretty, "pretty", false, "List every value of the compliance data by its path")
+}
+
+func runShowCompliance(cmd *cobra.Command, args []string) error {
+	if showComplianceJSON && showCompliancePretty {
+		return fmt.Errorf("--json and --pretty cannot be combined")
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Initialize data store
+	ds, err := openDataStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize data store: %w", err)
+	}
+
+	data := ds.GetComplianceData()
+	if showComplianceJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+	}
+	if data == nil {
+		fmt.Println("No compliance data is stored.")
+		return nil
+	}
+
+	if showCompliancePretty {
+		printComplianceValues("", data)
+		return nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode compliance data: %w", err)
+	}
+	fmt.Printf("Compliance data: %d bytes\n", len(encoded))
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		fmt.Printf("Value: %s\n", formatDiffValue(data))
+		return nil
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("Fields:")
+	for _, name := range names {
+		fmt.Printf("  %s: %s\n", name, describeComplianceValue(fields[name]))
+	}
+	fmt.Println("Use --pretty to list every value, or --json for the data as stored.")
+	return nil
+}
+
+// describeComplianceValue describes value briefly: the size of an object
+// or list, or a scalar as JSON.
+func describeComplianceValue(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return fmt.Sprintf("object with %d fields", len(v))
+	case []interface{}:
+		return fmt.Sprintf("list of %d", len(v))
+	default:
+		return formatDiffValue(v)
+	}
+}
+
+// printComplianceValues prints each scalar in value on its own line as
+// "path: value", with object fields in name order and list entries by
+// index, as in "matchList[0]". Empty objects and lists are printed as such.
+func printComplianceValues(path string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			fmt.Printf("%s: {}\n", path)
+			return
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := name
+			if path != "" {
+				child = path + "." + name
+			}
+			printComplianceValues(child, v[name])
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			fmt.Printf("%s: []\n", path)
+			return
+		}
+		for i, entry := range v {
+			printComplianceValues(path+"["+strconv.Itoa(i)+"]", entry)
+		}
+	default:
+		if path == "" {
+			path = "value"
+		}
+		fmt.Printf("%s: %s\n", path, formatDiffValue(v))
+	}
+}
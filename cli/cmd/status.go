@@ -1,7 +1,12 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -22,16 +27,76 @@ This shows:
 - Last sync time and status
 - System information
 
+With --json (or -o json), the registration, sync, and daemon state is
+printed as a JSON object for monitoring systems, with timestamps in
+RFC 3339; with --verbose, it includes the system information. --fields
+limits it to the named fields, and --raw prints the bare value of a single
+field: strings without quotes, null as an empty line, and anything else as
+JSON.
+
 Example:
-  drata-agent status`,
+  drata-agent status
+  drata-agent status -o json --verbose
+  drata-agent status --json --fields syncState,lastCheckedAt
+  drata-agent status --json --fields syncState --raw`,
 	RunE: runStatus,
 }
 
-var verboseStatus bool
+var (
+	verboseStatus bool
+	statusJSON    bool
+	statusFormat  string
+	statusFields  string
+	statusRaw     bool
+)
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
 	statusCmd.Flags().BoolVarP(&verboseStatus, "verbose", "v", false, "Show detailed system information")
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Output as JSON")
+	statusCmd.Flags().StringVarP(&statusFormat, "output", "o", "text", "Output format: text or json")
+	statusCmd.Flags().StringVar(&statusFields, "fields", "", "Comma-separated fields to include in --json output")
+	statusCmd.Flags().BoolVar(&statusRaw, "raw", false, "Print the bare value of the single --fields field")
+}
+
+// statusUser is the registered user in the status command's JSON output.
+type statusUser struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	JobTitle  string `json:"jobTitle,omitempty"`
+}
+
+// statusOutput is the JSON form of the status command's output. Values
+// that are not recorded are null, so the set of keys is always the same.
+type statusOutput struct {
+	Version             string                      `json:"version"`
+	Environment         config.TargetEnv            `json:"environment"`
+	Region              *string                     `json:"region"`
+	APIEndpoint         string                      `json:"apiEndpoint"`
+	Registered          bool                        `json:"registered"`
+	User                *statusUser                 `json:"user"`
+	SyncState           *string                     `json:"syncState"`
+	LastCheckedAt       *string                     `json:"lastCheckedAt"`
+	LastSyncAttemptedAt *string                     `json:"lastSyncAttemptedAt"`
+	LastError           *datastore.SyncError        `json:"lastError"`
+	ConnectionFailures  int                         `json:"connectionFailures"`
+	LastSkipReason      *string                     `json:"lastSkipReason"`
+	LastSkippedAt       *string                     `json:"lastSkippedAt"`
+	DaemonStartedAt     *string                     `json:"daemonStartedAt"`
+	LastShutdown        *datastore.ShutdownRecord   `json:"lastShutdown"`
+	LastCollection      *datastore.CollectionTiming `json:"lastCollection"`
+	SyncIntervalHours   int                         `json:"syncIntervalHours"`
+	System              *statusSystem               `json:"system"`
+}
+
+// statusSystem is the system information in the status command's JSON
+// output with --verbose. Warnings lists what could not be read.
+type statusSystem struct {
+	Platform    *string                         `json:"platform"`
+	DebugInfo   map[string]interface{}          `json:"debugInfo"`
+	Identifiers *osquery.AgentDeviceIdentifiers `json:"identifiers"`
+	Warnings    []string                        `json:"warnings"`
 }
 
 func runStatus(cmd *cobra.Command, args []string) error {
@@ -42,11 +107,29 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	}
 
 	// Initialize data store
-	ds, err := datastore.New()
+	ds, err := openDataStore(cfg)
 	if err != nil {
 		return fmt.Errorf("failed to initialize data store: %w", err)
 	}
 
+	switch statusFormat {
+	case "text":
+	case "json":
+		statusJSON = true
+	default:
+		return fmt.Errorf("unknown output format: %s (valid: text, json)", statusFormat)
+	}
+	if !statusJSON && (statusFields != "" || statusRaw) {
+		return fmt.Errorf("--fields and --raw require --json")
+	}
+	if statusJSON {
+		output := newStatusOutput(cfg, ds)
+		if verboseStatus {
+			output.System = newStatusSystem(cfg)
+		}
+		return printStatusJSON(output, config.ParseList(statusFields), statusRaw)
+	}
+
 	fmt.Println("Drata Agent CLI Status")
 	fmt.Println("======================")
 	fmt.Println()
@@ -62,7 +145,7 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	fmt.Println("Registration")
 	fmt.Println("------------")
 	if ds.IsRegistered() {
-		fmt.Println("Status: ✓ Registered")
+		fmt.Printf("Status: %s Registered\n", markOK)
 		user := ds.GetUser()
 		if user != nil {
 			fmt.Printf("User: %s %s\n", user.FirstName, user.LastName)
@@ -72,7 +155,7 @@ func runStatus(cmd *cobra.Command, args []string) error {
 			}
 		}
 	} else {
-		fmt.Println("Status: ✗ Not registered")
+		fmt.Printf("Status: %s Not registered\n", markFailed)
 		fmt.Println()
 		fmt.Println("To register, run:")
 		fmt.Printf("  drata-agent register YOUR_TOKEN --region %s\n", cfg.Region)
@@ -85,11 +168,13 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	syncState := ds.GetSyncState()
 	switch syncState {
 	case datastore.SyncStateSuccess:
-		fmt.Println("Last Sync: ✓ Success")
+		fmt.Printf("Last Sync: %s Success\n", markOK)
 	case datastore.SyncStateError:
-		fmt.Println("Last Sync: ✗ Error")
+		fmt.Printf("Last Sync: %s Error\n", markFailed)
+	case datastore.SyncStateDeferred:
+		fmt.Printf("Last Sync: %s Deferred (could not reach Drata; Error after %d failures in a row, %d so far)\n", markPending, cfg.ConnectionFailureThreshold, ds.GetConnectionFailures())
 	case datastore.SyncStateRunning:
-		fmt.Println("Last Sync: ⋯ In Progress")
+		fmt.Printf("Last Sync: %s In Progress\n", markPending)
 	case datastore.SyncStateUnknown:
 		fmt.Println("Last Sync: ? Unknown")
 	default:
@@ -113,14 +198,63 @@ func runStatus(cmd *cobra.Command, args []string) error {
 			fmt.Printf("Last Attempt: %s\n", lastAttempt)
 		}
 	}
+
+	if lastError := ds.GetLastError(); lastError != nil {
+		detail := lastError.Message
+		if lastError.Code != "" {
+			detail = fmt.Sprintf("%s (%s)", detail, lastError.Code)
+		}
+		if lastError.StatusCode != 0 {
+			detail = fmt.Sprintf("%s [HTTP %d]", detail, lastError.StatusCode)
+		}
+		if t, err := time.Parse(time.RFC3339, lastError.OccurredAt); err == nil {
+			fmt.Printf("Last Error: %s failed at %s (%s ago): %s\n", lastError.Step, t.Local().Format(time.RFC1123), formatDuration(time.Since(t)), detail)
+		} else {
+			fmt.Printf("Last Error: %s failed: %s\n", lastError.Step, detail)
+		}
+	}
+
+	if skipReason, skippedAt := ds.GetLastSkip(); skipReason != "" {
+		if t, err := time.Parse(time.RFC3339, skippedAt); err == nil {
+			fmt.Printf("Last Skipped: %s (%s ago): %s\n", t.Local().Format(time.RFC1123), formatDuration(time.Since(t)), skipReason)
+		} else {
+			fmt.Printf("Last Skipped: %s\n", skipReason)
+		}
+	}
 	fmt.Println()
 
+	// Daemon status
+	startedAt := ds.GetDaemonStartedAt()
+	lastShutdown := ds.GetLastShutdown()
+	if startedAt != "" || lastShutdown != nil {
+		fmt.Println("Daemon")
+		fmt.Println("------")
+		if startedAt != "" {
+			// The daemon may since have stopped without recording it, which
+			// its next start reports as a crash
+			fmt.Printf("Started: %s (running, or stopped without shutting down cleanly)\n", formatTimestamp(startedAt))
+		}
+		if lastShutdown != nil {
+			if lastShutdown.Reason == datastore.ShutdownCrash {
+				fmt.Printf("Last Shutdown: %s Crashed: run started %s %s\n", markFailed, formatTimestamp(lastShutdown.StartedAt), lastShutdown.Detail)
+			} else {
+				fmt.Printf("Last Shutdown: %s Clean (%s: %s) at %s\n", markOK, lastShutdown.Reason, lastShutdown.Detail, formatTimestamp(lastShutdown.At))
+			}
+		}
+		fmt.Println()
+	}
+
+	// Collection timing
+	if lastCollection := ds.GetLastCollection(); verboseStatus && lastCollection != nil {
+		printCollectionTiming(lastCollection)
+	}
+
 	// System information
 	if verboseStatus {
 		fmt.Println("System Information")
 		fmt.Println("------------------")
 
-		osq, err := osquery.NewClient(cfg.OsqueryPath)
+		osq, err := newOsqueryClient(cfg, false)
 		if err != nil {
 			fmt.Printf("Warning: Could not initialize osquery: %v\n", err)
 		} else {
@@ -135,6 +269,9 @@ func runStatus(cmd *cobra.Command, args []string) error {
 						fmt.Printf("osquery Version: %s\n", version)
 					}
 				}
+				if drift := osqueryVersionDrift(cfg, osq); drift != "" {
+					fmt.Printf("Warning: %s\n", drift)
+				}
 				if osInfo, ok := debugInfo["os"].(map[string]interface{}); ok {
 					if platform, ok := osInfo["platform"].(string); ok {
 						fmt.Printf("OS Platform: %s\n", platform)
@@ -171,10 +308,212 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	} else {
 		fmt.Println("osquery Path: (auto-detect)")
 	}
+	if cfg.PreSyncHook != "" {
+		fmt.Printf("Pre-Sync Hook: %s\n", cfg.PreSyncHook)
+	}
 
 	return nil
 }
 
+// newStatusOutput returns the status command's JSON output.
+func newStatusOutput(cfg *config.Config, ds *datastore.DataStore) statusOutput {
+	output := statusOutput{
+		Version:             cfg.Version,
+		Environment:         cfg.TargetEnv,
+		Region:              optionalString(string(ds.GetRegion())),
+		APIEndpoint:         cfg.APIHostURL(),
+		Registered:          ds.IsRegistered(),
+		SyncState:           optionalString(string(ds.GetSyncState())),
+		LastCheckedAt:       optionalTimestamp(ds.GetLastCheckedAt()),
+		LastSyncAttemptedAt: optionalTimestamp(ds.GetLastSyncAttemptedAt()),
+		LastError:           ds.GetLastError(),
+		ConnectionFailures:  ds.GetConnectionFailures(),
+		DaemonStartedAt:     optionalTimestamp(ds.GetDaemonStartedAt()),
+		LastShutdown:        ds.GetLastShutdown(),
+		LastCollection:      ds.GetLastCollection(),
+		SyncIntervalHours:   cfg.SyncIntervalHours,
+	}
+	if user := ds.GetUser(); output.Registered && user != nil {
+		output.User = &statusUser{
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Email:     user.Email,
+			JobTitle:  user.JobTitle,
+		}
+	}
+	reason, skippedAt := ds.GetLastSkip()
+	output.LastSkipReason = optionalString(reason)
+	output.LastSkippedAt = optionalTimestamp(skippedAt)
+	return output
+}
+
+// newStatusSystem returns the system information for the status command's
+// JSON output, as shown by --verbose.
+func newStatusSystem(cfg *config.Config) *statusSystem {
+	system := &statusSystem{}
+	osq, err := newOsqueryClient(cfg, false)
+	if err != nil {
+		system.Warnings = append(system.Warnings, fmt.Sprintf("could not initialize osquery: %v", err))
+		return system
+	}
+	system.Platform = optionalString(string(osq.GetPlatform()))
+
+	debugInfo, err := osq.GetDebugInfo()
+	if err != nil {
+		system.Warnings = append(system.Warnings, fmt.Sprintf("could not get debug info: %v", err))
+	} else {
+		system.DebugInfo = debugInfo
+	}
+	if drift := osqueryVersionDrift(cfg, osq); drift != "" {
+		system.Warnings = append(system.Warnings, drift)
+	}
+	identifiers, err := osq.GetAgentDeviceIdentifiers()
+	if err != nil {
+		system.Warnings = append(system.Warnings, fmt.Sprintf("could not get device identifiers: %v", err))
+	} else {
+		system.Identifiers = identifiers
+	}
+	return system
+}
+
+// optionalString returns nil for an empty string, which is encoded as null.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// optionalTimestamp returns a stored timestamp in RFC 3339 UTC, or nil when
+// it is empty. Timestamps written by other versions with fractional
+// seconds or an offset are normalized; one that cannot be parsed is
+// returned unchanged.
+func optionalTimestamp(timestamp string) *string {
+	if t, err := time.Parse(time.RFC3339, timestamp); err == nil {
+		timestamp = t.UTC().Format(time.RFC3339)
+	}
+	return optionalString(timestamp)
+}
+
+// printStatusJSON prints output as JSON: all of it, only the named fields,
+// or with raw the bare value of a single field.
+func printStatusJSON(output statusOutput, fields []string, raw bool) error {
+	if raw && len(fields) != 1 {
+		return fmt.Errorf("--raw needs exactly one field in --fields")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if len(fields) == 0 {
+		return encoder.Encode(output)
+	}
+
+	selected, err := selectStatusFields(output, fields)
+	if err != nil {
+		return err
+	}
+	if raw {
+		fmt.Println(rawJSONValue(selected[fields[0]]))
+		return nil
+	}
+	return encoder.Encode(selected)
+}
+
+// selectStatusFields returns the named fields of output, failing on a name
+// that is not a field.
+func selectStatusFields(output statusOutput, fields []string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		value, ok := all[field]
+		if !ok {
+			known := make([]string, 0, len(all))
+			for name := range all {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown status field: %s (valid: %s)", field, strings.Join(known, ", "))
+		}
+		selected[field] = value
+	}
+	return selected, nil
+}
+
+// rawJSONValue returns a JSON value for printing bare: a string without
+// quotes, null as an empty string, and anything else as compact JSON.
+func rawJSONValue(value json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	if string(value) == "null" {
+		return ""
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, value); err != nil {
+		return string(value)
+	}
+	return compact.String()
+}
+
+// slowestChecksShown is how many checks 'status --verbose' lists by duration.
+const slowestChecksShown = 5
+
+// printCollectionTiming prints how long the last collection took against
+// its budget, its slowest checks, and its incomplete checks with why.
+func printCollectionTiming(timing *datastore.CollectionTiming) {
+	fmt.Println("Last Collection")
+	fmt.Println("---------------")
+	fmt.Printf("Collected: %s\n", formatTimestamp(timing.CollectedAt))
+	if timing.BudgetMs > 0 {
+		fmt.Printf("Duration: %s of a %s budget\n", formatMillis(timing.TotalMs), formatMillis(timing.BudgetMs))
+	} else {
+		fmt.Printf("Duration: %s (no budget)\n", formatMillis(timing.TotalMs))
+	}
+
+	names := make([]string, 0, len(timing.CheckMs))
+	for name := range timing.CheckMs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if timing.CheckMs[names[i]] != timing.CheckMs[names[j]] {
+			return timing.CheckMs[names[i]] > timing.CheckMs[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > slowestChecksShown {
+		names = names[:slowestChecksShown]
+	}
+	if len(names) > 0 {
+		fmt.Println("Slowest Checks:")
+		for _, name := range names {
+			fmt.Printf("  %s: %s\n", name, formatMillis(timing.CheckMs[name]))
+		}
+	}
+	if summary := incompleteSummary(timing.Incomplete, true); summary != "" {
+		fmt.Println(summary)
+	}
+	fmt.Println()
+}
+
+// formatMillis formats a duration in milliseconds, to a tenth of a second
+// from one second up.
+func formatMillis(ms int64) string {
+	d := time.Duration(ms) * time.Millisecond
+	if d >= time.Second {
+		d = d.Round(100 * time.Millisecond)
+	}
+	return d.String()
+}
+
 func formatDuration(d time.Duration) string {
 	if d < time.Minute {
 		return "just now"
@@ -199,3 +538,13 @@ func formatDuration(d time.Duration) string {
 	}
 	return fmt.Sprintf("%d days", days)
 }
+
+// formatTimestamp formats an RFC 3339 timestamp in local time with how long
+// ago it was, or returns it unchanged if it cannot be parsed.
+func formatTimestamp(timestamp string) string {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.RFC1123), formatDuration(time.Since(t)))
+}
@@ -1,7 +1,13 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -9,7 +15,9 @@ import (
 	"github.com/drata/drata-agent-cli/internal/api"
 	"github.com/drata/drata-agent-cli/internal/config"
 	"github.com/drata/drata-agent-cli/internal/datastore"
+	"github.com/drata/drata-agent-cli/internal/hook"
 	"github.com/drata/drata-agent-cli/internal/osquery"
+	"github.com/drata/drata-agent-cli/internal/telemetry"
 )
 
 var syncCmd = &cobra.Command{
@@ -29,32 +37,148 @@ The following information is collected:
 - Browser extensions
 - Auto-update settings
 
+Use --only to collect and upload just the named checks, for example to
+iterate on a single failing control. Like --force, it bypasses throttling.
+Only the named sections are sent, so controls backed by other checks are
+not updated by the run.
+
+Use --retry-on-throttle to wait until throttling allows a sync instead of
+skipping it, for scripts that need the machine synced as soon as the
+configured limits permit. The wait is capped by --max-wait.
+
+Use --input to upload a payload collected earlier, for example on an
+air-gapped machine, instead of collecting on this one. The payload is
+uploaded under this host's registration, without throttling.
+
+Privilege separation: when collect_as_user is set and the agent runs as
+root, collection runs in a child process as that user. The parent keeps
+the access token and does the upload. The child gets the configuration
+on stdin, runs osquery and every command the checks use without root,
+and returns the payload on stdout, so a flaw in collection cannot reach
+the token or act as root. Checks that need root report what that user
+can see. Unix only.
+
+Use --summary to print a single line with the outcome instead of the
+usual progress output, for logs and frequent runs:
+  sync ok platform=LINUX duration=4.2s lastChecked=2024-05-01T12:00:00Z
+  sync skipped reason="last successful sync was 2 hours ago"
+  sync error step=upload code=TOKEN_EXPIRED message="..."
+Errors are still written to stderr and the exit code is unchanged.
+
+Failed syncs are retried according to sync_attempts and
+sync_retry_wait_seconds. Use --attempts and --retry-wait to override them
+for a single run, for example to push through a flaky connection. Each
+attempt also retries its request up to max_retries times after a server
+error or an unreachable host; --verbose logs those retries.
+
 Example:
-  drata-agent sync`,
+  drata-agent sync
+  drata-agent sync --only firewall,screenLock
+  drata-agent sync --retry-on-throttle --max-wait 30m
+  drata-agent sync --force --attempts 5 --retry-wait 10s
+  drata-agent sync --force --endpoint https://agent.example.com
+  drata-agent sync --input payload.json
+  drata-agent sync --summary`,
 	RunE: runSync,
 }
 
 var forceSync bool
 var verboseSync bool
+var syncAttempts int
+var syncRetryWait time.Duration
+var onlyChecks string
+var retryOnThrottle bool
+var maxThrottleWait time.Duration
+var syncInput string
+var syncSummary bool
 
 func init() {
 	rootCmd.AddCommand(syncCmd)
 	syncCmd.Flags().BoolVarP(&forceSync, "force", "f", false, "Force sync even if recently synced")
 	syncCmd.Flags().BoolVarP(&verboseSync, "verbose", "v", false, "Show verbose output including queries being executed")
+	syncCmd.Flags().IntVar(&syncAttempts, "attempts", 0, "Attempt the sync up to this many times (default: sync_attempts)")
+	syncCmd.Flags().DurationVar(&syncRetryWait, "retry-wait", 0, "Wait before the first retry, doubling after each attempt (default: sync_retry_wait_seconds)")
+	syncCmd.Flags().StringVar(&endpointOverride, "endpoint", "", endpointFlagUsage)
+	syncCmd.Flags().DurationVar(&collectTimeout, "collect-timeout", 0, collectTimeoutFlagUsage)
+	syncCmd.Flags().StringVar(&onlyChecks, "only", "", "Comma-separated checks to collect and upload, bypassing throttling")
+	syncCmd.Flags().BoolVar(&retryOnThrottle, "retry-on-throttle", false, "Wait until throttling allows a sync instead of skipping it")
+	syncCmd.Flags().DurationVar(&maxThrottleWait, "max-wait", 24*time.Hour, "Longest --retry-on-throttle waits before giving up")
+	syncCmd.Flags().StringVar(&syncInput, "input", "", "Upload this previously collected payload instead of collecting")
+	syncCmd.Flags().BoolVar(&syncSummary, "summary", false, "Print a single line with the outcome instead of the progress output")
+}
+
+// readSyncInput reads and validates the payload given with --input.
+func readSyncInput(path string) (*osquery.QueryResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --input: %w", err)
+	}
+	queryResult, err := osquery.ParseQueryResult(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --input %s: %w", path, err)
+	}
+	return queryResult, nil
 }
 
-func runSync(cmd *cobra.Command, args []string) error {
+func runSync(cmd *cobra.Command, args []string) (err error) {
+	// Replace the progress output with a one-line outcome, if requested
+	outcome := &syncOutcome{}
+	if syncSummary {
+		start := time.Now()
+		restore := silenceStdout()
+		defer func() {
+			restore()
+			fmt.Println(outcome.summary(time.Since(start), err))
+		}()
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if err := applyEndpointOverride(cfg, endpointOverride); err != nil {
+		return err
+	}
+	if err := applyCollectTimeout(cfg, collectTimeout); err != nil {
+		return err
+	}
+
+	// Restrict collection to the requested checks; a partial sync is manual
+	only := config.ParseList(onlyChecks)
+	if err := osquery.ValidateCheckNames(only); err != nil {
+		return err
+	}
+	forced := forceSync || len(only) > 0
+
+	// Upload a previously collected payload instead of collecting
+	var input *osquery.QueryResult
+	if syncInput != "" {
+		if len(only) > 0 || retryOnThrottle {
+			return fmt.Errorf("--input cannot be combined with --only or --retry-on-throttle")
+		}
+		if input, err = readSyncInput(syncInput); err != nil {
+			return err
+		}
+		forced = true
+	}
+
+	// Set up tracing, if configured
+	shutdownTracing, err := telemetry.Setup(cfg.OtelEndpoint, cfg.Version)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer shutdownTracing()
+
+	// Write sync audit events to the system log, if configured
+	defer openSystemLog(cfg)()
 
 	// Initialize data store
-	ds, err := datastore.New()
+	ds, err := openDataStore(cfg)
 	if err != nil {
 		return fmt.Errorf("failed to initialize data store: %w", err)
 	}
+	outcome.ds = ds
 
 	// Check if registered
 	if !ds.IsRegistered() {
@@ -62,11 +186,17 @@ func runSync(cmd *cobra.Command, args []string) error {
 	}
 
 	// Check sync throttling (unless forced)
-	if !forceSync {
+	if !forced {
 		if ds.GetSyncState() == datastore.SyncStateRunning {
 			return fmt.Errorf("sync is already in progress")
 		}
 
+		if retryOnThrottle {
+			if err := waitOutThrottle(cfg, ds, maxThrottleWait); err != nil {
+				return err
+			}
+		}
+
 		minutesSinceLastAttempt := ds.MinutesSinceLastAttempt()
 		if minutesSinceLastAttempt >= 0 && minutesSinceLastAttempt < cfg.MinMinutesBetweenSyncs {
 			return fmt.Errorf("sync was attempted %d minutes ago. Wait %d more minutes or use --force",
@@ -77,15 +207,73 @@ func runSync(cmd *cobra.Command, args []string) error {
 		if hoursSinceLastSuccess >= 0 && hoursSinceLastSuccess < cfg.MinHoursSinceLastSync {
 			fmt.Printf("Last successful sync was %d hours ago. Skipping automatic sync.\n", hoursSinceLastSuccess)
 			fmt.Println("Use --force to sync anyway.")
+			outcome.skipReason = fmt.Sprintf("last successful sync was %d hours ago", hoursSinceLastSuccess)
+			recordSkip(ds, outcome.skipReason)
 			return nil
 		}
 	}
 
+	if syncAttempts < 0 {
+		return fmt.Errorf("--attempts must be a positive integer")
+	}
+	if syncRetryWait < 0 {
+		return fmt.Errorf("--retry-wait must not be negative")
+	}
+
+	// Override the configured retry behavior for this run, if requested
+	policy := configRetryPolicy(cfg)
+	if syncAttempts > 0 {
+		policy.Attempts = syncAttempts
+	}
+	if syncRetryWait > 0 {
+		policy.Wait = syncRetryWait
+	}
+
+	report := func(attempt, attempts int, err error) {
+		if attempts == 1 {
+			return
+		}
+		if err != nil {
+			fmt.Printf("%s Attempt %d/%d failed: %v\n", markFailed, attempt, attempts, err)
+			return
+		}
+		fmt.Printf("%s Attempt %d/%d succeeded\n", markOK, attempt, attempts)
+	}
+
+	// Upload the given payload under this host's registration, skipping
+	// everything that concerns collection on this machine
+	if input != nil {
+		fmt.Printf("Uploading %s payload collected by agent %s from %s...\n", input.Platform, input.DrataAgentVersion, syncInput)
+		outcome.platform = string(input.Platform)
+		outcome.attempted = true
+		apiClient, err := api.NewClient(cfg, ds)
+		if err != nil {
+			return fmt.Errorf("failed to initialize API client: %w", err)
+		}
+		apiClient.SetVerbose(verboseSync)
+		if err := runWithRetries(policy, report, func() error {
+			return syncOnce(cfg, ds, nil, apiClient, false, input)
+		}); err != nil {
+			return err
+		}
+		fmt.Printf("%s Payload uploaded successfully!\n", markOK)
+		return nil
+	}
+
 	// Initialize osquery client with verbose option
-	osq, err := osquery.NewClientWithVerbose(cfg.OsqueryPath, verboseSync)
+	osq, err := newOsqueryClient(cfg, verboseSync)
 	if err != nil {
 		return fmt.Errorf("failed to initialize osquery: %w", err)
 	}
+	osq.SetCollectionTimeout(collectTimeout)
+	keepRegisteredMac(osq, ds)
+
+	if len(only) > 0 {
+		osq.SetCheckFilter(osquery.CheckFilter{
+			Enabled:  only,
+			Disabled: cfg.DisabledChecks,
+		})
+	}
 
 	if verboseSync {
 		fmt.Printf("Verbose mode enabled\n")
@@ -93,9 +281,121 @@ func runSync(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Agent version: %s\n", cfg.Version)
 	}
 
+	// Refuse to sync under WSL, if configured
+	if err := checkWSL(cfg, osq); err != nil {
+		return err
+	}
+
+	// Let the pre-sync hook veto the sync
+	if reason := hook.RunPreSync(cfg.PreSyncHook, hook.PreSyncContext{
+		Platform:     string(osq.GetPlatform()),
+		AgentVersion: cfg.Version,
+		Forced:       forced,
+	}); reason != "" {
+		fmt.Printf("Sync skipped: %s\n", reason)
+		outcome.skipReason = reason
+		recordSkip(ds, reason)
+		return nil
+	}
+
+	// Trace the sync
+	ctx, span := startSyncSpan(osq, forced)
+	defer func() { endSyncSpan(span, err) }()
+	osq = osq.WithContext(ctx)
+
 	// Initialize API client
-	apiClient := api.NewClient(cfg, ds)
+	apiClient, err := api.NewClient(cfg, ds)
+	if err != nil {
+		return fmt.Errorf("failed to initialize API client: %w", err)
+	}
+	apiClient.SetVerbose(verboseSync)
+	apiClient = apiClient.WithContext(ctx)
 
+	outcome.platform = string(osq.GetPlatform())
+	outcome.attempted = true
+	fmt.Println("Syncing system information with Drata...")
+	fmt.Printf("Using osquery: %s\n", describeOsquery(osq))
+	if drift := osqueryVersionDrift(cfg, osq); drift != "" {
+		fmt.Printf("Warning: %s\n", drift)
+	}
+
+	err = runWithRetries(policy, report, func() error {
+		return syncOnce(cfg, ds, osq, apiClient, len(only) == 0, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Sync completed successfully!\n", markOK)
+
+	// Show last checked time
+	lastChecked := ds.GetLastCheckedAt()
+	if lastChecked != "" {
+		fmt.Printf("Last successful sync: %s\n", lastChecked)
+	}
+
+	return nil
+}
+
+// waitOutThrottle sleeps until throttling allows a sync, printing how long
+// it will wait. It fails without waiting if that is longer than maxWait,
+// and stops early on Ctrl+C.
+func waitOutThrottle(cfg *config.Config, ds *datastore.DataStore, maxWait time.Duration) error {
+	wait, reason := throttleRemaining(cfg, ds, time.Now())
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxWait {
+		return fmt.Errorf("sync is throttled: %s; waiting %s for the throttle window would exceed --max-wait %s", reason, wait.Round(time.Second), maxWait)
+	}
+
+	fmt.Printf("Sync is throttled: %s. Waiting %s before syncing (Ctrl+C to cancel)...\n", reason, wait.Round(time.Second))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("interrupted while waiting for the throttle window")
+	}
+}
+
+// throttleRemaining returns how long until min_minutes_between_syncs and
+// min_hours_since_last_sync both allow a sync at now, and why it is
+// throttled. It returns zero if a sync may run now.
+func throttleRemaining(cfg *config.Config, ds *datastore.DataStore, now time.Time) (time.Duration, string) {
+	var wait time.Duration
+	var reason string
+
+	if lastAttempt, err := time.Parse(time.RFC3339, ds.GetLastSyncAttemptedAt()); err == nil {
+		if remaining := lastAttempt.Add(time.Duration(cfg.MinMinutesBetweenSyncs) * time.Minute).Sub(now); remaining > wait {
+			wait = remaining
+			reason = fmt.Sprintf("sync was attempted %d minutes ago", int(now.Sub(lastAttempt).Minutes()))
+		}
+	}
+	if lastSuccess, err := time.Parse(time.RFC3339, ds.GetLastCheckedAt()); err == nil {
+		if remaining := lastSuccess.Add(time.Duration(cfg.MinHoursSinceLastSync) * time.Hour).Sub(now); remaining > wait {
+			wait = remaining
+			reason = fmt.Sprintf("last successful sync was %d hours ago", int(now.Sub(lastSuccess).Hours()))
+		}
+	}
+
+	if wait <= 0 {
+		return 0, ""
+	}
+	// Throttling compares whole minutes and hours, so wait a moment past
+	// the boundary
+	return wait + time.Second, reason
+}
+
+// syncOnce makes a single attempt to collect system information and send
+// it to Drata, recording the attempt and its outcome in the data store.
+// When input is given, it is sent instead of collecting. The payload of a
+// full sync is kept for diffing.
+func syncOnce(cfg *config.Config, ds *datastore.DataStore, osq *osquery.Client, apiClient *api.Client, full bool, input *osquery.QueryResult) error {
 	// Set sync state to running
 	if err := ds.SetSyncState(datastore.SyncStateRunning); err != nil {
 		return fmt.Errorf("failed to update sync state: %w", err)
@@ -106,54 +406,138 @@ func runSync(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to update last sync attempted: %w", err)
 	}
 
-	fmt.Println("Syncing system information with Drata...")
-
 	// Get initialization data if needed
 	if !ds.IsInitDataReady() {
 		fmt.Println("Fetching initialization data...")
 		if _, err := apiClient.GetInitData(); err != nil {
-			if err := ds.SetSyncState(datastore.SyncStateError); err != nil {
-				fmt.Printf("Warning: failed to update sync state: %v\n", err)
-			}
+			recordSyncError(cfg, ds, syncStepInit, err)
 			return fmt.Errorf("failed to get initialization data: %w", err)
 		}
 	}
 
-	// Collect system information
-	fmt.Println("Collecting system information...")
-	queryResult, err := osq.GetSystemInfo(cfg.Version)
-	if err != nil {
-		if err := ds.SetSyncState(datastore.SyncStateError); err != nil {
-			fmt.Printf("Warning: failed to update sync state: %v\n", err)
+	// Collect system information, unless a collected payload was given
+	queryResult := input
+	if queryResult == nil {
+		var err error
+		if queryResult, err = collectForSync(cfg, ds, osq); err != nil {
+			return err
+		}
+		// A partial collection may leave out the identifiers
+		if full {
+			if _, _, err := ensureAgentID(ds, queryResult.DeviceIdentifiers()); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
 		}
-		return fmt.Errorf("failed to collect system information: %w", err)
 	}
 
-	// Mark as manual run if forced
-	queryResult.ManualRun = forceSync
-
-	// Send to Drata
-	fmt.Println("Sending data to Drata...")
-	_, err = apiClient.Sync(queryResult)
-	if err != nil {
-		if err := ds.SetSyncState(datastore.SyncStateError); err != nil {
-			fmt.Printf("Warning: failed to update sync state: %v\n", err)
-		}
-		return fmt.Errorf("failed to sync: %w", err)
+	// Send to Drata and any mirror
+	printf := func(format string, v ...interface{}) { fmt.Printf(format+"\n", v...) }
+	if err := uploadPayload(cfg, apiClient, queryResult, printf); err != nil {
+		recordSyncError(cfg, ds, syncStepUpload, err)
+		return err
 	}
+	if full {
+		recordPayload(ds, queryResult)
+		recordUpload(ds, queryResult)
+	}
+	recordSyncSuccess(queryResult)
 
 	// Update sync state
 	if err := ds.SetSyncState(datastore.SyncStateSuccess); err != nil {
 		return fmt.Errorf("failed to update sync state: %w", err)
 	}
 
-	fmt.Println("✓ Sync completed successfully!")
+	return nil
+}
 
-	// Show last checked time
-	lastChecked := ds.GetLastCheckedAt()
-	if lastChecked != "" {
-		fmt.Printf("Last successful sync: %s\n", lastChecked)
+// collectForSync collects system information for upload, reporting which
+// checks are incomplete, and enforces fail_on_missing_critical. The result
+// is marked as a manual run.
+func collectForSync(cfg *config.Config, ds *datastore.DataStore, osq *osquery.Client) (*osquery.QueryResult, error) {
+	fmt.Println("Collecting system information...")
+	queryResult, err := collectSystemInfo(cfg, osq)
+	if err != nil {
+		recordSyncError(cfg, ds, syncStepCollect, err)
+		return nil, fmt.Errorf("failed to collect system information: %w", err)
+	}
+	recordCollection(ds, queryResult)
+	if queryResult.Partial {
+		fmt.Printf("Warning: collection budget spent, skipped checks: %s\n", strings.Join(queryResult.SkippedChecks, ", "))
+	}
+	if summary := incompleteSummary(queryResult.IncompleteChecks(), verboseSync); summary != "" {
+		fmt.Printf("Warning: %s\n", summary)
+		if !verboseSync {
+			fmt.Println("Use --verbose to see why, or 'drata-agent status --verbose' after the sync.")
+		}
+	}
+	if err := checkCriticalChecks(cfg, osq, queryResult); err != nil {
+		recordSyncError(cfg, ds, syncStepCollect, err)
+		return nil, err
 	}
 
-	return nil
+	// A sync run from the command line is on demand, forced or not
+	queryResult.ManualRun = true
+	return queryResult, nil
+}
+
+// syncOutcome is what a sync run got to, for its --summary line.
+type syncOutcome struct {
+	ds       *datastore.DataStore
+	platform string
+	// attempted is set once the sync itself starts, after the checks that
+	// can refuse or skip it.
+	attempted  bool
+	skipReason string
+}
+
+// summary returns the --summary line for a sync that took duration and
+// ended with err: "sync ok", "sync skipped" or "sync error", followed by
+// key=value fields. Values that may contain spaces are quoted.
+func (o *syncOutcome) summary(duration time.Duration, err error) string {
+	fields := []string{"sync ok"}
+	switch {
+	case err != nil:
+		fields = []string{"sync error"}
+		// Only a failure during the sync itself has a recorded step
+		if o.attempted && o.ds != nil {
+			if lastErr := o.ds.GetLastError(); lastErr != nil {
+				fields = append(fields, "step="+lastErr.Step)
+			}
+		}
+		var apiErr *api.APIError
+		if errors.As(err, &apiErr) {
+			if apiErr.Code != "" {
+				fields = append(fields, "code="+apiErr.Code)
+			}
+			fields = append(fields, fmt.Sprintf("status=%d", apiErr.StatusCode))
+		}
+		fields = append(fields, fmt.Sprintf("message=%q", err.Error()))
+	case o.skipReason != "":
+		fields = []string{"sync skipped", fmt.Sprintf("reason=%q", o.skipReason)}
+	}
+	if o.platform != "" {
+		fields = append(fields, "platform="+o.platform)
+	}
+	fields = append(fields, "duration="+duration.Round(100*time.Millisecond).String())
+	if err == nil && o.skipReason == "" && o.ds != nil {
+		if lastChecked := o.ds.GetLastCheckedAt(); lastChecked != "" {
+			fields = append(fields, "lastChecked="+lastChecked)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// silenceStdout discards everything written to standard output until the
+// returned function restores it.
+func silenceStdout() func() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return func() {}
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	return func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}
 }
@@ -5,7 +5,7 @@ import (
 
 	"github.com/spf13/cobra"
 
-	"github.com/drata/drata-agent-cli/internal/datastore"
+	"github.com/drata/drata-agent-cli/internal/config"
 )
 
 var unregisterCmd = &cobra.Command{
@@ -29,8 +29,14 @@ func init() {
 }
 
 func runUnregister(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
 	// Initialize data store
-	ds, err := datastore.New()
+	ds, err := openDataStore(cfg)
 	if err != nil {
 		return fmt.Errorf("failed to initialize data store: %w", err)
 	}
@@ -61,7 +67,7 @@ func runUnregister(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to clear data: %w", err)
 	}
 
-	fmt.Println("✓ Agent unregistered successfully.")
+	fmt.Printf("%s Agent unregistered successfully.\n", markOK)
 	fmt.Println()
 	fmt.Println("To register again, run:")
 	fmt.Println("  drata-agent register YOUR_TOKEN --region NA")
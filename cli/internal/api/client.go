@@ -3,25 +3,65 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"github.com/drata/drata-agent-cli/internal/config"
 	"github.com/drata/drata-agent-cli/internal/datastore"
 	"github.com/drata/drata-agent-cli/internal/osquery"
+	"github.com/drata/drata-agent-cli/internal/telemetry"
 )
 
 const (
 	requestTimeout = 5 * time.Minute
+
+	// getMeAttempts bounds how many times the login flow asks for the user
+	// profile. New accounts can receive a valid token before their profile
+	// is readable, and the magic-link token cannot be reused.
+	getMeAttempts   = 4
+	getMeRetryDelay = 2 * time.Second
+
+	// regionHeader carries the agent's region to non-production hosts,
+	// which do not encode it in the hostname.
+	regionHeader = "X-Drata-Region"
+
+	// agentIDHeader carries the stable agent ID, which unlike the device
+	// UUID survives the agent's data being wiped, for server-side
+	// correlation.
+	agentIDHeader = "X-Drata-Agent-Id"
+
+	// refreshTokenPath exchanges the refresh token for a new access token.
+	refreshTokenPath = "/auth/refresh-token"
 )
 
 // AuthResponse represents the response from authentication endpoints.
 type AuthResponse struct {
-	AccessToken string `json:"accessToken,omitempty"`
+	AccessToken  string `json:"accessToken,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+// refreshRequest is the body of a refresh token request.
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// registerRequest is the body of a register request: the device
+// identifiers and, when requests are signed, the public key the server
+// pins for the device to verify them with.
+type registerRequest struct {
+	*osquery.AgentDeviceIdentifiers
+	DevicePublicKey string `json:"devicePublicKey,omitempty"`
 }
 
 // MeResponse represents the user information response.
@@ -67,47 +107,186 @@ type ErrorResponse struct {
 	SecondaryMessage string `json:"secondaryMessage,omitempty"`
 }
 
+// APIError is returned when the API responds with a non-success status.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// IsAuthError reports whether err is an authentication or account failure
+// that retrying the same request cannot fix.
+func IsAuthError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.Code {
+	case "MAGIC_TOKEN_NOT_FOUND", "REFRESH_TOKEN_NOT_FOUND", "TOKEN_EXPIRED",
+		"ACCOUNT_ADMIN_DISABLED", "ACCOUNT_NON_PAYMENT", "ACCOUNT_USER_DELETED":
+		return true
+	}
+
+	return apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+}
+
 // Client is the API client for Drata services.
 type Client struct {
 	httpClient *http.Client
 	config     *config.Config
 	dataStore  *datastore.DataStore
 	version    string
+	ctx        context.Context
+	// verbose logs retries and other detail
+	verbose bool
+	// refreshMu serializes token refreshes, so concurrent requests that
+	// find the access token expired refresh it once. It is shared by the
+	// copies WithContext makes.
+	refreshMu *sync.Mutex
 }
 
-// NewClient creates a new API client.
-func NewClient(cfg *config.Config, ds *datastore.DataStore) *Client {
+// NewClient creates a new API client. It fails if the configured client
+// certificate cannot be loaded.
+func NewClient(cfg *config.Config, ds *datastore.DataStore) (*Client, error) {
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return &Client{
-		httpClient: &http.Client{
-			Timeout: requestTimeout,
-		},
-		config:    cfg,
-		dataStore: ds,
-		version:   cfg.Version,
+		httpClient: httpClient,
+		config:     cfg,
+		dataStore:  ds,
+		version:    cfg.Version,
+		refreshMu:  &sync.Mutex{},
+	}, nil
+}
+
+// SetVerbose sets the verbose mode for the client.
+func (c *Client) SetVerbose(verbose bool) {
+	c.verbose = verbose
+}
+
+// logVerbose prints a message if verbose mode is enabled.
+func (c *Client) logVerbose(format string, args ...interface{}) {
+	if c.verbose {
+		fmt.Printf("[VERBOSE] "+format+"\n", args...)
 	}
 }
 
-// doRequest performs an HTTP request with the appropriate headers.
+// WithContext returns a shallow copy of the client whose requests run
+// under ctx.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	clone := *c
+	clone.ctx = ctx
+	return &clone
+}
+
+// context returns the client's context, defaulting to the background context.
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// doRequest performs an HTTP request with the appropriate headers. When the
+// access token has expired and a refresh token is stored, the token is
+// refreshed and the request sent once more; if the refresh fails, the
+// original response is returned.
 func (c *Client) doRequest(method, path string, body interface{}) (*http.Response, error) {
-	var bodyReader io.Reader
+	accessToken := c.dataStore.GetAccessToken()
+	resp, err := c.doRequestOnce(method, path, body)
+	if err != nil || strings.HasPrefix(path, "/auth/") || c.dataStore.GetRefreshToken() == "" || !isTokenExpired(resp) {
+		return resp, err
+	}
+
+	if err := c.refreshExpiredToken(accessToken); err != nil {
+		log.Printf("Warning: failed to refresh the access token: %v", err)
+		return resp, nil
+	}
+	resp.Body.Close()
+	return c.doRequestOnce(method, path, body)
+}
+
+// isTokenExpired reports whether resp rejects the access token, with HTTP
+// 401 or the TOKEN_EXPIRED error code. The body is left readable.
+func isTokenExpired(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+		return false
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if resp.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	var errResp ErrorResponse
+	return json.Unmarshal(body, &errResp) == nil && errResp.Code == "TOKEN_EXPIRED"
+}
+
+// doRequestOnce performs an HTTP request with the appropriate headers.
+func (c *Client) doRequestOnce(method, path string, body interface{}) (resp *http.Response, err error) {
+	ctx, span := telemetry.StartSpan(c.context(), "api.request",
+		attribute.String("http.method", method),
+		attribute.String("http.route", requestRoute(path)),
+	)
+	defer func() {
+		if resp != nil {
+			span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		}
+		telemetry.EndSpan(span, err)
+	}()
+
+	var jsonBody []byte
 	if body != nil {
-		jsonBody, err := json.Marshal(body)
+		jsonBody, err = json.Marshal(body)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		bodyReader = bytes.NewReader(jsonBody)
 	}
 
-	// Determine base URL based on region in datastore or config
-	region := c.dataStore.GetRegion()
-	if region == "" {
-		region = c.config.Region
+	// Determine base URL based on region in datastore or config; the
+	// config is only written when the region changes, as concurrent
+	// requests share it
+	if region := c.dataStore.GetRegion(); region != "" && region != c.config.Region {
+		c.config.Region = region
+	}
+
+	// Idempotent requests fall back to the region_failover regions when a
+	// host cannot be connected to; any HTTP response, even an error, and
+	// any failure after the request was sent are final
+	hosts := c.requestHosts(method, path)
+	for i, host := range hosts {
+		resp, err = c.sendRequest(ctx, method, host+path, i > 0, body != nil, jsonBody)
+		if err == nil {
+			if i > 0 {
+				log.Printf("Request to %s succeeded via failover host %s", requestRoute(path), host)
+			}
+			return resp, nil
+		}
+		if i+1 == len(hosts) || !isUnsentError(err) {
+			break
+		}
+		log.Printf("Could not reach %s (%v), trying %s", host, err, hosts[i+1])
 	}
-	c.config.Region = region
-
-	url := c.config.APIHostURL() + path
+	return nil, err
+}
 
-	req, err := http.NewRequest(method, url, bodyReader)
+// sendRequest sends one request to url with the agent's headers. A
+// failover request carries the device's region, as it goes to another
+// region's host.
+func (c *Client) sendRequest(ctx context.Context, method, url string, failover, hasBody bool, jsonBody []byte) (*http.Response, error) {
+	var bodyReader io.Reader
+	if hasBody {
+		bodyReader = bytes.NewReader(jsonBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -120,15 +299,65 @@ func (c *Client) doRequest(method, path string, body interface{}) (*http.Respons
 	if uuid != "" {
 		req.Header.Set("Correlation-Id", uuid)
 	}
+	if agentID, _ := c.dataStore.GetAgentID(); agentID != "" {
+		req.Header.Set(agentIDHeader, agentID)
+	}
 
 	accessToken := c.dataStore.GetAccessToken()
 	if accessToken != "" {
 		req.Header.Set("Authorization", "Bearer "+accessToken)
 	}
 
+	// Non-production environments serve every region from one host, so the
+	// region is sent for server-side region logic to be tested before PROD
+	switch c.config.TargetEnv {
+	case config.EnvLocal, config.EnvDev, config.EnvQA:
+		req.Header.Set(regionHeader, string(c.config.Region))
+	default:
+		if failover {
+			req.Header.Set(regionHeader, string(c.config.Region))
+		}
+	}
+
+	if c.config.SignPayloads {
+		if err := c.signRequest(req, jsonBody); err != nil {
+			return nil, err
+		}
+	}
+
 	return c.httpClient.Do(req)
 }
 
+// requestRoute returns path with secrets such as magic-link tokens removed,
+// for use in trace attributes.
+func requestRoute(path string) string {
+	if strings.HasPrefix(path, "/auth/magic-link/") {
+		return "/auth/magic-link/{token}"
+	}
+	return path
+}
+
+// Ping sends an unauthenticated request to the API host for the configured
+// region and environment and returns the HTTP status. Any response, even an
+// error status, means the host is reachable; only a failure to connect,
+// such as a DNS, TLS, or proxy error, is returned as an error. It does not
+// fail over to other regions. The request carries no credentials, agent
+// ID, or signature, and never creates the device key.
+func (c *Client) Ping() (int, error) {
+	req, err := http.NewRequestWithContext(c.context(), http.MethodGet, c.config.APIHostURL()+"/", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("Drata-Agent-CLI/%s (%s)", c.version, runtime.GOOS))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
 // LoginWithMagicLink authenticates using a magic link token.
 func (c *Client) LoginWithMagicLink(token string) (*MeResponse, error) {
 	resp, err := c.doRequest("POST", "/auth/magic-link/"+token, nil)
@@ -146,14 +375,97 @@ func (c *Client) LoginWithMagicLink(token string) (*MeResponse, error) {
 		return nil, fmt.Errorf("failed to decode auth response: %w", err)
 	}
 
+	if err := c.saveTokens(&authResp); err != nil {
+		return nil, err
+	}
+
+	// Get user info
+	return c.getMeWithRetry()
+}
+
+// RefreshToken exchanges the stored refresh token for a new access token,
+// and a new refresh token if the API rotates it.
+func (c *Client) RefreshToken() error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	return c.refreshToken()
+}
+
+// refreshExpiredToken refreshes the access token after a request sent with
+// expired was rejected, unless another request has replaced it since; the
+// refresh token may be single-use, so it is only spent once.
+func (c *Client) refreshExpiredToken(expired string) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if c.dataStore.GetAccessToken() != expired {
+		return nil
+	}
+	return c.refreshToken()
+}
+
+// refreshToken performs the refresh; the caller holds refreshMu.
+func (c *Client) refreshToken() error {
+	refreshToken := c.dataStore.GetRefreshToken()
+	if refreshToken == "" {
+		return fmt.Errorf("no refresh token is stored")
+	}
+
+	resp, err := c.doRequestOnce("POST", refreshTokenPath, refreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		return fmt.Errorf("failed to refresh token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return c.handleErrorResponse(resp)
+	}
+
+	var authResp AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		return fmt.Errorf("failed to decode refresh response: %w", err)
+	}
+	if authResp.AccessToken == "" {
+		return fmt.Errorf("refresh response has no access token")
+	}
+	return c.saveTokens(&authResp)
+}
+
+// saveTokens stores the tokens in an authentication response. A refresh
+// token that is not returned is left as it is.
+func (c *Client) saveTokens(authResp *AuthResponse) error {
 	if authResp.AccessToken != "" {
 		if err := c.dataStore.SetAccessToken(authResp.AccessToken); err != nil {
-			return nil, fmt.Errorf("failed to save access token: %w", err)
+			return fmt.Errorf("failed to save access token: %w", err)
+		}
+	}
+	if authResp.RefreshToken != "" {
+		if err := c.dataStore.SetRefreshToken(authResp.RefreshToken); err != nil {
+			return fmt.Errorf("failed to save refresh token: %w", err)
 		}
 	}
+	return nil
+}
 
-	// Get user info
-	return c.GetMe()
+// getMeWithRetry retrieves the user profile, retrying transient failures.
+// Authentication failures are returned immediately.
+func (c *Client) getMeWithRetry() (*MeResponse, error) {
+	var lastErr error
+	for attempt := 1; attempt <= getMeAttempts; attempt++ {
+		me, err := c.GetMe()
+		if err == nil {
+			return me, nil
+		}
+		if IsAuthError(err) {
+			return nil, err
+		}
+		lastErr = err
+
+		if attempt < getMeAttempts {
+			time.Sleep(getMeRetryDelay * time.Duration(attempt))
+		}
+	}
+
+	return nil, fmt.Errorf("user profile not ready after %d attempts: %w", getMeAttempts, lastErr)
 }
 
 // GetMe retrieves the current user information.
@@ -196,9 +508,20 @@ func (c *Client) GetMe() (*MeResponse, error) {
 	return &meResp, nil
 }
 
-// Register registers the agent with the device identifiers.
+// Register registers the agent with the device identifiers, retrying
+// server and network errors. With sign_payloads, the device's public key is
+// sent for the server to pin.
 func (c *Client) Register(identifiers *osquery.AgentDeviceIdentifiers) (*AgentV2Response, error) {
-	resp, err := c.doRequest("POST", "/agentv2/register", identifiers)
+	request := registerRequest{AgentDeviceIdentifiers: identifiers}
+	if c.config.SignPayloads {
+		publicKey, err := c.devicePublicKey()
+		if err != nil {
+			return nil, err
+		}
+		request.DevicePublicKey = publicKey
+	}
+
+	resp, err := c.doRequestWithRetries("POST", "/agentv2/register", request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to register: %w", err)
 	}
@@ -223,9 +546,10 @@ func (c *Client) Register(identifiers *osquery.AgentDeviceIdentifiers) (*AgentV2
 	return &agentResp, nil
 }
 
-// Sync sends system information to the Drata API.
+// Sync sends system information to the Drata API, retrying server and
+// network errors.
 func (c *Client) Sync(queryResult *osquery.QueryResult) (*SyncResponse, error) {
-	resp, err := c.doRequest("POST", "/agentv2/sync", queryResult)
+	resp, err := c.doRequestWithRetries("POST", "/agentv2/sync", queryResult)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sync: %w", err)
 	}
@@ -255,6 +579,23 @@ func (c *Client) Sync(queryResult *osquery.QueryResult) (*SyncResponse, error) {
 	return &syncResp, nil
 }
 
+// Heartbeat sends a minimal sync payload, such as the device identifiers
+// alone, so that Drata's last checked time for the device advances. Unlike
+// Sync, it leaves the data store untouched, so local throttling still
+// counts from the last full sync.
+func (c *Client) Heartbeat(queryResult *osquery.QueryResult) error {
+	resp, err := c.doRequestWithRetries("POST", "/agentv2/sync", queryResult)
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return c.handleErrorResponse(resp)
+	}
+	return nil
+}
+
 // GetInitData retrieves initialization data from the API.
 func (c *Client) GetInitData() (*InitDataResponse, error) {
 	resp, err := c.doRequest("GET", "/agentv2/init", nil)
@@ -286,39 +627,49 @@ func (c *Client) GetInitData() (*InitDataResponse, error) {
 func (c *Client) handleErrorResponse(resp *http.Response) error {
 	body, _ := io.ReadAll(resp.Body)
 
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
 	var errResp ErrorResponse
 	if err := json.Unmarshal(body, &errResp); err != nil {
-		return fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		apiErr.Message = fmt.Sprintf("API error (status %d): %s", resp.StatusCode, string(body))
+		return apiErr
 	}
+	apiErr.Code = errResp.Code
 
 	// Handle specific error codes
 	switch errResp.Code {
 	case "MAGIC_TOKEN_NOT_FOUND":
-		return fmt.Errorf("magic token not found or expired. Please request a new registration link")
+		apiErr.Message = "magic token not found or expired. Please request a new registration link"
 	case "REFRESH_TOKEN_NOT_FOUND":
-		return fmt.Errorf("refresh token not found. Please register the agent")
+		apiErr.Message = "refresh token not found. Please register the agent"
 	case "TOKEN_EXPIRED":
-		return fmt.Errorf("authorization has expired. Please register the agent again")
+		apiErr.Message = "authorization has expired. Please register the agent again"
 	case "ACCOUNT_PENDING":
-		return fmt.Errorf("account configuration is being completed. Please try again in a few minutes")
+		apiErr.Message = "account configuration is being completed. Please try again in a few minutes"
 	case "ACCOUNT_MAINTENANCE":
-		return fmt.Errorf("Drata is under maintenance. Please try again in a few minutes")
+		apiErr.Message = "Drata is under maintenance. Please try again in a few minutes"
 	case "ACCOUNT_ADMIN_DISABLED", "ACCOUNT_NON_PAYMENT":
-		return fmt.Errorf("your company's account is disabled. Please contact your system administrator")
+		apiErr.Message = "your company's account is disabled. Please contact your system administrator"
 	case "ACCOUNT_USER_DELETED":
-		return fmt.Errorf("your user account was deleted. Please contact your system administrator")
+		apiErr.Message = "your user account was deleted. Please contact your system administrator"
+	}
+	if apiErr.Message != "" {
+		return apiErr
 	}
 
 	if resp.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("unauthorized: please register the agent or check your credentials")
+		apiErr.Message = "unauthorized: please register the agent or check your credentials"
+		return apiErr
 	}
 
 	if errResp.Message != "" {
+		apiErr.Message = errResp.Message
 		if errResp.SecondaryMessage != "" {
-			return fmt.Errorf("%s: %s", errResp.Message, errResp.SecondaryMessage)
+			apiErr.Message = fmt.Sprintf("%s: %s", errResp.Message, errResp.SecondaryMessage)
 		}
-		return fmt.Errorf("%s", errResp.Message)
+		return apiErr
 	}
 
-	return fmt.Errorf("API error (status %d)", resp.StatusCode)
+	apiErr.Message = fmt.Sprintf("API error (status %d)", resp.StatusCode)
+	return apiErr
 }
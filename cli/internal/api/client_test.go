@@ -0,0 +1,868 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+	"github.com/drata/drata-agent-cli/internal/datastore"
+	"github.com/drata/drata-agent-cli/internal/osquery"
+)
+
+func TestIsAuthError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"unauthorized", &APIError{StatusCode: http.StatusUnauthorized}, true},
+		{"forbidden", &APIError{StatusCode: http.StatusForbidden}, true},
+		{"token expired", &APIError{StatusCode: http.StatusBadRequest, Code: "TOKEN_EXPIRED"}, true},
+		{"user deleted", &APIError{StatusCode: http.StatusNotFound, Code: "ACCOUNT_USER_DELETED"}, true},
+		{"account pending", &APIError{StatusCode: http.StatusPreconditionFailed, Code: "ACCOUNT_PENDING"}, false},
+		{"not found", &APIError{StatusCode: http.StatusNotFound}, false},
+		{"server error", &APIError{StatusCode: http.StatusInternalServerError}, false},
+		{"wrapped", fmt.Errorf("login: %w", &APIError{StatusCode: http.StatusUnauthorized}), true},
+		{"network", errors.New("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsAuthError(tt.err); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSignRequestParts(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"rawQueryResults":{}}`)
+
+	signature, err := base64.StdEncoding.DecodeString(signRequestParts(private, "POST", "/agentv2/sync", "1700000000", body))
+	if err != nil {
+		t.Fatalf("signature is not base64: %v", err)
+	}
+	message := "POST\n/agentv2/sync\n1700000000\n" + string(body)
+	if !ed25519.Verify(public, []byte(message), signature) {
+		t.Error("signature does not verify")
+	}
+
+	// The signature covers every part, not just the body
+	for _, tampered := range []string{
+		"POST\n/agentv2/sync\n1700000000\n" + `{"rawQueryResults":{"tampered":true}}`,
+		"POST\n/agentv2/register\n1700000000\n" + string(body),
+		"POST\n/agentv2/sync\n1700000001\n" + string(body),
+		"PUT\n/agentv2/sync\n1700000000\n" + string(body),
+	} {
+		if ed25519.Verify(public, []byte(tampered), signature) {
+			t.Errorf("signature verifies %q", tampered)
+		}
+	}
+}
+
+func TestSignedRegistration(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	var publicKey ed25519.PublicKey
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Drata-Device-Public-Key") != "" {
+			t.Error("public key sent with a request")
+		}
+		if r.URL.Path == "/agentv2/register" {
+			var request struct {
+				DevicePublicKey string `json:"devicePublicKey"`
+				DeviceName      string `json:"deviceName"`
+			}
+			if err := json.Unmarshal(body, &request); err != nil || request.DeviceName != "laptop" {
+				t.Errorf("register body = %s, %v", body, err)
+			}
+			key, err := base64.StdEncoding.DecodeString(request.DevicePublicKey)
+			if err != nil || len(key) != ed25519.PublicKeySize {
+				t.Fatalf("register body has no public key: %s", body)
+			}
+			publicKey = key
+		}
+
+		// Every request is signed with the pinned key
+		message := r.Method + "\n" + r.URL.RequestURI() + "\n" + r.Header.Get(timestampHeader) + "\n" + string(body)
+		signature, _ := base64.StdEncoding.DecodeString(r.Header.Get(signatureHeader))
+		if publicKey == nil || !ed25519.Verify(publicKey, []byte(message), signature) {
+			t.Errorf("%s %s has no valid signature", r.Method, r.URL.Path)
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.APIBaseURL = server.URL
+	cfg.SignPayloads = true
+	client, err := NewClient(cfg, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identifiers := &osquery.AgentDeviceIdentifiers{DeviceName: "laptop"}
+	if _, err := client.Register(identifiers); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if _, err := client.Sync(&osquery.QueryResult{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if _, err := client.GetMe(); err != nil {
+		t.Fatalf("GetMe failed: %v", err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	var received osquery.QueryResult
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode mirrored payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.SetAccessToken("drata-token"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.MirrorEndpoint = server.URL
+	cfg.MirrorHeaders = []string{"X-Api-Key: secret"}
+	cfg.SignPayloads = true
+	client, err := NewClient(cfg, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &osquery.QueryResult{Platform: osquery.PlatformLinux, RawQueryResults: map[string]interface{}{"firewallStatus": "on"}}
+	if err := client.Mirror(result); err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if received.Platform != osquery.PlatformLinux || received.RawQueryResults["firewallStatus"] != "on" {
+		t.Errorf("unexpected mirrored payload: %+v", received)
+	}
+	if header.Get("X-Api-Key") != "secret" || header.Get("Authorization") != "" || header.Get(signatureHeader) != "" {
+		t.Errorf("unexpected mirror headers: %v", header)
+	}
+	if ds.GetDeviceKey() != "" {
+		t.Error("mirror created a device key")
+	}
+	if ds.GetLastCheckedAt() != "" {
+		t.Error("mirror advanced last checked at without mirror_only")
+	}
+
+	cfg.MirrorOnly = true
+	if err := client.Mirror(result); err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if ds.GetLastCheckedAt() != "" {
+		t.Error("mirror_only advanced last checked at")
+	}
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	if err := client.Mirror(result); err == nil {
+		t.Error("expected an error for HTTP 503")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	var path string
+	var received osquery.QueryResult
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode heartbeat: %v", err)
+		}
+		fmt.Fprint(w, `{"data":{"lastCheckedAt":"2024-05-01T12:00:00Z"}}`)
+	}))
+	defer server.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.APIBaseURL = server.URL
+	client, err := NewClient(cfg, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &osquery.QueryResult{Platform: osquery.PlatformLinux, RawQueryResults: map[string]interface{}{"boardSerial": "BOARD123"}}
+	if err := client.Heartbeat(result); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	if path != "/agentv2/sync" || received.RawQueryResults["boardSerial"] != "BOARD123" {
+		t.Errorf("unexpected heartbeat %s: %+v", path, received)
+	}
+	if ds.GetLastCheckedAt() != "" {
+		t.Error("heartbeat advanced last checked at")
+	}
+}
+
+func TestRequestHosts(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Region = config.RegionNA
+	cfg.RegionFailover = []string{"APAC", "NA", "EU"}
+	client := &Client{config: cfg}
+
+	// The regions are tried in order, skipping the region's own host
+	hosts := client.requestHosts(http.MethodPost, "/agentv2/sync")
+	if want := []string{"https://agent.drata.com", "https://agent.apac.drata.com", "https://agent.eu.drata.com"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("sync hosts = %v, want %v", hosts, want)
+	}
+	if hosts := client.requestHosts(http.MethodGet, "/agentv2/init"); len(hosts) != 3 {
+		t.Errorf("init hosts = %v, want three", hosts)
+	}
+	if hosts := client.requestHosts(http.MethodPost, "/agentv2/register"); len(hosts) != 1 {
+		t.Errorf("register hosts = %v, want no failover", hosts)
+	}
+
+	cfg.APIBaseURL = "https://agent.staging.example.com"
+	if hosts := client.requestHosts(http.MethodPost, "/agentv2/sync"); len(hosts) != 1 {
+		t.Errorf("hosts with api_base_url = %v, want no failover", hosts)
+	}
+}
+
+func TestFailoverAfterSend(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	// The primary host accepts the connection and drops it after reading
+	// the request, which may have been acted on
+	var primaryRequests, failoverRequests atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryRequests.Add(1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer primary.Close()
+	failover := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failoverRequests.Add(1)
+		if r.Header.Get(regionHeader) != "NA" {
+			t.Errorf("failover request region = %q, want NA", r.Header.Get(regionHeader))
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer failover.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.Region = config.RegionNA
+	cfg.RegionFailover = []string{"EU"}
+	client, err := NewClient(cfg, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests for the region's host go to the primary server, and those
+	// for the failover region's host to the failover server
+	primaryURL, _ := url.Parse(primary.URL)
+	failoverURL, _ := url.Parse(failover.URL)
+	client.httpClient.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		switch r.URL.Host {
+		case "agent.drata.com":
+			r.URL.Scheme, r.URL.Host = primaryURL.Scheme, primaryURL.Host
+		case "agent.eu.drata.com":
+			r.URL.Scheme, r.URL.Host = failoverURL.Scheme, failoverURL.Host
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	if _, err := client.doRequestOnce(http.MethodPost, "/agentv2/sync", map[string]string{}); err == nil {
+		t.Fatal("expected the dropped connection to fail the request")
+	}
+	if primaryRequests.Load() != 1 || failoverRequests.Load() != 0 {
+		t.Errorf("a request that reached the host was resent to a failover region: %d primary, %d failover requests", primaryRequests.Load(), failoverRequests.Load())
+	}
+
+	// A host that refuses the connection never saw the request
+	primary.Close()
+	if _, err := client.doRequestOnce(http.MethodPost, "/agentv2/sync", map[string]string{}); err != nil {
+		t.Fatalf("expected failover after a refused connection, got %v", err)
+	}
+	if failoverRequests.Load() != 1 {
+		t.Errorf("expected one failover request, got %d", failoverRequests.Load())
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper that calls itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestRegionHeader(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		env      config.TargetEnv
+		region   config.Region
+		expected string
+	}{
+		{config.EnvDev, config.RegionEU, "EU"},
+		{config.EnvDev, config.RegionAPAC, "APAC"},
+		{config.EnvQA, config.RegionEU, "EU"},
+		{config.EnvQA, config.RegionAPAC, "APAC"},
+		{config.EnvLocal, config.RegionNA, "NA"},
+		{config.EnvProd, config.RegionEU, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.env)+" "+string(tt.region), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.TargetEnv = tt.env
+			cfg.Region = tt.region
+			cfg.APIBaseURL = server.URL
+			client, err := NewClient(cfg, ds)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := client.GetMe(); err != nil {
+				t.Fatalf("GetMe failed: %v", err)
+			}
+			if got := header.Get(regionHeader); got != tt.expected {
+				t.Errorf("%s = %q, want %q", regionHeader, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAgentIDHeader(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.APIBaseURL = server.URL
+	client, err := NewClient(cfg, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.GetMe(); err != nil {
+		t.Fatalf("GetMe failed: %v", err)
+	}
+	if got := header.Get(agentIDHeader); got != "" {
+		t.Errorf("%s = %q before an agent ID is derived, want none", agentIDHeader, got)
+	}
+
+	if err := ds.SetAgentID("0123456789abcdef", osquery.AgentIDSourceHardwareSerial); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetMe(); err != nil {
+		t.Fatalf("GetMe failed: %v", err)
+	}
+	if got := header.Get(agentIDHeader); got != "0123456789abcdef" {
+		t.Errorf("%s = %q, want %q", agentIDHeader, got, "0123456789abcdef")
+	}
+}
+
+func TestPingSendsNoCredentials(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.SetAccessToken("drata-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.SetAgentID("0123456789abcdef", osquery.AgentIDSourceHardwareSerial); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.APIBaseURL = server.URL
+	cfg.SignPayloads = true
+	client, err := NewClient(cfg, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := client.Ping()
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if status != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", status, http.StatusNotFound)
+	}
+	for _, name := range []string{"Authorization", agentIDHeader, signatureHeader, timestampHeader} {
+		if got := header.Get(name); got != "" {
+			t.Errorf("Ping sent %s = %q", name, got)
+		}
+	}
+	if ds.GetDeviceKey() != "" {
+		t.Error("Ping created a device key")
+	}
+}
+
+func TestRefreshOnExpiredToken(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	refreshes := 0
+	refreshStatus := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == refreshTokenPath {
+			refreshes++
+			var body refreshRequest
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken != "refresh-1" {
+				t.Errorf("refresh request body = %+v, %v", body, err)
+			}
+			w.WriteHeader(refreshStatus)
+			if refreshStatus == http.StatusOK {
+				fmt.Fprint(w, `{"accessToken":"access-2","refreshToken":"refresh-2"}`)
+			} else {
+				fmt.Fprint(w, `{"statusCode":401,"code":"REFRESH_TOKEN_NOT_FOUND"}`)
+			}
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer access-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"statusCode":401,"code":"TOKEN_EXPIRED"}`)
+			return
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.APIBaseURL = server.URL
+	client, err := NewClient(cfg, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a refresh token, the expired token is an error
+	ds.SetAccessToken("access-1")
+	_, err = client.GetMe()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "TOKEN_EXPIRED" || refreshes != 0 {
+		t.Fatalf("expected TOKEN_EXPIRED without a refresh, got %v after %d refreshes", err, refreshes)
+	}
+
+	// A failed refresh falls through to the original error
+	ds.SetRefreshToken("refresh-1")
+	refreshStatus = http.StatusUnauthorized
+	_, err = client.GetMe()
+	if !errors.As(err, &apiErr) || apiErr.Code != "TOKEN_EXPIRED" || refreshes != 1 {
+		t.Fatalf("expected TOKEN_EXPIRED after a failed refresh, got %v after %d refreshes", err, refreshes)
+	}
+
+	// A successful refresh stores the new tokens and retries the request
+	refreshStatus = http.StatusOK
+	if _, err := client.GetMe(); err != nil {
+		t.Fatalf("GetMe failed after refresh: %v", err)
+	}
+	if refreshes != 2 || ds.GetAccessToken() != "access-2" || ds.GetRefreshToken() != "refresh-2" {
+		t.Errorf("expected the refreshed tokens, got %q and %q after %d refreshes", ds.GetAccessToken(), ds.GetRefreshToken(), refreshes)
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	var mu sync.Mutex
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == refreshTokenPath {
+			mu.Lock()
+			refreshes++
+			mu.Unlock()
+			fmt.Fprint(w, `{"accessToken":"access-2","refreshToken":"refresh-2"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer access-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"statusCode":401,"code":"TOKEN_EXPIRED"}`)
+			return
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.APIBaseURL = server.URL
+	client, err := NewClient(cfg, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.SetAccessToken("access-1")
+	ds.SetRefreshToken("refresh-1")
+
+	// Requests that find the token expired together refresh it once
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetMe(); err != nil {
+				t.Errorf("GetMe failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if refreshes != 1 {
+		t.Errorf("expected one refresh, got %d", refreshes)
+	}
+}
+
+func TestSyncRetries(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	defer func(base time.Duration) { retryBaseDelay = base }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	// dropConnection in statuses closes the connection without a response
+	const dropConnection = -1
+	var mu sync.Mutex
+	requests := 0
+	statuses := []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		status := http.StatusOK
+		if requests < len(statuses) {
+			status = statuses[requests]
+		}
+		requests++
+		mu.Unlock()
+		if status == dropConnection {
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			fmt.Fprint(w, `{"data":{}}`)
+		} else {
+			fmt.Fprintf(w, `{"statusCode":%d}`, status)
+		}
+	}))
+	defer server.Close()
+
+	ds, err := datastore.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.APIBaseURL = server.URL
+	client, err := NewClient(cfg, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		register bool
+		statuses []int
+		requests int
+		status   int
+	}{
+		{"recovers", false, []int{http.StatusBadGateway, http.StatusServiceUnavailable}, 3, 0},
+		{"gives up", false, []int{500, 500, 500, 500, 500}, 4, 500},
+		{"client error", false, []int{http.StatusBadRequest}, 1, http.StatusBadRequest},
+		// Resending a sync only replaces the device's state
+		{"dropped after sending", false, []int{dropConnection, dropConnection}, 3, 0},
+		// The server may have acted on a registration it dropped
+		{"register dropped after sending", true, []int{dropConnection, dropConnection}, 1, dropConnection},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			requests, statuses = 0, tt.statuses
+			mu.Unlock()
+			var err error
+			if tt.register {
+				_, err = client.Register(&osquery.AgentDeviceIdentifiers{})
+			} else {
+				_, err = client.Sync(&osquery.QueryResult{Platform: osquery.PlatformLinux})
+			}
+			var apiErr *APIError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Errorf("Sync failed: %v", err)
+			case tt.status == dropConnection && (err == nil || errors.As(err, &apiErr)):
+				t.Errorf("expected a connection error, got %v", err)
+			case tt.status != 0 && tt.status != dropConnection && (!errors.As(err, &apiErr) || apiErr.StatusCode != tt.status):
+				t.Errorf("expected HTTP %d, got %v", tt.status, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if requests != tt.requests {
+				t.Errorf("expected %d requests, got %d", tt.requests, requests)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for retry, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := retryDelay(retry); got < want/2 || got > want {
+			t.Errorf("retryDelay(%d) = %s, want %s to %s", retry, got, want/2, want)
+		}
+	}
+	if got := retryDelay(20); got > retryMaxDelay {
+		t.Errorf("retryDelay(20) = %s, want at most %s", got, retryMaxDelay)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"dns", &net.DNSError{Err: "no such host", Name: "agent.drata.com"}, true},
+		{"refused", fmt.Errorf("post: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"canceled", fmt.Errorf("post: %w", context.Canceled), false},
+		{"other", errors.New("failed to load device key"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsConnectionError(tt.err); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIsUnsentError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"dns", &net.DNSError{Err: "no such host", Name: "agent.drata.com"}, true},
+		{"refused", fmt.Errorf("post: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"proxy", fmt.Errorf("post: %w", &net.OpError{Op: "proxyconnect", Err: errors.New("connection refused")}), true},
+		{"reset after sending", fmt.Errorf("post: %w", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}), false},
+		{"write", fmt.Errorf("post: %w", &net.OpError{Op: "write", Err: errors.New("broken pipe")}), false},
+		{"canceled", fmt.Errorf("post: %w", context.Canceled), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUnsentError(tt.err); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	// Any response, even an error status, is reachable
+	check := EndpointCheck{URL: server.URL}
+	checkEndpoint(context.Background(), server.Client(), "test", &check)
+	if !check.Reachable || check.StatusCode != http.StatusNotFound || check.TLSMs == nil || check.FailedStep != "" {
+		t.Errorf("trusted server: got %+v", check)
+	}
+
+	// An untrusted certificate fails in the TLS handshake
+	check = EndpointCheck{URL: server.URL}
+	checkEndpoint(context.Background(), &http.Client{Timeout: 5 * time.Second}, "test", &check)
+	if check.Reachable || check.FailedStep != EndpointStepTLS || check.Error == "" {
+		t.Errorf("untrusted server: got %+v", check)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "https://" + listener.Addr().String()
+	listener.Close()
+	check = EndpointCheck{URL: closed}
+	checkEndpoint(context.Background(), &http.Client{Timeout: 5 * time.Second}, "test", &check)
+	if check.Reachable || check.FailedStep != EndpointStepConnect {
+		t.Errorf("closed port: got %+v", check)
+	}
+}
+
+// writeClientCertificate writes a self-signed PEM certificate and key to dir.
+func writeClientCertificate(t *testing.T, dir, name string) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath = filepath.Join(dir, name+".pem")
+	keyPath = filepath.Join(dir, name+"-key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeClientCertificate(t, dir, "agent")
+	_, otherKeyPath := writeClientCertificate(t, dir, "other")
+
+	var presented string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.ClientCertPath = certPath
+	cfg.ClientKeyPath = keyPath
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	client.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with client certificate failed: %v", err)
+	}
+	resp.Body.Close()
+	if presented != "agent" {
+		t.Errorf("presented certificate = %q, want agent", presented)
+	}
+
+	// A key that does not belong to the certificate is rejected up front
+	cfg.ClientKeyPath = otherKeyPath
+	if _, err := newHTTPClient(cfg); err == nil {
+		t.Error("expected an error for a mismatched key")
+	}
+	cfg.ClientKeyPath = filepath.Join(dir, "missing.pem")
+	if _, err := newHTTPClient(cfg); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+)
+
+// Steps at which an endpoint check can fail.
+const (
+	EndpointStepDNS     = "dns"
+	EndpointStepConnect = "connect"
+	EndpointStepTLS     = "tls"
+	EndpointStepHTTP    = "http"
+)
+
+// EndpointCheck is the result of checking connectivity to one API host.
+type EndpointCheck struct {
+	// Name is the region the host serves, or "configured" for an
+	// api_base_url or non-production host.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Current is whether the agent is configured to use this host.
+	Current    bool `json:"current"`
+	Reachable  bool `json:"reachable"`
+	StatusCode int  `json:"statusCode,omitempty"`
+	// LatencyMs is the time until the first response byte, and DNSMs and
+	// TLSMs the time taken by name resolution and the TLS handshake. A
+	// step that did not happen, as DNS through a proxy, is omitted.
+	LatencyMs int64  `json:"latencyMs"`
+	DNSMs     *int64 `json:"dnsMs,omitempty"`
+	TLSMs     *int64 `json:"tlsMs,omitempty"`
+	// FailedStep is where an unreachable host failed: dns, connect, tls,
+	// or http.
+	FailedStep string `json:"failedStep,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// CheckEndpoints checks connectivity to the production API host of every
+// region, and to the configured host when it is none of them, with the
+// same client certificate and proxy settings as API requests. The hosts
+// are checked concurrently, each bounded by timeout. Like Ping, any HTTP
+// response means a host is reachable, and no credentials are sent. It
+// fails only if the client certificate cannot be loaded.
+func CheckEndpoints(ctx context.Context, cfg *config.Config, timeout time.Duration) ([]EndpointCheck, error) {
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Timeout = timeout
+
+	current := cfg.APIHostURL()
+	var checks []EndpointCheck
+	seen := make(map[string]bool)
+	for _, region := range []config.Region{config.RegionNA, config.RegionEU, config.RegionAPAC} {
+		regional := config.Config{TargetEnv: config.EnvProd, Region: region}
+		url := regional.APIHostURL()
+		seen[url] = true
+		checks = append(checks, EndpointCheck{Name: string(region), URL: url, Current: url == current})
+	}
+	if !seen[current] {
+		checks = append(checks, EndpointCheck{Name: "configured", URL: current, Current: true})
+	}
+
+	userAgent := fmt.Sprintf("Drata-Agent-CLI/%s", cfg.Version)
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(check *EndpointCheck) {
+			defer wg.Done()
+			checkEndpoint(ctx, httpClient, userAgent, check)
+		}(&checks[i])
+	}
+	wg.Wait()
+	return checks, nil
+}
+
+// checkEndpoint sends an unauthenticated GET to check's URL and records
+// whether it was reachable, how long each step took, and where it failed:
+// the last step started before the error.
+func checkEndpoint(ctx context.Context, httpClient *http.Client, userAgent string, check *EndpointCheck) {
+	// Trace hooks run on the transport's goroutines, concurrently when
+	// dialing both IPv4 and IPv6, and may still run after a timeout
+	var mu sync.Mutex
+	step := EndpointStepConnect
+	var dnsStart, tlsStart time.Time
+	var dnsMs, tlsMs *int64
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			step, dnsStart = EndpointStepDNS, time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			dnsMs = elapsedMs(dnsStart)
+		},
+		ConnectStart: func(_, _ string) {
+			mu.Lock()
+			defer mu.Unlock()
+			step = EndpointStepConnect
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			step, tlsStart = EndpointStepTLS, time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			tlsMs = elapsedMs(tlsStart)
+		},
+		WroteHeaders: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			step = EndpointStepHTTP
+		},
+	}
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, check.URL+"/", nil)
+	if err != nil {
+		check.FailedStep, check.Error = EndpointStepHTTP, err.Error()
+		return
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	check.LatencyMs = *elapsedMs(start)
+	mu.Lock()
+	check.DNSMs, check.TLSMs = dnsMs, tlsMs
+	failedStep := step
+	mu.Unlock()
+	if err != nil {
+		check.FailedStep = failedStep
+		check.Error = err.Error()
+		return
+	}
+	resp.Body.Close()
+	check.Reachable = true
+	check.StatusCode = resp.StatusCode
+}
+
+// elapsedMs returns the milliseconds since start.
+func elapsedMs(start time.Time) *int64 {
+	ms := time.Since(start).Milliseconds()
+	return &ms
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+)
+
+// failoverPaths are the POST routes that are safe to send again, to the
+// same host or another. Resending a sync payload only replaces the
+// device's state; registration and login are never resent.
+var failoverPaths = map[string]bool{
+	"/agentv2/sync": true,
+}
+
+// resendable reports whether a request may be sent again after the server
+// may already have received it.
+func resendable(method, path string) bool {
+	return method == http.MethodGet || failoverPaths[path]
+}
+
+// requestHosts returns the API hosts to try for a request, in order: the
+// host for the current region, then the hosts of the region_failover
+// regions for idempotent requests. An explicit api_base_url disables
+// failover. Regions that resolve to an already listed host are skipped, so
+// each host is tried at most once.
+func (c *Client) requestHosts(method, path string) []string {
+	hosts := []string{c.config.APIHostURL()}
+	if c.config.APIBaseURL != "" || !resendable(method, path) {
+		return hosts
+	}
+
+	seen := map[string]bool{hosts[0]: true}
+	for _, name := range c.config.RegionFailover {
+		region, err := config.ParseRegion(name)
+		if err != nil {
+			continue
+		}
+		regional := *c.config
+		regional.Region = region
+		if host := regional.APIHostURL(); !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// IsConnectionError reports whether err means the API host could not be
+// reached at all, such as a DNS failure, refused connection, or timeout.
+// HTTP error responses never get here, and cancellation is not a
+// connection failure.
+func IsConnectionError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+	return errors.As(err, &dnsErr) || errors.As(err, &opErr) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// isUnsentError reports whether err means the request never reached the
+// host: its name did not resolve, or the connection to it could not be
+// made. Only then is it certain the server did not act on the request; a
+// connection that failed after the request was written may have.
+func isUnsentError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect")
+}
+
+// isDroppedError reports whether err means the connection was reset or
+// closed before a complete response arrived, after the request may have
+// been sent.
+func isDroppedError(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
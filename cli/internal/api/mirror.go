@@ -0,0 +1,58 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/drata/drata-agent-cli/internal/osquery"
+	"github.com/drata/drata-agent-cli/internal/telemetry"
+)
+
+// Mirror posts the same payload Sync sends to Drata to the configured
+// mirror endpoint, with the configured mirror headers. Neither the Drata
+// access token nor a device key signature is ever sent to the mirror. The
+// last checked time records when Drata last accepted a payload, so a mirror
+// post never advances it, even with mirror_only.
+func (c *Client) Mirror(queryResult *osquery.QueryResult) (err error) {
+	ctx, span := telemetry.StartSpan(c.context(), "api.mirror")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	body, err := json.Marshal(queryResult)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.MirrorEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create mirror request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("Drata-Agent-CLI/%s (%s)", c.version, runtime.GOOS))
+	if uuid := c.dataStore.GetUUID(); uuid != "" {
+		req.Header.Set("Correlation-Id", uuid)
+	}
+	for _, header := range c.config.MirrorHeaders {
+		name, value, _ := strings.Cut(header, ":")
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to mirror: %w", err)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("mirror returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Backoff between retries of a request: the first wait is about
+// retryBaseDelay, doubling for each retry up to retryMaxDelay. They are
+// variables so tests can shorten them.
+var (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// doRequestWithRetries performs a request like doRequest, retrying up to
+// max_retries times after a 5xx response or a failure to reach the host
+// before the request was sent. A connection that was reset or closed after
+// the request was sent is retried only for requests that are safe to send
+// again, such as a sync; for others, such as registration, the server may
+// have acted on the request, so the failure is returned at once, as are
+// other responses, such as 4xx errors. After the last try, its response or
+// error is returned.
+//
+// Each try already fails over to the region_failover regions, so a try
+// fails only when every region's host did. The retries are within one sync
+// attempt: a sync that still fails is attempted again up to sync_attempts
+// times, each with its own retries, so a sync sends at most
+// sync_attempts * (max_retries + 1) requests to each host.
+func (c *Client) doRequestWithRetries(method, path string, body interface{}) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		resp, err := c.doRequest(method, path, body)
+		retryable := (err != nil && (isUnsentError(err) || (resendable(method, path) && isDroppedError(err)))) ||
+			(err == nil && resp.StatusCode >= http.StatusInternalServerError)
+		if !retryable || retry >= c.config.MaxRetries {
+			return resp, err
+		}
+
+		delay := retryDelay(retry)
+		if err != nil {
+			c.logVerbose("Request to %s failed (%v); retry %d of %d in %s", requestRoute(path), err, retry+1, c.config.MaxRetries, delay)
+		} else {
+			resp.Body.Close()
+			c.logVerbose("Request to %s failed with HTTP %d; retry %d of %d in %s", requestRoute(path), resp.StatusCode, retry+1, c.config.MaxRetries, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-c.context().Done():
+			timer.Stop()
+			return nil, c.context().Err()
+		}
+	}
+}
+
+// retryDelay returns the wait before the given retry, counting from 0:
+// retryBaseDelay doubled for each earlier retry, capped at retryMaxDelay,
+// with jitter that keeps it between half and all of that, so agents that
+// failed together do not retry together.
+func retryDelay(retry int) time.Duration {
+	delay := retryBaseDelay
+	for i := 0; i < retry && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/drata/drata-agent-cli/internal/config"
+)
+
+// newHTTPClient returns the HTTP client for API requests. When a client
+// certificate is configured, it is presented in the TLS handshake for
+// servers and proxies that require mutual TLS. Proxy settings from the
+// environment apply either way.
+func newHTTPClient(cfg *config.Config) (*http.Client, error) {
+	client := &http.Client{Timeout: requestTimeout}
+	if cfg.ClientCertPath == "" {
+		return client, nil
+	}
+
+	certificate, err := LoadClientCertificate(cfg.ClientCertPath, cfg.ClientKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+	client.Transport = transport
+	return client, nil
+}
+
+// LoadClientCertificate loads a PEM client certificate and its private key,
+// failing if either cannot be read or they do not belong together.
+func LoadClientCertificate(certPath, keyPath string) (tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load client certificate %s with key %s: %w", certPath, keyPath, err)
+	}
+	return certificate, nil
+}
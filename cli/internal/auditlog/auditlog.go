@@ -0,0 +1,132 @@
+// Package auditlog writes sync audit events to the platform's system log,
+// syslog on Unix and the Event Log on Windows, for SIEMs that collect from
+// there. It is separate from the agent's operational logging.
+package auditlog
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/drata/drata-agent-cli/internal/osquery"
+)
+
+// source is the program name events are logged under: the syslog tag, and
+// the Windows Event Log source.
+const source = "drata-agent"
+
+// Audit events. Each is written as one JSON object whose "event" field is
+// one of these.
+const (
+	EventSyncSucceeded = "sync.succeeded"
+	EventSyncFailed    = "sync.failed"
+	EventSyncSkipped   = "sync.skipped"
+)
+
+// writer writes messages to the system log at a severity.
+type writer interface {
+	Info(event, message string) error
+	Error(event, message string) error
+	Close() error
+}
+
+// Logger writes audit events to the system log. A nil Logger discards
+// them, so callers need not check whether the system log is enabled.
+type Logger struct {
+	w       writer
+	version string
+	summary bool
+}
+
+// Open opens the system log for audit events from the given agent version.
+// With summary, successful syncs also list the checks that were sent.
+func Open(version string, summary bool) (*Logger, error) {
+	w, err := openWriter()
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{w: w, version: version, summary: summary}, nil
+}
+
+// Close closes the system log.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.w.Close()
+}
+
+// SyncSucceeded records a sync that sent result.
+func (l *Logger) SyncSucceeded(result *osquery.QueryResult) error {
+	if l == nil {
+		return nil
+	}
+	event := map[string]interface{}{
+		"platform":  result.Platform,
+		"manualRun": result.ManualRun,
+		"partial":   result.Partial,
+	}
+	if l.summary {
+		event["summary"] = summarize(result)
+	}
+	return l.write(EventSyncSucceeded, event)
+}
+
+// SyncFailed records a sync that failed at step.
+func (l *Logger) SyncFailed(step string, err error) error {
+	if l == nil {
+		return nil
+	}
+	return l.write(EventSyncFailed, map[string]interface{}{
+		"step":  step,
+		"error": err.Error(),
+	})
+}
+
+// SyncSkipped records a sync that was skipped for reason.
+func (l *Logger) SyncSkipped(reason string) error {
+	if l == nil {
+		return nil
+	}
+	return l.write(EventSyncSkipped, map[string]interface{}{
+		"reason": reason,
+	})
+}
+
+// write adds the event name and agent version to fields and writes them as
+// one JSON line, at error severity for failures.
+func (l *Logger) write(event string, fields map[string]interface{}) error {
+	fields["event"] = event
+	fields["agentVersion"] = l.version
+	message, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if event == EventSyncFailed {
+		return l.w.Error(event, string(message))
+	}
+	return l.w.Info(event, string(message))
+}
+
+// summarize lists which checks a sync sent, skipped, found empty, or saw
+// errors in, without any of the collected values.
+func summarize(result *osquery.QueryResult) map[string]interface{} {
+	results := make([]string, 0, len(result.RawQueryResults))
+	for name := range result.RawQueryResults {
+		results = append(results, name)
+	}
+	sort.Strings(results)
+
+	summary := map[string]interface{}{
+		"results": results,
+	}
+	if len(result.SkippedChecks) > 0 {
+		summary["skippedChecks"] = result.SkippedChecks
+	}
+	if len(result.EmptyChecks) > 0 {
+		summary["emptyChecks"] = result.EmptyChecks
+	}
+	if len(result.CheckErrors) > 0 {
+		summary["checkErrors"] = result.CheckErrors
+	}
+	return summary
+}
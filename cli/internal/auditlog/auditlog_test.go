@@ -0,0 +1,95 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/drata/drata-agent-cli/internal/osquery"
+)
+
+// entry is a message written to a fakeWriter.
+type entry struct {
+	severity string
+	event    string
+	fields   map[string]interface{}
+}
+
+// fakeWriter records messages instead of writing them to the system log.
+type fakeWriter struct {
+	entries []entry
+}
+
+func (f *fakeWriter) add(severity, event, message string) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &fields); err != nil {
+		return err
+	}
+	f.entries = append(f.entries, entry{severity: severity, event: event, fields: fields})
+	return nil
+}
+
+func (f *fakeWriter) Info(event, message string) error  { return f.add("info", event, message) }
+func (f *fakeWriter) Error(event, message string) error { return f.add("error", event, message) }
+func (f *fakeWriter) Close() error                      { return nil }
+
+func TestLogger(t *testing.T) {
+	w := &fakeWriter{}
+	logger := &Logger{w: w, version: "1.2.3", summary: true}
+
+	result := &osquery.QueryResult{
+		Platform:        osquery.PlatformLinux,
+		RawQueryResults: map[string]interface{}{"osVersion": "secret", "firewallStatus": 1},
+		CheckErrors:     map[string]string{"diskEncryption": "no such table"},
+	}
+	if err := logger.SyncSucceeded(result); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.SyncFailed("upload", errors.New("connection refused")); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.SyncSkipped("outside sync window"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(w.entries))
+	}
+
+	succeeded := w.entries[0]
+	if succeeded.severity != "info" || succeeded.fields["event"] != EventSyncSucceeded || succeeded.fields["agentVersion"] != "1.2.3" {
+		t.Errorf("unexpected success entry: %+v", succeeded)
+	}
+	summary, _ := succeeded.fields["summary"].(map[string]interface{})
+	if !reflect.DeepEqual(summary["results"], []interface{}{"firewallStatus", "osVersion"}) {
+		t.Errorf("expected sorted result names in summary, got %v", summary["results"])
+	}
+	if _, ok := summary["checkErrors"]; !ok {
+		t.Error("expected check errors in summary")
+	}
+
+	failed := w.entries[1]
+	if failed.severity != "error" || failed.fields["step"] != "upload" || failed.fields["error"] != "connection refused" {
+		t.Errorf("unexpected failure entry: %+v", failed)
+	}
+
+	skipped := w.entries[2]
+	if skipped.severity != "info" || skipped.fields["reason"] != "outside sync window" {
+		t.Errorf("unexpected skip entry: %+v", skipped)
+	}
+
+	// Without summary, only the outcome is written
+	w.entries = nil
+	logger.summary = false
+	logger.SyncSucceeded(result)
+	if _, ok := w.entries[0].fields["summary"]; ok {
+		t.Error("expected no summary")
+	}
+
+	// A nil logger discards events
+	var disabled *Logger
+	if err := disabled.SyncFailed("upload", errors.New("ignored")); err != nil {
+		t.Errorf("expected nil logger to discard events, got %v", err)
+	}
+}
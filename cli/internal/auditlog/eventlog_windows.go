@@ -0,0 +1,44 @@
+//go:build windows
+
+package auditlog
+
+import (
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Windows Event Log event IDs, one per event.
+var eventIDs = map[string]uint32{
+	EventSyncSucceeded: 1,
+	EventSyncFailed:    2,
+	EventSyncSkipped:   3,
+}
+
+// eventLogWriter writes events to the Windows Application event log.
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+func openWriter() (writer, error) {
+	// Registering the source needs administrator rights and fails once it
+	// exists; events are still written without it, only with a generic
+	// description around the message
+	_ = eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+
+	log, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return eventLogWriter{log: log}, nil
+}
+
+func (e eventLogWriter) Info(event, message string) error {
+	return e.log.Info(eventIDs[event], message)
+}
+
+func (e eventLogWriter) Error(event, message string) error {
+	return e.log.Error(eventIDs[event], message)
+}
+
+func (e eventLogWriter) Close() error {
+	return e.log.Close()
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package auditlog
+
+import (
+	"log/syslog"
+)
+
+// syslogWriter writes events to the local syslog daemon under the daemon
+// facility.
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func openWriter() (writer, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, source)
+	if err != nil {
+		return nil, err
+	}
+	return syslogWriter{w: w}, nil
+}
+
+func (s syslogWriter) Info(event, message string) error {
+	return s.w.Info(message)
+}
+
+func (s syslogWriter) Error(event, message string) error {
+	return s.w.Err(message)
+}
+
+func (s syslogWriter) Close() error {
+	return s.w.Close()
+}
@@ -2,10 +2,17 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -19,6 +26,20 @@ const (
 	RegionAPAC Region = "APAC"
 )
 
+// DefaultRegion is the region used when neither region nor default_region
+// is configured. A build for one region's customers can change it with
+// -ldflags "-X github.com/drata/drata-agent-cli/internal/config.DefaultRegion=EU".
+// An invalid value falls back to NA.
+var DefaultRegion = string(RegionNA)
+
+// builtInRegion returns DefaultRegion, or NA if it is not a region.
+func builtInRegion() Region {
+	if region, err := ParseRegion(DefaultRegion); err == nil {
+		return region
+	}
+	return RegionNA
+}
+
 // TargetEnv represents the target environment.
 type TargetEnv string
 
@@ -29,39 +50,300 @@ const (
 	EnvProd  TargetEnv = "PROD"
 )
 
+// MacRandomization controls how randomized MAC addresses are treated when
+// selecting the device MAC.
+type MacRandomization string
+
+const (
+	// MacRandomizationPreferStable uses a randomized MAC only when no
+	// interface has a stable one.
+	MacRandomizationPreferStable MacRandomization = "prefer_stable"
+	// MacRandomizationExclude never reports a randomized MAC.
+	MacRandomizationExclude MacRandomization = "exclude"
+	// MacRandomizationAllow treats randomized MACs like any other.
+	MacRandomizationAllow MacRandomization = "allow"
+)
+
+// WSLBehavior controls how the agent behaves under Windows Subsystem for Linux.
+type WSLBehavior string
+
+const (
+	// WSLBehaviorMark reports host-dependent controls as not applicable.
+	WSLBehaviorMark WSLBehavior = "mark"
+	// WSLBehaviorRefuse refuses to sync under WSL.
+	WSLBehaviorRefuse WSLBehavior = "refuse"
+	// WSLBehaviorCollect collects as on any other Linux system.
+	WSLBehaviorCollect WSLBehavior = "collect"
+)
+
+// CloneBehavior controls what the daemon does when it starts on a machine
+// whose hardware does not match the one the agent was registered on.
+type CloneBehavior string
+
+const (
+	// CloneBehaviorReregister registers the machine under a new UUID with
+	// the magic link token in clone_token_file.
+	CloneBehaviorReregister CloneBehavior = "reregister"
+	// CloneBehaviorWarn logs a warning and continues.
+	CloneBehaviorWarn CloneBehavior = "warn"
+	// CloneBehaviorIgnore skips clone detection.
+	CloneBehaviorIgnore CloneBehavior = "ignore"
+)
+
+// OsqueryPreference selects which osqueryi installation is searched first.
+type OsqueryPreference string
+
+const (
+	// OsqueryPreferVendored prefers the copy shipped with the Drata Agent.
+	OsqueryPreferVendored OsqueryPreference = "vendored"
+	// OsqueryPreferSystem prefers a system-wide osquery installation.
+	OsqueryPreferSystem OsqueryPreference = "system"
+	// OsqueryPreferPath prefers whatever osqueryi is first on PATH.
+	OsqueryPreferPath OsqueryPreference = "path"
+)
+
+// SystemLogMode selects which audit events are written to the system log.
+type SystemLogMode string
+
+const (
+	// SystemLogOff writes nothing to the system log.
+	SystemLogOff SystemLogMode = "off"
+	// SystemLogOutcomes writes whether each sync succeeded, failed, or was
+	// skipped.
+	SystemLogOutcomes SystemLogMode = "outcomes"
+	// SystemLogSummary also summarizes the checks a successful sync sent.
+	SystemLogSummary SystemLogMode = "summary"
+)
+
+// AppMatch selects how required_apps and prohibited_apps entries are
+// compared with installed app names.
+type AppMatch string
+
+const (
+	// AppMatchSubstring matches apps whose name contains the entry.
+	AppMatchSubstring AppMatch = "substring"
+	// AppMatchExact matches apps whose name is the entry.
+	AppMatchExact AppMatch = "exact"
+)
+
+// TokenStorage selects where the access and refresh tokens are kept at rest.
+type TokenStorage string
+
+const (
+	// TokenStorageFile keeps the tokens in app-data.json.
+	TokenStorageFile TokenStorage = "file"
+	// TokenStorageKeyring keeps the tokens in the OS secret store.
+	TokenStorageKeyring TokenStorage = "keyring"
+)
+
 // Config holds all configuration for the CLI.
 type Config struct {
 	// API configuration
 	Region    Region    `mapstructure:"region"`
 	TargetEnv TargetEnv `mapstructure:"target_env"`
+	// APIBaseURL overrides the API URL derived from region and environment
+	APIBaseURL string `mapstructure:"api_base_url"`
+	// RegionFailover lists regions whose hosts idempotent requests fall
+	// back to, in order, when the region's host cannot be connected to
+	RegionFailover []string `mapstructure:"region_failover"`
+	// DefaultRegion is the region used when region is not set, such as by
+	// register without --region; empty uses the built-in default
+	DefaultRegion Region `mapstructure:"default_region"`
+	// ClientCertPath and ClientKeyPath are a PEM client certificate and key
+	// presented for mutual TLS
+	ClientCertPath string `mapstructure:"client_cert_path"`
+	ClientKeyPath  string `mapstructure:"client_key_path"`
+	// TokenStorage is where the tokens are kept: in app-data.json, or
+	// in the OS keyring, falling back to the file where none is available
+	TokenStorage TokenStorage `mapstructure:"token_storage"`
 
 	// Sync configuration
 	SyncIntervalHours      int `mapstructure:"sync_interval_hours"`
 	MinHoursSinceLastSync  int `mapstructure:"min_hours_since_last_sync"`
 	MinMinutesBetweenSyncs int `mapstructure:"min_minutes_between_syncs"`
+	SyncAttempts           int `mapstructure:"sync_attempts"`
+	SyncRetryWaitSeconds   int `mapstructure:"sync_retry_wait_seconds"`
+	// HeartbeatWhenThrottled has the daemon send a minimal sync of the
+	// device identifiers when min_hours_since_last_sync skips a full sync
+	HeartbeatWhenThrottled bool `mapstructure:"heartbeat_when_throttled"`
+	// HeartbeatIntervalMinutes schedules the same heartbeat between the
+	// daemon's full syncs; 0 disables it
+	HeartbeatIntervalMinutes int `mapstructure:"heartbeat_interval_minutes"`
+	// MaxRetries is how many times a sync or registration request is
+	// retried, with exponential backoff, after a 5xx response or a failure
+	// to reach the host before sending; each of the sync_attempts gets its
+	// own retries
+	MaxRetries int `mapstructure:"max_retries"`
+	// ConnectionFailureThreshold is how many consecutive sync attempts must
+	// fail to reach Drata before the sync state shows an error; until then
+	// it is deferred
+	ConnectionFailureThreshold int `mapstructure:"connection_failure_threshold"`
+	// SkipUnchangedSyncs has the daemon skip uploading a payload identical
+	// to the last one uploaded, uploading it anyway once a day
+	SkipUnchangedSyncs bool `mapstructure:"skip_unchanged_syncs"`
+	// MaxRuntime is a duration, such as 24h, after which the daemon exits so
+	// its supervisor restarts it; empty disables it
+	MaxRuntime string `mapstructure:"max_runtime"`
+	// SyncOnStart has the daemon sync shortly after it starts; when unset,
+	// its first sync is the first scheduled one
+	SyncOnStart bool `mapstructure:"sync_on_start"`
+	// InitialSyncDelayMinSeconds and InitialSyncDelayMaxSeconds bound the
+	// daemon's delay before its first sync, picked per device so a fleet
+	// started together does not sync at once
+	InitialSyncDelayMinSeconds int `mapstructure:"initial_sync_delay_min_seconds"`
+	InitialSyncDelayMaxSeconds int `mapstructure:"initial_sync_delay_max_seconds"`
+	// BootSyncDelayMaxSeconds widens the initial sync delay when the machine
+	// booted less than BootUptimeThresholdMinutes ago; 0 disables it
+	BootSyncDelayMaxSeconds    int `mapstructure:"boot_sync_delay_max_seconds"`
+	BootUptimeThresholdMinutes int `mapstructure:"boot_uptime_threshold_minutes"`
+	// SyncWindow limits scheduled daemon syncs to daily "HH:MM-HH:MM"
+	// ranges in SyncWindowTimezone, or local time; empty allows any time
+	SyncWindow         string `mapstructure:"sync_window"`
+	SyncWindowTimezone string `mapstructure:"sync_window_timezone"`
 
 	// osquery configuration
-	OsqueryPath string `mapstructure:"osquery_path"`
+	OsqueryPath   string            `mapstructure:"osquery_path"`
+	OsqueryPrefer OsqueryPreference `mapstructure:"osquery_prefer"`
+	// ExpectedOsqueryVersion is the osquery version a warning is logged for
+	// differing from; empty means the bundled version and "any" disables it
+	ExpectedOsqueryVersion string `mapstructure:"expected_osquery_version"`
+	// OsqueryFlags and OsqueryFlagfile are extra arguments passed to osqueryi
+	OsqueryFlags    []string `mapstructure:"osquery_flags"`
+	OsqueryFlagfile string   `mapstructure:"osquery_flagfile"`
+	// CollectAsUser runs collection in a child process as this user when
+	// the agent runs as root, leaving the token and upload to the parent;
+	// empty collects in the agent's own process. Unix only
+	CollectAsUser string `mapstructure:"collect_as_user"`
+
+	// Device identifier configuration
+	MacInterfaceAllowlist        []string `mapstructure:"mac_interface_allowlist"`
+	MacInterfaceDenylist         []string `mapstructure:"mac_interface_denylist"`
+	MacIncludeInactiveInterfaces bool     `mapstructure:"mac_include_inactive_interfaces"`
+	// MacRandomization controls whether randomized, locally administered
+	// MACs, which change between Wi-Fi networks, may be the device MAC
+	MacRandomization MacRandomization `mapstructure:"mac_randomization"`
+	// RequireIdentifiers refuses registration when no hardware or board
+	// serial, device name, or asset tag is available
+	RequireIdentifiers bool `mapstructure:"require_identifiers"`
+	// DeviceName and AssetTag are sent with registration and every sync
+	DeviceName string `mapstructure:"device_name"`
+	AssetTag   string `mapstructure:"asset_tag"`
+
+	// Check selection
+	EnabledChecks  []string `mapstructure:"enabled_checks"`
+	DisabledChecks []string `mapstructure:"disabled_checks"`
+
+	// CriticalChecks must produce data for a sync to upload when
+	// FailOnMissingCritical is set
+	CriticalChecks        []string `mapstructure:"critical_checks"`
+	FailOnMissingCritical bool     `mapstructure:"fail_on_missing_critical"`
+
+	// RequiredApps and ProhibitedApps are reported as installed or not in
+	// the appPolicy check, matched against app names as AppMatch says
+	RequiredApps   []string `mapstructure:"required_apps"`
+	ProhibitedApps []string `mapstructure:"prohibited_apps"`
+	AppMatch       AppMatch `mapstructure:"app_match"`
+
+	// SanctionedRemoteAccess and ProhibitedRemoteAccess classify the tools
+	// reported by the remoteAccess check
+	SanctionedRemoteAccess []string `mapstructure:"sanctioned_remote_access"`
+	ProhibitedRemoteAccess []string `mapstructure:"prohibited_remote_access"`
+
+	// CollectProcesses turns on the opt-in processSnapshot check, which
+	// reports up to ProcessSnapshotMaxRows running executables, with their
+	// hashes when ProcessSnapshotHashes is set
+	CollectProcesses       bool `mapstructure:"collect_processes"`
+	ProcessSnapshotMaxRows int  `mapstructure:"process_snapshot_max_rows"`
+	ProcessSnapshotHashes  bool `mapstructure:"process_snapshot_hashes"`
+
+	// OSEOLOnlineLookup refreshes OS end-of-life dates from endoflife.date
+	OSEOLOnlineLookup bool `mapstructure:"os_eol_online_lookup"`
+
+	// CollectionBudgetSeconds bounds how long collection keeps starting
+	// checks; 0 disables it
+	CollectionBudgetSeconds int `mapstructure:"collection_budget_seconds"`
+
+	// MaxFieldBytes caps the size of each collected string value; 0 disables it
+	MaxFieldBytes int `mapstructure:"max_field_bytes"`
+
+	// MaxQueryOutputBytes caps the output read from each osquery query or
+	// command, which is killed and fails once over it; 0 disables it
+	MaxQueryOutputBytes int `mapstructure:"max_query_output_bytes"`
+
+	// Platform behavior
+	WSLBehavior WSLBehavior   `mapstructure:"wsl_behavior"`
+	OnClone     CloneBehavior `mapstructure:"on_clone"`
+	// CloneTokenFile is a file holding a fresh magic link token that
+	// on_clone reregister registers a cloned machine with
+	CloneTokenFile string `mapstructure:"clone_token_file"`
+	// VirtualNotApplicable reports device controls as not applicable in a
+	// detected container or VM
+	VirtualNotApplicable bool `mapstructure:"virtual_not_applicable"`
+
+	// Hook configuration
+	PreSyncHook string `mapstructure:"pre_sync_hook"`
+
+	// SignPayloads signs requests with a per-device key whose public half
+	// is pinned at registration
+	SignPayloads bool `mapstructure:"sign_payloads"`
+
+	// MirrorEndpoint receives a copy of every sync payload, sent with the
+	// "Name: value" MirrorHeaders; MirrorOnly sends it there instead of Drata
+	MirrorEndpoint string   `mapstructure:"mirror_endpoint"`
+	MirrorHeaders  []string `mapstructure:"mirror_headers"`
+	MirrorOnly     bool     `mapstructure:"mirror_only"`
+
+	// SystemLog writes sync audit events to syslog or the Windows Event Log
+	SystemLog SystemLogMode `mapstructure:"system_log"`
+
+	// Telemetry configuration
+	OtelEndpoint string `mapstructure:"otel_endpoint"`
 
 	// CLI version
 	Version string `mapstructure:"version"`
+
+	// sources records where each non-default setting came from
+	sources map[string]string
 }
 
 // DefaultConfig returns the default configuration.
 func DefaultConfig() *Config {
 	return &Config{
-		Region:                 RegionNA,
-		TargetEnv:              EnvProd,
-		SyncIntervalHours:      2,
-		MinHoursSinceLastSync:  24,
-		MinMinutesBetweenSyncs: 15,
-		OsqueryPath:            "",
-		Version:                "3.9.9-cli",
+		Region:                     builtInRegion(),
+		TargetEnv:                  EnvProd,
+		SyncIntervalHours:          2,
+		MinHoursSinceLastSync:      24,
+		MinMinutesBetweenSyncs:     15,
+		SyncAttempts:               1,
+		ConnectionFailureThreshold: 3,
+		SyncRetryWaitSeconds:       30,
+		MaxRetries:                 3,
+		SyncOnStart:                true,
+		InitialSyncDelayMinSeconds: 10,
+		InitialSyncDelayMaxSeconds: 60,
+		BootUptimeThresholdMinutes: 10,
+		OsqueryPath:                "",
+		OsqueryPrefer:              OsqueryPreferVendored,
+		TokenStorage:               TokenStorageFile,
+		AppMatch:                   AppMatchSubstring,
+		ProcessSnapshotMaxRows:     500,
+		SystemLog:                  SystemLogOff,
+		MaxFieldBytes:              65536,
+		MaxQueryOutputBytes:        64 << 20,
+		WSLBehavior:                WSLBehaviorMark,
+		MacRandomization:           MacRandomizationPreferStable,
+		OnClone:                    CloneBehaviorWarn,
+		Version:                    "3.9.9-cli",
 	}
 }
 
-// APIHostURL returns the API host URL based on environment and region.
+// APIHostURL returns the API host URL: APIBaseURL if set, otherwise the URL
+// for the environment and region.
 func (c *Config) APIHostURL() string {
+	if c.APIBaseURL != "" {
+		return strings.TrimRight(c.APIBaseURL, "/")
+	}
+
 	apiURLs := map[TargetEnv]map[Region]string{
 		EnvLocal: {
 			RegionNA:   "http://localhost:3000",
@@ -145,32 +427,897 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	cfg.sources = make(map[string]string)
+	for key := range cfg.Settings() {
+		if os.Getenv("DRATA_"+strings.ToUpper(key)) != "" {
+			cfg.sources[key] = SourceEnv
+		} else if viper.InConfig(key) {
+			cfg.sources[key] = SourceUser
+		}
+	}
+
+	// Managed settings override everything else
+	if err := applyManaged(cfg); err != nil {
+		return nil, err
+	}
+	cfg.applyDefaultRegion()
+
 	return cfg, nil
 }
 
-// Save saves the configuration to file.
+// applyDefaultRegion uses default_region as the region when region is not
+// set anywhere, so a deployment can change the default without pinning
+// the region itself.
+func (c *Config) applyDefaultRegion() {
+	if c.DefaultRegion == "" || c.Source("region") != SourceDefault {
+		return
+	}
+	if region, err := ParseRegion(string(c.DefaultRegion)); err == nil {
+		c.Region = region
+	}
+}
+
+// Save saves the configuration to file, holding the config lock so it does
+// not interleave with other writers.
 func (c *Config) Save() error {
+	configPath, err := configFilePath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(configPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.writeFile(configPath)
+}
+
+// UpdateSetting sets a single setting in the saved configuration. The file
+// is locked and re-read before the change is applied, so concurrent updates
+// of different settings do not overwrite each other.
+func UpdateSetting(key, value string) error {
+	configPath, err := configFilePath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(configPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cfg, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	if err := cfg.CheckNotManaged(key); err != nil {
+		return err
+	}
+	if err := cfg.Set(key, value); err != nil {
+		return err
+	}
+	cfg.sources[key] = SourceUser
+	if err := cfg.writeFile(configPath); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	return nil
+}
+
+// configFilePath returns the path of the config file, creating its
+// directory if needed.
+func configFilePath() (string, error) {
 	configDir, err := getConfigDir()
 	if err != nil {
-		return fmt.Errorf("failed to get config directory: %w", err)
+		return "", fmt.Errorf("failed to get config directory: %w", err)
 	}
 
 	// Ensure config directory exists
 	if err := os.MkdirAll(configDir, 0700); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+		return "", fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	return filepath.Join(configDir, "config.yaml"), nil
+}
+
+// readConfigFile reads the saved configuration at path on top of the
+// defaults, ignoring environment overrides so they are never persisted.
+// A missing file yields the defaults. Keys in the file are marked as set
+// by the user. Managed keys are marked but their values are not applied,
+// so they are not copied into the user's file.
+func readConfigFile(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	managed := &Config{sources: make(map[string]string)}
+	if err := applyManaged(managed); err != nil {
+		return nil, err
+	}
+	cfg.sources = managed.sources
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return cfg, nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	cfg.markUserKeys(v)
+	return cfg, nil
+}
+
+// markUserKeys marks the settings v has a value for as set by the user,
+// unless they are managed.
+func (c *Config) markUserKeys(v *viper.Viper) {
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	for key := range c.Settings() {
+		if v.InConfig(key) && !c.IsManaged(key) {
+			c.sources[key] = SourceUser
+		}
+	}
+}
+
+// writeFile writes the settings the user set to path, leaving out those
+// still at their defaults so that a later change of default, such as
+// default_region, takes effect. The caller must hold the config lock.
+func (c *Config) writeFile(path string) error {
+	v := viper.New()
+	for key, value := range c.Settings() {
+		if c.Source(key) == SourceUser {
+			v.Set(key, value)
+		}
 	}
+	v.Set("version", c.Version)
+
+	return v.WriteConfigAs(path)
+}
 
-	// Set values in viper
-	viper.Set("region", string(c.Region))
-	viper.Set("target_env", string(c.TargetEnv))
-	viper.Set("sync_interval_hours", c.SyncIntervalHours)
-	viper.Set("min_hours_since_last_sync", c.MinHoursSinceLastSync)
-	viper.Set("min_minutes_between_syncs", c.MinMinutesBetweenSyncs)
-	viper.Set("osquery_path", c.OsqueryPath)
-	viper.Set("version", c.Version)
+// Settings returns the user-configurable settings keyed by their config
+// file names. It is the portable form of the configuration used by export.
+func (c *Config) Settings() map[string]interface{} {
+	return map[string]interface{}{
+		"region":                          string(c.Region),
+		"target_env":                      string(c.TargetEnv),
+		"api_base_url":                    c.APIBaseURL,
+		"region_failover":                 c.RegionFailover,
+		"default_region":                  string(c.DefaultRegion),
+		"client_cert_path":                c.ClientCertPath,
+		"client_key_path":                 c.ClientKeyPath,
+		"token_storage":                   string(c.TokenStorage),
+		"sync_interval_hours":             c.SyncIntervalHours,
+		"min_hours_since_last_sync":       c.MinHoursSinceLastSync,
+		"min_minutes_between_syncs":       c.MinMinutesBetweenSyncs,
+		"sync_attempts":                   c.SyncAttempts,
+		"connection_failure_threshold":    c.ConnectionFailureThreshold,
+		"sync_retry_wait_seconds":         c.SyncRetryWaitSeconds,
+		"heartbeat_when_throttled":        c.HeartbeatWhenThrottled,
+		"heartbeat_interval_minutes":      c.HeartbeatIntervalMinutes,
+		"max_retries":                     c.MaxRetries,
+		"skip_unchanged_syncs":            c.SkipUnchangedSyncs,
+		"sync_on_start":                   c.SyncOnStart,
+		"max_runtime":                     c.MaxRuntime,
+		"initial_sync_delay_min_seconds":  c.InitialSyncDelayMinSeconds,
+		"initial_sync_delay_max_seconds":  c.InitialSyncDelayMaxSeconds,
+		"boot_sync_delay_max_seconds":     c.BootSyncDelayMaxSeconds,
+		"boot_uptime_threshold_minutes":   c.BootUptimeThresholdMinutes,
+		"sync_window":                     c.SyncWindow,
+		"sync_window_timezone":            c.SyncWindowTimezone,
+		"osquery_path":                    c.OsqueryPath,
+		"osquery_prefer":                  string(c.OsqueryPrefer),
+		"expected_osquery_version":        c.ExpectedOsqueryVersion,
+		"osquery_flags":                   c.OsqueryFlags,
+		"osquery_flagfile":                c.OsqueryFlagfile,
+		"collect_as_user":                 c.CollectAsUser,
+		"mac_interface_allowlist":         c.MacInterfaceAllowlist,
+		"mac_interface_denylist":          c.MacInterfaceDenylist,
+		"mac_include_inactive_interfaces": c.MacIncludeInactiveInterfaces,
+		"mac_randomization":               string(c.MacRandomization),
+		"require_identifiers":             c.RequireIdentifiers,
+		"device_name":                     c.DeviceName,
+		"asset_tag":                       c.AssetTag,
+		"enabled_checks":                  c.EnabledChecks,
+		"disabled_checks":                 c.DisabledChecks,
+		"critical_checks":                 c.CriticalChecks,
+		"required_apps":                   c.RequiredApps,
+		"prohibited_apps":                 c.ProhibitedApps,
+		"app_match":                       string(c.AppMatch),
+		"sanctioned_remote_access":        c.SanctionedRemoteAccess,
+		"prohibited_remote_access":        c.ProhibitedRemoteAccess,
+		"fail_on_missing_critical":        c.FailOnMissingCritical,
+		"os_eol_online_lookup":            c.OSEOLOnlineLookup,
+		"collection_budget_seconds":       c.CollectionBudgetSeconds,
+		"max_field_bytes":                 c.MaxFieldBytes,
+		"max_query_output_bytes":          c.MaxQueryOutputBytes,
+		"wsl_behavior":                    string(c.WSLBehavior),
+		"on_clone":                        string(c.OnClone),
+		"clone_token_file":                c.CloneTokenFile,
+		"virtual_not_applicable":          c.VirtualNotApplicable,
+		"pre_sync_hook":                   c.PreSyncHook,
+		"sign_payloads":                   c.SignPayloads,
+		"mirror_endpoint":                 c.MirrorEndpoint,
+		"mirror_headers":                  c.MirrorHeaders,
+		"mirror_only":                     c.MirrorOnly,
+		"system_log":                      string(c.SystemLog),
+		"otel_endpoint":                   c.OtelEndpoint,
+	}
+}
 
-	configPath := filepath.Join(configDir, "config.yaml")
-	return viper.WriteConfigAs(configPath)
+// IsSettingKey reports whether key is a user-configurable setting.
+func IsSettingKey(key string) bool {
+	_, ok := (&Config{}).Settings()[key]
+	return ok
+}
+
+// Set parses value and assigns it to the setting named key.
+func (c *Config) Set(key, value string) error {
+	switch key {
+	case "region":
+		region, err := ParseRegion(value)
+		if err != nil {
+			return err
+		}
+		c.Region = region
+	case "default_region":
+		if value == "" {
+			c.DefaultRegion = ""
+			break
+		}
+		region, err := ParseRegion(value)
+		if err != nil {
+			return err
+		}
+		c.DefaultRegion = region
+	case "target_env":
+		env, err := ParseTargetEnv(value)
+		if err != nil {
+			return err
+		}
+		c.TargetEnv = env
+	case "api_base_url":
+		if err := ValidateEndpointURL(value); err != nil {
+			return fmt.Errorf("api_base_url %w", err)
+		}
+		c.APIBaseURL = value
+	case "region_failover":
+		var regions []string
+		for _, name := range ParseList(value) {
+			region, err := ParseRegion(name)
+			if err != nil {
+				return fmt.Errorf("region_failover: %w", err)
+			}
+			regions = append(regions, string(region))
+		}
+		c.RegionFailover = regions
+	case "client_cert_path":
+		if value != "" && !filepath.IsAbs(value) {
+			return fmt.Errorf("client_cert_path must be an absolute path")
+		}
+		c.ClientCertPath = value
+	case "client_key_path":
+		if value != "" && !filepath.IsAbs(value) {
+			return fmt.Errorf("client_key_path must be an absolute path")
+		}
+		c.ClientKeyPath = value
+	case "token_storage":
+		storage, err := ParseTokenStorage(value)
+		if err != nil {
+			return err
+		}
+		c.TokenStorage = storage
+	case "sync_interval_hours":
+		interval, err := strconv.Atoi(value)
+		if err != nil || interval < 1 {
+			return fmt.Errorf("sync_interval_hours must be a positive integer")
+		}
+		c.SyncIntervalHours = interval
+	case "min_hours_since_last_sync":
+		hours, err := strconv.Atoi(value)
+		if err != nil || hours < 0 {
+			return fmt.Errorf("min_hours_since_last_sync must be a non-negative integer")
+		}
+		c.MinHoursSinceLastSync = hours
+	case "min_minutes_between_syncs":
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 0 {
+			return fmt.Errorf("min_minutes_between_syncs must be a non-negative integer")
+		}
+		c.MinMinutesBetweenSyncs = minutes
+	case "sync_attempts":
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 1 {
+			return fmt.Errorf("sync_attempts must be a positive integer")
+		}
+		c.SyncAttempts = attempts
+	case "connection_failure_threshold":
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 1 {
+			return fmt.Errorf("connection_failure_threshold must be a positive integer")
+		}
+		c.ConnectionFailureThreshold = threshold
+	case "sync_retry_wait_seconds":
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("sync_retry_wait_seconds must be a non-negative integer")
+		}
+		c.SyncRetryWaitSeconds = seconds
+	case "heartbeat_when_throttled":
+		heartbeat, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("heartbeat_when_throttled must be true or false")
+		}
+		c.HeartbeatWhenThrottled = heartbeat
+	case "heartbeat_interval_minutes":
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 0 || minutes > MaxHeartbeatIntervalMinutes {
+			return fmt.Errorf("heartbeat_interval_minutes must be an integer from 0 to %d", MaxHeartbeatIntervalMinutes)
+		}
+		c.HeartbeatIntervalMinutes = minutes
+	case "max_retries":
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return fmt.Errorf("max_retries must be a non-negative integer")
+		}
+		c.MaxRetries = retries
+	case "skip_unchanged_syncs":
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("skip_unchanged_syncs must be true or false")
+		}
+		c.SkipUnchangedSyncs = skip
+	case "sync_on_start":
+		sync, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("sync_on_start must be true or false")
+		}
+		c.SyncOnStart = sync
+	case "initial_sync_delay_min_seconds":
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("initial_sync_delay_min_seconds must be a non-negative integer")
+		}
+		if seconds > c.InitialSyncDelayMaxSeconds {
+			return fmt.Errorf("initial_sync_delay_min_seconds must be at most initial_sync_delay_max_seconds (%d); raise that first", c.InitialSyncDelayMaxSeconds)
+		}
+		c.InitialSyncDelayMinSeconds = seconds
+	case "initial_sync_delay_max_seconds":
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("initial_sync_delay_max_seconds must be a non-negative integer")
+		}
+		if seconds < c.InitialSyncDelayMinSeconds {
+			return fmt.Errorf("initial_sync_delay_max_seconds must be at least initial_sync_delay_min_seconds (%d); lower that first", c.InitialSyncDelayMinSeconds)
+		}
+		c.InitialSyncDelayMaxSeconds = seconds
+	case "boot_sync_delay_max_seconds":
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("boot_sync_delay_max_seconds must be a non-negative integer")
+		}
+		c.BootSyncDelayMaxSeconds = seconds
+	case "boot_uptime_threshold_minutes":
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 0 {
+			return fmt.Errorf("boot_uptime_threshold_minutes must be a non-negative integer")
+		}
+		c.BootUptimeThresholdMinutes = minutes
+	case "max_runtime":
+		if _, err := ParseMaxRuntime(value); err != nil {
+			return err
+		}
+		c.MaxRuntime = value
+	case "sync_window":
+		if _, err := ParseSyncWindow(value, ""); err != nil {
+			return err
+		}
+		c.SyncWindow = value
+	case "sync_window_timezone":
+		if _, err := ParseTimezone(value); err != nil {
+			return err
+		}
+		c.SyncWindowTimezone = value
+	case "osquery_path":
+		c.OsqueryPath = value
+	case "osquery_prefer":
+		prefer, err := ParseOsqueryPreference(value)
+		if err != nil {
+			return err
+		}
+		c.OsqueryPrefer = prefer
+	case "expected_osquery_version":
+		if err := ValidateExpectedOsqueryVersion(value); err != nil {
+			return err
+		}
+		c.ExpectedOsqueryVersion = value
+	case "osquery_flags":
+		// Space-separated, since flag values may themselves contain commas
+		c.OsqueryFlags = strings.Fields(value)
+	case "osquery_flagfile":
+		if value != "" && !filepath.IsAbs(value) {
+			return fmt.Errorf("osquery_flagfile must be an absolute path")
+		}
+		c.OsqueryFlagfile = value
+	case "collect_as_user":
+		if value != "" && runtime.GOOS == "windows" {
+			return fmt.Errorf("collect_as_user is not supported on Windows")
+		}
+		c.CollectAsUser = value
+	case "mac_interface_allowlist":
+		c.MacInterfaceAllowlist = ParseList(value)
+	case "mac_interface_denylist":
+		c.MacInterfaceDenylist = ParseList(value)
+	case "mac_include_inactive_interfaces":
+		include, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("mac_include_inactive_interfaces must be true or false")
+		}
+		c.MacIncludeInactiveInterfaces = include
+	case "mac_randomization":
+		handling, err := ParseMacRandomization(value)
+		if err != nil {
+			return err
+		}
+		c.MacRandomization = handling
+	case "require_identifiers":
+		require, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("require_identifiers must be true or false")
+		}
+		c.RequireIdentifiers = require
+	case "device_name":
+		if err := ValidateDeviceLabel(value); err != nil {
+			return fmt.Errorf("device_name %w", err)
+		}
+		c.DeviceName = value
+	case "asset_tag":
+		if err := ValidateDeviceLabel(value); err != nil {
+			return fmt.Errorf("asset_tag %w", err)
+		}
+		c.AssetTag = value
+	case "enabled_checks":
+		c.EnabledChecks = ParseList(value)
+	case "disabled_checks":
+		c.DisabledChecks = ParseList(value)
+	case "critical_checks":
+		c.CriticalChecks = ParseList(value)
+	case "fail_on_missing_critical":
+		fail, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("fail_on_missing_critical must be true or false")
+		}
+		c.FailOnMissingCritical = fail
+	case "required_apps":
+		c.RequiredApps = ParseList(value)
+	case "prohibited_apps":
+		c.ProhibitedApps = ParseList(value)
+	case "app_match":
+		match, err := ParseAppMatch(value)
+		if err != nil {
+			return err
+		}
+		c.AppMatch = match
+	case "sanctioned_remote_access":
+		c.SanctionedRemoteAccess = ParseList(value)
+	case "prohibited_remote_access":
+		c.ProhibitedRemoteAccess = ParseList(value)
+	case "collect_processes":
+		collect, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("collect_processes must be true or false")
+		}
+		c.CollectProcesses = collect
+	case "process_snapshot_max_rows":
+		rows, err := strconv.Atoi(value)
+		if err != nil || rows < 1 {
+			return fmt.Errorf("process_snapshot_max_rows must be a positive integer")
+		}
+		c.ProcessSnapshotMaxRows = rows
+	case "process_snapshot_hashes":
+		hashes, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("process_snapshot_hashes must be true or false")
+		}
+		c.ProcessSnapshotHashes = hashes
+	case "os_eol_online_lookup":
+		lookup, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("os_eol_online_lookup must be true or false")
+		}
+		c.OSEOLOnlineLookup = lookup
+	case "collection_budget_seconds":
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("collection_budget_seconds must be a non-negative integer")
+		}
+		c.CollectionBudgetSeconds = seconds
+	case "max_field_bytes":
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("max_field_bytes must be a non-negative integer")
+		}
+		c.MaxFieldBytes = limit
+	case "max_query_output_bytes":
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("max_query_output_bytes must be a non-negative integer")
+		}
+		c.MaxQueryOutputBytes = limit
+	case "wsl_behavior":
+		behavior, err := ParseWSLBehavior(value)
+		if err != nil {
+			return err
+		}
+		c.WSLBehavior = behavior
+	case "on_clone":
+		behavior, err := ParseCloneBehavior(value)
+		if err != nil {
+			return err
+		}
+		c.OnClone = behavior
+	case "clone_token_file":
+		if value != "" && !filepath.IsAbs(value) {
+			return fmt.Errorf("clone_token_file must be an absolute path")
+		}
+		c.CloneTokenFile = value
+	case "virtual_not_applicable":
+		mark, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("virtual_not_applicable must be true or false")
+		}
+		c.VirtualNotApplicable = mark
+	case "pre_sync_hook":
+		if value != "" && !filepath.IsAbs(value) {
+			return fmt.Errorf("pre_sync_hook must be an absolute path")
+		}
+		c.PreSyncHook = value
+	case "sign_payloads":
+		sign, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("sign_payloads must be true or false")
+		}
+		c.SignPayloads = sign
+	case "mirror_endpoint":
+		if err := ValidateEndpointURL(value); err != nil {
+			return fmt.Errorf("mirror_endpoint %w", err)
+		}
+		c.MirrorEndpoint = value
+	case "mirror_headers":
+		headers, err := ParseHeaders(value)
+		if err != nil {
+			return fmt.Errorf("mirror_headers %w", err)
+		}
+		if err := ValidateHeaders(headers); err != nil {
+			return fmt.Errorf("mirror_headers %w", err)
+		}
+		c.MirrorHeaders = headers
+	case "mirror_only":
+		only, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("mirror_only must be true or false")
+		}
+		c.MirrorOnly = only
+	case "system_log":
+		mode, err := ParseSystemLogMode(value)
+		if err != nil {
+			return err
+		}
+		c.SystemLog = mode
+	case "otel_endpoint":
+		if err := ValidateEndpointURL(value); err != nil {
+			return fmt.Errorf("otel_endpoint %w", err)
+		}
+		c.OtelEndpoint = value
+	default:
+		return fmt.Errorf("unknown configuration key: %s", key)
+	}
+
+	return nil
+}
+
+// MaxHeartbeatIntervalMinutes is the longest heartbeat_interval_minutes.
+// Heartbeats are scheduled on minutes within each hour, so longer intervals
+// would not repeat evenly.
+const MaxHeartbeatIntervalMinutes = 59
+
+// Validate checks every setting and reports all problems found.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, err := ParseRegion(string(c.Region)); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseTargetEnv(string(c.TargetEnv)); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ValidateEndpointURL(c.APIBaseURL); err != nil {
+		errs = append(errs, fmt.Errorf("api_base_url %w", err))
+	}
+	for _, name := range c.RegionFailover {
+		if _, err := ParseRegion(name); err != nil {
+			errs = append(errs, fmt.Errorf("region_failover: %w", err))
+		}
+	}
+	if c.DefaultRegion != "" {
+		if _, err := ParseRegion(string(c.DefaultRegion)); err != nil {
+			errs = append(errs, fmt.Errorf("default_region: %w", err))
+		}
+	}
+	if c.ClientCertPath != "" && !filepath.IsAbs(c.ClientCertPath) {
+		errs = append(errs, fmt.Errorf("client_cert_path must be an absolute path"))
+	}
+	if c.ClientKeyPath != "" && !filepath.IsAbs(c.ClientKeyPath) {
+		errs = append(errs, fmt.Errorf("client_key_path must be an absolute path"))
+	}
+	if (c.ClientCertPath == "") != (c.ClientKeyPath == "") {
+		errs = append(errs, fmt.Errorf("client_cert_path and client_key_path must be set together"))
+	}
+	if _, err := ParseTokenStorage(string(c.TokenStorage)); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseAppMatch(string(c.AppMatch)); err != nil {
+		errs = append(errs, err)
+	}
+	for _, tool := range c.ProhibitedRemoteAccess {
+		for _, sanctioned := range c.SanctionedRemoteAccess {
+			if strings.EqualFold(tool, sanctioned) {
+				errs = append(errs, fmt.Errorf("%s is in both sanctioned_remote_access and prohibited_remote_access", tool))
+			}
+		}
+	}
+	if _, err := ParseSystemLogMode(string(c.SystemLog)); err != nil {
+		errs = append(errs, err)
+	}
+	if c.SyncIntervalHours < 1 {
+		errs = append(errs, fmt.Errorf("sync_interval_hours must be a positive integer"))
+	}
+	if c.MinHoursSinceLastSync < 0 {
+		errs = append(errs, fmt.Errorf("min_hours_since_last_sync must be a non-negative integer"))
+	}
+	if c.MinMinutesBetweenSyncs < 0 {
+		errs = append(errs, fmt.Errorf("min_minutes_between_syncs must be a non-negative integer"))
+	}
+	if c.SyncAttempts < 1 {
+		errs = append(errs, fmt.Errorf("sync_attempts must be a positive integer"))
+	}
+	if c.ConnectionFailureThreshold < 1 {
+		errs = append(errs, fmt.Errorf("connection_failure_threshold must be a positive integer"))
+	}
+	if c.SyncRetryWaitSeconds < 0 {
+		errs = append(errs, fmt.Errorf("sync_retry_wait_seconds must be a non-negative integer"))
+	}
+	if c.HeartbeatIntervalMinutes < 0 || c.HeartbeatIntervalMinutes > MaxHeartbeatIntervalMinutes {
+		errs = append(errs, fmt.Errorf("heartbeat_interval_minutes must be an integer from 0 to %d", MaxHeartbeatIntervalMinutes))
+	}
+	if c.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("max_retries must be a non-negative integer"))
+	}
+	if _, err := ParseMaxRuntime(c.MaxRuntime); err != nil {
+		errs = append(errs, err)
+	}
+	if c.InitialSyncDelayMinSeconds < 0 {
+		errs = append(errs, fmt.Errorf("initial_sync_delay_min_seconds must be a non-negative integer"))
+	}
+	if c.InitialSyncDelayMaxSeconds < c.InitialSyncDelayMinSeconds {
+		errs = append(errs, fmt.Errorf("initial_sync_delay_max_seconds must be at least initial_sync_delay_min_seconds (%d)", c.InitialSyncDelayMinSeconds))
+	}
+	if c.BootSyncDelayMaxSeconds < 0 {
+		errs = append(errs, fmt.Errorf("boot_sync_delay_max_seconds must be a non-negative integer"))
+	}
+	if c.BootUptimeThresholdMinutes < 0 {
+		errs = append(errs, fmt.Errorf("boot_uptime_threshold_minutes must be a non-negative integer"))
+	}
+	if _, err := ParseSyncWindow(c.SyncWindow, c.SyncWindowTimezone); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseOsqueryPreference(string(c.OsqueryPrefer)); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ValidateExpectedOsqueryVersion(c.ExpectedOsqueryVersion); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ValidateDeviceLabel(c.DeviceName); err != nil {
+		errs = append(errs, fmt.Errorf("device_name %w", err))
+	}
+	if err := ValidateDeviceLabel(c.AssetTag); err != nil {
+		errs = append(errs, fmt.Errorf("asset_tag %w", err))
+	}
+	if c.OsqueryFlagfile != "" && !filepath.IsAbs(c.OsqueryFlagfile) {
+		errs = append(errs, fmt.Errorf("osquery_flagfile must be an absolute path"))
+	}
+	if c.CollectAsUser != "" && runtime.GOOS == "windows" {
+		errs = append(errs, fmt.Errorf("collect_as_user is not supported on Windows"))
+	}
+	if c.CollectionBudgetSeconds < 0 {
+		errs = append(errs, fmt.Errorf("collection_budget_seconds must be a non-negative integer"))
+	}
+	if c.MaxFieldBytes < 0 {
+		errs = append(errs, fmt.Errorf("max_field_bytes must be a non-negative integer"))
+	}
+	if c.ProcessSnapshotMaxRows < 1 {
+		errs = append(errs, fmt.Errorf("process_snapshot_max_rows must be a positive integer"))
+	}
+	if c.MaxQueryOutputBytes < 0 {
+		errs = append(errs, fmt.Errorf("max_query_output_bytes must be a non-negative integer"))
+	}
+	if _, err := ParseMacRandomization(string(c.MacRandomization)); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseWSLBehavior(string(c.WSLBehavior)); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseCloneBehavior(string(c.OnClone)); err != nil {
+		errs = append(errs, err)
+	}
+	if c.CloneTokenFile != "" && !filepath.IsAbs(c.CloneTokenFile) {
+		errs = append(errs, fmt.Errorf("clone_token_file must be an absolute path"))
+	}
+	if c.PreSyncHook != "" && !filepath.IsAbs(c.PreSyncHook) {
+		errs = append(errs, fmt.Errorf("pre_sync_hook must be an absolute path"))
+	}
+	if err := ValidateEndpointURL(c.MirrorEndpoint); err != nil {
+		errs = append(errs, fmt.Errorf("mirror_endpoint %w", err))
+	}
+	if err := ValidateHeaders(c.MirrorHeaders); err != nil {
+		errs = append(errs, fmt.Errorf("mirror_headers %w", err))
+	}
+	if c.MirrorOnly && c.MirrorEndpoint == "" {
+		errs = append(errs, fmt.Errorf("mirror_only requires mirror_endpoint"))
+	}
+	if err := ValidateEndpointURL(c.OtelEndpoint); err != nil {
+		errs = append(errs, fmt.Errorf("otel_endpoint %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateEndpointURL checks that value is empty or an http(s) URL.
+func ValidateEndpointURL(value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an http:// or https:// URL")
+	}
+	return nil
+}
+
+// RedactedHeaderValue replaces header values in config show and config
+// export, since they usually carry credentials.
+const RedactedHeaderValue = "****"
+
+// ParseHeaders parses a mirror_headers value: a JSON array of "Name: value"
+// strings, or a single header. Header values may contain commas, so a
+// single header is never split.
+func ParseHeaders(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "[") {
+		return []string{s}, nil
+	}
+	var headers []string
+	if err := json.Unmarshal([]byte(s), &headers); err != nil {
+		return nil, fmt.Errorf("must be a JSON array of \"Name: value\" strings: %w", err)
+	}
+	return headers, nil
+}
+
+// RedactHeaders returns headers with each value replaced by
+// RedactedHeaderValue.
+func RedactHeaders(headers []string) []string {
+	if headers == nil {
+		return nil
+	}
+	redacted := make([]string, len(headers))
+	for i, header := range headers {
+		name, _, _ := strings.Cut(header, ":")
+		redacted[i] = strings.TrimSpace(name) + ": " + RedactedHeaderValue
+	}
+	return redacted
+}
+
+// RestoreRedactedHeaders fills each redacted header in headers with the
+// value of the same header in current, so an exported configuration can be
+// imported back without losing its credentials. It fails if current has no
+// such header to take the value from.
+func RestoreRedactedHeaders(headers, current []string) ([]string, error) {
+	values := make(map[string]string, len(current))
+	for _, header := range current {
+		name, value, _ := strings.Cut(header, ":")
+		values[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+
+	restored := make([]string, len(headers))
+	for i, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if strings.TrimSpace(value) != RedactedHeaderValue {
+			restored[i] = header
+			continue
+		}
+		current, ok := values[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("mirror_headers %s has a redacted value and is not set on this device; set it with 'drata-agent config set mirror_headers'", name)
+		}
+		restored[i] = name + ": " + current
+	}
+	return restored, nil
+}
+
+// ValidateHeaders checks that every entry is a "Name: value" HTTP header.
+func ValidateHeaders(headers []string) error {
+	for _, header := range headers {
+		name, _, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("must be \"Name: value\" headers, got %q", header)
+		}
+	}
+	return nil
+}
+
+// maxDeviceLabelLength is the longest device_name or asset_tag accepted.
+const maxDeviceLabelLength = 64
+
+// ValidateDeviceLabel checks that value is empty or a device name or asset
+// tag of at most maxDeviceLabelLength letters, digits, spaces, and
+// - _ . : / # characters, without leading or trailing spaces.
+func ValidateDeviceLabel(value string) error {
+	if len(value) > maxDeviceLabelLength {
+		return fmt.Errorf("must be at most %d characters", maxDeviceLabelLength)
+	}
+	if strings.TrimSpace(value) != value {
+		return fmt.Errorf("must not start or end with a space")
+	}
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(" -_.:/#", r)) {
+			return fmt.Errorf("may only contain letters, digits, spaces, and - _ . : / #, got %q", r)
+		}
+	}
+	return nil
+}
+
+// LoadFile reads a standalone configuration file (YAML or JSON, chosen by
+// extension) on top of the defaults. Unknown keys are rejected and the
+// result is validated, so a partially invalid file is never returned.
+func LoadFile(path string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var unknown []string
+	for _, key := range v.AllKeys() {
+		if !IsSettingKey(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown configuration keys: %s", strings.Join(unknown, ", "))
+	}
+
+	cfg := DefaultConfig()
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	cfg.Version = DefaultConfig().Version
+	cfg.markUserKeys(v)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
 }
 
 // getConfigDir returns the configuration directory path.
@@ -182,13 +1329,21 @@ func getConfigDir() (string, error) {
 	return filepath.Join(homeDir, ".drata-agent"), nil
 }
 
-// GetDataDir returns the data directory path.
-func GetDataDir() (string, error) {
+// DataDirPath returns the data directory path without creating it.
+func DataDirPath() (string, error) {
 	configDir, err := getConfigDir()
 	if err != nil {
 		return "", err
 	}
-	dataDir := filepath.Join(configDir, "data")
+	return filepath.Join(configDir, "data"), nil
+}
+
+// GetDataDir returns the data directory path, creating it if needed.
+func GetDataDir() (string, error) {
+	dataDir, err := DataDirPath()
+	if err != nil {
+		return "", err
+	}
 	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		return "", err
 	}
@@ -209,6 +1364,18 @@ func ParseRegion(s string) (Region, error) {
 	}
 }
 
+// ParseList parses a comma-separated configuration value into a list,
+// dropping empty entries.
+func ParseList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // ParseTargetEnv parses a string into a TargetEnv.
 func ParseTargetEnv(s string) (TargetEnv, error) {
 	switch strings.ToUpper(s) {
@@ -224,3 +1391,127 @@ func ParseTargetEnv(s string) (TargetEnv, error) {
 		return "", fmt.Errorf("invalid environment: %s (valid: LOCAL, DEV, QA, PROD)", s)
 	}
 }
+
+// ParseMacRandomization parses a string into a MacRandomization.
+func ParseMacRandomization(s string) (MacRandomization, error) {
+	switch strings.ToLower(s) {
+	case "prefer_stable":
+		return MacRandomizationPreferStable, nil
+	case "exclude":
+		return MacRandomizationExclude, nil
+	case "allow":
+		return MacRandomizationAllow, nil
+	default:
+		return "", fmt.Errorf("invalid mac_randomization: %s (valid: prefer_stable, exclude, allow)", s)
+	}
+}
+
+// ParseWSLBehavior parses a string into a WSLBehavior.
+func ParseWSLBehavior(s string) (WSLBehavior, error) {
+	switch strings.ToLower(s) {
+	case "mark":
+		return WSLBehaviorMark, nil
+	case "refuse":
+		return WSLBehaviorRefuse, nil
+	case "collect":
+		return WSLBehaviorCollect, nil
+	default:
+		return "", fmt.Errorf("invalid wsl_behavior: %s (valid: mark, refuse, collect)", s)
+	}
+}
+
+// ParseMaxRuntime parses a max_runtime duration. An empty value is zero,
+// which disables it; otherwise it must be at least a minute.
+func ParseMaxRuntime(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf("max_runtime must be a duration of at least 1m, such as 24h")
+	}
+	return d, nil
+}
+
+// ParseCloneBehavior parses a string into a CloneBehavior.
+func ParseCloneBehavior(s string) (CloneBehavior, error) {
+	switch strings.ToLower(s) {
+	case "reregister":
+		return CloneBehaviorReregister, nil
+	case "warn":
+		return CloneBehaviorWarn, nil
+	case "ignore":
+		return CloneBehaviorIgnore, nil
+	default:
+		return "", fmt.Errorf("invalid on_clone: %s (valid: reregister, warn, ignore)", s)
+	}
+}
+
+// ParseOsqueryPreference parses a string into an OsqueryPreference.
+func ParseOsqueryPreference(s string) (OsqueryPreference, error) {
+	switch strings.ToLower(s) {
+	case "vendored":
+		return OsqueryPreferVendored, nil
+	case "system":
+		return OsqueryPreferSystem, nil
+	case "path":
+		return OsqueryPreferPath, nil
+	default:
+		return "", fmt.Errorf("invalid osquery_prefer: %s (valid: vendored, system, path)", s)
+	}
+}
+
+// ParseTokenStorage parses a string into a TokenStorage.
+func ParseTokenStorage(s string) (TokenStorage, error) {
+	switch strings.ToLower(s) {
+	case "file":
+		return TokenStorageFile, nil
+	case "keyring":
+		return TokenStorageKeyring, nil
+	default:
+		return "", fmt.Errorf("invalid token_storage: %s (valid: file, keyring)", s)
+	}
+}
+
+// ParseAppMatch parses a string into an AppMatch.
+func ParseAppMatch(s string) (AppMatch, error) {
+	switch strings.ToLower(s) {
+	case "substring":
+		return AppMatchSubstring, nil
+	case "exact":
+		return AppMatchExact, nil
+	default:
+		return "", fmt.Errorf("invalid app_match: %s (valid: substring, exact)", s)
+	}
+}
+
+// ParseSystemLogMode parses a string into a SystemLogMode.
+func ParseSystemLogMode(s string) (SystemLogMode, error) {
+	switch strings.ToLower(s) {
+	case "off":
+		return SystemLogOff, nil
+	case "outcomes":
+		return SystemLogOutcomes, nil
+	case "summary":
+		return SystemLogSummary, nil
+	default:
+		return "", fmt.Errorf("invalid system_log: %s (valid: off, outcomes, summary)", s)
+	}
+}
+
+// AnyOsqueryVersion disables the expected_osquery_version warning.
+const AnyOsqueryVersion = "any"
+
+// ValidateExpectedOsqueryVersion checks that s is empty, "any", or a dotted
+// version such as 5.19.0.
+func ValidateExpectedOsqueryVersion(s string) error {
+	if s == "" || s == AnyOsqueryVersion {
+		return nil
+	}
+	for _, part := range strings.Split(s, ".") {
+		if _, err := strconv.Atoi(part); err != nil || strings.HasPrefix(part, "-") {
+			return fmt.Errorf("invalid expected_osquery_version: %s (expected a version such as 5.19.0, or any)", s)
+		}
+	}
+	return nil
+}
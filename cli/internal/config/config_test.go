@@ -3,7 +3,12 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/spf13/viper"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -20,6 +25,10 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.SyncIntervalHours != 2 {
 		t.Errorf("expected default sync interval to be 2, got %d", cfg.SyncIntervalHours)
 	}
+
+	if !cfg.SyncOnStart {
+		t.Error("expected the daemon to sync on start by default")
+	}
 }
 
 func TestAPIHostURL(t *testing.T) {
@@ -33,8 +42,14 @@ func TestAPIHostURL(t *testing.T) {
 		{"PROD EU", EnvProd, RegionEU, "https://agent.eu.drata.com"},
 		{"PROD APAC", EnvProd, RegionAPAC, "https://agent.apac.drata.com"},
 		{"DEV NA", EnvDev, RegionNA, "https://agent.dev.drata.com"},
+		{"DEV EU", EnvDev, RegionEU, "https://agent.dev.drata.com"},
+		{"DEV APAC", EnvDev, RegionAPAC, "https://agent.dev.drata.com"},
+		{"QA NA", EnvQA, RegionNA, "https://agent.qa.drata.com"},
+		{"QA EU", EnvQA, RegionEU, "https://agent.qa.drata.com"},
+		{"QA APAC", EnvQA, RegionAPAC, "https://agent.qa.drata.com"},
 		{"LOCAL NA", EnvLocal, RegionNA, "http://localhost:3000"},
 		{"LOCAL EU", EnvLocal, RegionEU, "http://localhost:3001"},
+		{"LOCAL APAC", EnvLocal, RegionAPAC, "http://localhost:3002"},
 	}
 
 	for _, tt := range tests {
@@ -52,6 +67,18 @@ func TestAPIHostURL(t *testing.T) {
 	}
 }
 
+func TestAPIHostURLOverride(t *testing.T) {
+	cfg := &Config{
+		TargetEnv:  EnvProd,
+		Region:     RegionEU,
+		APIBaseURL: "https://agent.fallback.example.com/",
+	}
+
+	if got := cfg.APIHostURL(); got != "https://agent.fallback.example.com" {
+		t.Errorf("expected override URL, got %s", got)
+	}
+}
+
 func TestWebAppURL(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -146,6 +173,454 @@ func TestParseTargetEnv(t *testing.T) {
 	}
 }
 
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", nil},
+		{"en0", []string{"en0"}},
+		{"en0, en1 ,,en5", []string{"en0", "en1", "en5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result := ParseList(tt.input)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, result)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("expected %v, got %v", tt.expected, result)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", nil},
+		{"Accept: text/plain, application/json", []string{"Accept: text/plain, application/json"}},
+		{`["Authorization: Bearer abc", "Accept: a, b"]`, []string{"Authorization: Bearer abc", "Accept: a, b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseHeaders(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(result, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	current := []string{"Authorization: Bearer abc", "X-Source: drata-agent"}
+	redacted := RedactHeaders(current)
+	if strings.Join(redacted, "|") != "Authorization: ****|X-Source: ****" {
+		t.Fatalf("unexpected redacted headers: %q", redacted)
+	}
+
+	imported := append(redacted, "X-Team: it")
+	restored, err := RestoreRedactedHeaders(imported, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(restored, "|") != "Authorization: Bearer abc|X-Source: drata-agent|X-Team: it" {
+		t.Errorf("unexpected restored headers: %q", restored)
+	}
+
+	if _, err := RestoreRedactedHeaders([]string{"X-Api-Key: ****"}, current); err == nil {
+		t.Error("expected an error for a redacted header not set on this device")
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		key      string
+		value    string
+		hasError bool
+	}{
+		{"region", "eu", false},
+		{"region", "moon", true},
+		{"api_base_url", "https://agent.staging.example.com", false},
+		{"api_base_url", "agent.staging.example.com", true},
+		{"region_failover", "eu, apac", false},
+		{"region_failover", "EU,MOON", true},
+		{"sync_interval_hours", "4", false},
+		{"sync_interval_hours", "0", true},
+		{"min_minutes_between_syncs", "abc", true},
+		{"sync_attempts", "5", false},
+		{"sync_attempts", "0", true},
+		{"connection_failure_threshold", "1", false},
+		{"connection_failure_threshold", "0", true},
+		{"sync_retry_wait_seconds", "0", false},
+		{"sync_retry_wait_seconds", "-1", true},
+		{"heartbeat_when_throttled", "true", false},
+		{"heartbeat_when_throttled", "sometimes", true},
+		{"heartbeat_interval_minutes", "15", false},
+		{"heartbeat_interval_minutes", "0", false},
+		{"heartbeat_interval_minutes", "60", true},
+		{"heartbeat_interval_minutes", "-5", true},
+		{"max_retries", "0", false},
+		{"max_retries", "-1", true},
+		{"skip_unchanged_syncs", "true", false},
+		{"skip_unchanged_syncs", "maybe", true},
+		{"sync_on_start", "false", false},
+		{"sync_on_start", "later", true},
+		{"initial_sync_delay_min_seconds", "0", false},
+		{"initial_sync_delay_max_seconds", "300", false},
+		{"initial_sync_delay_max_seconds", "-1", true},
+		{"initial_sync_delay_max_seconds", "5", true},
+		{"initial_sync_delay_min_seconds", "61", true},
+		{"boot_sync_delay_max_seconds", "900", false},
+		{"boot_sync_delay_max_seconds", "soon", true},
+		{"boot_uptime_threshold_minutes", "5", false},
+		{"boot_uptime_threshold_minutes", "-5", true},
+		{"max_runtime", "168h", false},
+		{"max_runtime", "10s", true},
+		{"max_runtime", "a week", true},
+		{"sync_window", "19:00-07:00, 12:00-13:00", false},
+		{"sync_window", "", false},
+		{"sync_window", "7pm-7am", true},
+		{"sync_window", "09:00-09:00", true},
+		{"sync_window_timezone", "Europe/London", false},
+		{"sync_window_timezone", "Mars/Olympus_Mons", true},
+		{"os_eol_online_lookup", "true", false},
+		{"os_eol_online_lookup", "online", true},
+		{"fail_on_missing_critical", "true", false},
+		{"fail_on_missing_critical", "strict", true},
+		{"sign_payloads", "true", false},
+		{"sign_payloads", "yes please", true},
+		{"collection_budget_seconds", "120", false},
+		{"collection_budget_seconds", "-5", true},
+		{"max_field_bytes", "0", false},
+		{"max_field_bytes", "big", true},
+		{"max_query_output_bytes", "1048576", false},
+		{"max_query_output_bytes", "-1", true},
+		{"mac_include_inactive_interfaces", "true", false},
+		{"mac_include_inactive_interfaces", "maybe", true},
+		{"mac_randomization", "Exclude", false},
+		{"mac_randomization", "random", true},
+		{"require_identifiers", "true", false},
+		{"require_identifiers", "always", true},
+		{"device_name", "LAPTOP-0042 (ops)", true},
+		{"device_name", "ops-laptop-42", false},
+		{"device_name", "", false},
+		{"asset_tag", "ASSET#00042", false},
+		{"asset_tag", strings.Repeat("A", 65), true},
+		{"pre_sync_hook", "/usr/local/bin/gate", false},
+		{"pre_sync_hook", "gate", true},
+		{"mirror_endpoint", "https://evidence.example.com/drata", false},
+		{"mirror_endpoint", "evidence.example.com", true},
+		{"mirror_headers", "Authorization: Bearer abc", false},
+		{"mirror_headers", `["Authorization: Bearer abc", "Accept: text/plain, application/json"]`, false},
+		{"mirror_headers", "", false},
+		{"mirror_headers", "Authorization Bearer abc", true},
+		{"mirror_headers", `["Authorization: Bearer abc",`, true},
+		{"mirror_only", "true", false},
+		{"mirror_only", "only", true},
+		{"system_log", "summary", false},
+		{"system_log", "OUTCOMES", false},
+		{"system_log", "file", true},
+		{"otel_endpoint", "http://localhost:4318", false},
+		{"otel_endpoint", "localhost:4318", true},
+		{"osquery_prefer", "System", false},
+		{"osquery_prefer", "newest", true},
+		{"expected_osquery_version", "5.19.0", false},
+		{"expected_osquery_version", "any", false},
+		{"expected_osquery_version", "latest", true},
+		{"expected_osquery_version", "5.x", true},
+		{"osquery_flags", "--disable_events --disable_tables=a,b", false},
+		{"osquery_flagfile", "/etc/osquery/drata.flags", false},
+		{"osquery_flagfile", "drata.flags", true},
+		{"collect_as_user", "", false},
+		{"client_cert_path", "/etc/drata/client.pem", false},
+		{"client_cert_path", "client.pem", true},
+		{"client_key_path", "/etc/drata/client-key.pem", false},
+		{"client_key_path", "client-key.pem", true},
+		{"token_storage", "keyring", false},
+		{"token_storage", "file", false},
+		{"token_storage", "vault", true},
+		{"required_apps", "CrowdStrike Falcon, 1Password", false},
+		{"prohibited_apps", "TeamViewer", false},
+		{"app_match", "exact", false},
+		{"app_match", "regex", true},
+		{"default_region", "eu", false},
+		{"default_region", "", false},
+		{"default_region", "MOON", true},
+		{"sanctioned_remote_access", "ssh, teamViewer", false},
+		{"prohibited_remote_access", "anyDesk", false},
+		{"collect_processes", "true", false},
+		{"collect_processes", "sometimes", true},
+		{"process_snapshot_max_rows", "200", false},
+		{"process_snapshot_max_rows", "0", true},
+		{"process_snapshot_hashes", "false", false},
+		{"process_snapshot_hashes", "sha256", true},
+		{"wsl_behavior", "Refuse", false},
+		{"wsl_behavior", "ignore", true},
+		{"on_clone", "Reregister", false},
+		{"on_clone", "prompt", true},
+		{"clone_token_file", "/etc/drata/clone-token", false},
+		{"clone_token_file", "clone-token", true},
+		{"virtual_not_applicable", "true", false},
+		{"virtual_not_applicable", "ci", true},
+		{"unknown_key", "1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			err := DefaultConfig().Set(tt.key, tt.value)
+			if tt.hasError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.hasError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("default config should be valid: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Region = "MOON"
+	cfg.SyncIntervalHours = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected validation error")
+	}
+
+	cfg = DefaultConfig()
+	cfg.InitialSyncDelayMinSeconds = 120
+	cfg.InitialSyncDelayMaxSeconds = 60
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected an initial sync delay range ending before it starts to be invalid")
+	}
+
+	cfg = DefaultConfig()
+	cfg.SanctionedRemoteAccess = []string{"ssh", "teamViewer"}
+	cfg.ProhibitedRemoteAccess = []string{"TeamViewer"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected a tool both sanctioned and prohibited to be invalid")
+	}
+}
+
+func TestDefaultRegion(t *testing.T) {
+	original := DefaultRegion
+	t.Cleanup(func() { DefaultRegion = original })
+
+	DefaultRegion = "apac"
+	if cfg := DefaultConfig(); cfg.Region != RegionAPAC {
+		t.Errorf("expected the built-in default region APAC, got %s", cfg.Region)
+	}
+	DefaultRegion = "MOON"
+	if cfg := DefaultConfig(); cfg.Region != RegionNA {
+		t.Errorf("expected an invalid built-in default to fall back to NA, got %s", cfg.Region)
+	}
+
+	cfg := DefaultConfig()
+	cfg.DefaultRegion = RegionEU
+	cfg.applyDefaultRegion()
+	if cfg.Region != RegionEU {
+		t.Errorf("expected default_region to apply when region is not set, got %s", cfg.Region)
+	}
+
+	cfg = DefaultConfig()
+	cfg.Region = RegionAPAC
+	cfg.DefaultRegion = RegionEU
+	cfg.sources = map[string]string{"region": SourceEnv}
+	cfg.applyDefaultRegion()
+	if cfg.Region != RegionAPAC {
+		t.Errorf("expected a set region to win over default_region, got %s", cfg.Region)
+	}
+}
+
+func TestSettingsKeysAreSettable(t *testing.T) {
+	cfg := DefaultConfig()
+	for key := range cfg.Settings() {
+		if !IsSettingKey(key) {
+			t.Errorf("%s is not a setting key", key)
+		}
+		if err := cfg.Set(key, ""); err != nil && strings.HasPrefix(err.Error(), "unknown configuration key") {
+			t.Errorf("%s cannot be set", key)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.yaml")
+	if err := os.WriteFile(valid, []byte("region: EU\nsync_interval_hours: 6\nmac_interface_allowlist: [en0, en5]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadFile(valid)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Region != RegionEU || cfg.SyncIntervalHours != 6 || len(cfg.MacInterfaceAllowlist) != 2 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if cfg.MinHoursSinceLastSync != DefaultConfig().MinHoursSinceLastSync {
+		t.Errorf("missing settings should use defaults")
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"unknown key", "unknown.yaml", "region: EU\nfavorite_color: blue\n"},
+		{"invalid value", "invalid.json", `{"region": "EU", "sync_interval_hours": 0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadFile(path); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestUpdateSettingConcurrent(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	updates := map[string]string{
+		"region":                    "EU",
+		"sync_interval_hours":       "6",
+		"min_hours_since_last_sync": "12",
+		"osquery_path":              "/opt/osquery/bin/osqueryi",
+		"pre_sync_hook":             "/usr/local/bin/gate",
+		"wsl_behavior":              "refuse",
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(updates))
+	for key, value := range updates {
+		wg.Add(1)
+		go func(key, value string) {
+			defer wg.Done()
+			errs <- UpdateSetting(key, value)
+		}(key, value)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cfg, err := readConfigFile(filepath.Join(home, ".drata-agent", "config.yaml"))
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if cfg.Region != RegionEU || cfg.SyncIntervalHours != 6 || cfg.MinHoursSinceLastSync != 12 ||
+		cfg.OsqueryPath != "/opt/osquery/bin/osqueryi" || cfg.PreSyncHook != "/usr/local/bin/gate" ||
+		cfg.WSLBehavior != WSLBehaviorRefuse {
+		t.Errorf("concurrent updates were lost: %+v", cfg)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".drata-agent", "config.yaml.lock")); !os.IsNotExist(err) {
+		t.Error("lock file was not released")
+	}
+}
+
+func TestUpdateSettingDefaultRegion(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Cleanup(viper.Reset)
+
+	if err := UpdateSetting("default_region", "EU"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	viper.Reset()
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Region != RegionEU {
+		t.Errorf("region = %s, want EU from default_region", cfg.Region)
+	}
+	if got := cfg.Source("region"); got != SourceDefault {
+		t.Errorf("Source(region) = %q, want %q", got, SourceDefault)
+	}
+	if got := cfg.Source("default_region"); got != SourceUser {
+		t.Errorf("Source(default_region) = %q, want %q", got, SourceUser)
+	}
+
+	// A region set by the user still wins
+	if err := UpdateSetting("region", "APAC"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	viper.Reset()
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Region != RegionAPAC || cfg.Source("sync_interval_hours") != SourceDefault {
+		t.Errorf("region = %s, sync_interval_hours from %s; want APAC and the default", cfg.Region, cfg.Source("sync_interval_hours"))
+	}
+}
+
+func TestManagedConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	managed := filepath.Join(t.TempDir(), "managed.yaml")
+	if err := os.WriteFile(managed, []byte("region: EU\ndisabled_checks: [sessionInfo]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	original := managedConfigPath
+	managedConfigPath = managed
+	t.Cleanup(func() { managedConfigPath = original })
+
+	if err := UpdateSetting("sync_interval_hours", "6"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := UpdateSetting("region", "APAC"); err == nil {
+		t.Error("expected error updating a managed setting")
+	}
+
+	cfg := DefaultConfig()
+	cfg.sources = map[string]string{"region": SourceUser}
+	if err := applyManaged(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Region != RegionEU || len(cfg.DisabledChecks) != 1 || cfg.DisabledChecks[0] != "sessionInfo" {
+		t.Errorf("managed settings were not applied: %+v", cfg)
+	}
+	if !cfg.IsManaged("region") || !cfg.IsManaged("disabled_checks") || cfg.IsManaged("sync_interval_hours") {
+		t.Errorf("unexpected managed keys: %v", cfg.sources)
+	}
+	if got := cfg.Source("target_env"); got != SourceDefault {
+		t.Errorf("Source(target_env) = %q, want %q", got, SourceDefault)
+	}
+
+	if err := os.WriteFile(managed, []byte("region: EU\nfavorite_color: blue\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyManaged(DefaultConfig()); err == nil {
+		t.Error("expected error for unknown managed key")
+	}
+}
+
 func TestGetDataDir(t *testing.T) {
 	dir, err := GetDataDir()
 	if err != nil {
@@ -167,3 +642,48 @@ func TestGetDataDir(t *testing.T) {
 		t.Error("data directory was not created")
 	}
 }
+
+func TestSyncWindow(t *testing.T) {
+	utc := time.UTC
+	window, err := ParseSyncWindow("19:00-07:00,12:00-13:00", "UTC")
+	if err != nil {
+		t.Fatalf("ParseSyncWindow failed: %v", err)
+	}
+
+	tests := []struct {
+		at       time.Time
+		contains bool
+		nextOpen time.Time
+	}{
+		{time.Date(2024, 3, 4, 23, 30, 0, 0, utc), true, time.Date(2024, 3, 4, 23, 30, 0, 0, utc)},
+		{time.Date(2024, 3, 4, 6, 59, 0, 0, utc), true, time.Date(2024, 3, 4, 6, 59, 0, 0, utc)},
+		{time.Date(2024, 3, 4, 7, 0, 0, 0, utc), false, time.Date(2024, 3, 4, 12, 0, 0, 0, utc)},
+		{time.Date(2024, 3, 4, 12, 30, 0, 0, utc), true, time.Date(2024, 3, 4, 12, 30, 0, 0, utc)},
+		{time.Date(2024, 3, 4, 15, 0, 0, 0, utc), false, time.Date(2024, 3, 4, 19, 0, 0, 0, utc)},
+	}
+
+	for _, tt := range tests {
+		if got := window.Contains(tt.at); got != tt.contains {
+			t.Errorf("Contains(%s) = %v, expected %v", tt.at, got, tt.contains)
+		}
+		if got := window.NextOpen(tt.at); !got.Equal(tt.nextOpen) {
+			t.Errorf("NextOpen(%s) = %s, expected %s", tt.at, got, tt.nextOpen)
+		}
+	}
+
+	// Ranges are read in the window's time zone
+	window, err = ParseSyncWindow("09:00-17:00", "America/New_York")
+	if err != nil {
+		t.Fatalf("ParseSyncWindow failed: %v", err)
+	}
+	if !window.Contains(time.Date(2024, 1, 15, 15, 0, 0, 0, utc)) {
+		t.Error("expected 15:00 UTC (10:00 in New York) to be inside the window")
+	}
+	if next := window.NextOpen(time.Date(2024, 1, 15, 23, 0, 0, 0, utc)); !next.Equal(time.Date(2024, 1, 16, 14, 0, 0, 0, utc)) {
+		t.Errorf("NextOpen() = %s, expected 14:00 UTC the next day", next.UTC())
+	}
+
+	if window, err := ParseSyncWindow("", ""); window != nil || err != nil {
+		t.Errorf("ParseSyncWindow(\"\") = %v, %v, expected no window", window, err)
+	}
+}
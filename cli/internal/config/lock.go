@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// lockTimeout bounds how long a config write waits for another writer.
+	lockTimeout = 10 * time.Second
+
+	// staleLockAge is how old a lock file must be before it is assumed to
+	// belong to a writer that died and is removed.
+	staleLockAge = 30 * time.Second
+
+	// lockPollInterval is how often a held lock is retried.
+	lockPollInterval = 20 * time.Millisecond
+)
+
+// lockFile takes an advisory lock on path by exclusively creating a sibling
+// lock file, waiting for other holders to release it. The returned function
+// releases the lock.
+func lockFile(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s; remove it if no other drata-agent is running", lockPath)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
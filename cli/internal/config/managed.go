@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Setting sources reported by Source.
+const (
+	SourceDefault = "default"
+	SourceUser    = "user"
+	SourceEnv     = "env"
+	SourceManaged = "managed"
+)
+
+// managedConfigPath is the system-wide configuration file deployed by IT.
+// Its settings override the user's configuration and cannot be changed
+// locally.
+var managedConfigPath = defaultManagedConfigPath()
+
+// defaultManagedConfigPath returns the platform's managed config location.
+func defaultManagedConfigPath() string {
+	switch runtime.GOOS {
+	case "windows":
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			programData = `C:\ProgramData`
+		}
+		return filepath.Join(programData, "Drata Agent", "managed.yaml")
+	case "darwin":
+		return "/Library/Preferences/drata-agent/managed.yaml"
+	default:
+		return "/etc/drata-agent/managed.yaml"
+	}
+}
+
+// ManagedConfigPath returns the location of the managed configuration file.
+func ManagedConfigPath() string {
+	return managedConfigPath
+}
+
+// applyManaged overlays the managed configuration, if present, onto cfg and
+// marks its keys as managed. Unknown keys are rejected so a typo in policy
+// is never silently ignored.
+func applyManaged(cfg *Config) error {
+	if _, err := os.Stat(managedConfigPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(managedConfigPath)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read managed config %s: %w", managedConfigPath, err)
+	}
+
+	var unknown []string
+	for _, key := range v.AllKeys() {
+		if !IsSettingKey(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys in managed config %s: %s", managedConfigPath, strings.Join(unknown, ", "))
+	}
+
+	if err := v.Unmarshal(cfg); err != nil {
+		return fmt.Errorf("failed to parse managed config %s: %w", managedConfigPath, err)
+	}
+	for _, key := range v.AllKeys() {
+		cfg.sources[key] = SourceManaged
+	}
+	return nil
+}
+
+// IsManaged reports whether the setting named key is locked by the managed
+// configuration.
+func (c *Config) IsManaged(key string) bool {
+	return c.sources[key] == SourceManaged
+}
+
+// CheckNotManaged returns an error if the setting named key is locked by
+// the managed configuration.
+func (c *Config) CheckNotManaged(key string) error {
+	if c.IsManaged(key) {
+		return fmt.Errorf("%s is managed by your organization (%s) and cannot be changed locally", key, managedConfigPath)
+	}
+	return nil
+}
+
+// Source returns where the effective value of the setting named key came
+// from: default, user, env, or managed.
+func (c *Config) Source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SyncWindow is a set of daily time ranges in a time zone during which the
+// daemon may run scheduled syncs.
+type SyncWindow struct {
+	ranges   []windowRange
+	location *time.Location
+}
+
+// windowRange is a daily range in minutes after midnight. A range whose end
+// is not after its start wraps past midnight.
+type windowRange struct {
+	start, end int
+}
+
+// ParseSyncWindow parses a comma-separated list of "HH:MM-HH:MM" ranges,
+// such as "19:00-07:00,12:00-13:00", in the IANA time zone timezone, or in
+// local time when timezone is empty. An empty spec means no window and
+// returns nil.
+func ParseSyncWindow(spec, timezone string) (*SyncWindow, error) {
+	location, err := ParseTimezone(timezone)
+	if err != nil {
+		return nil, err
+	}
+	items := ParseList(spec)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	window := &SyncWindow{location: location}
+	for _, item := range items {
+		from, to, ok := strings.Cut(item, "-")
+		start, startErr := parseClock(strings.TrimSpace(from))
+		end, endErr := parseClock(strings.TrimSpace(to))
+		if !ok || startErr != nil || endErr != nil || start == end || start == 24*60 {
+			return nil, fmt.Errorf("invalid sync_window range: %s (expected HH:MM-HH:MM, such as 19:00-07:00)", item)
+		}
+		window.ranges = append(window.ranges, windowRange{start: start, end: end})
+	}
+	return window, nil
+}
+
+// ParseTimezone parses an IANA time zone name, such as Europe/London. An
+// empty name means local time.
+func ParseTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sync_window_timezone: %s (expected an IANA time zone, such as Europe/London)", name)
+	}
+	return location, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight. 24:00 is accepted
+// as the end of the day.
+func parseClock(s string) (int, error) {
+	var hours, minutes int
+	if len(s) != 5 || s[2] != ':' {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	if _, err := fmt.Sscanf(s, "%02d:%02d", &hours, &minutes); err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	if hours > 24 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// Contains reports whether t falls inside the window.
+func (w *SyncWindow) Contains(t time.Time) bool {
+	local := t.In(w.location)
+	minute := local.Hour()*60 + local.Minute()
+	for _, r := range w.ranges {
+		if r.start < r.end {
+			if minute >= r.start && minute < r.end {
+				return true
+			}
+		} else if minute >= r.start || minute < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns t if it falls inside the window, or otherwise the
+// earliest time after t at which a range starts.
+func (w *SyncWindow) NextOpen(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	local := t.In(w.location)
+	var next time.Time
+	for _, r := range w.ranges {
+		start := time.Date(local.Year(), local.Month(), local.Day(), r.start/60, r.start%60, 0, 0, w.location)
+		if !start.After(t) {
+			start = time.Date(local.Year(), local.Month(), local.Day()+1, r.start/60, r.start%60, 0, 0, w.location)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
@@ -3,10 +3,13 @@ package datastore
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/drata/drata-agent-cli/internal/config"
@@ -20,8 +23,107 @@ const (
 	SyncStateError   SyncState = "ERROR"
 	SyncStateRunning SyncState = "RUNNING"
 	SyncStateUnknown SyncState = "UNKNOWN"
+	// SyncStateDeferred means the last sync could not reach Drata, but not
+	// on enough consecutive syncs to be shown as an error.
+	SyncStateDeferred SyncState = "DEFERRED"
 )
 
+// SyncError describes why the last sync failed.
+type SyncError struct {
+	// Step is the sync step that failed: init, collect, or upload.
+	Step       string `json:"step"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Code       string `json:"code,omitempty"`
+	OccurredAt string `json:"occurredAt"`
+}
+
+// DeviceIdentifiers are the identifiers a device was last registered with.
+type DeviceIdentifiers struct {
+	HardwareSerial string `json:"hardwareSerial,omitempty"`
+	BoardSerial    string `json:"boardSerial,omitempty"`
+	MacAddress     string `json:"macAddress,omitempty"`
+	DeviceName     string `json:"deviceName,omitempty"`
+	AssetTag       string `json:"assetTag,omitempty"`
+}
+
+// Reasons a daemon run ended, recorded in a ShutdownRecord.
+const (
+	// ShutdownSignal means the daemon was stopped by a signal, such as
+	// SIGTERM from its service manager.
+	ShutdownSignal = "signal"
+	// ShutdownSelfExit means the daemon exited on its own, such as when
+	// max_runtime was reached.
+	ShutdownSelfExit = "self-exit"
+	// ShutdownCrash means the daemon panicked, or was found at the next
+	// start to have stopped without recording a shutdown, as when it is
+	// killed or the machine loses power.
+	ShutdownCrash = "crash"
+)
+
+// ShutdownRecord describes how the daemon's last run ended.
+type ShutdownRecord struct {
+	Reason string `json:"reason"`
+	// Detail is the signal, exit cause, or panic message.
+	Detail    string `json:"detail,omitempty"`
+	StartedAt string `json:"startedAt,omitempty"`
+	// At is when the daemon stopped. It is empty for a crash detected at
+	// the next start, whose time is unknown.
+	At string `json:"at,omitempty"`
+}
+
+// CollectionTiming records how long the last collection took, in
+// milliseconds, and which checks it could not fully collect.
+type CollectionTiming struct {
+	CollectedAt string `json:"collectedAt"`
+	TotalMs     int64  `json:"totalMs"`
+	// BudgetMs is the collection budget the collection ran under, or 0 if
+	// it had none.
+	BudgetMs int64 `json:"budgetMs,omitempty"`
+	// CheckMs is how long each check that ran took.
+	CheckMs map[string]int64 `json:"checkMs,omitempty"`
+	// Incomplete gives why each check that was skipped, failed, produced
+	// no data, or reported its result as unavailable is incomplete.
+	Incomplete map[string]string `json:"incomplete,omitempty"`
+}
+
+// UploadRecord identifies the last payload uploaded in full, so an
+// unchanged payload can be skipped.
+type UploadRecord struct {
+	// Hash is the payload's osquery.QueryResult.ContentHash.
+	Hash       string `json:"hash"`
+	UploadedAt string `json:"uploadedAt"`
+}
+
+// ErrReadOnly is returned by operations that must persist data when the
+// data directory cannot be written.
+var ErrReadOnly = errors.New("datastore is read-only")
+
+// ErrDiskFull is returned when data cannot be saved because the disk is
+// full. The previously saved data is left intact.
+var ErrDiskFull = errors.New("not enough disk space to save agent data")
+
+// maxPayloadHistory is how many synced payloads are kept for diffing.
+const maxPayloadHistory = 2
+
+// maxPayloadSnapshotBytes is the largest payload, as JSON, kept for
+// diffing, so that large app lists or process snapshots do not bloat the
+// data file that is rewritten on every save.
+const maxPayloadSnapshotBytes = 1 << 20
+
+// ErrPayloadTooLarge is returned by RecordPayload for a payload larger
+// than maxPayloadSnapshotBytes.
+var ErrPayloadTooLarge = fmt.Errorf("payload larger than %d bytes", maxPayloadSnapshotBytes)
+
+// PayloadSnapshot is the collected data sent by a successful sync.
+type PayloadSnapshot struct {
+	SyncedAt string `json:"syncedAt"`
+	// SchemaVersion is the payload's schema version, or 0 for a payload
+	// recorded before schema versions.
+	SchemaVersion   int                    `json:"schemaVersion,omitempty"`
+	RawQueryResults map[string]interface{} `json:"rawQueryResults"`
+}
+
 // User represents the authenticated user information.
 type User struct {
 	ID                 int      `json:"id"`
@@ -41,31 +143,61 @@ type User struct {
 
 // DataStore holds all persistent data for the agent.
 type DataStore struct {
-	UUID                   string        `json:"uuid,omitempty"`
-	AppVersion             string        `json:"appVersion,omitempty"`
-	AccessToken            string        `json:"accessToken,omitempty"`
-	User                   *User         `json:"user,omitempty"`
-	SyncState              SyncState     `json:"syncState,omitempty"`
-	LastCheckedAt          string        `json:"lastCheckedAt,omitempty"`
-	LastSyncAttemptedAt    string        `json:"lastSyncAttemptedAt,omitempty"`
-	ComplianceData         interface{}   `json:"complianceData,omitempty"`
-	WinAvServicesMatchList []string      `json:"winAvServicesMatchList,omitempty"`
-	Region                 config.Region `json:"region,omitempty"`
+	UUID                   string             `json:"uuid,omitempty"`
+	AgentID                string             `json:"agentId,omitempty"`
+	AgentIDSource          string             `json:"agentIdSource,omitempty"`
+	RegisteredSerial       string             `json:"registeredSerial,omitempty"`
+	RegisteredIdentifiers  *DeviceIdentifiers `json:"registeredIdentifiers,omitempty"`
+	AppVersion             string             `json:"appVersion,omitempty"`
+	AccessToken            string             `json:"accessToken,omitempty"`
+	RefreshToken           string             `json:"refreshToken,omitempty"`
+	DeviceKey              string             `json:"deviceKey,omitempty"`
+	User                   *User              `json:"user,omitempty"`
+	SyncState              SyncState          `json:"syncState,omitempty"`
+	LastCheckedAt          string             `json:"lastCheckedAt,omitempty"`
+	LastSyncAttemptedAt    string             `json:"lastSyncAttemptedAt,omitempty"`
+	LastSkipReason         string             `json:"lastSkipReason,omitempty"`
+	LastSkippedAt          string             `json:"lastSkippedAt,omitempty"`
+	LastError              *SyncError         `json:"lastError,omitempty"`
+	ConnectionFailures     int                `json:"connectionFailures,omitempty"`
+	DaemonStartedAt        string             `json:"daemonStartedAt,omitempty"`
+	LastShutdown           *ShutdownRecord    `json:"lastShutdown,omitempty"`
+	LastCollection         *CollectionTiming  `json:"lastCollection,omitempty"`
+	LastUpload             *UploadRecord      `json:"lastUpload,omitempty"`
+	PayloadHistory         []PayloadSnapshot  `json:"payloadHistory,omitempty"`
+	ComplianceData         interface{}        `json:"complianceData,omitempty"`
+	WinAvServicesMatchList []string           `json:"winAvServicesMatchList,omitempty"`
+	Region                 config.Region      `json:"region,omitempty"`
 
 	mu   sync.RWMutex
 	path string
+	// readOnly is set when the data directory could not be created, so
+	// there is nothing to load and nowhere to save.
+	readOnly bool
+	// keyring holds the secrets instead of app-data.json when set.
+	keyring Keyring
 }
 
-// New creates a new DataStore instance.
+// New creates a new DataStore instance. A data directory that cannot be
+// created because the filesystem is read-only yields an empty store whose
+// writes fail with ErrReadOnly, so read-only commands still work.
 func New() (*DataStore, error) {
+	readOnly := false
 	dataDir, err := config.GetDataDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get data directory: %w", err)
+		if !isReadOnlyError(err) {
+			return nil, fmt.Errorf("failed to get data directory: %w", err)
+		}
+		if dataDir, err = config.DataDirPath(); err != nil {
+			return nil, fmt.Errorf("failed to get data directory: %w", err)
+		}
+		readOnly = true
 	}
 
 	path := filepath.Join(dataDir, "app-data.json")
 	ds := &DataStore{
-		path: path,
+		path:     path,
+		readOnly: readOnly,
 	}
 
 	// Load existing data if file exists
@@ -98,14 +230,80 @@ func (ds *DataStore) load() error {
 	return nil
 }
 
-// save writes the data store to disk.
+// save writes the data store to disk, returning an error wrapping
+// ErrReadOnly if the data directory cannot be written.
 func (ds *DataStore) save() error {
+	if ds.readOnly {
+		return fmt.Errorf("%w: cannot create %s", ErrReadOnly, filepath.Dir(ds.path))
+	}
+
+	// The secrets are kept out of the file when the keyring holds them
+	var secrets []secret
+	var values []string
+	if ds.keyring != nil {
+		secrets = ds.secrets()
+		for _, secret := range secrets {
+			values = append(values, *secret.field)
+			*secret.field = ""
+		}
+	}
 	data, err := json.MarshalIndent(ds, "", "    ")
+	for i, secret := range secrets {
+		*secret.field = values[i]
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(ds.path, data, 0600); err != nil {
+		if isReadOnlyError(err) {
+			return fmt.Errorf("%w: %v", ErrReadOnly, err)
+		}
+		if isDiskFull(err) {
+			return fmt.Errorf("%w: free up space on the disk holding %s and try again (%v)", ErrDiskFull, filepath.Dir(ds.path), err)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeTemp writes data to the temporary file of an atomic write. Tests
+// replace it to simulate a full disk.
+var writeTemp = func(f *os.File, data []byte) (int, error) {
+	return f.Write(data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so a failed or partial write leaves the previous contents
+// intact.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := writeTemp(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
 
-	return os.WriteFile(ds.path, data, 0600)
+// isReadOnlyError reports whether err means the filesystem or directory
+// does not allow writing.
+func isReadOnlyError(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS)
 }
 
 // GetAccessToken returns the access token.
@@ -119,7 +317,114 @@ func (ds *DataStore) GetAccessToken() string {
 func (ds *DataStore) SetAccessToken(token string) error {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
-	ds.AccessToken = token
+	if err := ds.storeSecret(keyringAccessTokenAccount, &ds.AccessToken, token); err != nil {
+		return err
+	}
+	return ds.save()
+}
+
+// secret is a field of the data store that the keyring holds when one is
+// in use, and the keyring account it is stored under.
+type secret struct {
+	account string
+	field   *string
+}
+
+// secrets returns the fields the keyring holds: the access and refresh
+// tokens and the device signing key.
+func (ds *DataStore) secrets() []secret {
+	return []secret{
+		{keyringAccessTokenAccount, &ds.AccessToken},
+		{keyringRefreshTokenAccount, &ds.RefreshToken},
+		{keyringDeviceKeyAccount, &ds.DeviceKey},
+	}
+}
+
+// UseKeyring moves the access and refresh tokens and the device key into
+// keyring and reads them from there from now on. Secrets still in
+// app-data.json are copied to the keyring and removed from the file.
+func (ds *DataStore) UseKeyring(keyring Keyring) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	moved := false
+	for _, secret := range ds.secrets() {
+		if *secret.field == "" {
+			token, err := keyring.Get(secret.account)
+			if err != nil {
+				return fmt.Errorf("failed to read %s from keyring: %w", secret.account, err)
+			}
+			*secret.field = token
+			continue
+		}
+		if err := keyring.Set(secret.account, *secret.field); err != nil {
+			return fmt.Errorf("failed to move %s to keyring: %w", secret.account, err)
+		}
+		moved = true
+	}
+
+	ds.keyring = keyring
+	if !moved {
+		return nil
+	}
+	if err := ds.save(); err != nil {
+		return fmt.Errorf("failed to remove secrets from %s: %w", filepath.Base(ds.path), err)
+	}
+	return nil
+}
+
+// storeSecret sets field, one of the secrets, writing it to the keyring
+// under account if one is in use. The caller saves the data store and must
+// hold ds.mu.
+func (ds *DataStore) storeSecret(account string, field *string, token string) error {
+	if ds.keyring != nil {
+		var err error
+		if token == "" {
+			err = ds.keyring.Delete(account)
+		} else {
+			err = ds.keyring.Set(account, token)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update %s in keyring: %w", account, err)
+		}
+	}
+	*field = token
+	return nil
+}
+
+// GetRefreshToken returns the token that renews an expired access token.
+func (ds *DataStore) GetRefreshToken() string {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.RefreshToken
+}
+
+// SetRefreshToken sets the token that renews an expired access token.
+func (ds *DataStore) SetRefreshToken(token string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.storeSecret(keyringRefreshTokenAccount, &ds.RefreshToken, token); err != nil {
+		return err
+	}
+	return ds.save()
+}
+
+// GetDeviceKey returns the base64-encoded seed of the key that signs
+// requests, or an empty string if none has been generated.
+func (ds *DataStore) GetDeviceKey() string {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.DeviceKey
+}
+
+// SetDeviceKey sets the base64-encoded seed of the key that signs
+// requests, keeping it with the tokens.
+func (ds *DataStore) SetDeviceKey(key string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.storeSecret(keyringDeviceKeyAccount, &ds.DeviceKey, key); err != nil {
+		return err
+	}
 	return ds.save()
 }
 
@@ -145,14 +450,62 @@ func (ds *DataStore) GetSyncState() SyncState {
 	return ds.SyncState
 }
 
-// SetSyncState sets the sync state.
+// SetSyncState sets the sync state. A successful sync clears the last error.
 func (ds *DataStore) SetSyncState(state SyncState) error {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 	ds.SyncState = state
+	if state == SyncStateSuccess {
+		ds.LastError = nil
+		ds.ConnectionFailures = 0
+	}
 	return ds.save()
 }
 
+// GetLastError returns why the last sync failed, or nil.
+func (ds *DataStore) GetLastError() *SyncError {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.LastError
+}
+
+// SetSyncError records a failed sync: the sync state becomes error and
+// syncErr, stamped with the current time, becomes the last error.
+func (ds *DataStore) SetSyncError(syncErr SyncError) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	syncErr.OccurredAt = time.Now().UTC().Format(time.RFC3339)
+	ds.SyncState = SyncStateError
+	ds.LastError = &syncErr
+	ds.ConnectionFailures = 0
+	return ds.save()
+}
+
+// SetSyncConnectionError records a sync that could not reach Drata. The
+// sync state becomes deferred until threshold consecutive syncs have
+// failed this way, and error from then on; syncErr becomes the last error
+// either way.
+func (ds *DataStore) SetSyncConnectionError(syncErr SyncError, threshold int) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	syncErr.OccurredAt = time.Now().UTC().Format(time.RFC3339)
+	ds.ConnectionFailures++
+	ds.SyncState = SyncStateError
+	if ds.ConnectionFailures < threshold {
+		ds.SyncState = SyncStateDeferred
+	}
+	ds.LastError = &syncErr
+	return ds.save()
+}
+
+// GetConnectionFailures returns how many consecutive syncs could not reach
+// Drata.
+func (ds *DataStore) GetConnectionFailures() int {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.ConnectionFailures
+}
+
 // GetLastCheckedAt returns the last checked timestamp.
 func (ds *DataStore) GetLastCheckedAt() string {
 	ds.mu.RLock()
@@ -183,6 +536,152 @@ func (ds *DataStore) SetLastSyncAttemptedAt(timestamp string) error {
 	return ds.save()
 }
 
+// GetLastSkip returns the reason and timestamp of the last skipped sync.
+func (ds *DataStore) GetLastSkip() (reason string, timestamp string) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.LastSkipReason, ds.LastSkippedAt
+}
+
+// SetLastSkip records that a sync was skipped and why.
+func (ds *DataStore) SetLastSkip(reason string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.LastSkipReason = reason
+	ds.LastSkippedAt = time.Now().UTC().Format(time.RFC3339)
+	return ds.save()
+}
+
+// GetDaemonStartedAt returns when the running daemon started, or when the
+// last run that has not recorded a shutdown started. It is empty when no
+// daemon is running.
+func (ds *DataStore) GetDaemonStartedAt() string {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.DaemonStartedAt
+}
+
+// GetLastShutdown returns how the daemon's last run ended, or nil if no run
+// has ended.
+func (ds *DataStore) GetLastShutdown() *ShutdownRecord {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.LastShutdown
+}
+
+// RecordDaemonStart records that the daemon started and returns how its
+// previous run ended, or nil if there was none. A previous run that started
+// but never recorded a shutdown is recorded as a crash.
+func (ds *DataStore) RecordDaemonStart() (*ShutdownRecord, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.DaemonStartedAt != "" {
+		ds.LastShutdown = &ShutdownRecord{
+			Reason:    ShutdownCrash,
+			Detail:    "stopped without shutting down cleanly",
+			StartedAt: ds.DaemonStartedAt,
+		}
+	}
+	previous := ds.LastShutdown
+	ds.DaemonStartedAt = time.Now().UTC().Format(time.RFC3339)
+	return previous, ds.save()
+}
+
+// RecordDaemonShutdown records that the running daemon stopped for reason,
+// one of the Shutdown constants, with detail such as the signal received.
+func (ds *DataStore) RecordDaemonShutdown(reason, detail string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.LastShutdown = &ShutdownRecord{
+		Reason:    reason,
+		Detail:    detail,
+		StartedAt: ds.DaemonStartedAt,
+		At:        time.Now().UTC().Format(time.RFC3339),
+	}
+	ds.DaemonStartedAt = ""
+	return ds.save()
+}
+
+// GetLastCollection returns the timing of the last collection, or nil if
+// none has been recorded.
+func (ds *DataStore) GetLastCollection() *CollectionTiming {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.LastCollection
+}
+
+// SetLastCollection records the timing of a collection that has just
+// finished.
+func (ds *DataStore) SetLastCollection(timing CollectionTiming) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	timing.CollectedAt = time.Now().UTC().Format(time.RFC3339)
+	ds.LastCollection = &timing
+	return ds.save()
+}
+
+// GetLastUpload returns the last payload uploaded in full, or nil if none
+// has been recorded.
+func (ds *DataStore) GetLastUpload() *UploadRecord {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.LastUpload
+}
+
+// SetLastUpload records that the payload with hash has just been uploaded
+// in full.
+func (ds *DataStore) SetLastUpload(hash string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.LastUpload = &UploadRecord{Hash: hash, UploadedAt: time.Now().UTC().Format(time.RFC3339)}
+	return ds.save()
+}
+
+// GetPayloadHistory returns the payloads of the most recent successful
+// syncs, oldest first.
+func (ds *DataStore) GetPayloadHistory() []PayloadSnapshot {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return append([]PayloadSnapshot(nil), ds.PayloadHistory...)
+}
+
+// RecordPayload adds the raw query results of a successful sync to the
+// payload history, dropping the oldest beyond maxPayloadHistory. The results
+// are stored in their JSON form so they compare equal once reloaded. A
+// payload larger than maxPayloadSnapshotBytes is not kept, and clears the
+// history, so that diff does not compare older payloads as the latest.
+func (ds *DataStore) RecordPayload(schemaVersion int, rawQueryResults map[string]interface{}) error {
+	data, err := json.Marshal(rawQueryResults)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+	if len(data) > maxPayloadSnapshotBytes {
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+		ds.PayloadHistory = nil
+		if err := ds.save(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w (%d bytes); it is not kept for diff", ErrPayloadTooLarge, len(data))
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return fmt.Errorf("failed to decode payload: %w", err)
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.PayloadHistory = append(ds.PayloadHistory, PayloadSnapshot{
+		SyncedAt:        time.Now().UTC().Format(time.RFC3339),
+		SchemaVersion:   schemaVersion,
+		RawQueryResults: normalized,
+	})
+	if len(ds.PayloadHistory) > maxPayloadHistory {
+		ds.PayloadHistory = ds.PayloadHistory[len(ds.PayloadHistory)-maxPayloadHistory:]
+	}
+	return ds.save()
+}
+
 // GetRegion returns the region.
 func (ds *DataStore) GetRegion() config.Region {
 	ds.mu.RLock()
@@ -213,6 +712,59 @@ func (ds *DataStore) SetUUID(uuid string) error {
 	return ds.save()
 }
 
+// GetAgentID returns the stable agent ID and the identifier it was derived
+// from, or "" for both when none has been derived yet.
+func (ds *DataStore) GetAgentID() (id, source string) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.AgentID, ds.AgentIDSource
+}
+
+// SetAgentID sets the stable agent ID and the identifier it was derived
+// from.
+func (ds *DataStore) SetAgentID(id, source string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.AgentID = id
+	ds.AgentIDSource = source
+	return ds.save()
+}
+
+// GetRegisteredSerial returns the hardware serial recorded when the device
+// was registered.
+func (ds *DataStore) GetRegisteredSerial() string {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.RegisteredSerial
+}
+
+// SetRegisteredSerial sets the hardware serial recorded when the device was
+// registered.
+func (ds *DataStore) SetRegisteredSerial(serial string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.RegisteredSerial = serial
+	return ds.save()
+}
+
+// GetRegisteredIdentifiers returns the identifiers the device was last
+// registered with, or nil for a device registered before they were
+// recorded.
+func (ds *DataStore) GetRegisteredIdentifiers() *DeviceIdentifiers {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.RegisteredIdentifiers
+}
+
+// SetRegisteredIdentifiers sets the identifiers the device was last
+// registered with.
+func (ds *DataStore) SetRegisteredIdentifiers(identifiers DeviceIdentifiers) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.RegisteredIdentifiers = &identifiers
+	return ds.save()
+}
+
 // GetAppVersion returns the app version.
 func (ds *DataStore) GetAppVersion() string {
 	ds.mu.RLock()
@@ -278,23 +830,42 @@ func (ds *DataStore) Clear() error {
 	defer ds.mu.Unlock()
 
 	path := ds.path
-	mu := ds.mu
 
-	// Reset all fields except path and mutex
+	// Reset all fields except path and mutex. The agent ID is kept, as it
+	// identifies the device across registrations.
 	ds.UUID = ""
+	ds.RegisteredSerial = ""
+	ds.RegisteredIdentifiers = nil
 	ds.AppVersion = ""
-	ds.AccessToken = ""
+	var keyringErr error
+	for _, secret := range ds.secrets() {
+		if err := ds.storeSecret(secret.account, secret.field, ""); err != nil && keyringErr == nil {
+			keyringErr = err
+		}
+		*secret.field = ""
+	}
 	ds.User = nil
 	ds.SyncState = ""
 	ds.LastCheckedAt = ""
 	ds.LastSyncAttemptedAt = ""
+	ds.LastSkipReason = ""
+	ds.LastSkippedAt = ""
+	ds.LastError = nil
+	ds.ConnectionFailures = 0
+	ds.DaemonStartedAt = ""
+	ds.LastShutdown = nil
+	ds.LastCollection = nil
+	ds.LastUpload = nil
+	ds.PayloadHistory = nil
 	ds.ComplianceData = nil
 	ds.WinAvServicesMatchList = nil
 	ds.Region = ""
 	ds.path = path
-	ds.mu = mu
 
-	return ds.save()
+	if err := ds.save(); err != nil {
+		return err
+	}
+	return keyringErr
 }
 
 // Update updates multiple fields at once.
@@ -314,7 +885,9 @@ func (ds *DataStore) Update(updates map[string]interface{}) error {
 			}
 		case "accessToken":
 			if v, ok := value.(string); ok {
-				ds.AccessToken = v
+				if err := ds.storeSecret(keyringAccessTokenAccount, &ds.AccessToken, v); err != nil {
+					return err
+				}
 			}
 		case "syncState":
 			if v, ok := value.(SyncState); ok {
@@ -365,6 +938,23 @@ func (ds *DataStore) MinutesSinceLastAttempt() int {
 	return int(time.Since(lastAttempt).Minutes())
 }
 
+// MinutesSinceLastSuccess returns the minutes since the last successful sync.
+func (ds *DataStore) MinutesSinceLastSuccess() int {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	if ds.LastCheckedAt == "" {
+		return -1
+	}
+
+	lastSuccess, err := time.Parse(time.RFC3339, ds.LastCheckedAt)
+	if err != nil {
+		return -1
+	}
+
+	return int(time.Since(lastSuccess).Minutes())
+}
+
 // HoursSinceLastSuccess returns the hours since the last successful sync.
 func (ds *DataStore) HoursSinceLastSuccess() int {
 	ds.mu.RLock()
@@ -1,8 +1,12 @@
 package datastore
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -35,6 +39,24 @@ func TestDataStoreSetGet(t *testing.T) {
 		t.Errorf("expected UUID %s, got %s", uuid, got)
 	}
 
+	// Test RegisteredSerial
+	serial := "C02TEST123"
+	if err := ds.SetRegisteredSerial(serial); err != nil {
+		t.Fatalf("failed to set registered serial: %v", err)
+	}
+	if got := ds.GetRegisteredSerial(); got != serial {
+		t.Errorf("expected registered serial %s, got %s", serial, got)
+	}
+
+	// Test RegisteredIdentifiers
+	identifiers := DeviceIdentifiers{HardwareSerial: serial, MacAddress: "aa:bb:cc:dd:ee:ff", AssetTag: "IT-1234"}
+	if err := ds.SetRegisteredIdentifiers(identifiers); err != nil {
+		t.Fatalf("failed to set registered identifiers: %v", err)
+	}
+	if got := ds.GetRegisteredIdentifiers(); got == nil || *got != identifiers {
+		t.Errorf("expected registered identifiers %+v, got %+v", identifiers, got)
+	}
+
 	// Test AppVersion
 	version := "1.0.0-test"
 	if err := ds.SetAppVersion(version); err != nil {
@@ -160,6 +182,172 @@ func TestTimeSinceLastAttempt(t *testing.T) {
 	ds.Clear()
 }
 
+func TestLastSkip(t *testing.T) {
+	ds, err := New()
+	if err != nil {
+		t.Fatalf("failed to create data store: %v", err)
+	}
+	ds.Clear()
+
+	if reason, at := ds.GetLastSkip(); reason != "" || at != "" {
+		t.Errorf("expected no skip recorded, got %q at %q", reason, at)
+	}
+
+	if err := ds.SetLastSkip("pre-sync hook exited with status 1"); err != nil {
+		t.Fatalf("failed to set last skip: %v", err)
+	}
+
+	reason, at := ds.GetLastSkip()
+	if reason != "pre-sync hook exited with status 1" {
+		t.Errorf("unexpected skip reason %q", reason)
+	}
+	if _, err := time.Parse(time.RFC3339, at); err != nil {
+		t.Errorf("invalid skip timestamp %q: %v", at, err)
+	}
+
+	ds.Clear()
+	if reason, _ := ds.GetLastSkip(); reason != "" {
+		t.Error("skip reason not cleared")
+	}
+}
+
+func TestLastError(t *testing.T) {
+	ds, err := New()
+	if err != nil {
+		t.Fatalf("failed to create data store: %v", err)
+	}
+	ds.Clear()
+
+	if err := ds.SetSyncError(SyncError{Step: "upload", Message: "token expired", StatusCode: 401, Code: "TOKEN_EXPIRED"}); err != nil {
+		t.Fatalf("failed to set sync error: %v", err)
+	}
+
+	if ds.GetSyncState() != SyncStateError {
+		t.Errorf("expected sync state %s, got %s", SyncStateError, ds.GetSyncState())
+	}
+	got := ds.GetLastError()
+	if got == nil || got.Step != "upload" || got.Code != "TOKEN_EXPIRED" || got.StatusCode != 401 {
+		t.Fatalf("unexpected last error %+v", got)
+	}
+	if _, err := time.Parse(time.RFC3339, got.OccurredAt); err != nil {
+		t.Errorf("invalid error timestamp %q: %v", got.OccurredAt, err)
+	}
+
+	if err := ds.SetSyncState(SyncStateSuccess); err != nil {
+		t.Fatalf("failed to set sync state: %v", err)
+	}
+	if ds.GetLastError() != nil {
+		t.Error("last error not cleared on success")
+	}
+
+	ds.Clear()
+}
+
+func TestSyncConnectionError(t *testing.T) {
+	ds, err := New()
+	if err != nil {
+		t.Fatalf("failed to create data store: %v", err)
+	}
+	ds.Clear()
+
+	syncErr := SyncError{Step: "upload", Message: "no such host"}
+	expected := []SyncState{SyncStateDeferred, SyncStateDeferred, SyncStateError, SyncStateError}
+	for i, state := range expected {
+		if err := ds.SetSyncConnectionError(syncErr, 3); err != nil {
+			t.Fatalf("failed to set sync connection error: %v", err)
+		}
+		if ds.GetSyncState() != state {
+			t.Errorf("failure %d: expected sync state %s, got %s", i+1, state, ds.GetSyncState())
+		}
+		if ds.GetConnectionFailures() != i+1 {
+			t.Errorf("failure %d: expected %d connection failures, got %d", i+1, i+1, ds.GetConnectionFailures())
+		}
+		if ds.GetLastError() == nil {
+			t.Errorf("failure %d: last error not recorded", i+1)
+		}
+	}
+
+	if err := ds.SetSyncState(SyncStateSuccess); err != nil {
+		t.Fatalf("failed to set sync state: %v", err)
+	}
+	if ds.GetConnectionFailures() != 0 {
+		t.Error("connection failures not reset on success")
+	}
+
+	if err := ds.SetSyncConnectionError(syncErr, 1); err != nil {
+		t.Fatalf("failed to set sync connection error: %v", err)
+	}
+	if ds.GetSyncState() != SyncStateError {
+		t.Errorf("expected a threshold of 1 to mark the first failure as %s, got %s", SyncStateError, ds.GetSyncState())
+	}
+
+	ds.Clear()
+}
+
+func TestPayloadHistory(t *testing.T) {
+	ds, err := New()
+	if err != nil {
+		t.Fatalf("failed to create data store: %v", err)
+	}
+	ds.Clear()
+
+	for _, delay := range []int{300, 600, 900} {
+		payload := map[string]interface{}{"screenLockStatus": []interface{}{map[string]int{"idleDelaySeconds": delay}}}
+		if err := ds.RecordPayload(1, payload); err != nil {
+			t.Fatalf("failed to record payload: %v", err)
+		}
+	}
+
+	history := ds.GetPayloadHistory()
+	if len(history) != 2 {
+		t.Fatalf("expected 2 payloads, got %d", len(history))
+	}
+	status := history[0].RawQueryResults["screenLockStatus"].([]interface{})[0].(map[string]interface{})
+	if status["idleDelaySeconds"] != float64(600) {
+		t.Errorf("expected oldest kept payload to be the second, got %v", status)
+	}
+
+	large := map[string]interface{}{"appList": strings.Repeat("x", maxPayloadSnapshotBytes)}
+	if err := ds.RecordPayload(1, large); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("expected ErrPayloadTooLarge, got %v", err)
+	}
+	if len(ds.GetPayloadHistory()) != 0 {
+		t.Error("expected a payload too large to keep to clear the history")
+	}
+
+	if err := ds.RecordPayload(1, map[string]interface{}{}); err != nil {
+		t.Fatalf("failed to record payload: %v", err)
+	}
+	ds.Clear()
+	if len(ds.GetPayloadHistory()) != 0 {
+		t.Error("payload history not cleared")
+	}
+}
+
+func TestLastUpload(t *testing.T) {
+	ds, err := New()
+	if err != nil {
+		t.Fatalf("failed to create data store: %v", err)
+	}
+	ds.Clear()
+
+	if ds.GetLastUpload() != nil {
+		t.Fatal("expected no upload recorded")
+	}
+	if err := ds.SetLastUpload("abc123"); err != nil {
+		t.Fatalf("failed to record upload: %v", err)
+	}
+	upload := ds.GetLastUpload()
+	if upload == nil || upload.Hash != "abc123" || upload.UploadedAt == "" {
+		t.Errorf("unexpected upload record: %+v", upload)
+	}
+
+	ds.Clear()
+	if ds.GetLastUpload() != nil {
+		t.Error("last upload not cleared")
+	}
+}
+
 func TestClear(t *testing.T) {
 	ds, err := New()
 	if err != nil {
@@ -168,8 +356,12 @@ func TestClear(t *testing.T) {
 
 	// Set some data
 	ds.SetAccessToken("token")
+	ds.SetRefreshToken("refresh")
 	ds.SetUUID("uuid")
+	ds.SetRegisteredSerial("serial")
+	ds.SetRegisteredIdentifiers(DeviceIdentifiers{HardwareSerial: "serial"})
 	ds.SetAppVersion("1.0.0")
+	ds.SetAgentID("agent-id", "hardwareSerial")
 
 	// Clear
 	if err := ds.Clear(); err != nil {
@@ -180,12 +372,24 @@ func TestClear(t *testing.T) {
 	if ds.GetAccessToken() != "" {
 		t.Error("access token not cleared")
 	}
+	if ds.GetRefreshToken() != "" {
+		t.Error("refresh token not cleared")
+	}
 	if ds.GetUUID() != "" {
 		t.Error("UUID not cleared")
 	}
+	if ds.GetRegisteredSerial() != "" {
+		t.Error("registered serial not cleared")
+	}
+	if ds.GetRegisteredIdentifiers() != nil {
+		t.Error("registered identifiers not cleared")
+	}
 	if ds.GetAppVersion() != "" {
 		t.Error("app version not cleared")
 	}
+	if id, source := ds.GetAgentID(); id != "agent-id" || source != "hardwareSerial" {
+		t.Errorf("expected the agent ID to be kept, got %q from %q", id, source)
+	}
 }
 
 func TestDataStorePersistence(t *testing.T) {
@@ -270,3 +474,241 @@ func TestDataStoreFile(t *testing.T) {
 
 	ds.Clear()
 }
+
+func TestReadOnly(t *testing.T) {
+	ds := &DataStore{path: filepath.Join(t.TempDir(), "data", "app-data.json"), readOnly: true, UUID: "loaded"}
+
+	// Reads still work
+	if ds.GetUUID() != "loaded" || ds.IsRegistered() {
+		t.Error("unexpected read from read-only data store")
+	}
+
+	// Writes report that the store is read-only
+	if err := ds.SetUUID("changed"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+
+	for _, err := range []error{
+		&fs.PathError{Op: "open", Path: "app-data.json", Err: syscall.EROFS},
+		&fs.PathError{Op: "open", Path: "app-data.json", Err: syscall.EACCES},
+	} {
+		if !isReadOnlyError(err) {
+			t.Errorf("expected %v to be a read-only error", err)
+		}
+	}
+	if isReadOnlyError(&fs.PathError{Op: "open", Path: "app-data.json", Err: syscall.ENOSPC}) {
+		t.Error("ENOSPC is not a read-only error")
+	}
+}
+
+func TestSaveDiskFull(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app-data.json")
+	ds := &DataStore{path: path}
+	if err := ds.SetUUID("good"); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate running out of space partway through the write
+	original := writeTemp
+	writeTemp = func(f *os.File, data []byte) (int, error) {
+		n, _ := f.Write(data[:len(data)/2])
+		return n, &fs.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+	}
+	t.Cleanup(func() { writeTemp = original })
+
+	if err := ds.SetUUID("bad"); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("expected ErrDiskFull, got %v", err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(good) {
+		t.Error("previous data was not left intact")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files were left behind: %v", entries)
+	}
+}
+
+// memoryKeyring is a Keyring that keeps the tokens in memory. With err set,
+// every call fails with it.
+type memoryKeyring struct {
+	tokens map[string]string
+	err    error
+}
+
+func (k *memoryKeyring) Get(account string) (string, error) { return k.tokens[account], k.err }
+
+func (k *memoryKeyring) Set(account, token string) error {
+	if k.err != nil {
+		return k.err
+	}
+	if k.tokens == nil {
+		k.tokens = make(map[string]string)
+	}
+	k.tokens[account] = token
+	return nil
+}
+
+func (k *memoryKeyring) Delete(account string) error {
+	if k.err != nil {
+		return k.err
+	}
+	delete(k.tokens, account)
+	return nil
+}
+
+func TestUseKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app-data.json")
+	ds := &DataStore{path: path, AccessToken: "file-token", RefreshToken: "file-refresh", DeviceKey: "file-seed"}
+	if err := ds.save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	// Tokens in the file move to the keyring
+	keyring := &memoryKeyring{}
+	if err := ds.UseKeyring(keyring); err != nil {
+		t.Fatalf("failed to use keyring: %v", err)
+	}
+	if keyring.tokens["access-token"] != "file-token" || ds.GetAccessToken() != "file-token" {
+		t.Errorf("expected access token moved to keyring, got keyring %q, store %q", keyring.tokens["access-token"], ds.GetAccessToken())
+	}
+	if keyring.tokens["refresh-token"] != "file-refresh" || ds.GetRefreshToken() != "file-refresh" {
+		t.Errorf("expected refresh token moved to keyring, got keyring %q, store %q", keyring.tokens["refresh-token"], ds.GetRefreshToken())
+	}
+	if keyring.tokens["device-key"] != "file-seed" || ds.GetDeviceKey() != "file-seed" {
+		t.Errorf("expected device key moved to keyring, got keyring %q, store %q", keyring.tokens["device-key"], ds.GetDeviceKey())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read data file: %v", err)
+	}
+	if strings.Contains(string(data), "file-token") || strings.Contains(string(data), "file-refresh") || strings.Contains(string(data), "file-seed") {
+		t.Error("secrets left in data file")
+	}
+
+	// New tokens are only written to the keyring
+	if err := ds.SetAccessToken("new-token"); err != nil {
+		t.Fatalf("failed to set access token: %v", err)
+	}
+	if err := ds.SetRefreshToken("new-refresh"); err != nil {
+		t.Fatalf("failed to set refresh token: %v", err)
+	}
+	if keyring.tokens["access-token"] != "new-token" || keyring.tokens["refresh-token"] != "new-refresh" {
+		t.Errorf("expected new tokens in keyring, got %v", keyring.tokens)
+	}
+	data, _ = os.ReadFile(path)
+	if strings.Contains(string(data), "new-token") || strings.Contains(string(data), "new-refresh") {
+		t.Error("tokens written to data file")
+	}
+
+	// A fresh store reads the token from the keyring
+	reloaded := &DataStore{path: path}
+	if err := reloaded.load(); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if err := reloaded.UseKeyring(keyring); err != nil {
+		t.Fatalf("failed to use keyring: %v", err)
+	}
+	if !reloaded.IsRegistered() || reloaded.GetAccessToken() != "new-token" || reloaded.GetRefreshToken() != "new-refresh" {
+		t.Errorf("expected tokens from keyring, got %q and %q", reloaded.GetAccessToken(), reloaded.GetRefreshToken())
+	}
+
+	// Clearing the store deletes the tokens from the keyring
+	if err := reloaded.Clear(); err != nil {
+		t.Fatalf("failed to clear: %v", err)
+	}
+	if len(keyring.tokens) != 0 || reloaded.IsRegistered() {
+		t.Errorf("expected tokens deleted from keyring, got %v", keyring.tokens)
+	}
+}
+
+func TestUseKeyringFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app-data.json")
+	ds := &DataStore{path: path, AccessToken: "file-token", RefreshToken: "file-refresh"}
+	if err := ds.save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	// A keyring that cannot be written leaves the tokens in the file
+	broken := &memoryKeyring{err: errors.New("locked")}
+	if err := ds.UseKeyring(broken); err == nil {
+		t.Fatal("expected an error from a broken keyring")
+	}
+	if ds.GetAccessToken() != "file-token" || ds.GetRefreshToken() != "file-refresh" {
+		t.Errorf("expected tokens kept, got %q and %q", ds.GetAccessToken(), ds.GetRefreshToken())
+	}
+	if err := ds.SetAccessToken("new-token"); err != nil {
+		t.Fatalf("failed to set access token: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "new-token") || !strings.Contains(string(data), "file-refresh") {
+		t.Errorf("expected tokens in data file, got %s", data)
+	}
+
+	// A keyring that fails once in use keeps the previous token
+	keyring := &memoryKeyring{}
+	if err := ds.UseKeyring(keyring); err != nil {
+		t.Fatalf("failed to use keyring: %v", err)
+	}
+	keyring.err = errors.New("locked")
+	if err := ds.SetAccessToken("newer-token"); err == nil {
+		t.Error("expected an error storing the token in a broken keyring")
+	}
+	if ds.GetAccessToken() != "new-token" {
+		t.Errorf("expected previous token kept, got %q", ds.GetAccessToken())
+	}
+}
+
+func TestDaemonShutdown(t *testing.T) {
+	ds := &DataStore{path: filepath.Join(t.TempDir(), "app-data.json")}
+
+	// The first start has no previous run
+	previous, err := ds.RecordDaemonStart()
+	if err != nil {
+		t.Fatalf("failed to record start: %v", err)
+	}
+	if previous != nil {
+		t.Errorf("expected no previous run, got %+v", previous)
+	}
+	startedAt := ds.GetDaemonStartedAt()
+	if startedAt == "" {
+		t.Fatal("expected start time to be recorded")
+	}
+
+	// A clean shutdown is reported at the next start
+	if err := ds.RecordDaemonShutdown(ShutdownSignal, "SIGTERM"); err != nil {
+		t.Fatalf("failed to record shutdown: %v", err)
+	}
+	if ds.GetDaemonStartedAt() != "" {
+		t.Error("expected start time cleared on shutdown")
+	}
+	previous, err = ds.RecordDaemonStart()
+	if err != nil {
+		t.Fatalf("failed to record start: %v", err)
+	}
+	if previous == nil || previous.Reason != ShutdownSignal || previous.Detail != "SIGTERM" || previous.StartedAt != startedAt || previous.At == "" {
+		t.Errorf("unexpected previous shutdown: %+v", previous)
+	}
+
+	// A run that never recorded a shutdown is reported as a crash
+	startedAt = ds.GetDaemonStartedAt()
+	previous, err = ds.RecordDaemonStart()
+	if err != nil {
+		t.Fatalf("failed to record start: %v", err)
+	}
+	if previous == nil || previous.Reason != ShutdownCrash || previous.StartedAt != startedAt || previous.At != "" {
+		t.Errorf("expected crash, got %+v", previous)
+	}
+	if got := ds.GetLastShutdown(); got == nil || got.Reason != ShutdownCrash {
+		t.Errorf("expected crash recorded, got %+v", got)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package datastore
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isDiskFull reports whether err means there is no space left on the disk.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package datastore
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// isDiskFull reports whether err means there is no space left on the disk.
+// Windows reports a full disk as ERROR_DISK_FULL, or ERROR_HANDLE_DISK_FULL
+// for some writes through an open handle, rather than ENOSPC.
+func isDiskFull(err error) bool {
+	return errors.Is(err, windows.ERROR_DISK_FULL) || errors.Is(err, windows.ERROR_HANDLE_DISK_FULL) || errors.Is(err, syscall.ENOSPC)
+}
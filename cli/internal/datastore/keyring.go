@@ -0,0 +1,294 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strings"
+)
+
+// ErrKeyringUnavailable is returned by SystemKeyring when the platform has
+// no usable OS secret store, such as on headless Linux.
+var ErrKeyringUnavailable = errors.New("no OS keyring is available")
+
+// Keyring keeps the agent's tokens and device key in an OS secret store
+// instead of app-data.json, one entry per account.
+type Keyring interface {
+	// Get returns the token stored for account, or an empty string if
+	// there is none.
+	Get(account string) (string, error)
+	Set(account, token string) error
+	// Delete removes the token stored for account. Deleting a missing
+	// token is not an error.
+	Delete(account string) error
+}
+
+// keyringService identifies the agent's entries in the OS secret store;
+// the accounts name the entries within it.
+const (
+	keyringService             = "drata-agent"
+	keyringAccessTokenAccount  = "access-token"
+	keyringRefreshTokenAccount = "refresh-token"
+	keyringDeviceKeyAccount    = "device-key"
+)
+
+// Process identity, replaced in tests.
+var (
+	geteuid     = os.Geteuid
+	currentUser = user.Current
+)
+
+// serviceAccountSIDs are the Windows accounts services run as: LocalSystem,
+// LocalService and NetworkService.
+var serviceAccountSIDs = map[string]bool{
+	"S-1-5-18": true,
+	"S-1-5-19": true,
+	"S-1-5-20": true,
+}
+
+// isServiceAccount reports whether the agent runs as root or a Windows
+// service account, as the daemon does when installed as a service. Such an
+// account has no login keyring: it cannot reach the keyring of the user who
+// configured token_storage, and one it created for itself would not be
+// unlocked.
+func isServiceAccount() bool {
+	if runtime.GOOS != "windows" {
+		return geteuid() == 0
+	}
+	current, err := currentUser()
+	return err == nil && serviceAccountSIDs[current.Uid]
+}
+
+// SystemKeyring returns the secret store of the current platform: the
+// Keychain on macOS, the Credential Manager on Windows, and the Secret
+// Service through libsecret's secret-tool on Linux. It returns an error
+// wrapping ErrKeyringUnavailable when running as a service account, when
+// the tool it needs is missing or, on Linux, when there is no D-Bus session
+// to reach the Secret Service over.
+func SystemKeyring() (Keyring, error) {
+	if isServiceAccount() {
+		return nil, fmt.Errorf("%w: the agent runs as a service account, which cannot reach a user's keyring", ErrKeyringUnavailable)
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := exec.LookPath("security"); err != nil {
+			return nil, fmt.Errorf("%w: security not found", ErrKeyringUnavailable)
+		}
+		return macKeychain{}, nil
+	case "windows":
+		if _, err := exec.LookPath("powershell"); err != nil {
+			return nil, fmt.Errorf("%w: powershell not found", ErrKeyringUnavailable)
+		}
+		return windowsCredentialStore{}, nil
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, fmt.Errorf("%w: secret-tool (libsecret) not found", ErrKeyringUnavailable)
+		}
+		if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+			return nil, fmt.Errorf("%w: no D-Bus session for the Secret Service", ErrKeyringUnavailable)
+		}
+		return secretServiceKeyring{}, nil
+	default:
+		return nil, fmt.Errorf("%w on %s", ErrKeyringUnavailable, runtime.GOOS)
+	}
+}
+
+// runKeyringCommand runs a secret store tool, passing stdin on standard
+// input so the token never appears in the process list. It returns the
+// trimmed standard output, or standard error when the tool exits non-zero,
+// and the exit code; err is only set when the tool could not be run at all.
+func runKeyringCommand(stdin string, name string, args ...string) (string, int, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return strings.TrimSpace(stderr.String()), exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to run %s: %w", name, err)
+	}
+	return strings.TrimSpace(stdout.String()), 0, nil
+}
+
+// keyringCommandError describes a secret store tool that exited non-zero.
+func keyringCommandError(action, account, name string, exitCode int, output string) error {
+	if output == "" {
+		return fmt.Errorf("failed to %s %s with %s: exit code %d", action, account, name, exitCode)
+	}
+	return fmt.Errorf("failed to %s %s with %s: %s", action, account, name, output)
+}
+
+// macKeychain stores the token as a generic password in the user's login
+// keychain.
+type macKeychain struct{}
+
+// securityItemNotFound is the exit code of security when no item matches.
+const securityItemNotFound = 44
+
+func (macKeychain) Get(account string) (string, error) {
+	output, exitCode, err := runKeyringCommand("", "security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	switch {
+	case err != nil:
+		return "", err
+	case exitCode == securityItemNotFound:
+		return "", nil
+	case exitCode != 0:
+		return "", keyringCommandError("read", account, "security", exitCode, output)
+	}
+	return output, nil
+}
+
+func (macKeychain) Set(account, token string) error {
+	// security -i reads commands from standard input, which keeps the token
+	// out of its arguments
+	command, err := securityCommandLine("add-generic-password", "-U", "-s", keyringService, "-a", account, "-w", token)
+	if err != nil {
+		return err
+	}
+	output, exitCode, err := runKeyringCommand(command, "security", "-i")
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return keyringCommandError("store", account, "security", exitCode, output)
+	}
+	return nil
+}
+
+func (macKeychain) Delete(account string) error {
+	output, exitCode, err := runKeyringCommand("", "security", "delete-generic-password", "-s", keyringService, "-a", account)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 && exitCode != securityItemNotFound {
+		return keyringCommandError("delete", account, "security", exitCode, output)
+	}
+	return nil
+}
+
+// securityCommandLine returns a command line for security -i. Each argument
+// is put in double quotes with backslashes and double quotes escaped, which
+// is how security splits its input; a line break would end the command, so
+// an argument with one is refused.
+func securityCommandLine(args ...string) (string, error) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.ContainsAny(arg, "\r\n") {
+			return "", fmt.Errorf("cannot pass a value with a line break to security")
+		}
+		arg = strings.ReplaceAll(arg, `\`, `\\`)
+		arg = strings.ReplaceAll(arg, `"`, `\"`)
+		quoted[i] = `"` + arg + `"`
+	}
+	return strings.Join(quoted, " ") + "\n", nil
+}
+
+// secretServiceKeyring stores the token through the Secret Service, such as
+// GNOME Keyring or KWallet, using libsecret's secret-tool.
+type secretServiceKeyring struct{}
+
+func (secretServiceKeyring) Get(account string) (string, error) {
+	output, exitCode, err := runKeyringCommand("", "secret-tool", "lookup", "service", keyringService, "account", account)
+	switch {
+	case err != nil:
+		return "", err
+	case exitCode == 1 && output == "":
+		// secret-tool exits 1 without a message when nothing matches
+		return "", nil
+	case exitCode != 0:
+		return "", keyringCommandError("read", account, "secret-tool", exitCode, output)
+	}
+	return output, nil
+}
+
+func (secretServiceKeyring) Set(account, token string) error {
+	output, exitCode, err := runKeyringCommand(token, "secret-tool", "store", "--label", "Drata Agent "+account, "service", keyringService, "account", account)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return keyringCommandError("store", account, "secret-tool", exitCode, output)
+	}
+	return nil
+}
+
+func (secretServiceKeyring) Delete(account string) error {
+	output, exitCode, err := runKeyringCommand("", "secret-tool", "clear", "service", keyringService, "account", account)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 && output != "" {
+		return keyringCommandError("delete", account, "secret-tool", exitCode, output)
+	}
+	return nil
+}
+
+// windowsCredentialStore stores the token in the Credential Manager through
+// the Windows Runtime PasswordVault, which Windows PowerShell can load
+// without extra modules.
+type windowsCredentialStore struct{}
+
+// passwordVaultScript loads the PasswordVault into $vault.
+const passwordVaultScript = `$ErrorActionPreference = 'Stop'; ` +
+	`[void][Windows.Security.Credentials.PasswordVault, Windows.Security.Credentials, ContentType = WindowsRuntime]; ` +
+	`$vault = New-Object Windows.Security.Credentials.PasswordVault; `
+
+// passwordVaultNotFound is the exit code the scripts use when the vault
+// has no entry for the token.
+const passwordVaultNotFound = 3
+
+// runPasswordVault runs script after passwordVaultScript.
+func runPasswordVault(stdin, script string) (string, int, error) {
+	return runKeyringCommand(stdin, "powershell", "-NoProfile", "-NonInteractive", "-Command", passwordVaultScript+script)
+}
+
+func (windowsCredentialStore) Get(account string) (string, error) {
+	output, exitCode, err := runPasswordVault("", fmt.Sprintf(
+		`try { $c = $vault.Retrieve('%s', '%s') } catch { exit %d }; $c.RetrievePassword(); [Console]::Out.Write($c.Password)`,
+		keyringService, account, passwordVaultNotFound))
+	switch {
+	case err != nil:
+		return "", err
+	case exitCode == passwordVaultNotFound:
+		return "", nil
+	case exitCode != 0:
+		return "", keyringCommandError("read", account, "PowerShell", exitCode, output)
+	}
+	return output, nil
+}
+
+func (windowsCredentialStore) Set(account, token string) error {
+	// Adding a credential for an existing resource and user replaces it
+	output, exitCode, err := runPasswordVault(token, fmt.Sprintf(
+		`$vault.Add((New-Object Windows.Security.Credentials.PasswordCredential('%s', '%s', [Console]::In.ReadToEnd())))`,
+		keyringService, account))
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return keyringCommandError("store", account, "PowerShell", exitCode, output)
+	}
+	return nil
+}
+
+func (windowsCredentialStore) Delete(account string) error {
+	output, exitCode, err := runPasswordVault("", fmt.Sprintf(
+		`try { $c = $vault.Retrieve('%s', '%s') } catch { exit 0 }; $vault.Remove($c)`,
+		keyringService, account))
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return keyringCommandError("delete", account, "PowerShell", exitCode, output)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package datastore
+
+import (
+	"errors"
+	"os/user"
+	"runtime"
+	"testing"
+)
+
+func TestSecurityCommandLine(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{[]string{"add-generic-password", "-w", "eyJhbGciOi.J9"}, `"add-generic-password" "-w" "eyJhbGciOi.J9"` + "\n", false},
+		// Quotes and backslashes are escaped, not Go-quoted
+		{[]string{"-w", `a"b\c`}, `"-w" "a\"b\\c"` + "\n", false},
+		{[]string{"-w", "naïve token"}, `"-w" "naïve token"` + "\n", false},
+		{[]string{"-w", "a\nb"}, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := securityCommandLine(tt.args...)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("securityCommandLine(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("securityCommandLine(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSystemKeyringServiceAccount(t *testing.T) {
+	defer func(euid func() int, current func() (*user.User, error)) {
+		geteuid, currentUser = euid, current
+	}(geteuid, currentUser)
+	geteuid = func() int { return 0 }
+	currentUser = func() (*user.User, error) { return &user.User{Uid: "S-1-5-18"}, nil }
+
+	// A daemon running as root or LocalSystem cannot reach a user's keyring
+	if _, err := SystemKeyring(); !errors.Is(err, ErrKeyringUnavailable) {
+		t.Errorf("expected ErrKeyringUnavailable for a service account, got %v", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		currentUser = func() (*user.User, error) { return &user.User{Uid: "S-1-5-21-1-2-3-1001"}, nil }
+	} else {
+		geteuid = func() int { return 501 }
+	}
+	if isServiceAccount() {
+		t.Error("expected a user account not to be a service account")
+	}
+}
@@ -0,0 +1,121 @@
+// Package diff compares collected payloads field by field.
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ChangeKind describes how a field changed between two payloads.
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Changed ChangeKind = "changed"
+)
+
+// Change is a single field-level difference between two payloads.
+type Change struct {
+	Kind ChangeKind  `json:"kind"`
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Compare returns the differences between old and new, which must be
+// decoded JSON values (maps, slices, and scalars). Maps are compared key by
+// key; lists are compared as sets, so reordering is not a change and list
+// entries are reported as added or removed. Changes are sorted by path.
+func Compare(old, new interface{}) []Change {
+	var changes []Change
+	compare("", old, new, &changes)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// compare appends the differences between old and new at path to changes.
+func compare(path string, old, new interface{}, changes *[]Change) {
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		for key, oldValue := range oldMap {
+			newValue, ok := newMap[key]
+			if !ok {
+				*changes = append(*changes, Change{Kind: Removed, Path: join(path, key), Old: oldValue})
+				continue
+			}
+			compare(join(path, key), oldValue, newValue, changes)
+		}
+		for key, newValue := range newMap {
+			if _, ok := oldMap[key]; !ok {
+				*changes = append(*changes, Change{Kind: Added, Path: join(path, key), New: newValue})
+			}
+		}
+		return
+	}
+
+	oldList, oldIsList := old.([]interface{})
+	newList, newIsList := new.([]interface{})
+	if oldIsList && newIsList {
+		compareLists(path, oldList, newList, changes)
+		return
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, Change{Kind: Changed, Path: path, Old: old, New: new})
+	}
+}
+
+// compareLists appends the entries only in old as removed and the entries
+// only in new as added.
+func compareLists(path string, old, new []interface{}, changes *[]Change) {
+	oldCounts := make(map[string]int)
+	for _, item := range old {
+		oldCounts[key(item)]++
+	}
+	newCounts := make(map[string]int)
+	for _, item := range new {
+		newCounts[key(item)]++
+	}
+
+	listPath := path + "[]"
+	for _, item := range old {
+		k := key(item)
+		if newCounts[k] > 0 {
+			newCounts[k]--
+			continue
+		}
+		*changes = append(*changes, Change{Kind: Removed, Path: listPath, Old: item})
+	}
+	for _, item := range new {
+		k := key(item)
+		if oldCounts[k] > 0 {
+			oldCounts[k]--
+			continue
+		}
+		*changes = append(*changes, Change{Kind: Added, Path: listPath, New: item})
+	}
+}
+
+// key returns a canonical string for a decoded JSON value. encoding/json
+// sorts map keys, so equal values produce equal keys.
+func key(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// join appends a map key to a dotted path.
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,50 @@
+package diff
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid JSON %q: %v", s, err)
+	}
+	return v
+}
+
+func TestCompare(t *testing.T) {
+	old := decode(t, `{
+		"appList": [{"name": "Slack", "version": "4.1"}, {"name": "Zoom", "version": "5.0"}],
+		"screenLockStatus": [{"idleDelaySeconds": 300}],
+		"firewallStatus": {"global_state": "1"},
+		"wsl": {"detected": true}
+	}`)
+	new := decode(t, `{
+		"appList": [{"name": "Zoom", "version": "5.0"}, {"name": "Slack", "version": "4.2"}],
+		"screenLockStatus": [{"idleDelaySeconds": 300}],
+		"firewallStatus": {"global_state": "0"},
+		"autoUpdateEnabled": true
+	}`)
+
+	expected := []Change{
+		{Kind: Removed, Path: "appList[]", Old: map[string]interface{}{"name": "Slack", "version": "4.1"}},
+		{Kind: Added, Path: "appList[]", New: map[string]interface{}{"name": "Slack", "version": "4.2"}},
+		{Kind: Added, Path: "autoUpdateEnabled", New: true},
+		{Kind: Changed, Path: "firewallStatus.global_state", Old: "1", New: "0"},
+		{Kind: Removed, Path: "wsl", Old: map[string]interface{}{"detected": true}},
+	}
+
+	if got := Compare(old, new); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestCompareIdentical(t *testing.T) {
+	payload := `{"appList": [{"name": "Slack"}, {"name": "Slack"}], "hwModel": {"hardware_model": "MacBookPro18,3"}}`
+	if got := Compare(decode(t, payload), decode(t, payload)); len(got) != 0 {
+		t.Errorf("expected no changes, got %+v", got)
+	}
+}
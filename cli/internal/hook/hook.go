@@ -0,0 +1,88 @@
+// Package hook runs operator-supplied executables around agent operations.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// preSyncTimeout bounds how long a pre-sync hook may run.
+	preSyncTimeout = 60 * time.Second
+
+	// maxReasonLength caps how much hook output is kept as a skip reason.
+	maxReasonLength = 200
+)
+
+// PreSyncContext describes the sync a pre-sync hook is asked to gate.
+type PreSyncContext struct {
+	Platform     string
+	AgentVersion string
+	Forced       bool
+}
+
+// RunPreSync runs the pre-sync hook at path. It returns an empty string if
+// the sync may proceed, or a human-readable reason if it must be skipped.
+// A hook that cannot be started or times out also blocks the sync, so a
+// broken gate never lets data through.
+func RunPreSync(path string, syncCtx PreSyncContext) string {
+	if path == "" {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), preSyncTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(),
+		"DRATA_PLATFORM="+syncCtx.Platform,
+		"DRATA_AGENT_VERSION="+syncCtx.AgentVersion,
+		"DRATA_SYNC_FORCED="+strconv.FormatBool(syncCtx.Forced),
+	)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if err == nil {
+		return ""
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Sprintf("pre-sync hook timed out after %s", preSyncTimeout)
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return fmt.Sprintf("pre-sync hook could not be run: %v", err)
+	}
+
+	reason := fmt.Sprintf("pre-sync hook exited with status %d", exitErr.ExitCode())
+	if detail := firstLine(output.String()); detail != "" {
+		reason += ": " + detail
+	}
+	return reason
+}
+
+// firstLine returns the first non-empty line of s, truncated to maxReasonLength.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(line) > maxReasonLength {
+			line = line[:maxReasonLength]
+		}
+		return line
+	}
+	return ""
+}
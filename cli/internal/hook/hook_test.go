@@ -0,0 +1,52 @@
+package hook
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0700); err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+	return path
+}
+
+func TestRunPreSyncNoHook(t *testing.T) {
+	if reason := RunPreSync("", PreSyncContext{}); reason != "" {
+		t.Errorf("expected no reason, got %q", reason)
+	}
+}
+
+func TestRunPreSyncAllows(t *testing.T) {
+	path := writeScript(t, "exit 0\n")
+	if reason := RunPreSync(path, PreSyncContext{Platform: "LINUX"}); reason != "" {
+		t.Errorf("expected no reason, got %q", reason)
+	}
+}
+
+func TestRunPreSyncBlocks(t *testing.T) {
+	path := writeScript(t, "echo \"guest network ($DRATA_PLATFORM, forced=$DRATA_SYNC_FORCED)\"\nexit 3\n")
+	reason := RunPreSync(path, PreSyncContext{Platform: "LINUX", Forced: true})
+
+	expected := "pre-sync hook exited with status 3: guest network (LINUX, forced=true)"
+	if reason != expected {
+		t.Errorf("expected %q, got %q", expected, reason)
+	}
+}
+
+func TestRunPreSyncMissingBinary(t *testing.T) {
+	reason := RunPreSync(filepath.Join(t.TempDir(), "missing"), PreSyncContext{})
+	if !strings.HasPrefix(reason, "pre-sync hook could not be run") {
+		t.Errorf("unexpected reason: %q", reason)
+	}
+}
@@ -0,0 +1,82 @@
+// Package logging provides log helpers for the long-running daemon.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Deduplicator collapses repeated identical log messages. The first
+// occurrence of a message is logged immediately; identical repeats are
+// counted and summarized at most once per window, or as soon as a different
+// message is logged or Reset is called.
+type Deduplicator struct {
+	window time.Duration
+	output func(string)
+	now    func() time.Time
+
+	mu          sync.Mutex
+	last        string
+	repeats     int
+	repeatSince time.Time
+	lastLogged  time.Time
+}
+
+// NewDeduplicator returns a Deduplicator that writes to the standard logger
+// and summarizes repeats at most once per window.
+func NewDeduplicator(window time.Duration) *Deduplicator {
+	return &Deduplicator{
+		window: window,
+		output: func(msg string) { log.Print(msg) },
+		now:    time.Now,
+	}
+}
+
+// Printf logs the formatted message unless it repeats the previous one.
+func (d *Deduplicator) Printf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if msg != d.last {
+		d.flush(now)
+		d.output(msg)
+		d.last = msg
+		d.lastLogged = now
+		return
+	}
+
+	if d.repeats == 0 {
+		d.repeatSince = now
+	}
+	d.repeats++
+	if now.Sub(d.lastLogged) >= d.window {
+		d.flush(now)
+	}
+}
+
+// Reset summarizes any pending repeats and forgets the previous message, so
+// the next message is logged immediately. Call it when state changes, such
+// as after a successful sync.
+func (d *Deduplicator) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.flush(d.now())
+	d.last = ""
+}
+
+// flush logs a summary of pending repeats. d.mu must be held.
+func (d *Deduplicator) flush(now time.Time) {
+	if d.repeats == 0 {
+		return
+	}
+	d.output(fmt.Sprintf("last message repeated %d times in the past %s: %s",
+		d.repeats, now.Sub(d.repeatSince).Round(time.Second), d.last))
+	d.repeats = 0
+	d.lastLogged = now
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDeduplicator returns a Deduplicator with a controllable clock that
+// records what it logs.
+func newTestDeduplicator(window time.Duration) (*Deduplicator, *[]string, *time.Time) {
+	var logged []string
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := &Deduplicator{
+		window: window,
+		output: func(msg string) { logged = append(logged, msg) },
+		now:    func() time.Time { return now },
+	}
+	return d, &logged, &now
+}
+
+func TestDeduplicatorCollapsesRepeats(t *testing.T) {
+	d, logged, now := newTestDeduplicator(time.Hour)
+
+	d.Printf("Sync error: %s", "network down")
+	for i := 0; i < 3; i++ {
+		*now = now.Add(10 * time.Minute)
+		d.Printf("Sync error: %s", "network down")
+	}
+
+	if len(*logged) != 1 {
+		t.Fatalf("expected only the first occurrence, got %q", *logged)
+	}
+
+	*now = now.Add(40 * time.Minute)
+	d.Printf("Sync error: %s", "network down")
+
+	if len(*logged) != 2 {
+		t.Fatalf("expected a summary after the window, got %q", *logged)
+	}
+	if !strings.HasPrefix((*logged)[1], "last message repeated 4 times in the past 1h0m0s") {
+		t.Errorf("unexpected summary %q", (*logged)[1])
+	}
+}
+
+func TestDeduplicatorLogsChangesPromptly(t *testing.T) {
+	d, logged, now := newTestDeduplicator(time.Hour)
+
+	d.Printf("Sync error: network down")
+	*now = now.Add(time.Minute)
+	d.Printf("Sync error: network down")
+	d.Printf("Sync error: token expired")
+
+	expected := []string{
+		"Sync error: network down",
+		"last message repeated 1 times in the past 0s: Sync error: network down",
+		"Sync error: token expired",
+	}
+	if strings.Join(*logged, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, *logged)
+	}
+}
+
+func TestDeduplicatorReset(t *testing.T) {
+	d, logged, _ := newTestDeduplicator(time.Hour)
+
+	d.Printf("Sync error: network down")
+	d.Reset()
+	d.Printf("Sync error: network down")
+
+	if len(*logged) != 2 {
+		t.Errorf("expected the message to be logged again after reset, got %q", *logged)
+	}
+}
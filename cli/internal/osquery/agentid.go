@@ -0,0 +1,75 @@
+package osquery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Agent ID sources, from the most to the least stable. The device UUID is
+// the agent's own and only used when the hardware reports nothing usable.
+const (
+	AgentIDSourceHardwareSerial = "hardwareSerial"
+	AgentIDSourceBoardSerial    = "boardSerial"
+	AgentIDSourceMac            = "macAddress"
+	AgentIDSourceDeviceUUID     = "deviceUUID"
+)
+
+// placeholderIdentifiers are values firmware reports in place of a real
+// serial, shared by every machine that reports them.
+var placeholderIdentifiers = map[string]bool{
+	"0":                      true,
+	"none":                   true,
+	"n/a":                    true,
+	"default string":         true,
+	"to be filled by o.e.m.": true,
+	"system serial number":   true,
+	"not applicable":         true,
+	"not specified":          true,
+}
+
+// DeviceIdentifiers returns the hardware serial, board serial and MAC
+// address the hwSerial, systemInfo and macAddress checks collected into r,
+// leaving blank any that were not collected.
+func (r *QueryResult) DeviceIdentifiers() *AgentDeviceIdentifiers {
+	identifiers := &AgentDeviceIdentifiers{}
+	if hwSerial, ok := r.RawQueryResults["hwSerial"].(map[string]interface{}); ok {
+		identifiers.HWSerial.HardwareSerial, _ = hwSerial["hardware_serial"].(string)
+	}
+	identifiers.HWSerial.BoardSerial, _ = r.RawQueryResults["boardSerial"].(string)
+	if mac, ok := r.RawQueryResults["macAddress"].(map[string]interface{}); ok {
+		identifiers.MacAddress.Mac, _ = mac["mac"].(string)
+	}
+	identifiers.DeviceName = r.DeviceName
+	identifiers.AssetTag = r.AssetTag
+	return identifiers
+}
+
+// DeriveAgentID derives the agent ID from the most stable identifier that
+// is usable: the hardware serial, board serial, MAC address, then the
+// device UUID. The ID is the first 16 bytes of the SHA-256 of the source
+// and value, in hex, so the same identifier always gives the same ID. The
+// hash is unsalted, so it hides the identifier only from casual reading:
+// anyone who can guess a serial or MAC can check it against the ID. It
+// returns "" when nothing is usable.
+func DeriveAgentID(identifiers *AgentDeviceIdentifiers, deviceUUID string) (id, source string) {
+	var candidates [][2]string
+	if identifiers != nil {
+		candidates = append(candidates,
+			[2]string{AgentIDSourceHardwareSerial, identifiers.HWSerial.HardwareSerial},
+			[2]string{AgentIDSourceBoardSerial, identifiers.HWSerial.BoardSerial},
+			[2]string{AgentIDSourceMac, strings.ToLower(identifiers.MacAddress.Mac)},
+		)
+	}
+	candidates = append(candidates, [2]string{AgentIDSourceDeviceUUID, deviceUUID})
+
+	for _, candidate := range candidates {
+		value := strings.TrimSpace(candidate[1])
+		if value == "" || placeholderIdentifiers[strings.ToLower(value)] {
+			continue
+		}
+		sum := sha256.Sum256([]byte(candidate[0] + ":" + value))
+		return hex.EncodeToString(sum[:16]), candidate[0]
+	}
+	return "", ""
+}
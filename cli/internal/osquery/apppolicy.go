@@ -0,0 +1,112 @@
+package osquery
+
+import (
+	"strings"
+)
+
+// AppPolicy lists apps whose presence the appPolicy check reports, so that
+// whether a required tool is installed can be answered without the whole
+// app list.
+type AppPolicy struct {
+	Required   []string
+	Prohibited []string
+	// ExactMatch matches entries against whole app names rather than any
+	// part of them. Matching ignores case either way.
+	ExactMatch bool
+}
+
+// SetAppPolicy sets the apps reported by the appPolicy check.
+func (c *Client) SetAppPolicy(policy AppPolicy) {
+	c.appPolicy = policy
+}
+
+// collectAppPolicy reports whether each required and prohibited app is
+// installed, matched against the platform's app inventory. Nothing is
+// reported when no apps are configured or the inventory cannot be read.
+func (c *Client) collectAppPolicy(rawResults map[string]interface{}) {
+	if len(c.appPolicy.Required) == 0 && len(c.appPolicy.Prohibited) == 0 {
+		return
+	}
+
+	names, err := c.installedAppNames()
+	if err != nil {
+		return
+	}
+	rawResults["appPolicy"] = evaluateAppPolicy(c.appPolicy, names)
+}
+
+// installedAppNames returns the names in the platform's app inventory: the
+// apps table on macOS, programs on Windows, and the rpm or deb packages on
+// Linux.
+func (c *Client) installedAppNames() ([]string, error) {
+	var query string
+	switch c.platform {
+	case PlatformMacOS:
+		query = "SELECT name FROM apps"
+	case PlatformWindows:
+		query = "SELECT name FROM programs"
+	default:
+		if c.isRPMBasedDistro() {
+			query = "SELECT name FROM rpm_packages"
+		} else {
+			query = "SELECT name FROM deb_packages"
+		}
+	}
+
+	rows, err := c.RunQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if name, ok := row["name"].(string); ok && name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// evaluateAppPolicy maps each required and prohibited app to whether it is
+// among names. passed is true when every required app is installed and no
+// prohibited app is.
+func evaluateAppPolicy(policy AppPolicy, names []string) map[string]interface{} {
+	passed := true
+	required := make(map[string]bool, len(policy.Required))
+	for _, app := range policy.Required {
+		required[app] = appInstalled(app, names, policy.ExactMatch)
+		if !required[app] {
+			passed = false
+		}
+	}
+	prohibited := make(map[string]bool, len(policy.Prohibited))
+	for _, app := range policy.Prohibited {
+		prohibited[app] = appInstalled(app, names, policy.ExactMatch)
+		if prohibited[app] {
+			passed = false
+		}
+	}
+
+	return map[string]interface{}{
+		"required":   required,
+		"prohibited": prohibited,
+		"passed":     passed,
+	}
+}
+
+// appInstalled reports whether app matches any of names, ignoring case. A
+// macOS bundle name such as "Slack.app" also matches exactly without its
+// extension.
+func appInstalled(app string, names []string, exact bool) bool {
+	app = strings.ToLower(app)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if exact {
+			if name == app || strings.TrimSuffix(name, ".app") == app {
+				return true
+			}
+		} else if strings.Contains(name, app) {
+			return true
+		}
+	}
+	return false
+}
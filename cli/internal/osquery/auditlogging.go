@@ -0,0 +1,233 @@
+package osquery
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// macOSAuditControlFile configures BSM auditing on macOS. It is only
+// readable by root.
+const macOSAuditControlFile = "/etc/security/audit_control"
+
+// auditLogging is the result of the auditLogging check.
+type auditLogging struct {
+	// enabled is nil when whether audit logging is enabled is unknown, as
+	// when reading it needs privileges the agent does not have.
+	enabled *bool
+	source  string
+	details map[string]interface{}
+	// insufficientPrivileges is set when a source could not be read with
+	// the agent's privileges, so its part of the result is missing rather
+	// than false.
+	insufficientPrivileges bool
+	notes                  []string
+}
+
+// collectAuditLogging collects whether system audit logging is enabled:
+// auditd on Linux, BSM auditd on macOS, and the audit policy on Windows.
+// Sources that need elevation report insufficientPrivileges, with enabled
+// null, rather than false.
+func (c *Client) collectAuditLogging(rawResults map[string]interface{}) {
+	var audit auditLogging
+	switch c.platform {
+	case PlatformLinux:
+		audit = c.linuxAuditLogging()
+	case PlatformMacOS:
+		audit = c.macOSAuditLogging()
+	case PlatformWindows:
+		audit = c.windowsAuditLogging()
+	default:
+		return
+	}
+
+	result := map[string]interface{}{
+		"enabled":                nil,
+		"source":                 audit.source,
+		"details":                audit.details,
+		"insufficientPrivileges": audit.insufficientPrivileges,
+		"notes":                  audit.notes,
+	}
+	if audit.enabled != nil {
+		result["enabled"] = *audit.enabled
+	}
+	rawResults["auditLogging"] = result
+}
+
+// linuxAuditLogging reads whether the auditd service is running and, with
+// root, whether kernel auditing is enabled and how many rules are loaded.
+func (c *Client) linuxAuditLogging() auditLogging {
+	audit := auditLogging{source: "auditd", details: map[string]interface{}{}}
+
+	// is-active exits 0 for an active unit, and 127 without systemd
+	serviceActive := false
+	if output, exitCode, err := c.RunCommandStatus("systemctl is-active auditd 2>/dev/null"); err == nil && exitCode != 127 {
+		serviceActive = exitCode == 0
+		audit.details["serviceState"] = output
+	} else if _, exitCode, err := c.RunCommandStatus("pgrep -x auditd"); err == nil && exitCode <= 1 {
+		serviceActive = exitCode == 0
+	} else {
+		audit.notes = append(audit.notes, "could not tell whether auditd is running")
+		return audit
+	}
+	audit.details["serviceActive"] = serviceActive
+
+	output, exitCode, err := c.RunCommandStatus("auditctl -s 2>&1")
+	switch {
+	case err != nil || exitCode == 127:
+		audit.notes = append(audit.notes, "auditctl is not installed")
+		audit.enabled = boolPtr(serviceActive)
+		return audit
+	case exitCode != 0:
+		audit.insufficientPrivileges = true
+		audit.notes = append(audit.notes, "auditctl needs root; run the agent as root to report kernel auditing and rules")
+		// A running auditd enables kernel auditing, but a stopped one
+		// leaves it unknown
+		if serviceActive {
+			audit.enabled = boolPtr(true)
+		}
+		return audit
+	}
+	kernelEnabled, ok := parseAuditctlEnabled(output)
+	if ok {
+		audit.details["kernelEnabled"] = kernelEnabled
+		audit.enabled = boolPtr(serviceActive && kernelEnabled)
+	} else {
+		audit.enabled = boolPtr(serviceActive)
+	}
+
+	// auditctl -l prints loaded rules in audit.rules syntax
+	if output, exitCode, err := c.RunCommandStatus("auditctl -l 2>&1"); err == nil && exitCode == 0 {
+		audit.details["ruleCount"] = countAuditRules(output)
+	}
+	return audit
+}
+
+// parseAuditctlEnabled reads the enabled line of `auditctl -s` output, which
+// is 1 when auditing is on and 2 when it is on and locked.
+func parseAuditctlEnabled(output string) (enabled, ok bool) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "enabled" {
+			n, err := strconv.Atoi(fields[1])
+			return n != 0, err == nil
+		}
+	}
+	return false, false
+}
+
+// macOSAuditLogging reads whether BSM auditd is loaded and which event
+// classes audit_control records. Both need root. Unified logging is always
+// on and cannot be turned off, so it is reported as such.
+func (c *Client) macOSAuditLogging() auditLogging {
+	audit := auditLogging{source: "auditd", details: map[string]interface{}{"unifiedLogging": true}}
+
+	// launchctl only lists the system domain's services to root
+	if os.Geteuid() != 0 {
+		audit.insufficientPrivileges = true
+		audit.notes = append(audit.notes, "BSM audit status needs root; run the agent as root to report it")
+		return audit
+	}
+
+	_, exitCode, err := c.RunCommandStatus("launchctl list com.apple.auditd")
+	if err != nil {
+		audit.notes = append(audit.notes, "failed to read the auditd service state")
+		return audit
+	}
+	loaded := exitCode == 0
+	audit.details["serviceLoaded"] = loaded
+
+	content, err := os.ReadFile(macOSAuditControlFile)
+	if err != nil {
+		audit.notes = append(audit.notes, "failed to read "+macOSAuditControlFile)
+		// Without the flags, a loaded auditd may still record nothing
+		if !loaded {
+			audit.enabled = boolPtr(false)
+		}
+		return audit
+	}
+	flags := parseAuditControlFlags(string(content))
+	audit.details["flags"] = flags
+	audit.enabled = boolPtr(loaded && len(flags) > 0)
+	return audit
+}
+
+// parseAuditControlFlags returns the event classes in the flags line of
+// audit_control, such as lo and aa.
+func parseAuditControlFlags(content string) []string {
+	flags := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "flags:")
+		if !ok {
+			continue
+		}
+		for _, flag := range strings.Split(value, ",") {
+			if flag = strings.TrimSpace(flag); flag != "" {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	return flags
+}
+
+// auditpolSettingPattern matches a subcategory line of `auditpol /get`
+// output: an indented name, two or more spaces, and its setting.
+var auditpolSettingPattern = regexp.MustCompile(`^\s+(\S.*?)\s{2,}(No Auditing|Success and Failure|Success|Failure)\s*$`)
+
+// windowsAuditLogging summarizes the audit policy from auditpol, which
+// needs an elevated agent.
+func (c *Client) windowsAuditLogging() auditLogging {
+	audit := auditLogging{source: "auditpol", details: map[string]interface{}{}}
+
+	output, exitCode, err := c.RunCommandStatus("auditpol /get /category:*")
+	switch {
+	case err != nil:
+		audit.notes = append(audit.notes, "failed to run auditpol")
+		return audit
+	case exitCode != 0:
+		audit.insufficientPrivileges = true
+		audit.notes = append(audit.notes, "auditpol needs an elevated agent; run it as an administrator or SYSTEM to report the audit policy")
+		return audit
+	}
+
+	categories, audited, total := parseAuditpol(output)
+	if total == 0 {
+		audit.notes = append(audit.notes, "could not read the audit policy from auditpol output, which may be in another language")
+		return audit
+	}
+	audit.details["auditedCategories"] = categories
+	audit.details["auditedSubcategories"] = audited
+	audit.details["totalSubcategories"] = total
+	audit.enabled = boolPtr(audited > 0)
+	return audit
+}
+
+// parseAuditpol returns the categories in `auditpol /get /category:*`
+// output with at least one audited subcategory, and how many of all the
+// subcategories are audited.
+func parseAuditpol(output string) (categories []string, audited, total int) {
+	categories = []string{}
+	category := ""
+	categoryAudited := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if match := auditpolSettingPattern.FindStringSubmatch(line); match != nil {
+			total++
+			if match[2] != "No Auditing" {
+				audited++
+				if category != "" && !categoryAudited {
+					categories = append(categories, category)
+					categoryAudited = true
+				}
+			}
+			continue
+		}
+		// Category lines are not indented
+		if trimmed := strings.TrimSpace(line); trimmed != "" && trimmed == line {
+			category = trimmed
+			categoryAudited = false
+		}
+	}
+	return categories, audited, total
+}
@@ -0,0 +1,249 @@
+package osquery
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// unattendedUpgradeBinary is installed by the unattended-upgrades package.
+const unattendedUpgradeBinary = "/usr/bin/unattended-upgrade"
+
+// dnfAutomaticConfig is dnf-automatic's configuration file.
+const dnfAutomaticConfig = "/etc/dnf/automatic.conf"
+
+// dnfAutomaticTimers are the timers that run dnf-automatic: the first
+// follows apply_updates, the second always installs updates.
+var dnfAutomaticTimers = []string{"dnf-automatic.timer", "dnf-automatic-install.timer"}
+
+// systemdTimer is the state of a systemd timer unit.
+type systemdTimer struct {
+	enabled bool
+	// schedule is the timer's OnCalendar expression, and lastRun when it
+	// last fired as RFC 3339; either is empty when unknown.
+	schedule string
+	lastRun  string
+}
+
+// unattendedUpgradesSettings reads whether unattended-upgrades installs
+// updates, how often in days according to APT::Periodic, and when
+// apt-daily-upgrade.timer last ran it. Under systemd, it is enabled only
+// when that timer, which runs it, is enabled too.
+func (c *Client) unattendedUpgradesSettings() map[string]interface{} {
+	_, err := os.Stat(unattendedUpgradeBinary)
+	installed := err == nil
+	settings := map[string]interface{}{
+		"installed":              installed,
+		"enabled":                false,
+		"intervalDays":           nil,
+		"updatePackageListsDays": nil,
+		"lastRun":                nil,
+	}
+	if !installed {
+		return settings
+	}
+
+	if output, err := c.RunCommand("apt-config dump APT::Periodic"); err == nil {
+		periodic := parseAptPeriodic(output)
+		if days, ok := periodic["Unattended-Upgrade"]; ok {
+			settings["intervalDays"] = days
+			settings["enabled"] = days > 0
+		}
+		if days, ok := periodic["Update-Package-Lists"]; ok {
+			settings["updatePackageListsDays"] = days
+		}
+	}
+	if timer, ok := c.systemdTimerState("apt-daily-upgrade.timer"); ok {
+		settings["enabled"] = settings["enabled"] == true && timer.enabled
+		settings["lastRun"] = nullIfEmpty(timer.lastRun)
+	}
+	return settings
+}
+
+// dnfAutomaticSettings reads whether a dnf-automatic timer is enabled,
+// whether it applies updates or only downloads them, which updates, on
+// what schedule, and when it last ran.
+func (c *Client) dnfAutomaticSettings() map[string]interface{} {
+	settings := map[string]interface{}{
+		"installed":    false,
+		"enabled":      false,
+		"applyUpdates": false,
+		"upgradeType":  nil,
+		"schedule":     nil,
+		"lastRun":      nil,
+	}
+	data, err := os.ReadFile(dnfAutomaticConfig)
+	if err != nil {
+		return settings
+	}
+	settings["installed"] = true
+	config := parseINIValues(string(data), "commands")
+	applyUpdates := config["apply_updates"] == "yes" || config["apply_updates"] == "true" || config["apply_updates"] == "1"
+	settings["upgradeType"] = nullIfEmpty(config["upgrade_type"])
+
+	for _, unit := range dnfAutomaticTimers {
+		timer, ok := c.systemdTimerState(unit)
+		if !ok || !timer.enabled {
+			continue
+		}
+		settings["enabled"] = true
+		// dnf-automatic-install.timer installs regardless of apply_updates
+		settings["applyUpdates"] = applyUpdates || unit == "dnf-automatic-install.timer"
+		settings["schedule"] = nullIfEmpty(timer.schedule)
+		settings["lastRun"] = nullIfEmpty(timer.lastRun)
+		break
+	}
+	return settings
+}
+
+// systemdTimerProperties are the timer properties systemdTimerState reads.
+const systemdTimerProperties = "--property=LoadState,UnitFileState,TimersCalendar,LastTriggerUSec,LastTriggerUSecMonotonic"
+
+// systemBootTime returns when the system booted, from the btime line of
+// /proc/stat. Tests replace it.
+var systemBootTime = func() (time.Time, bool) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			if seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+				return time.Unix(seconds, 0), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// systemdTimerState reads a timer's state with systemctl. ok is false when
+// systemctl or the unit is missing. Times are read as Unix timestamps,
+// which systemd prints from version 251; before that the last run is read
+// as the time since boot.
+func (c *Client) systemdTimerState(unit string) (systemdTimer, bool) {
+	quiet := c.withoutErrorLog()
+	output, err := quiet.RunCommand("systemctl show " + unit + " --timestamp=unix " + systemdTimerProperties)
+	if err != nil {
+		if output, err = quiet.RunCommand("systemctl show " + unit + " " + systemdTimerProperties); err != nil {
+			return systemdTimer{}, false
+		}
+	}
+	return parseSystemdTimer(output, systemBootTime)
+}
+
+// parseSystemdTimer parses the Key=Value properties printed by
+// systemdTimerState. ok is false when the unit is not loaded. The last run
+// is read from LastTriggerUSec as a Unix timestamp, or else from
+// LastTriggerUSecMonotonic, the time since boot, which is unknown for a
+// timer that last ran before the current boot.
+func parseSystemdTimer(output string, bootTime func() (time.Time, bool)) (systemdTimer, bool) {
+	properties := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			properties[key] = value
+		}
+	}
+	if properties["LoadState"] != "loaded" {
+		return systemdTimer{}, false
+	}
+
+	unitFileState := properties["UnitFileState"]
+	timer := systemdTimer{enabled: unitFileState == "enabled" || unitFileState == "enabled-runtime"}
+	// TimersCalendar is "{ OnCalendar=<spec> ; next_elapse=<time> }"
+	if _, rest, ok := strings.Cut(properties["TimersCalendar"], "OnCalendar="); ok {
+		spec, _, _ := strings.Cut(rest, ";")
+		timer.schedule = strings.TrimSpace(spec)
+	}
+	if value, ok := strings.CutPrefix(properties["LastTriggerUSec"], "@"); ok {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 0 {
+			timer.lastRun = time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+		}
+	} else if sinceBoot, ok := parseSystemdTimespan(properties["LastTriggerUSecMonotonic"]); ok && sinceBoot > 0 {
+		if boot, ok := bootTime(); ok {
+			timer.lastRun = boot.Add(sinceBoot).UTC().Format(time.RFC3339)
+		}
+	}
+	return timer, true
+}
+
+// systemdTimespanUnits are the units of systemd's timespans.
+var systemdTimespanUnits = map[string]time.Duration{
+	"us":    time.Microsecond,
+	"ms":    time.Millisecond,
+	"s":     time.Second,
+	"min":   time.Minute,
+	"h":     time.Hour,
+	"d":     24 * time.Hour,
+	"w":     7 * 24 * time.Hour,
+	"month": 2629800 * time.Second,
+	"y":     31557600 * time.Second,
+}
+
+// parseSystemdTimespan parses a timespan as systemctl show prints it, such
+// as "1d 2h 3min 4.567s", or a bare number of microseconds, as older
+// versions print it.
+func parseSystemdTimespan(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if usec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(usec) * time.Microsecond, true
+	}
+	var total time.Duration
+	for _, part := range strings.Fields(value) {
+		split := strings.IndexFunc(part, func(r rune) bool { return r != '.' && !unicode.IsDigit(r) })
+		if split <= 0 {
+			return 0, false
+		}
+		number, err := strconv.ParseFloat(part[:split], 64)
+		unit, ok := systemdTimespanUnits[part[split:]]
+		if err != nil || !ok {
+			return 0, false
+		}
+		total += time.Duration(number * float64(unit))
+	}
+	return total, true
+}
+
+// parseAptPeriodic returns the day intervals in `apt-config dump
+// APT::Periodic` output, whose lines look like
+// `APT::Periodic::Unattended-Upgrade "1";`, keyed by the name after
+// APT::Periodic::. Values that are not whole days are left out.
+func parseAptPeriodic(output string) map[string]int {
+	periodic := make(map[string]int)
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || !strings.HasPrefix(key, "APT::Periodic::") {
+			continue
+		}
+		value = strings.Trim(strings.TrimSuffix(value, ";"), `"`)
+		if days, err := strconv.Atoi(value); err == nil {
+			periodic[strings.TrimPrefix(key, "APT::Periodic::")] = days
+		}
+	}
+	return periodic
+}
+
+// parseINIValues returns the key = value settings in section of an INI
+// file, with comments left out.
+func parseINIValues(data, section string) map[string]string {
+	values := make(map[string]string)
+	inSection := false
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "["):
+			inSection = strings.Trim(line, "[]") == section
+		case inSection:
+			if key, value, ok := strings.Cut(line, "="); ok {
+				values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	return values
+}
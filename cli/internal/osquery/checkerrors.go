@@ -0,0 +1,53 @@
+package osquery
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// maxCheckErrorLength caps the length of each error summary in checkErrors.
+const maxCheckErrorLength = 200
+
+// checkErrorLog collects the query and command failures of one check.
+type checkErrorLog struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// recordError adds err to the error log of the check being collected, if
+// any. Queries and commands call it for every failure.
+func (c *Client) recordError(err error) {
+	if c.errorLog == nil || err == nil {
+		return
+	}
+	c.errorLog.mu.Lock()
+	defer c.errorLog.mu.Unlock()
+	c.errorLog.errs = append(c.errorLog.errs, err)
+}
+
+// withoutErrorLog returns a copy of the client whose failures are not
+// recorded against the current check, for queries that are expected to
+// fail on some systems and have a fallback.
+func (c *Client) withoutErrorLog() *Client {
+	clone := *c
+	clone.errorLog = nil
+	return &clone
+}
+
+// summary returns the first recorded error on one line, cut to
+// maxCheckErrorLength, noting how many more there were. It returns an
+// empty string when nothing failed.
+func (l *checkErrorLog) summary() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.errs) == 0 {
+		return ""
+	}
+
+	summary := truncateString(strings.Join(strings.Fields(l.errs[0].Error()), " "), maxCheckErrorLength)
+	if more := len(l.errs) - 1; more > 0 {
+		summary += fmt.Sprintf(" (and %d more)", more)
+	}
+	return summary
+}
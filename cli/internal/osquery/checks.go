@@ -0,0 +1,405 @@
+package osquery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/drata/drata-agent-cli/internal/telemetry"
+)
+
+// check is a named group of related results that are collected together
+// and can be enabled or disabled as a unit.
+type check struct {
+	name string
+	// optIn checks only run when explicitly enabled.
+	optIn   bool
+	collect func(c *Client, rawResults map[string]interface{})
+}
+
+// CheckFilter selects which checks run during collection.
+type CheckFilter struct {
+	// Enabled restricts collection to these checks. When empty, every
+	// check that is not opt-in runs.
+	Enabled []string
+	// Disabled removes these checks from collection.
+	Disabled []string
+	// OptIn adds these opt-in checks to the default checks when Enabled
+	// is empty.
+	OptIn []string
+}
+
+// SetCheckFilter sets which checks run during collection.
+func (c *Client) SetCheckFilter(filter CheckFilter) {
+	c.checkFilter = filter
+}
+
+// CheckFilter returns which checks run during collection.
+func (c *Client) CheckFilter() CheckFilter {
+	return c.checkFilter
+}
+
+// checkEnabled reports whether chk should run under the client's filter.
+func (c *Client) checkEnabled(chk check) bool {
+	for _, name := range c.checkFilter.Disabled {
+		if strings.EqualFold(name, chk.name) {
+			return false
+		}
+	}
+	if len(c.checkFilter.Enabled) == 0 {
+		if !chk.optIn {
+			return true
+		}
+		for _, name := range c.checkFilter.OptIn {
+			if strings.EqualFold(name, chk.name) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range c.checkFilter.Enabled {
+		if strings.EqualFold(name, chk.name) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonChecks returns the checks collected identically on every platform.
+func commonChecks() []check {
+	return []check{
+		{name: "osVersion", collect: (*Client).collectOSVersion},
+		{name: "osSupportStatus", collect: (*Client).collectOSSupportStatus},
+		{name: "hwSerial", collect: (*Client).collectHWSerial},
+		{name: "hwModel", collect: (*Client).collectHWModel},
+		{name: "systemInfo", collect: (*Client).collectSystemInfo},
+		{name: "sessionInfo", collect: (*Client).collectSessionInfo},
+		{name: "rebootRequired", collect: (*Client).collectRebootRequired},
+		{name: "updateHistory", collect: (*Client).collectUpdateHistory},
+		{name: "vpnStatus", collect: (*Client).collectVPNStatus},
+		{name: "localAccountsPolicy", collect: (*Client).collectLocalAccountsPolicy},
+		{name: "passwordPolicy", collect: (*Client).collectPasswordPolicy},
+		{name: "auditLogging", collect: (*Client).collectAuditLogging},
+		{name: "timeSyncStatus", collect: (*Client).collectTimeSyncStatus},
+		{name: "localeInfo", collect: (*Client).collectLocaleInfo},
+		{name: "appPolicy", collect: (*Client).collectAppPolicy},
+		{name: "remoteAccess", collect: (*Client).collectRemoteAccess},
+		{name: "processSnapshot", optIn: true, collect: (*Client).collectProcessSnapshot},
+		{name: "firmwareInfo", collect: (*Client).collectFirmwareInfo},
+	}
+}
+
+// platformChecks returns every check for the given platform in collection order.
+func platformChecks(platform Platform) ([]check, error) {
+	var checks []check
+	switch platform {
+	case PlatformMacOS:
+		checks = macOSChecks()
+	case PlatformWindows:
+		checks = windowsChecks()
+	case PlatformLinux:
+		checks = linuxChecks()
+	default:
+		return nil, fmt.Errorf("unsupported platform: %s", platform)
+	}
+	return append(commonChecks(), checks...), nil
+}
+
+// SetCollectionBudget sets how long collection may run before the
+// remaining checks are skipped. Zero disables the budget.
+func (c *Client) SetCollectionBudget(budget time.Duration) {
+	c.collectionBudget = budget
+}
+
+// SetCollectionTimeout sets a deadline for collection: once it passes, the
+// queries and commands of the check in progress are cancelled, and that
+// check and the remaining ones are skipped. Zero disables the deadline.
+func (c *Client) SetCollectionTimeout(timeout time.Duration) {
+	c.collectionTimeout = timeout
+}
+
+// CollectionTimeout returns the deadline set with SetCollectionTimeout.
+func (c *Client) CollectionTimeout() time.Duration {
+	return c.collectionTimeout
+}
+
+// runChecks collects every enabled check into a new results map. Once the
+// collection budget is spent or the client's context is done, no further
+// checks are started and their names are returned as skipped, along with
+// a check the context ended during. Checks that ran but added no results are
+// returned as empty, and checks in which a query or command failed are
+// returned in checkErrors with a summary of the failure. durations holds
+// how long each check that ran took, and resultKeys the results each added.
+func (c *Client) runChecks(checks []check) (rawResults map[string]interface{}, skipped, empty []string, checkErrors map[string]string, durations map[string]time.Duration, resultKeys map[string][]string) {
+	rawResults = make(map[string]interface{})
+	durations = make(map[string]time.Duration)
+	resultKeys = make(map[string][]string)
+	start := time.Now()
+	for _, chk := range checks {
+		if !c.checkEnabled(chk) {
+			c.logVerbose("Skipping disabled check: %s", chk.name)
+			continue
+		}
+		if c.collectionBudget > 0 && time.Since(start) >= c.collectionBudget {
+			c.logVerbose("Collection budget spent, skipping check: %s", chk.name)
+			skipped = append(skipped, chk.name)
+			continue
+		}
+		if c.context().Err() != nil {
+			c.logVerbose("Collection timed out, skipping check: %s", chk.name)
+			skipped = append(skipped, chk.name)
+			continue
+		}
+
+		ctx, span := telemetry.StartSpan(c.context(), "osquery.check", attribute.String("check", chk.name))
+		before := len(rawResults)
+		existing := make(map[string]bool, len(rawResults))
+		for key := range rawResults {
+			existing[key] = true
+		}
+		checkClient := c.WithContext(ctx)
+		checkClient.errorLog = &checkErrorLog{}
+		checkStart := time.Now()
+		chk.collect(checkClient, rawResults)
+		durations[chk.name] = time.Since(checkStart)
+		telemetry.EndSpan(span, nil)
+		if c.context().Err() != nil {
+			c.logVerbose("Collection timed out during check: %s", chk.name)
+			skipped = append(skipped, chk.name)
+		} else if len(rawResults) == before {
+			c.logVerbose("Check produced no data: %s", chk.name)
+			empty = append(empty, chk.name)
+		}
+		for key := range rawResults {
+			if !existing[key] {
+				resultKeys[chk.name] = append(resultKeys[chk.name], key)
+			}
+		}
+		if summary := checkClient.errorLog.summary(); summary != "" {
+			c.logVerbose("Check reported errors: %s: %s", chk.name, summary)
+			if checkErrors == nil {
+				checkErrors = make(map[string]string)
+			}
+			checkErrors[chk.name] = summary
+		}
+	}
+	return rawResults, skipped, empty, checkErrors, durations, resultKeys
+}
+
+// MissingChecks returns the checks in names that exist on this platform but
+// contributed nothing to result because they were disabled, skipped, or
+// produced no data. Checks that do not exist on the platform are ignored.
+func (c *Client) MissingChecks(result *QueryResult, names []string) []string {
+	checks, err := platformChecks(c.platform)
+	if err != nil {
+		return nil
+	}
+
+	absent := make(map[string]bool)
+	for _, name := range append(append([]string{}, result.SkippedChecks...), result.EmptyChecks...) {
+		absent[strings.ToLower(name)] = true
+	}
+
+	var missing []string
+	for _, name := range names {
+		for _, chk := range checks {
+			if !strings.EqualFold(name, chk.name) {
+				continue
+			}
+			if !c.checkEnabled(chk) || absent[strings.ToLower(chk.name)] {
+				missing = append(missing, chk.name)
+			}
+		}
+	}
+	return missing
+}
+
+// unavailableChecks returns the reason for each check whose results, as
+// listed in resultKeys, include one marked not applicable, indeterminate,
+// or read with insufficient privileges: the result's reason where it gives
+// one. A check with several such results gets the reason of the first in
+// key order.
+func unavailableChecks(rawResults map[string]interface{}, resultKeys map[string][]string) map[string]string {
+	unavailable := make(map[string]string)
+	for name, keys := range resultKeys {
+		sorted := append([]string{}, keys...)
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			if reason := unavailableReason(rawResults[key]); reason != "" {
+				unavailable[name] = reason
+				break
+			}
+		}
+	}
+	return unavailable
+}
+
+// unavailableReason returns why a result is not a full answer, or "" if it
+// is one.
+func unavailableReason(result interface{}) string {
+	fields, ok := result.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	reason, _ := fields["reason"].(string)
+	switch {
+	case fields["notApplicable"] == true:
+		if reason == "" {
+			reason = "not applicable"
+		}
+	case fields["indeterminate"] == true:
+		if reason == "" {
+			reason = "indeterminate"
+		}
+	case fields["insufficientPrivileges"] == true:
+		reason = "insufficient privileges; run the agent as root or Administrator"
+	default:
+		return ""
+	}
+	return reason
+}
+
+// IncompleteChecks returns, for each check that contributed less than a
+// full result, why: skipped when the collection budget ran out, produced no
+// data, with the error behind it if there was one, or reported its result
+// as unavailable. A check that recorded an error but still produced its
+// result is complete; many probe for something that may be absent.
+func (r *QueryResult) IncompleteChecks() map[string]string {
+	incomplete := make(map[string]string)
+	for _, name := range r.SkippedChecks {
+		incomplete[name] = "skipped, collection budget spent"
+	}
+	for _, name := range r.EmptyChecks {
+		if summary := r.CheckErrors[name]; summary != "" {
+			incomplete[name] = summary
+		} else {
+			incomplete[name] = "produced no data"
+		}
+	}
+	// A check's own reason explains more than the error behind it
+	for name, reason := range r.UnavailableChecks {
+		incomplete[name] = reason
+	}
+	return incomplete
+}
+
+// collectOSVersion collects the operating system name and version, with
+// the build and patch level details of the platform.
+func (c *Client) collectOSVersion(rawResults map[string]interface{}) {
+	if result, err := c.queryFirst("SELECT name, version, platform FROM os_version"); err == nil && result != nil {
+		c.addOSBuildDetails(result)
+		rawResults["osVersion"] = result
+	}
+}
+
+// addOSBuildDetails adds the platform's build and patch level to an
+// osVersion result: the kernel release and OS build on Linux, the build and
+// Update Build Revision on Windows, and the build version on macOS. Details
+// that cannot be read are left out.
+func (c *Client) addOSBuildDetails(osVersion map[string]interface{}) {
+	switch c.platform {
+	case PlatformLinux:
+		if output, err := c.RunCommand("uname -r"); err == nil && strings.TrimSpace(output) != "" {
+			osVersion["kernelVersion"] = strings.TrimSpace(output)
+		}
+		if result, err := c.queryFirst("SELECT build FROM os_version"); err == nil && result != nil && result["build"] != "" {
+			osVersion["build"] = result["build"]
+		}
+	case PlatformWindows:
+		build := ""
+		if result, err := c.queryFirst("SELECT build FROM os_version"); err == nil && result != nil {
+			build, _ = result["build"].(string)
+		}
+		ubr := ""
+		if result, err := c.queryFirst(`SELECT data FROM registry WHERE path = 'HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Windows NT\CurrentVersion\UBR'`); err == nil && result != nil {
+			ubr, _ = result["data"].(string)
+		}
+		if build != "" {
+			osVersion["build"] = build
+		}
+		if ubr != "" {
+			osVersion["ubr"] = ubr
+		}
+		if fullBuild := windowsFullBuild(build, ubr); fullBuild != "" {
+			osVersion["fullBuild"] = fullBuild
+		}
+	case PlatformMacOS:
+		if output, err := c.RunCommand("sw_vers -buildVersion"); err == nil && strings.TrimSpace(output) != "" {
+			osVersion["build"] = strings.TrimSpace(output)
+		}
+	}
+}
+
+// windowsFullBuild joins a Windows build number and Update Build Revision
+// into the patch level Windows reports, such as 22631.4317.
+func windowsFullBuild(build, ubr string) string {
+	if build == "" || ubr == "" {
+		return ""
+	}
+	return build + "." + ubr
+}
+
+// collectHWSerial collects the hardware serial number.
+func (c *Client) collectHWSerial(rawResults map[string]interface{}) {
+	if result, err := c.queryFirst("SELECT hardware_serial FROM system_info"); err == nil && result != nil {
+		rawResults["hwSerial"] = result
+	}
+}
+
+// collectHWModel collects the hardware model.
+func (c *Client) collectHWModel(rawResults map[string]interface{}) {
+	if result, err := c.queryFirst("SELECT hardware_model FROM system_info"); err == nil && result != nil {
+		rawResults["hwModel"] = result
+	}
+}
+
+// collectSystemInfo collects board and host naming information.
+func (c *Client) collectSystemInfo(rawResults map[string]interface{}) {
+	if result, err := c.queryFirst("SELECT board_serial, board_model, computer_name, hostname, local_hostname FROM system_info"); err == nil && result != nil {
+		rawResults["boardSerial"] = result["board_serial"]
+		rawResults["boardModel"] = result["board_model"]
+		rawResults["computerName"] = result["computer_name"]
+		rawResults["hostName"] = result["hostname"]
+		rawResults["localHostName"] = result["local_hostname"]
+	}
+}
+
+// KnownChecks returns the names of all checks across platforms, sorted.
+func KnownChecks() []string {
+	seen := make(map[string]bool)
+	for _, platform := range []Platform{PlatformMacOS, PlatformWindows, PlatformLinux} {
+		checks, _ := platformChecks(platform)
+		for _, chk := range checks {
+			seen[chk.name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateCheckNames returns an error naming any entries that are not known checks.
+func ValidateCheckNames(names []string) error {
+	known := make(map[string]bool)
+	for _, name := range KnownChecks() {
+		known[strings.ToLower(name)] = true
+	}
+
+	var unknown []string
+	for _, name := range names {
+		if !known[strings.ToLower(name)] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown checks: %s (valid: %s)", strings.Join(unknown, ", "), strings.Join(KnownChecks(), ", "))
+	}
+	return nil
+}
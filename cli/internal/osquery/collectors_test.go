@@ -0,0 +1,206 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the collector golden files from the current output")
+
+// collectorFixture is recorded osquery and command output for the checks
+// of one platform, read from testdata/collectors/<case>.json.
+type collectorFixture struct {
+	Description string   `json:"description"`
+	Platform    Platform `json:"platform"`
+	Checks      []string `json:"checks"`
+	// Root collects as root; RPMBased marks the distro as RPM-based.
+	Root     bool              `json:"root"`
+	RPMBased bool              `json:"rpmBased"`
+	Env      map[string]string `json:"env"`
+	// Queries maps each query to its rows or an osquery error, and
+	// Commands each command to its output and exit code.
+	Queries  map[string]fixtureQuery   `json:"queries"`
+	Commands map[string]fixtureCommand `json:"commands"`
+}
+
+type fixtureQuery struct {
+	Rows  []map[string]interface{} `json:"rows"`
+	Error string                   `json:"error"`
+}
+
+type fixtureCommand struct {
+	Output   string `json:"output"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// collectorGolden is what the fixture's checks are expected to produce,
+// read from testdata/collectors/<case>.golden.json.
+type collectorGolden struct {
+	RawResults  map[string]interface{} `json:"rawResults"`
+	EmptyChecks []string               `json:"emptyChecks"`
+	CheckErrors map[string]string      `json:"checkErrors"`
+}
+
+// fixtureRunner replays a fixture's recorded output, noting any query or
+// command that was not recorded.
+type fixtureRunner struct {
+	fixture collectorFixture
+
+	mu         sync.Mutex
+	unrecorded []string
+}
+
+func (r *fixtureRunner) query(ctx context.Context, query string) ([]byte, error) {
+	recorded, ok := r.fixture.Queries[query]
+	if !ok {
+		r.note("query: " + query)
+		return nil, fmt.Errorf("osquery error: no fixture for query")
+	}
+	if recorded.Error != "" {
+		return nil, fmt.Errorf("osquery error: %s", recorded.Error)
+	}
+	rows := recorded.Rows
+	if rows == nil {
+		rows = []map[string]interface{}{}
+	}
+	return json.Marshal(rows)
+}
+
+func (r *fixtureRunner) command(ctx context.Context, command string) ([]byte, []byte, int, error) {
+	recorded, ok := r.fixture.Commands[command]
+	if !ok {
+		r.note("command: " + command)
+		return nil, []byte("no fixture for command"), 127, nil
+	}
+	return []byte(recorded.Output), []byte(recorded.Stderr), recorded.ExitCode, nil
+}
+
+func (r *fixtureRunner) note(entry string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unrecorded = append(r.unrecorded, entry)
+}
+
+// TestCollectorFixtures replays recorded osquery and command output through
+// the collectors and compares what they produce with golden JSON. Run with
+// -update to rewrite the golden files after an intended change.
+func TestCollectorFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "collectors", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []string
+	for _, path := range paths {
+		if !strings.HasSuffix(path, ".golden.json") {
+			cases = append(cases, strings.TrimSuffix(path, ".json"))
+		}
+	}
+	if len(cases) == 0 {
+		t.Fatal("no collector fixtures found")
+	}
+
+	for _, base := range cases {
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			var fixture collectorFixture
+			readJSON(t, base+".json", &fixture)
+
+			for _, name := range []string{"SUDO_USER", "LOGNAME", "USER"} {
+				t.Setenv(name, fixture.Env[name])
+			}
+			for name, value := range fixture.Env {
+				t.Setenv(name, value)
+			}
+			originalEUID, originalMarkers := geteuid, rpmDistroMarkers
+			t.Cleanup(func() { geteuid, rpmDistroMarkers = originalEUID, originalMarkers })
+			euid := 1000
+			if fixture.Root {
+				euid = 0
+			}
+			geteuid = func() int { return euid }
+			marker := filepath.Join(t.TempDir(), "redhat-release")
+			if fixture.RPMBased {
+				if err := os.WriteFile(marker, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rpmDistroMarkers = []string{marker}
+
+			checks, err := platformChecks(fixture.Platform)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runner := &fixtureRunner{fixture: fixture}
+			c := &Client{platform: fixture.Platform, runner: runner, checkFilter: CheckFilter{Enabled: fixture.Checks}}
+			rawResults, _, empty, checkErrors, _, _ := c.runChecks(checks)
+			for _, entry := range runner.unrecorded {
+				t.Errorf("no recorded output for %s", entry)
+			}
+
+			got := normalizeJSON(t, collectorGolden{RawResults: rawResults, EmptyChecks: empty, CheckErrors: checkErrors})
+			if *updateGolden {
+				data, err := json.MarshalIndent(got, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(base+".golden.json", append(data, '\n'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var golden collectorGolden
+			readJSON(t, base+".golden.json", &golden)
+			if want := normalizeJSON(t, golden); !reflect.DeepEqual(got, want) {
+				gotJSON, _ := json.MarshalIndent(got, "", "  ")
+				wantJSON, _ := json.MarshalIndent(want, "", "  ")
+				t.Errorf("%s: collected results differ from %s.golden.json\ngot:\n%s\nwant:\n%s", fixture.Description, filepath.Base(base), gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+// readJSON decodes the JSON file at path into v, rejecting unknown fields.
+func readJSON(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		t.Fatalf("invalid %s: %v", path, err)
+	}
+}
+
+// normalizeJSON returns golden as generic JSON values, with empty lists and maps
+// as null, so typed results compare equal to decoded golden files.
+func normalizeJSON(t *testing.T, golden collectorGolden) map[string]interface{} {
+	t.Helper()
+	sort.Strings(golden.EmptyChecks)
+	if len(golden.EmptyChecks) == 0 {
+		golden.EmptyChecks = nil
+	}
+	if len(golden.CheckErrors) == 0 {
+		golden.CheckErrors = nil
+	}
+	data, err := json.Marshal(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		t.Fatal(err)
+	}
+	return normalized
+}
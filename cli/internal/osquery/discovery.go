@@ -0,0 +1,109 @@
+package osquery
+
+// Statuses of a candidate in a BinaryDiscovery.
+const (
+	// CandidateSelected is the binary the agent uses.
+	CandidateSelected = "selected"
+	// CandidateNotFound is a location with no file.
+	CandidateNotFound = "not found"
+	// CandidateBroken is a binary that exists but could not run a query.
+	CandidateBroken = "broken"
+	// CandidateOutdated is a working binary older than the minimum version,
+	// passed over for a newer one.
+	CandidateOutdated = "outdated"
+	// CandidateNotTried is a binary after the selected one, or any binary
+	// when osquery_path is set.
+	CandidateNotTried = "not tried"
+	// CandidateDuplicate is a location already listed earlier.
+	CandidateDuplicate = "duplicate"
+)
+
+// CandidateResult is what discovery found at one candidate location.
+type CandidateResult struct {
+	BinaryCandidate
+	Status  string `json:"status"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BinaryDiscovery reports how the osqueryi binary is chosen: every location
+// searched, in order, and the binary selected.
+type BinaryDiscovery struct {
+	BinaryName     string           `json:"binaryName"`
+	Preference     BinaryPreference `json:"preference"`
+	ConfiguredPath string           `json:"configuredPath,omitempty"`
+	MinVersion     string           `json:"minVersion"`
+	// PathLookupFound is whether looking up BinaryName on PATH found a
+	// binary, which is then among the candidates.
+	PathLookupFound bool              `json:"pathLookupFound"`
+	Candidates      []CandidateResult `json:"candidates"`
+	// Selected is empty when no working binary was found.
+	Selected        string `json:"selected"`
+	SelectedVersion string `json:"selectedVersion"`
+	SelectedSource  string `json:"selectedSource"`
+	// SelectedError is why the configured binary cannot run a query.
+	SelectedError string `json:"selectedError,omitempty"`
+}
+
+// DiscoverBinary reports which osqueryi NewClientWithPreference would use
+// for configuredPath and prefer, and every location the search tries. Each
+// existing candidate up to the selected one is run once, as the search
+// does. When configuredPath is set the search is skipped, and the
+// candidates are listed as not tried.
+func DiscoverBinary(configuredPath string, prefer BinaryPreference) BinaryDiscovery {
+	discovery := BinaryDiscovery{
+		BinaryName:     osqueryBinaryName(),
+		Preference:     prefer,
+		ConfiguredPath: configuredPath,
+		MinVersion:     minOsqueryVersion,
+	}
+	candidates := osquerySearchPaths(prefer)
+
+	probes := make(map[string]candidateProbe)
+	if configuredPath != "" {
+		discovery.Selected = configuredPath
+		discovery.SelectedSource = BinarySourceConfig
+		version, err := probeOsquery(configuredPath)
+		discovery.SelectedVersion = version
+		if err != nil {
+			discovery.SelectedError = err.Error()
+		}
+	} else {
+		path, version, tried := probeOsqueryCandidates(candidatePaths(candidates, ""))
+		for _, p := range tried {
+			probes[p.path] = p
+		}
+		discovery.Selected = path
+		discovery.SelectedVersion = version
+	}
+
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if candidate.Source == BinarySourcePath {
+			discovery.PathLookupFound = true
+		}
+		result := CandidateResult{BinaryCandidate: candidate}
+		probe, tried := probes[candidate.Path]
+		switch {
+		case seen[candidate.Path]:
+			result.Status = CandidateDuplicate
+		case !fileExists(candidate.Path):
+			result.Status = CandidateNotFound
+		case !tried:
+			result.Status = CandidateNotTried
+		case probe.err != nil:
+			result.Status = CandidateBroken
+			result.Error = probe.err.Error()
+		case candidate.Path == discovery.Selected:
+			result.Status = CandidateSelected
+			result.Version = probe.version
+			discovery.SelectedSource = candidate.Source
+		default:
+			result.Status = CandidateOutdated
+			result.Version = probe.version
+		}
+		seen[candidate.Path] = true
+		discovery.Candidates = append(discovery.Candidates, result)
+	}
+	return discovery
+}
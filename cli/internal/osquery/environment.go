@@ -0,0 +1,190 @@
+package osquery
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Environment types reported in the environment result.
+const (
+	EnvironmentContainer = "container"
+	EnvironmentVM        = "vm"
+	// EnvironmentNone means no container or VM was detected. The device may
+	// still be virtual, as not every hypervisor identifies itself.
+	EnvironmentNone = "none"
+)
+
+// Environment describes the container or VM the agent runs in.
+type Environment struct {
+	Type string
+	// Detail names the container runtime or hypervisor, such as docker or
+	// Amazon EC2.
+	Detail string
+	// Source is the evidence the environment was detected from.
+	Source string
+}
+
+// virtualAffectedControls maps, per platform, the checks that describe an
+// end-user device to the results they produce, which are meaningless in a
+// container or a CI or cloud VM.
+var virtualAffectedControls = map[Platform]map[string][]string{
+	PlatformLinux: {
+		"firewall":   {"firewallStatus"},
+		"screenLock": {"screenLockStatus", "screenLockSettings"},
+	},
+	PlatformMacOS: {
+		"diskEncryption": {"hddEncryptionStatus", "fileVaultEnabled"},
+		"firewall":       {"firewallStatus"},
+		"screenLock":     {"screenLockStatus", "screenLockSettings"},
+	},
+	PlatformWindows: {
+		"diskEncryption": {"hddEncryptionStatus"},
+		"firewall":       {"firewallStatus"},
+		"screenLock":     {"screenLockStatus", "screenLockSettings"},
+	},
+}
+
+// containerCgroupMarkers maps substrings of /proc/1/cgroup to the container
+// runtime they identify.
+var containerCgroupMarkers = []struct {
+	marker  string
+	runtime string
+}{
+	{"kubepods", "kubernetes"},
+	{"docker", "docker"},
+	{"libpod", "podman"},
+	{"containerd", "containerd"},
+	{"lxc", "lxc"},
+}
+
+// vmSignatures maps substrings of the lowercased hardware vendor and model
+// to the hypervisor or cloud they identify. More specific entries come
+// first.
+var vmSignatures = []struct {
+	marker     string
+	hypervisor string
+}{
+	{"amazon ec2", "Amazon EC2"},
+	{"google compute engine", "Google Compute Engine"},
+	{"microsoft corporation virtual machine", "Microsoft Hyper-V"},
+	{"digitalocean", "DigitalOcean"},
+	{"openstack", "OpenStack"},
+	{"vmware", "VMware"},
+	{"virtualbox", "VirtualBox"},
+	{"innotek", "VirtualBox"},
+	{"parallels", "Parallels"},
+	{"qemu", "QEMU"},
+	{"kvm", "KVM"},
+	{"xen", "Xen"},
+	{"bochs", "Bochs"},
+	{"virtualmac", "Apple Virtualization"},
+}
+
+// SetMarkVirtualNotApplicable sets whether controls that are meaningless in
+// a container or VM are reported as not applicable there instead of
+// collected.
+func (c *Client) SetMarkVirtualNotApplicable(mark bool) {
+	c.markVirtualNotApplicable = mark
+}
+
+// DetectEnvironment reports whether the agent runs in a container, detected
+// on Linux from /.dockerenv, /run/.containerenv, or /proc/1/cgroup, or in a
+// VM, detected from the hardware vendor and model.
+func (c *Client) DetectEnvironment() Environment {
+	if c.platform == PlatformLinux {
+		if env, ok := detectContainer(); ok {
+			return env
+		}
+	}
+
+	var vendor, model string
+	if row, err := c.withoutErrorLog().queryFirst("SELECT hardware_vendor, hardware_model FROM system_info"); err == nil && row != nil {
+		vendor, _ = row["hardware_vendor"].(string)
+		model, _ = row["hardware_model"].(string)
+	}
+	if hypervisor := matchVMSignature(vendor, model); hypervisor != "" {
+		return Environment{Type: EnvironmentVM, Detail: hypervisor, Source: "system_info"}
+	}
+	// system_info can miss the DMI strings without root, which sysfs has
+	if c.platform == PlatformLinux {
+		if hypervisor := matchVMSignature(readDMIField("sys_vendor"), readDMIField("product_name")); hypervisor != "" {
+			return Environment{Type: EnvironmentVM, Detail: hypervisor, Source: "sysfs"}
+		}
+	}
+	return Environment{Type: EnvironmentNone}
+}
+
+// detectContainer reports the container runtime the agent runs under, if
+// any.
+func detectContainer() (Environment, bool) {
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return Environment{Type: EnvironmentContainer, Detail: "docker", Source: "/.dockerenv"}, true
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return Environment{Type: EnvironmentContainer, Detail: "podman", Source: "/run/.containerenv"}, true
+	}
+	if data, err := os.ReadFile("/proc/1/cgroup"); err == nil {
+		if runtime := containerFromCgroup(string(data)); runtime != "" {
+			return Environment{Type: EnvironmentContainer, Detail: runtime, Source: "/proc/1/cgroup"}, true
+		}
+	}
+	return Environment{}, false
+}
+
+// containerFromCgroup returns the container runtime named in the contents of
+// /proc/1/cgroup, or an empty string. Under cgroup v2 a container's init
+// usually sees only "0::/", so this finds containers on cgroup v1 hosts.
+func containerFromCgroup(content string) string {
+	content = strings.ToLower(content)
+	for _, m := range containerCgroupMarkers {
+		if strings.Contains(content, m.marker) {
+			return m.runtime
+		}
+	}
+	return ""
+}
+
+// matchVMSignature returns the hypervisor or cloud that the hardware vendor
+// and model identify, or an empty string for other hardware.
+func matchVMSignature(vendor, model string) string {
+	hardware := strings.ToLower(vendor + " " + model)
+	for _, sig := range vmSignatures {
+		if strings.Contains(hardware, sig.marker) {
+			return sig.hypervisor
+		}
+	}
+	return ""
+}
+
+// result returns the environment as reported in the payload.
+func (e Environment) result() map[string]interface{} {
+	return map[string]interface{}{
+		"type":   e.Type,
+		"detail": nullIfEmpty(e.Detail),
+		"source": nullIfEmpty(e.Source),
+	}
+}
+
+// applyVirtualNotApplicable replaces the results of enabled checks that are
+// meaningless in env with not-applicable markers giving env as the reason.
+func (c *Client) applyVirtualNotApplicable(rawResults map[string]interface{}, env Environment) {
+	checks, err := platformChecks(c.platform)
+	if err != nil {
+		return
+	}
+	reason := fmt.Sprintf("running in a %s (%s); this control describes an end-user device, not a container or CI or cloud VM", env.Type, env.Detail)
+	affected := virtualAffectedControls[c.platform]
+	for _, chk := range checks {
+		controls, ok := affected[chk.name]
+		if !ok || !c.checkEnabled(chk) {
+			continue
+		}
+		for _, control := range controls {
+			rawResults[control] = map[string]interface{}{
+				"notApplicable": true,
+				"reason":        reason,
+			}
+		}
+	}
+}
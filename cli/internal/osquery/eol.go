@@ -0,0 +1,241 @@
+package osquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eolDateLayout is the format of end-of-life dates.
+const eolDateLayout = "2006-01-02"
+
+// bundledEOL maps each product, named as on endoflife.date, to the end of
+// life date of each release cycle. An empty date means no end of life has
+// been announced. Update this table with every release.
+var bundledEOL = map[string]map[string]string{
+	"macos": {
+		"10.15": "2022-09-12",
+		"11":    "2023-09-26",
+		"12":    "2024-09-16",
+		"13":    "2025-09-15",
+		"14":    "",
+		"15":    "",
+		"26":    "",
+	},
+	// Windows cycles are build numbers; dates are for Home and Pro editions
+	"windows": {
+		"19044": "2023-06-13",
+		"19045": "2025-10-14",
+		"22000": "2023-10-10",
+		"22621": "2024-10-08",
+		"22631": "2025-11-11",
+		"26100": "2026-10-13",
+		"26200": "2027-10-12",
+	},
+	"windows-enterprise": {
+		"19044": "2024-06-11",
+		"19045": "2025-10-14",
+		"22000": "2024-10-08",
+		"22621": "2025-10-14",
+		"22631": "2026-11-10",
+		"26100": "2027-10-12",
+		"26200": "2028-10-10",
+	},
+	"ubuntu": {
+		"18.04": "2023-05-31",
+		"20.04": "2025-05-29",
+		"22.04": "2027-04-01",
+		"24.04": "2029-05-31",
+		"24.10": "2025-07-10",
+		"25.04": "2026-01-15",
+		"25.10": "2026-07-09",
+	},
+	"debian": {
+		"10": "2022-09-10",
+		"11": "2024-08-14",
+		"12": "2026-06-10",
+		"13": "2028-08-09",
+	},
+	"rhel": {
+		"7": "2024-06-30",
+		"8": "2029-05-31",
+		"9": "2032-05-31",
+	},
+	"rocky-linux": {
+		"8": "2029-05-31",
+		"9": "2032-05-31",
+	},
+	"almalinux": {
+		"8": "2029-03-01",
+		"9": "2032-05-31",
+	},
+	"centos": {
+		"7": "2024-06-30",
+		"8": "2021-12-31",
+	},
+	"amazon-linux": {
+		"2":    "2026-06-30",
+		"2023": "2029-06-30",
+	},
+}
+
+// linuxEOLProducts maps os_version platforms to endoflife.date products.
+var linuxEOLProducts = map[string]string{
+	"ubuntu":    "ubuntu",
+	"debian":    "debian",
+	"rhel":      "rhel",
+	"rocky":     "rocky-linux",
+	"almalinux": "almalinux",
+	"centos":    "centos",
+	"amzn":      "amazon-linux",
+}
+
+// eolAPIURL is the endoflife.date API used by the optional online lookup.
+const eolAPIURL = "https://endoflife.date/api/%s.json"
+
+// eolLookupTimeout bounds the optional online lookup.
+const eolLookupTimeout = 10 * time.Second
+
+// SetOnlineEOLLookup sets whether end-of-life dates are refreshed from
+// endoflife.date, falling back to the bundled table when offline.
+func (c *Client) SetOnlineEOLLookup(enabled bool) {
+	c.onlineEOLLookup = enabled
+}
+
+// collectOSSupportStatus reports whether the installed OS release still
+// receives support from its vendor. osSupported is unknown (null) for
+// releases missing from the end-of-life table.
+func (c *Client) collectOSSupportStatus(rawResults map[string]interface{}) {
+	row, err := c.queryFirst("SELECT name, major, minor, build, platform FROM os_version")
+	if err != nil || row == nil {
+		return
+	}
+
+	product, cycle := eolCycle(c.platform, row)
+	eol, known := bundledEOL[product][cycle]
+	source := "bundled"
+	// endoflife.date names Windows cycles by release rather than build
+	if c.onlineEOLLookup && !strings.HasPrefix(product, "windows") {
+		if date, ok := c.lookupOnlineEOL(product, cycle); ok {
+			eol, known, source = date, true, "endoflife.date"
+		}
+	}
+
+	status := map[string]interface{}{
+		"product":     product,
+		"cycle":       cycle,
+		"osSupported": nil,
+		"eolDate":     nil,
+		"source":      source,
+	}
+	if known {
+		if eol != "" {
+			status["eolDate"] = eol
+		}
+		if supported := eolSupported(eol, time.Now()); supported != nil {
+			status["osSupported"] = *supported
+		}
+	}
+	rawResults["osSupportStatus"] = status
+}
+
+// eolCycle returns the endoflife.date product and release cycle of the OS
+// described by an os_version row.
+func eolCycle(platform Platform, row map[string]interface{}) (product, cycle string) {
+	field := func(key string) string {
+		value, _ := row[key].(string)
+		return strings.TrimSpace(value)
+	}
+
+	switch platform {
+	case PlatformMacOS:
+		if field("major") == "10" {
+			return "macos", "10." + field("minor")
+		}
+		return "macos", field("major")
+	case PlatformWindows:
+		name := field("name")
+		if strings.Contains(name, "Enterprise") || strings.Contains(name, "Education") {
+			return "windows-enterprise", field("build")
+		}
+		if strings.Contains(name, "Server") {
+			return "windows-server", field("build")
+		}
+		return "windows", field("build")
+	default:
+		osPlatform := field("platform")
+		product, ok := linuxEOLProducts[osPlatform]
+		if !ok {
+			return osPlatform, field("major")
+		}
+		if product == "ubuntu" {
+			// Ubuntu cycles are zero-padded, such as 22.04
+			minor, _ := strconv.Atoi(field("minor"))
+			return product, fmt.Sprintf("%s.%02d", field("major"), minor)
+		}
+		return product, field("major")
+	}
+}
+
+// eolSupported reports whether a release with the given end-of-life date
+// is still supported at now. An empty date means no end of life has been
+// announced; an unparseable date is unknown (nil).
+func eolSupported(eol string, now time.Time) *bool {
+	if eol == "" {
+		return boolPtr(true)
+	}
+	date, err := time.Parse(eolDateLayout, eol)
+	if err != nil {
+		return nil
+	}
+	return boolPtr(now.Before(date.AddDate(0, 0, 1)))
+}
+
+// lookupOnlineEOL fetches the end-of-life date of cycle from
+// endoflife.date. It reports false if the lookup fails or the cycle is not
+// listed.
+func (c *Client) lookupOnlineEOL(product, cycle string) (string, bool) {
+	client := &http.Client{Timeout: eolLookupTimeout}
+	req, err := http.NewRequestWithContext(c.context(), http.MethodGet, fmt.Sprintf(eolAPIURL, product), nil)
+	if err != nil {
+		return "", false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		c.logVerbose("End-of-life lookup failed: %v", err)
+		return "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.logVerbose("End-of-life lookup failed: HTTP %d", resp.StatusCode)
+		return "", false
+	}
+
+	var cycles []struct {
+		Cycle string      `json:"cycle"`
+		EOL   interface{} `json:"eol"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cycles); err != nil {
+		c.logVerbose("Failed to parse end-of-life data: %v", err)
+		return "", false
+	}
+	for _, entry := range cycles {
+		if entry.Cycle != cycle {
+			continue
+		}
+		// eol is a date, or false when no end of life is announced
+		switch eol := entry.EOL.(type) {
+		case string:
+			return eol, true
+		case bool:
+			if !eol {
+				return "", true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
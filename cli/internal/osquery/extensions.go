@@ -0,0 +1,143 @@
+package osquery
+
+import (
+	"sort"
+	"strings"
+)
+
+// extensionSource is an osquery table listing one browser's extensions.
+type extensionSource struct {
+	// browser names the browser when rows do not carry a browser_type.
+	browser string
+	// queries are tried in order until one succeeds, so that older
+	// osquery versions missing newer columns still report extensions.
+	queries []string
+}
+
+var (
+	firefoxExtensions = extensionSource{
+		browser: "firefox",
+		queries: []string{"SELECT name, identifier, version, active FROM firefox_addons"},
+	}
+	chromeExtensions = extensionSource{
+		browser: "chrome",
+		queries: []string{
+			"SELECT browser_type, name, identifier, version, state FROM chrome_extensions",
+			"SELECT name, identifier, version FROM chrome_extensions",
+		},
+	}
+	safariExtensions = extensionSource{
+		browser: "safari",
+		queries: []string{"SELECT name, identifier, version FROM safari_extensions"},
+	}
+	ieExtensions = extensionSource{
+		browser: "ie",
+		queries: []string{"SELECT name, version FROM ie_extensions"},
+	}
+)
+
+// browserExtensions queries each source and returns the normalized,
+// de-duplicated extensions.
+func (c *Client) browserExtensions(sources []extensionSource) []map[string]interface{} {
+	var extensions []map[string]interface{}
+	for _, source := range sources {
+		for i, query := range source.queries {
+			// Only the last query's failure is an error; earlier ones have
+			// a fallback
+			queryClient := c
+			if i < len(source.queries)-1 {
+				queryClient = c.withoutErrorLog()
+			}
+			rows, err := queryClient.RunQuery(query)
+			if err != nil {
+				continue
+			}
+			extensions = append(extensions, normalizeBrowserExtensions(rows, source.browser)...)
+			break
+		}
+	}
+	return dedupeBrowserExtensions(extensions)
+}
+
+// normalizeBrowserExtensions converts extension rows to entries of the form
+// {browser, name, identifier?, version?, enabled?}. Optional fields are
+// omitted when the table does not report them.
+func normalizeBrowserExtensions(rows []map[string]interface{}, browser string) []map[string]interface{} {
+	extensions := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		field := func(key string) string {
+			value, _ := row[key].(string)
+			return strings.TrimSpace(value)
+		}
+
+		name := field("name")
+		if name == "" {
+			continue
+		}
+		extension := map[string]interface{}{
+			"browser": browser,
+			"name":    name,
+		}
+		if browserType := field("browser_type"); browserType != "" {
+			extension["browser"] = strings.ToLower(browserType)
+		}
+		if identifier := field("identifier"); identifier != "" {
+			extension["identifier"] = identifier
+		}
+		if version := field("version"); version != "" {
+			extension["version"] = version
+		}
+		// Firefox reports active and Chromium browsers report state
+		for _, key := range []string{"active", "state"} {
+			switch field(key) {
+			case "1":
+				extension["enabled"] = true
+			case "0":
+				extension["enabled"] = false
+			}
+		}
+		extensions = append(extensions, extension)
+	}
+	return extensions
+}
+
+// dedupeBrowserExtensions merges extensions installed in several profiles
+// of the same browser, keyed by identifier (or name) and version. A merged
+// extension is enabled if it is enabled in any profile. The result is
+// sorted by browser, name and version.
+func dedupeBrowserExtensions(extensions []map[string]interface{}) []map[string]interface{} {
+	byKey := make(map[string]map[string]interface{})
+	deduped := make([]map[string]interface{}, 0, len(extensions))
+	for _, extension := range extensions {
+		id, ok := extension["identifier"].(string)
+		if !ok {
+			id = extension["name"].(string)
+		}
+		version, _ := extension["version"].(string)
+		key := extension["browser"].(string) + "\x00" + id + "\x00" + version
+
+		existing, ok := byKey[key]
+		if !ok {
+			byKey[key] = extension
+			deduped = append(deduped, extension)
+			continue
+		}
+		if enabled, ok := extension["enabled"].(bool); ok && (enabled || existing["enabled"] == nil) {
+			existing["enabled"] = enabled
+		}
+	}
+
+	sort.SliceStable(deduped, func(i, j int) bool {
+		a, b := deduped[i], deduped[j]
+		if a["browser"] != b["browser"] {
+			return a["browser"].(string) < b["browser"].(string)
+		}
+		if a["name"] != b["name"] {
+			return a["name"].(string) < b["name"].(string)
+		}
+		av, _ := a["version"].(string)
+		bv, _ := b["version"].(string)
+		return av < bv
+	})
+	return deduped
+}
@@ -0,0 +1,247 @@
+package osquery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fimTool describes how to detect a Linux file integrity monitoring tool.
+type fimTool struct {
+	name string
+	// packages are the package names the tool ships as on RPM and Debian.
+	packages []string
+	// units are systemd services or timers that run the tool.
+	units []string
+	// binaries are files only the tool installs, which show it is there
+	// when it was not installed as a package.
+	binaries []string
+	// configs are configuration files present once the tool is set up.
+	configs []string
+	// sharedConfigs are configuration files another tool uses too, which
+	// show the tool is configured but not that it is there.
+	sharedConfigs []string
+	// supersededBy names a tool derived from this one that installs the
+	// same files. While that tool is there, this one is reported only when
+	// its own package is installed.
+	supersededBy string
+	// cronJobs are cron scripts that schedule periodic checks.
+	cronJobs []string
+}
+
+// fimTools lists the file integrity monitoring tools that are detected.
+var fimTools = []fimTool{
+	{
+		name:     "aide",
+		packages: []string{"aide", "aide-common"},
+		units:    []string{"aidecheck.timer", "dailyaidecheck.timer"},
+		configs:  []string{"/etc/aide.conf", "/etc/aide/aide.conf"},
+		cronJobs: []string{"/etc/cron.daily/aide", "/etc/cron.daily/aide-check", "/etc/cron.d/aide"},
+	},
+	{
+		name:     "tripwire",
+		packages: []string{"tripwire"},
+		configs:  []string{"/etc/tripwire/tw.cfg", "/etc/tripwire/twcfg.txt"},
+		cronJobs: []string{"/etc/cron.daily/tripwire", "/etc/cron.daily/tripwire-check"},
+	},
+	{
+		name:          "ossec",
+		packages:      []string{"ossec-hids", "ossec-hids-agent", "ossec-hids-server"},
+		units:         []string{"ossec.service", "ossec-hids.service"},
+		binaries:      []string{"/var/ossec/bin/ossec-control"},
+		sharedConfigs: []string{"/var/ossec/etc/ossec.conf"},
+		// Wazuh is a fork of OSSEC that installs under /var/ossec too,
+		// with ossec-control before Wazuh 4.2
+		supersededBy: "wazuh",
+	},
+	{
+		name:          "wazuh",
+		packages:      []string{"wazuh-agent", "wazuh-manager"},
+		units:         []string{"wazuh-agent.service", "wazuh-manager.service"},
+		binaries:      []string{"/var/ossec/bin/wazuh-control", "/var/ossec/bin/wazuh-modulesd"},
+		sharedConfigs: []string{"/var/ossec/etc/ossec.conf"},
+	},
+	{
+		name:     "auditd",
+		packages: []string{"audit", "auditd"},
+		units:    []string{"auditd.service"},
+		configs:  []string{"/etc/audit/auditd.conf"},
+	},
+}
+
+// auditRulesGlob matches the persistent audit rule files loaded by augenrules.
+const auditRulesGlob = "/etc/audit/rules.d/*.rules"
+
+// collectLinuxFileIntegrityMonitoring detects installed file integrity
+// monitoring tools and whether each is actively scheduled or running.
+func (c *Client) collectLinuxFileIntegrityMonitoring(rawResults map[string]interface{}) {
+	installed := c.installedPackages(fimPackageNames())
+
+	tools := make(map[string]interface{})
+	active := false
+	for _, tool := range fimTools {
+		status := c.fimToolStatus(tool, installed)
+		if status == nil {
+			continue
+		}
+		tools[tool.name] = status
+		if status["active"] == true {
+			active = true
+		}
+	}
+
+	rawResults["fileIntegrityMonitoring"] = map[string]interface{}{
+		"tools":  tools,
+		"active": active,
+	}
+}
+
+// fimToolStatus reports how tool is installed, configured and scheduled, or
+// nil if there is no sign of it on the system.
+func (c *Client) fimToolStatus(tool fimTool, installed map[string]bool) map[string]interface{} {
+	if !fimToolPresent(tool, installed, fileExists) {
+		return nil
+	}
+	packageInstalled := fimPackageInstalled(tool, installed)
+	configured := anyFileExists(tool.configs) || anyFileExists(tool.sharedConfigs)
+
+	var activeUnits []string
+	for _, unit := range tool.units {
+		// is-active exits 0 only when the unit is active
+		if _, exitCode, err := c.RunCommandStatus("systemctl is-active " + unit); err == nil && exitCode == 0 {
+			activeUnits = append(activeUnits, unit)
+		}
+	}
+	var cronJobs []string
+	for _, job := range tool.cronJobs {
+		if fileExists(job) {
+			cronJobs = append(cronJobs, job)
+		}
+	}
+
+	status := map[string]interface{}{
+		"installed":   packageInstalled,
+		"configured":  configured,
+		"activeUnits": activeUnits,
+		"cronJobs":    cronJobs,
+		"active":      len(activeUnits) > 0 || len(cronJobs) > 0,
+	}
+
+	// auditd only monitors files when it has watch or syscall rules loaded
+	if tool.name == "auditd" {
+		rules := countAuditRulesInFiles(auditRulesGlob, "/etc/audit/audit.rules")
+		status["rules"] = rules
+		status["active"] = len(activeUnits) > 0 && rules > 0
+	}
+
+	return status
+}
+
+// fimToolPresent reports whether there is a sign of tool on the system,
+// with exists telling which files exist: its package, one of its
+// binaries, or a configuration file of its own. While the tool that
+// supersedes it is present, only its package counts.
+func fimToolPresent(tool fimTool, installed map[string]bool, exists func(string) bool) bool {
+	packageInstalled := fimPackageInstalled(tool, installed)
+	for _, other := range fimTools {
+		if tool.supersededBy != "" && other.name == tool.supersededBy && fimToolPresent(other, installed, exists) {
+			return packageInstalled
+		}
+	}
+	if packageInstalled {
+		return true
+	}
+	for _, path := range append(append([]string{}, tool.binaries...), tool.configs...) {
+		if exists(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// fimPackageInstalled reports whether one of tool's packages is installed.
+func fimPackageInstalled(tool fimTool, installed map[string]bool) bool {
+	for _, pkg := range tool.packages {
+		if installed[pkg] {
+			return true
+		}
+	}
+	return false
+}
+
+// installedPackages returns which of names are installed as rpm or deb packages.
+func (c *Client) installedPackages(names []string) map[string]bool {
+	table := "deb_packages"
+	if c.isRPMBasedDistro() {
+		table = "rpm_packages"
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "'" + name + "'"
+	}
+
+	installed := make(map[string]bool)
+	query := fmt.Sprintf("SELECT name FROM %s WHERE name IN (%s)", table, strings.Join(quoted, ", "))
+	if result, err := c.RunQuery(query); err == nil {
+		for _, row := range result {
+			if name, ok := row["name"].(string); ok {
+				installed[name] = true
+			}
+		}
+	}
+	return installed
+}
+
+// fimPackageNames returns the package names of every file integrity
+// monitoring tool.
+func fimPackageNames() []string {
+	var names []string
+	for _, tool := range fimTools {
+		names = append(names, tool.packages...)
+	}
+	return names
+}
+
+// anyFileExists reports whether any of paths is an existing file.
+func anyFileExists(paths []string) bool {
+	for _, path := range paths {
+		if fileExists(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// countAuditRulesInFiles counts the audit rules in the files matching
+// pattern, falling back to the compiled rules file when none match.
+func countAuditRulesInFiles(pattern, fallback string) int {
+	files, _ := filepath.Glob(pattern)
+	if len(files) == 0 {
+		files = []string{fallback}
+	}
+
+	count := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		count += countAuditRules(string(data))
+	}
+	return count
+}
+
+// countAuditRules counts the watch (-w) and syscall (-a) rules in audit
+// rules content, ignoring comments and control lines such as -D or -b.
+func countAuditRules(content string) int {
+	count := 0
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-w ") || strings.HasPrefix(line, "-a ") {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,211 @@
+package osquery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// linuxDMIDir holds the SMBIOS BIOS fields, readable without root.
+const linuxDMIDir = "/sys/class/dmi/id"
+
+// firmwareInfo is the result of the firmwareInfo check. Fields that could
+// not be read are empty and reported as null.
+type firmwareInfo struct {
+	vendor      string
+	version     string
+	releaseDate string
+	source      string
+	// updateAvailable is nil when whether an update is available is unknown.
+	updateAvailable *bool
+	// updates names the devices with firmware updates available.
+	updates []string
+	notes   []string
+}
+
+// collectFirmwareInfo collects the BIOS/UEFI vendor, version, and release
+// date, and on Linux whether fwupd knows of firmware updates. Values that
+// cannot be read, for lack of privileges or tooling, are null rather than
+// guessed, with a note.
+func (c *Client) collectFirmwareInfo(rawResults map[string]interface{}) {
+	var info firmwareInfo
+	switch c.platform {
+	case PlatformLinux:
+		info = c.linuxFirmwareInfo()
+	case PlatformMacOS:
+		info = c.macOSFirmwareInfo()
+	case PlatformWindows:
+		info = c.windowsFirmwareInfo()
+	default:
+		return
+	}
+
+	result := map[string]interface{}{
+		"vendor":          nullIfEmpty(info.vendor),
+		"version":         nullIfEmpty(info.version),
+		"releaseDate":     nullIfEmpty(info.releaseDate),
+		"source":          info.source,
+		"updateAvailable": nil,
+		"notes":           info.notes,
+	}
+	if info.updateAvailable != nil {
+		result["updateAvailable"] = *info.updateAvailable
+		result["updates"] = info.updates
+	}
+	rawResults["firmwareInfo"] = result
+}
+
+// linuxFirmwareInfo reads the BIOS fields from osquery's platform_info,
+// falling back to sysfs, and asks fwupd for pending firmware updates.
+func (c *Client) linuxFirmwareInfo() firmwareInfo {
+	var info firmwareInfo
+
+	// platform_info fails where SMBIOS needs root, and sysfs is the fallback
+	if row, err := c.withoutErrorLog().queryFirst("SELECT vendor, version, date FROM platform_info"); err == nil && row != nil {
+		info.vendor, _ = row["vendor"].(string)
+		info.version, _ = row["version"].(string)
+		info.releaseDate, _ = row["date"].(string)
+		info.source = "platform_info"
+	}
+	if info.version == "" {
+		info.vendor = readDMIField("bios_vendor")
+		info.version = readDMIField("bios_version")
+		info.releaseDate = readDMIField("bios_date")
+		info.source = "sysfs"
+	}
+	if info.version == "" {
+		info.notes = append(info.notes, "BIOS version not available; the system may not expose SMBIOS, as in some VMs and containers")
+	}
+
+	// get-updates exits 2 when there is nothing to do, and 127 when fwupd
+	// is not installed
+	output, exitCode, err := c.RunCommandStatus("fwupdmgr get-updates --json --no-unreported-check 2>/dev/null")
+	switch {
+	case err != nil || exitCode == 127:
+		info.notes = append(info.notes, "fwupdmgr is not available; firmware update status unknown")
+	case exitCode == 2:
+		info.updateAvailable = boolPtr(false)
+		info.updates = []string{}
+	default:
+		if updates, ok := parseFwupdUpdates(output); ok {
+			info.updateAvailable = boolPtr(len(updates) > 0)
+			info.updates = updates
+		} else {
+			info.notes = append(info.notes, "could not read firmware update status from fwupdmgr")
+		}
+	}
+	return info
+}
+
+// readDMIField returns a field from linuxDMIDir, or an empty string if it
+// cannot be read.
+func readDMIField(name string) string {
+	content, err := os.ReadFile(filepath.Join(linuxDMIDir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// parseFwupdUpdates returns the names of the devices with releases in
+// `fwupdmgr get-updates --json` output. ok is false when the output is not
+// in the expected form.
+func parseFwupdUpdates(output string) (updates []string, ok bool) {
+	var parsed struct {
+		Devices []struct {
+			Name     string `json:"Name"`
+			Releases []struct {
+				Version string `json:"Version"`
+			} `json:"Releases"`
+		} `json:"Devices"`
+	}
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil {
+		return nil, false
+	}
+	updates = []string{}
+	for _, device := range parsed.Devices {
+		if len(device.Releases) > 0 {
+			updates = append(updates, device.Name)
+		}
+	}
+	return updates, true
+}
+
+// macOSFirmwareInfo reads the boot ROM version from system_profiler. Mac
+// firmware is only updated with macOS, so update status is not reported.
+func (c *Client) macOSFirmwareInfo() firmwareInfo {
+	info := firmwareInfo{
+		source: "system_profiler",
+		notes:  []string{"Mac firmware is updated with macOS"},
+	}
+	if output, err := c.RunCommand("system_profiler SPHardwareDataType -json"); err == nil {
+		if version := parseBootROMVersion(output); version != "" {
+			info.vendor = "Apple"
+			info.version = version
+		}
+	}
+	if info.version == "" {
+		info.notes = append(info.notes, "boot ROM version not available from system_profiler")
+	}
+	return info
+}
+
+// parseBootROMVersion returns the boot ROM version in `system_profiler
+// SPHardwareDataType -json` output.
+func parseBootROMVersion(output string) string {
+	var parsed struct {
+		SPHardwareDataType []struct {
+			BootROMVersion string `json:"boot_rom_version"`
+		} `json:"SPHardwareDataType"`
+	}
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil || len(parsed.SPHardwareDataType) == 0 {
+		return ""
+	}
+	return parsed.SPHardwareDataType[0].BootROMVersion
+}
+
+// windowsBIOSCommand prints Win32_BIOS as JSON, with the release date as
+// yyyy-MM-dd rather than PowerShell's /Date(...)/ form.
+const windowsBIOSCommand = `powershell -NoProfile -Command "Get-CimInstance Win32_BIOS | ` +
+	`Select-Object Manufacturer, SMBIOSBIOSVersion, @{n='ReleaseDate';e={$_.ReleaseDate.ToString('yyyy-MM-dd')}} | ConvertTo-Json"`
+
+// windowsFirmwareInfo reads the BIOS from Win32_BIOS. Firmware updates come
+// through Windows Update or the vendor's tools, so update status is not
+// reported.
+func (c *Client) windowsFirmwareInfo() firmwareInfo {
+	info := firmwareInfo{
+		source: "Win32_BIOS",
+		notes:  []string{"firmware update status is not reported on Windows"},
+	}
+	if output, err := c.RunCommand(windowsBIOSCommand); err == nil {
+		info.vendor, info.version, info.releaseDate = parseWin32BIOS(output)
+	}
+	if info.version == "" {
+		info.notes = append(info.notes, "BIOS version not available from Win32_BIOS")
+	}
+	return info
+}
+
+// parseWin32BIOS returns the manufacturer, version, and release date in
+// the JSON printed by windowsBIOSCommand.
+func parseWin32BIOS(output string) (vendor, version, releaseDate string) {
+	var parsed struct {
+		Manufacturer      string `json:"Manufacturer"`
+		SMBIOSBIOSVersion string `json:"SMBIOSBIOSVersion"`
+		ReleaseDate       string `json:"ReleaseDate"`
+	}
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil {
+		return "", "", ""
+	}
+	return parsed.Manufacturer, parsed.SMBIOSBIOSVersion, parsed.ReleaseDate
+}
+
+// nullIfEmpty returns nil for an empty string, so unknown values are
+// reported as null.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package osquery
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// forbiddenFlags are osquery flags that cannot be passed through
+// configuration, with the reason for each. Extensions run arbitrary
+// executables, output format flags break parsing of the JSON results, and
+// nested flag files would bypass this validation.
+var forbiddenFlags = map[string]string{
+	"extension":             "loads an executable",
+	"extensions_autoload":   "loads executables",
+	"extensions_require":    "requires extensions",
+	"extensions_socket":     "connects to extensions",
+	"allow_unsafe":          "allows loading unsafe extensions",
+	"flagfile":              "use osquery_flagfile instead",
+	"json":                  "sets the output format",
+	"csv":                   "changes the output format",
+	"line":                  "changes the output format",
+	"list":                  "changes the output format",
+	"header":                "changes the output format",
+	"separator":             "changes the output format",
+	"pack":                  "runs a query pack",
+	"config_plugin":         "selects a config plugin",
+	"logger_plugin":         "selects a logger plugin",
+	"distributed_plugin":    "selects a distributed query plugin",
+	"database_path":         "writes to another database",
+	"enroll_secret_path":    "enrolls with a remote server",
+	"tls_hostname":          "connects to a remote server",
+	"config_tls_endpoint":   "fetches config from a remote server",
+	"logger_tls_endpoint":   "sends logs to a remote server",
+	"enroll_tls_endpoint":   "enrolls with a remote server",
+	"carver_start_endpoint": "uploads files to a remote server",
+}
+
+// SetFlags sets extra flags passed to osqueryi for every query, and a flag
+// file whose flags are loaded before them. Both must have been validated
+// with ValidateFlags and ValidateFlagfile.
+func (c *Client) SetFlags(flags []string, flagfile string) {
+	c.flags = flags
+	c.flagfile = flagfile
+}
+
+// queryArgs returns the osqueryi arguments that run query.
+func (c *Client) queryArgs(query string) []string {
+	var args []string
+	if c.flagfile != "" {
+		args = append(args, "--flagfile="+c.flagfile)
+	}
+	args = append(args, c.flags...)
+	return append(args, "--json", query)
+}
+
+// ValidateFlags checks that every flag is a --name or --name=value osquery
+// flag that is safe to pass through configuration.
+func ValidateFlags(flags []string) error {
+	for _, flag := range flags {
+		if err := validateFlag(flag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateFlagfile checks that path is empty or a readable osquery flag
+// file containing only flags allowed by ValidateFlags.
+func ValidateFlagfile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read osquery flagfile: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := validateFlag(line); err != nil {
+			return fmt.Errorf("osquery flagfile %s: %w", path, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read osquery flagfile: %w", err)
+	}
+	return nil
+}
+
+// validateFlag checks a single osquery flag.
+func validateFlag(flag string) error {
+	if !strings.HasPrefix(flag, "--") || len(flag) == 2 {
+		return fmt.Errorf("invalid osquery flag %q: flags must start with --", flag)
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+	if reason, ok := forbiddenFlags[name]; ok {
+		return fmt.Errorf("osquery flag --%s is not allowed: %s", name, reason)
+	}
+	return nil
+}
@@ -1,14 +1,29 @@
 package osquery
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	osuser "os/user"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"unicode"
 )
 
+// geteuid returns the agent's effective user ID. Collector tests replace
+// it to collect as root or not.
+var geteuid = os.Geteuid
+
+// rpmDistroMarkers are files whose presence marks an RPM-based distro.
+var rpmDistroMarkers = []string{
+	"/etc/redhat-release",
+	"/etc/fedora-release",
+	// dnf or yum package managers
+	"/usr/bin/dnf",
+	"/usr/bin/yum",
+}
+
 func isValidSessionUser(user string) bool {
 	if user == "" || user == "root" {
 		return false
@@ -21,19 +36,28 @@ func isValidSessionUser(user string) bool {
 	return true
 }
 
+// getDesktopSessionUser returns the user whose desktop settings apply: the
+// user who ran the agent with sudo, then, as root, the user of the active
+// graphical session, as when a service manager runs the agent, then the
+// login user.
 func (c *Client) getDesktopSessionUser() string {
-	candidates := []string{
-		os.Getenv("SUDO_USER"),
-		os.Getenv("LOGNAME"),
-		os.Getenv("USER"),
+	if user := strings.TrimSpace(os.Getenv("SUDO_USER")); isValidSessionUser(user) {
+		return user
 	}
-	for _, candidate := range candidates {
+	if geteuid() == 0 {
+		if user := c.graphicalSessionUser(); user != "" {
+			return user
+		}
+	}
+	for _, candidate := range []string{os.Getenv("LOGNAME"), os.Getenv("USER")} {
 		candidate = strings.TrimSpace(candidate)
 		if isValidSessionUser(candidate) {
 			return candidate
 		}
 	}
-	if output, err := c.RunCommand("logname"); err == nil {
+	// logname fails without a controlling terminal, as under a service
+	// manager, which only means there is no login user
+	if output, err := c.withoutErrorLog().RunCommand("logname"); err == nil {
 		candidate := strings.TrimSpace(output)
 		if isValidSessionUser(candidate) {
 			return candidate
@@ -42,19 +66,153 @@ func (c *Client) getDesktopSessionUser() string {
 	return ""
 }
 
-func (c *Client) runGsettingsCommand(args string) (string, error) {
-	baseCmd := fmt.Sprintf("gsettings %s", args)
+// graphicalSessionTypes are the loginctl session types of a local desktop.
+var graphicalSessionTypes = map[string]bool{"x11": true, "wayland": true, "mir": true}
+
+// graphicalSessionUser returns the user of the active local graphical
+// session, as listed by systemd-logind, or, where loginctl is not
+// available, the first user osquery reports logged in on an X display. It
+// returns "" when nobody is logged in to a desktop.
+func (c *Client) graphicalSessionUser() string {
+	quiet := c.withoutErrorLog()
+	if output, err := quiet.RunCommand("loginctl list-sessions --no-legend"); err == nil {
+		for _, line := range strings.Split(output, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || !isValidSessionUser(fields[0]) {
+				continue
+			}
+			properties, err := quiet.RunCommand("loginctl show-session " + fields[0] + " -p Name -p Type -p Active -p Remote")
+			if err != nil {
+				continue
+			}
+			if user := graphicalSession(properties); user != "" {
+				return user
+			}
+		}
+		return ""
+	}
+
+	result, err := quiet.RunQuery("SELECT user, tty FROM logged_in_users WHERE type = 'user'")
+	if err != nil {
+		return ""
+	}
+	for _, row := range result {
+		user, _ := row["user"].(string)
+		tty, _ := row["tty"].(string)
+		if strings.HasPrefix(tty, ":") && isValidSessionUser(user) {
+			return user
+		}
+	}
+	return ""
+}
+
+// graphicalSession returns the user of a session from the properties
+// loginctl show-session prints, one Key=value per line, if it is an active
+// local graphical session, or "" otherwise.
+func graphicalSession(properties string) string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(properties, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			values[key] = value
+		}
+	}
+	if values["Active"] != "yes" || values["Remote"] == "yes" || !graphicalSessionTypes[values["Type"]] || !isValidSessionUser(values["Name"]) {
+		return ""
+	}
+	return values["Name"]
+}
+
+// desktopSession is a desktop user's login session, whose session bus
+// gsettings needs to read that user's settings.
+type desktopSession struct {
+	user       string
+	runtimeDir string
+}
+
+// reachableDesktopSession returns the desktop user's session, or why no
+// session is reachable.
+func (c *Client) reachableDesktopSession() (desktopSession, string) {
 	user := c.getDesktopSessionUser()
 	if user == "" {
-		return c.RunCommand(baseCmd)
+		return desktopSession{}, "running as root and nobody is logged in to a graphical session to read settings for, as on a server"
+	}
+	uid, err := c.withoutErrorLog().RunCommand("id -u " + user)
+	if err != nil {
+		return desktopSession{}, fmt.Sprintf("desktop user %s does not exist", user)
 	}
+	runtimeDir := "/run/user/" + strings.TrimSpace(uid)
+	if _, err := os.Stat(filepath.Join(runtimeDir, "bus")); err != nil {
+		return desktopSession{}, fmt.Sprintf("desktop user %s has no session bus at %s, as when they are not logged in", user, filepath.Join(runtimeDir, "bus"))
+	}
+	return desktopSession{user: user, runtimeDir: runtimeDir}, ""
+}
 
-	userCmd := fmt.Sprintf("uid=$(id -u %[1]s) && sudo -u %[1]s env XDG_RUNTIME_DIR=/run/user/$uid DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/$uid/bus %s", user, baseCmd)
-	if output, err := c.RunCommand(userCmd); err == nil {
-		return output, nil
+// DesktopSession returns the desktop user whose settings the Linux checks
+// read, or why no desktop session is reachable. The agent uses it as root
+// before it starts collection as another user, which cannot find the
+// desktop user's session itself.
+func (c *Client) DesktopSession() (user, unavailable string) {
+	if c.platform != PlatformLinux {
+		return "", ""
+	}
+	session, reason := c.withoutErrorLog().reachableDesktopSession()
+	return session.user, reason
+}
+
+// SetDesktopSession sets the desktop user found by DesktopSession in the
+// root agent, and why their session is unavailable, if it is. A client
+// not running as that user then reports their settings as unavailable
+// rather than reading its own.
+func (c *Client) SetDesktopSession(user, unavailable string) {
+	c.desktopDelegated = true
+	c.desktopUnavailable = unavailable
+	if unavailable != "" {
+		return
+	}
+	if current, err := osuser.Current(); err != nil || current.Username != user {
+		name := "another user"
+		if err == nil {
+			name = current.Username
+		}
+		c.desktopUnavailable = fmt.Sprintf("collecting as %s, which cannot read the settings of desktop user %s", name, user)
 	}
+}
 
-	return c.RunCommand(baseCmd)
+// desktopSessionUnavailable returns why gsettings cannot read the desktop
+// user's settings, or an empty string when it can. Only root, or a client
+// given the desktop user by SetDesktopSession, is affected, since gsettings
+// otherwise reads the agent user's own settings, which are usually the
+// defaults.
+func (c *Client) desktopSessionUnavailable() string {
+	if c.desktopDelegated {
+		return c.desktopUnavailable
+	}
+	if geteuid() != 0 {
+		return ""
+	}
+	_, reason := c.reachableDesktopSession()
+	return reason
+}
+
+// runGsettingsCommand runs gsettings for the desktop user. As root it runs
+// as that user on their session bus, and fails rather than reading root's
+// settings when there is no reachable session, as it does for a client
+// given a desktop user other than its own by SetDesktopSession.
+func (c *Client) runGsettingsCommand(args string) (string, error) {
+	baseCmd := fmt.Sprintf("gsettings %s", args)
+	if c.desktopDelegated && c.desktopUnavailable != "" {
+		return "", errors.New(c.desktopUnavailable)
+	}
+	if c.desktopDelegated || geteuid() != 0 {
+		return c.RunCommand(baseCmd)
+	}
+
+	session, reason := c.reachableDesktopSession()
+	if reason != "" {
+		return "", errors.New(reason)
+	}
+	userCmd := fmt.Sprintf("sudo -u %[1]s env XDG_RUNTIME_DIR=%[2]s DBUS_SESSION_BUS_ADDRESS=unix:path=%[2]s/bus %[3]s", session.user, session.runtimeDir, baseCmd)
+	return c.RunCommand(userCmd)
 }
 
 func parseGsettingsUint(output string) (int, error) {
@@ -72,57 +230,37 @@ func parseGsettingsUint(output string) (int, error) {
 
 // isRPMBasedDistro checks if the system is RPM-based (Fedora/RHEL/CentOS).
 func (c *Client) isRPMBasedDistro() bool {
-	// Check if /etc/redhat-release or /etc/fedora-release exists
-	if _, err := os.Stat("/etc/redhat-release"); err == nil {
-		return true
-	}
-	if _, err := os.Stat("/etc/fedora-release"); err == nil {
-		return true
-	}
-	// Check for dnf or yum package managers
-	if _, err := os.Stat("/usr/bin/dnf"); err == nil {
-		return true
-	}
-	if _, err := os.Stat("/usr/bin/yum"); err == nil {
-		return true
+	for _, marker := range rpmDistroMarkers {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
 	}
 	return false
 }
 
-// getLinuxSystemInfo collects Linux-specific system information.
-func (c *Client) getLinuxSystemInfo(version string) (*QueryResult, error) {
-	rawResults := make(map[string]interface{})
-
-	// OS Version
-	if result, err := c.queryFirst("SELECT name, version, platform FROM os_version"); err == nil && result != nil {
-		rawResults["osVersion"] = result
-	}
-
-	// Hardware Serial
-	if result, err := c.queryFirst("SELECT hardware_serial FROM system_info"); err == nil && result != nil {
-		rawResults["hwSerial"] = result
-	}
-
-	// Hardware Model
-	if result, err := c.queryFirst("SELECT hardware_model FROM system_info"); err == nil && result != nil {
-		rawResults["hwModel"] = result
-	}
-
-	// System Information
-	if result, err := c.queryFirst("SELECT board_serial, board_model, computer_name, hostname, local_hostname FROM system_info"); err == nil && result != nil {
-		rawResults["boardSerial"] = result["board_serial"]
-		rawResults["boardModel"] = result["board_model"]
-		rawResults["computerName"] = result["computer_name"]
-		rawResults["hostName"] = result["hostname"]
-		rawResults["localHostName"] = result["local_hostname"]
+// linuxChecks returns the Linux-specific checks in collection order.
+func linuxChecks() []check {
+	return []check{
+		{name: "firewall", collect: (*Client).collectLinuxFirewall},
+		{name: "appList", collect: (*Client).collectLinuxAppList},
+		{name: "antivirus", collect: (*Client).collectLinuxAntivirus},
+		{name: "browserExtensions", collect: (*Client).collectLinuxBrowserExtensions},
+		{name: "macAddress", collect: (*Client).collectMacAddress},
+		{name: "autoUpdate", collect: (*Client).collectLinuxAutoUpdate},
+		{name: "screenLock", collect: (*Client).collectLinuxScreenLock},
+		{name: "locationServices", collect: (*Client).collectLinuxLocationServices},
+		{name: "fileIntegrityMonitoring", collect: (*Client).collectLinuxFileIntegrityMonitoring},
 	}
+}
 
-	// Firewall Status - try both firewalld (RHEL/Fedora) and UFW (Debian/Ubuntu)
+// collectLinuxFirewall collects firewall status, trying firewalld
+// (RHEL/Fedora) or UFW (Debian/Ubuntu).
+func (c *Client) collectLinuxFirewall(rawResults map[string]interface{}) {
 	if c.isRPMBasedDistro() {
-		// Firewalld for RHEL/Fedora
-		if output, err := c.RunCommand("systemctl is-active firewalld"); err == nil {
+		// Firewalld for RHEL/Fedora; is-active exits 0 only when the unit is active
+		if output, exitCode, err := c.RunCommandStatus("systemctl is-active firewalld"); err == nil {
 			rawResults["firewallStatus"] = map[string]interface{}{
-				"passed": output == "active",
+				"passed": exitCode == 0,
 				"type":   "firewalld",
 				"status": output,
 			}
@@ -133,8 +271,10 @@ func (c *Client) getLinuxSystemInfo(version string) (*QueryResult, error) {
 			rawResults["firewallStatus"] = result
 		}
 	}
+}
 
-	// Application List - try both rpm_packages and deb_packages
+// collectLinuxAppList collects installed packages from rpm or dpkg.
+func (c *Client) collectLinuxAppList(rawResults map[string]interface{}) {
 	if c.isRPMBasedDistro() {
 		if result, err := c.RunQuery("SELECT name, version FROM rpm_packages"); err == nil {
 			rawResults["appList"] = result
@@ -144,12 +284,16 @@ func (c *Client) getLinuxSystemInfo(version string) (*QueryResult, error) {
 			rawResults["appList"] = result
 		}
 	}
+}
 
-	// Antivirus Check - check for clamav and flatpak-installed clam apps
+// collectLinuxAntivirus checks for clamav and flatpak-installed clam apps.
+// The probes exit non-zero when nothing is installed, which is a result,
+// not a check error.
+func (c *Client) collectLinuxAntivirus(rawResults map[string]interface{}) {
 	antivirusStatus := map[string]interface{}{"passed": false}
 	if c.isRPMBasedDistro() {
 		// Check for clamav daemon
-		if output, err := c.RunCommand("rpm -q clamav"); err == nil && output != "" {
+		if output, exitCode, err := c.RunCommandStatus("rpm -q clamav"); err == nil && exitCode == 0 && output != "" {
 			antivirusStatus["clamav"] = map[string]interface{}{
 				"installed": true,
 				"version":   output,
@@ -158,7 +302,7 @@ func (c *Client) getLinuxSystemInfo(version string) (*QueryResult, error) {
 		}
 	} else {
 		// Check for clamav on Debian/Ubuntu
-		if output, err := c.RunCommand("dpkg -l clamav | grep -E '^ii'"); err == nil && output != "" {
+		if output, exitCode, err := c.RunCommandStatus("dpkg -l clamav | grep -E '^ii'"); err == nil && exitCode == 0 && output != "" {
 			antivirusStatus["clamav"] = map[string]interface{}{
 				"installed": true,
 				"version":   output,
@@ -175,7 +319,7 @@ func (c *Client) getLinuxSystemInfo(version string) (*QueryResult, error) {
 		{"user", "flatpak list --app --user | grep -i clam"},
 	}
 	for _, scope := range flatpakScopes {
-		if output, err := c.RunCommand(scope.command); err == nil && output != "" {
+		if output, exitCode, err := c.RunCommandStatus(scope.command); err == nil && exitCode == 0 && output != "" {
 			antivirusStatus["flatpak"] = map[string]interface{}{
 				"installed": true,
 				"scope":     scope.label,
@@ -186,57 +330,78 @@ func (c *Client) getLinuxSystemInfo(version string) (*QueryResult, error) {
 		}
 	}
 	rawResults["antivirusStatus"] = antivirusStatus
+}
 
-	// Browser Extensions - use user home directory paths
+// collectLinuxBrowserExtensions collects Firefox and Chrome extensions from
+// the user's profile directories.
+func (c *Client) collectLinuxBrowserExtensions(rawResults map[string]interface{}) {
 	homeDir := os.Getenv("HOME")
 	if homeDir == "" {
 		homeDir = "/root"
 	}
-	extensions := make([]interface{}, 0)
+	var sources []extensionSource
 
 	// Firefox addons - check user profile directory
-	firefoxPath := filepath.Join(homeDir, ".mozilla", "firefox")
-	if _, err := os.Stat(firefoxPath); err == nil {
-		if result, err := c.RunQuery("SELECT name FROM firefox_addons"); err == nil {
-			for _, r := range result {
-				extensions = append(extensions, r)
-			}
-		}
+	if _, err := os.Stat(filepath.Join(homeDir, ".mozilla", "firefox")); err == nil {
+		sources = append(sources, firefoxExtensions)
 	}
 
 	// Chrome extensions - check user profile directory
-	chromePath := filepath.Join(homeDir, ".config", "google-chrome")
-	if _, err := os.Stat(chromePath); err == nil {
-		if result, err := c.RunQuery("SELECT name FROM chrome_extensions"); err == nil {
-			for _, r := range result {
-				extensions = append(extensions, r)
-			}
-		}
-	}
-	rawResults["browserExtensions"] = extensions
-
-	// MAC Address
-	if result, err := c.queryFirst("SELECT mac FROM interface_details WHERE interface in (SELECT DISTINCT interface FROM interface_addresses WHERE interface NOT IN ('lo')) LIMIT 1"); err == nil && result != nil {
-		rawResults["macAddress"] = result
+	if _, err := os.Stat(filepath.Join(homeDir, ".config", "google-chrome")); err == nil {
+		sources = append(sources, chromeExtensions)
 	}
+	rawResults["browserExtensions"] = c.browserExtensions(sources)
+}
 
-	// Auto Update Settings - use only gsettings for autoUpdateEnabled check
+// collectLinuxAutoUpdate collects automatic update settings: the GNOME
+// Software download-updates setting, and unattended-upgrades or
+// dnf-automatic, whichever belongs to the distro. autoUpdateEnabled passes
+// when GNOME Software downloads updates or either service installs them.
+func (c *Client) collectLinuxAutoUpdate(rawResults map[string]interface{}) {
 	autoUpdateSettings := make([]interface{}, 0)
-	// GNOME Software automatic updates check - only this sets autoUpdateEnabled
+	enabled := false
 	if output, err := c.runGsettingsCommand("get org.gnome.software download-updates"); err == nil {
 		autoUpdateSettings = append(autoUpdateSettings, map[string]string{"gnomeSoftwareDownloadUpdates": output})
-		if output == "true" {
-			rawResults["autoUpdateEnabled"] = map[string]interface{}{"passed": 1}
-		}
+		enabled = output == "true"
+	}
+
+	if c.isRPMBasedDistro() {
+		dnfAutomatic := c.dnfAutomaticSettings()
+		autoUpdateSettings = append(autoUpdateSettings, map[string]interface{}{"dnfAutomatic": dnfAutomatic})
+		enabled = enabled || (dnfAutomatic["enabled"] == true && dnfAutomatic["applyUpdates"] == true)
+	} else {
+		unattendedUpgrades := c.unattendedUpgradesSettings()
+		autoUpdateSettings = append(autoUpdateSettings, map[string]interface{}{"unattendedUpgrades": unattendedUpgrades})
+		enabled = enabled || unattendedUpgrades["enabled"] == true
 	}
 
+	if enabled {
+		rawResults["autoUpdateEnabled"] = map[string]interface{}{"passed": 1}
+	}
 	rawResults["autoUpdateSettings"] = autoUpdateSettings
+}
+
+// collectLinuxScreenLock collects GNOME screen lock status and settings.
+// Both are reported as indeterminate, with the reason, when no desktop
+// session is reachable to read them from.
+func (c *Client) collectLinuxScreenLock(rawResults map[string]interface{}) {
+	if reason := c.desktopSessionUnavailable(); reason != "" {
+		for _, control := range []string{"screenLockStatus", "screenLockSettings"} {
+			rawResults[control] = map[string]interface{}{
+				"indeterminate": true,
+				"reason":        reason,
+			}
+		}
+		return
+	}
 
 	// Screen Lock Status - only check idle-delay for Fedora/RHEL Gnome
 	screenLockStatus := make([]interface{}, 0)
+	idleDelaySeconds := -1
 	// Capture idle-delay from org.gnome.desktop.session so we know when the screen saver triggers
 	if output, err := c.runGsettingsCommand("get org.gnome.desktop.session idle-delay"); err == nil {
 		if seconds, parseErr := parseGsettingsUint(output); parseErr == nil {
+			idleDelaySeconds = seconds
 			screenLockStatus = append(screenLockStatus, map[string]interface{}{"idleDelaySeconds": seconds})
 		} else {
 			screenLockStatus = append(screenLockStatus, map[string]string{"idleDelay": output})
@@ -250,14 +415,15 @@ func (c *Client) getLinuxSystemInfo(version string) (*QueryResult, error) {
 			screenLockStatus = append(screenLockStatus, map[string]string{"lockDelay": output})
 		}
 	}
-	rawResults["screenLockStatus"] = screenLockStatus
-
-	// Location Services
-	locationServices := make(map[string]interface{})
-	if output, err := c.runGsettingsCommand("get org.gnome.system.location enabled"); err == nil {
-		locationServices["gnomeLocation"] = output
+	// Check lock-enabled, since a short idle-delay only blanks the screen if locking is off
+	lockEnabled, lockEnabledKnown := false, false
+	if output, err := c.runGsettingsCommand("get org.gnome.desktop.screensaver lock-enabled"); err == nil {
+		screenLockStatus = append(screenLockStatus, map[string]string{"lockEnabled": output})
+		if enabled, parseErr := strconv.ParseBool(strings.TrimSpace(output)); parseErr == nil {
+			lockEnabled, lockEnabledKnown = enabled, true
+		}
 	}
-	rawResults["locationServices"] = locationServices
+	rawResults["screenLockStatus"] = screenLockStatus
 
 	// Screen Lock Settings - use gsettings which works for current user
 	screenLockSettings := make(map[string]interface{})
@@ -270,13 +436,55 @@ func (c *Client) getLinuxSystemInfo(version string) (*QueryResult, error) {
 	if output, err := c.runGsettingsCommand("list-recursively org.gnome.desktop.session"); err == nil && output != "" {
 		screenLockSettings["sessionSettings"] = output
 	}
+	// The screen only locks if locking is enabled and the idle timer is not disabled (0)
+	if lockEnabledKnown {
+		screenLockSettings["screenLockEnabled"] = lockEnabled && idleDelaySeconds != 0
+	}
+	if required := c.linuxRequirePasswordOnWake(lockEnabled, lockEnabledKnown); required != nil {
+		screenLockSettings["requirePasswordOnWake"] = *required
+	}
 	rawResults["screenLockSettings"] = screenLockSettings
+}
 
-	return &QueryResult{
-		DrataAgentVersion: version,
-		Platform:          PlatformLinux,
-		RawQueryResults:   rawResults,
-	}, nil
+// linuxLockOnSuspendKeys are the GNOME settings for locking the screen on
+// suspend: Ubuntu's own key first, then the upstream one where it exists.
+var linuxLockOnSuspendKeys = []string{
+	"org.gnome.desktop.screensaver ubuntu-lock-on-suspend",
+	"org.gnome.desktop.screensaver lock-on-suspend",
+}
+
+// linuxRequirePasswordOnWake reports whether GNOME locks the screen on
+// suspend, so that a password is needed on resume. Without a
+// lock-on-suspend setting, GNOME locks on suspend when screen locking is
+// enabled. The lock screen can be disabled outright by lockdown policy. It
+// returns nil when this cannot be told.
+func (c *Client) linuxRequirePasswordOnWake(lockEnabled, lockEnabledKnown bool) *bool {
+	if output, err := c.withoutErrorLog().runGsettingsCommand("get org.gnome.desktop.lockdown disable-lock-screen"); err == nil && strings.TrimSpace(output) == "true" {
+		return boolPtr(false)
+	}
+	// The keys do not exist on every distro, so their absence is not an error
+	for _, key := range linuxLockOnSuspendKeys {
+		output, err := c.withoutErrorLog().runGsettingsCommand("get " + key)
+		if err != nil {
+			continue
+		}
+		if enabled, parseErr := strconv.ParseBool(strings.TrimSpace(output)); parseErr == nil {
+			return boolPtr(enabled)
+		}
+	}
+	if lockEnabledKnown {
+		return boolPtr(lockEnabled)
+	}
+	return nil
+}
+
+// collectLinuxLocationServices collects the GNOME location services setting.
+func (c *Client) collectLinuxLocationServices(rawResults map[string]interface{}) {
+	locationServices := make(map[string]interface{})
+	if output, err := c.runGsettingsCommand("get org.gnome.system.location enabled"); err == nil {
+		locationServices["gnomeLocation"] = output
+	}
+	rawResults["locationServices"] = locationServices
 }
 
 // getLinuxDeviceIdentifiers returns Linux device identifiers.
@@ -292,10 +500,8 @@ func (c *Client) getLinuxDeviceIdentifiers() (*AgentDeviceIdentifiers, error) {
 		}
 	}
 
-	if result, err := c.queryFirst("SELECT mac FROM interface_details WHERE interface in (SELECT DISTINCT interface FROM interface_addresses WHERE interface NOT IN ('lo')) LIMIT 1"); err == nil && result != nil {
-		if v, ok := result["mac"].(string); ok {
-			identifiers.MacAddress.Mac = v
-		}
+	if mac, err := c.getMacAddress(); err == nil {
+		identifiers.MacAddress.Mac = mac
 	}
 
 	return identifiers, nil
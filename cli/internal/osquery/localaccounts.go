@@ -0,0 +1,285 @@
+package osquery
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// linuxAdminGroups are the groups whose members can usually gain root
+// through sudo.
+var linuxAdminGroups = []string{"sudo", "wheel", "admin"}
+
+// sudoersFile and sudoersDir are read for principals granted sudo
+// directly, in addition to members of linuxAdminGroups.
+const (
+	sudoersFile = "/etc/sudoers"
+	sudoersDir  = "/etc/sudoers.d"
+)
+
+// windowsAdministratorsSID is the well-known SID of the local Administrators
+// group, which does not depend on the display language.
+const windowsAdministratorsSID = "S-1-5-32-544"
+
+// localAccountsPolicy is the result of the localAccountsPolicy check.
+type localAccountsPolicy struct {
+	// guestEnabled is nil when the guest account state could not be read.
+	guestEnabled *bool
+	admins       []string
+	// sudoers lists principals granted sudo in the sudoers files on Linux.
+	// It is nil when the files could not be read.
+	sudoers []string
+	// insufficientPrivileges is set when a source could not be read with
+	// the agent's privileges, so its part of the result is missing rather
+	// than empty.
+	insufficientPrivileges bool
+	notes                  []string
+}
+
+// collectLocalAccountsPolicy collects whether the built-in guest account is
+// enabled and who belongs to the local administrator groups. Results that
+// could not be read are null, with a note, rather than reported as false or
+// empty.
+func (c *Client) collectLocalAccountsPolicy(rawResults map[string]interface{}) {
+	var policy localAccountsPolicy
+	switch c.platform {
+	case PlatformLinux:
+		policy = c.linuxLocalAccountsPolicy()
+	case PlatformMacOS:
+		policy = c.macOSLocalAccountsPolicy()
+	case PlatformWindows:
+		policy = c.windowsLocalAccountsPolicy()
+	default:
+		return
+	}
+
+	result := map[string]interface{}{
+		"guestAccountEnabled":    nil,
+		"localAdmins":            policy.admins,
+		"insufficientPrivileges": policy.insufficientPrivileges,
+		"notes":                  policy.notes,
+	}
+	if policy.guestEnabled != nil {
+		result["guestAccountEnabled"] = *policy.guestEnabled
+	}
+	if c.platform == PlatformLinux {
+		result["sudoers"] = policy.sudoers
+	}
+	rawResults["localAccountsPolicy"] = result
+}
+
+// linuxLocalAccountsPolicy reads the members of the sudo, wheel, and admin
+// groups, the principals in the sudoers files, and whether a guest account
+// with a login shell exists. The sudoers files are only readable by root.
+func (c *Client) linuxLocalAccountsPolicy() localAccountsPolicy {
+	var policy localAccountsPolicy
+
+	// getent exits 2 when some of the groups do not exist, and 127 when it
+	// is not installed
+	if output, exitCode, err := c.RunCommandStatus("getent group " + strings.Join(linuxAdminGroups, " ")); err == nil && exitCode != 127 {
+		policy.admins = parseGroupMembers(output)
+	} else {
+		policy.notes = append(policy.notes, "getent is not available; admin group members unknown")
+	}
+
+	sudoers, err := readSudoersPrincipals()
+	switch {
+	case err == nil:
+		policy.sudoers = sudoers
+	case os.IsPermission(err):
+		policy.insufficientPrivileges = true
+		policy.notes = append(policy.notes, "sudoers files are only readable by root; run the agent as root to report them")
+	default:
+		policy.notes = append(policy.notes, "failed to read sudoers files: "+err.Error())
+	}
+
+	output, exitCode, err := c.RunCommandStatus("getent passwd guest")
+	switch {
+	case err != nil:
+	case exitCode == 2:
+		// getent exits 2 when the key is not found
+		policy.guestEnabled = boolPtr(false)
+	case exitCode == 0:
+		policy.guestEnabled = boolPtr(hasLoginShell(output))
+	}
+	return policy
+}
+
+// readSudoersPrincipals returns the principals granted sudo in /etc/sudoers
+// and the files under /etc/sudoers.d.
+func readSudoersPrincipals() ([]string, error) {
+	content, err := os.ReadFile(sudoersFile)
+	if err != nil {
+		return nil, err
+	}
+	contents := []string{string(content)}
+
+	entries, err := os.ReadDir(sudoersDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		// sudo skips files ending in ~ or containing a dot
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, "~") || strings.Contains(name, ".") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(sudoersDir, name))
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, string(content))
+	}
+	return parseSudoersPrincipals(strings.Join(contents, "\n")), nil
+}
+
+// parseSudoersPrincipals returns the users and %groups that sudoers rules
+// grant privileges to, sorted and without duplicates. Defaults, aliases,
+// and include directives are skipped.
+func parseSudoersPrincipals(content string) []string {
+	seen := make(map[string]bool)
+	principals := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "@") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "Defaults") || strings.HasSuffix(fields[0], "_Alias") {
+			continue
+		}
+		for _, principal := range strings.Split(fields[0], ",") {
+			if principal != "" && !seen[principal] {
+				seen[principal] = true
+				principals = append(principals, principal)
+			}
+		}
+	}
+	sort.Strings(principals)
+	return principals
+}
+
+// parseGroupMembers returns the members listed in `getent group` output,
+// sorted and without duplicates.
+func parseGroupMembers(output string) []string {
+	seen := make(map[string]bool)
+	members := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) < 4 {
+			continue
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member != "" && !seen[member] {
+				seen[member] = true
+				members = append(members, member)
+			}
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// hasLoginShell reports whether a passwd entry has a shell that allows
+// logging in.
+func hasLoginShell(entry string) bool {
+	fields := strings.Split(strings.TrimSpace(entry), ":")
+	if len(fields) < 7 {
+		return false
+	}
+	shell := filepath.Base(fields[6])
+	return shell != "" && shell != "nologin" && shell != "false"
+}
+
+// macOSLocalAccountsPolicy reads the admin group membership with dscl and
+// the Guest user setting from the login window preferences.
+func (c *Client) macOSLocalAccountsPolicy() localAccountsPolicy {
+	var policy localAccountsPolicy
+
+	if output, err := c.RunCommand("dscl . -read /Groups/admin GroupMembership"); err == nil {
+		policy.admins = parseDsclMembers(output)
+	} else {
+		policy.notes = append(policy.notes, "failed to read admin group membership with dscl")
+	}
+
+	// GuestEnabled is absent until the Guest user is first turned on
+	output, exitCode, err := c.RunCommandStatus("defaults read /Library/Preferences/com.apple.loginwindow GuestEnabled 2>/dev/null")
+	if err == nil {
+		policy.guestEnabled = boolPtr(exitCode == 0 && output == "1")
+	}
+	return policy
+}
+
+// parseDsclMembers returns the members in `dscl -read GroupMembership`
+// output, which lists them space separated after the attribute name.
+func parseDsclMembers(output string) []string {
+	_, list, _ := strings.Cut(output, "GroupMembership:")
+	members := strings.Fields(list)
+	sort.Strings(members)
+	return members
+}
+
+// windowsLocalAccountsPolicy reads the members of the Administrators group
+// and whether the built-in Guest account is active. The Guest account is
+// found by its relative ID, 501, since it may have been renamed.
+func (c *Client) windowsLocalAccountsPolicy() localAccountsPolicy {
+	var policy localAccountsPolicy
+
+	rows, err := c.RunQuery(`SELECT u.username FROM user_groups ug JOIN users u ON u.uid = ug.uid JOIN groups g ON g.gid = ug.gid WHERE g.group_sid = '` + windowsAdministratorsSID + `'`)
+	if err == nil {
+		policy.admins = []string{}
+		for _, row := range rows {
+			if name, ok := row["username"].(string); ok && name != "" {
+				policy.admins = append(policy.admins, name)
+			}
+		}
+		sort.Strings(policy.admins)
+	} else {
+		policy.notes = append(policy.notes, "failed to read Administrators group membership")
+	}
+
+	guest, err := c.queryFirst("SELECT username FROM users WHERE uid = 501 AND type = 'local'")
+	if err != nil {
+		return policy
+	}
+	if guest == nil {
+		policy.guestEnabled = boolPtr(false)
+		return policy
+	}
+	name, _ := guest["username"].(string)
+	output, exitCode, err := c.RunCommandStatus(`net user "` + name + `"`)
+	if err != nil {
+		return policy
+	}
+	if exitCode != 0 {
+		policy.insufficientPrivileges = true
+		policy.notes = append(policy.notes, "net user could not read the Guest account with the agent's privileges")
+		return policy
+	}
+	if active := parseNetUserActive(output); active != nil {
+		policy.guestEnabled = active
+	} else {
+		policy.notes = append(policy.notes, "could not read the Guest account state from net user output")
+	}
+	return policy
+}
+
+// parseNetUserActive reads the "Account active" line of `net user` output.
+// It returns nil when the line is missing, as when Windows is displaying
+// another language.
+func parseNetUserActive(output string) *bool {
+	for _, line := range strings.Split(output, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "Account active")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(value) {
+		case "Yes":
+			return boolPtr(true)
+		case "No":
+			return boolPtr(false)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package osquery
+
+import (
+	"os"
+	"strings"
+)
+
+// linuxLocaleFiles set the system locale on Red Hat, Arch, and SUSE style
+// systems and on Debian style systems respectively.
+var linuxLocaleFiles = []string{"/etc/locale.conf", "/etc/default/locale"}
+
+// localeInfo is the time zone and locale part of the result of the
+// localeInfo check.
+type localeInfo struct {
+	timezone string
+	locale   string
+}
+
+// collectLocaleInfo collects the system time zone and locale, and whether
+// the clock is kept accurate by a time sync service, which the
+// timeSyncStatus check reports in full. The time sync state is taken from
+// that check's result when it ran, so its commands, such as sntp's network
+// probe on macOS, run once per collection.
+func (c *Client) collectLocaleInfo(rawResults map[string]interface{}) {
+	var info localeInfo
+	switch c.platform {
+	case PlatformLinux:
+		info = c.linuxLocaleInfo()
+	case PlatformMacOS:
+		info = c.macOSLocaleInfo()
+	case PlatformWindows:
+		info = c.windowsLocaleInfo()
+	default:
+		return
+	}
+
+	sync, ok := rawResults["timeSyncStatus"].(map[string]interface{})
+	if !ok {
+		sync = timeSyncResult(c.timeSyncState())
+	}
+	rawResults["localeInfo"] = map[string]interface{}{
+		"timezone":               nullIfEmpty(info.timezone),
+		"locale":                 nullIfEmpty(info.locale),
+		"timeSyncEnabled":        sync["enabled"],
+		"timeSynchronized":       sync["synchronized"],
+		"timeSource":             sync["source"],
+		"insufficientPrivileges": sync["insufficientPrivileges"],
+		"notes":                  sync["notes"],
+	}
+}
+
+// linuxLocaleInfo reads the time zone from timedatectl, falling back to
+// /etc/timezone and /etc/localtime without systemd, and the locale from the
+// locale configuration files or localectl.
+func (c *Client) linuxLocaleInfo() localeInfo {
+	var info localeInfo
+
+	if output, err := c.withoutErrorLog().RunCommand(linuxTimedatectlCommand); err == nil {
+		info.timezone, _, _ = parseTimedatectl(output)
+	}
+	if info.timezone == "" {
+		info.timezone = linuxTimezone()
+	}
+
+	for _, path := range linuxLocaleFiles {
+		if content, err := os.ReadFile(path); err == nil {
+			if info.locale = parseLocaleConf(string(content)); info.locale != "" {
+				break
+			}
+		}
+	}
+	if info.locale == "" {
+		if output, err := c.withoutErrorLog().RunCommand("localectl status"); err == nil {
+			info.locale = parseLocaleConf(strings.ReplaceAll(output, "System Locale:", ""))
+		}
+	}
+	return info
+}
+
+// linuxTimezone returns the time zone named by /etc/timezone or by the
+// /etc/localtime link, or an empty string.
+func linuxTimezone() string {
+	if content, err := os.ReadFile("/etc/timezone"); err == nil {
+		if tz := strings.TrimSpace(string(content)); tz != "" {
+			return tz
+		}
+	}
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		return timezoneFromZoneinfoPath(target)
+	}
+	return ""
+}
+
+// parseTimedatectl reads the time zone and NTP state from `timedatectl
+// show` properties, or from the labels of `timedatectl status`, which
+// differ across systemd versions.
+func parseTimedatectl(output string) (timezone string, enabled, synchronized *bool) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.ContainsAny(key, " :") {
+			if key, value, ok = strings.Cut(line, ":"); !ok {
+				continue
+			}
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "Timezone", "Time zone":
+			// status appends the abbreviation and offset
+			if fields := strings.Fields(value); len(fields) > 0 {
+				timezone = fields[0]
+			}
+		case "NTP", "NTP enabled", "Network time on", "systemd-timesyncd.service active":
+			enabled = parseYesNo(value)
+		case "NTP service":
+			// n/a when no time sync service is installed
+			if value == "active" || value == "inactive" {
+				enabled = boolPtr(value == "active")
+			}
+		case "NTPSynchronized", "NTP synchronized", "System clock synchronized":
+			synchronized = parseYesNo(value)
+		}
+	}
+	return timezone, enabled, synchronized
+}
+
+// parseYesNo parses a yes or no value, or returns nil for anything else.
+func parseYesNo(value string) *bool {
+	switch strings.ToLower(value) {
+	case "yes":
+		return boolPtr(true)
+	case "no":
+		return boolPtr(false)
+	}
+	return nil
+}
+
+// parseLocaleConf returns the LANG setting of a locale configuration file,
+// without quotes, or an empty string.
+func parseLocaleConf(content string) string {
+	var locale string
+	for _, line := range strings.Split(content, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "LANG=")
+		if ok {
+			locale = strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return locale
+}
+
+// timezoneFromZoneinfoPath returns the time zone name in a path into a
+// zoneinfo database, such as /usr/share/zoneinfo/Europe/London, or an
+// empty string.
+func timezoneFromZoneinfoPath(path string) string {
+	if _, tz, ok := strings.Cut(path, "zoneinfo/"); ok {
+		return tz
+	}
+	return ""
+}
+
+// macOSLocaleInfo reads the time zone from the /etc/localtime link, or
+// from systemsetup as root, and the locale from the global AppleLocale
+// preference.
+func (c *Client) macOSLocaleInfo() localeInfo {
+	var info localeInfo
+
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		info.timezone = timezoneFromZoneinfoPath(target)
+	}
+	if info.timezone == "" && os.Geteuid() == 0 {
+		if output, err := c.RunCommand("systemsetup -gettimezone"); err == nil {
+			// "Time Zone: Europe/London"
+			_, tz, _ := strings.Cut(output, ":")
+			info.timezone = strings.TrimSpace(tz)
+		}
+	}
+	if output, err := c.withoutErrorLog().RunCommand("defaults read -g AppleLocale"); err == nil {
+		info.locale = output
+	}
+	return info
+}
+
+// windowsLocaleInfo reads the time zone from tzutil and the system locale
+// from PowerShell.
+func (c *Client) windowsLocaleInfo() localeInfo {
+	var info localeInfo
+
+	if output, err := c.RunCommand("tzutil /g"); err == nil {
+		info.timezone = output
+	}
+	if output, err := c.RunCommand(`powershell -NoProfile -Command "(Get-WinSystemLocale).Name"`); err == nil {
+		info.locale = output
+	}
+	return info
+}
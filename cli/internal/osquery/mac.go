@@ -0,0 +1,250 @@
+package osquery
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RandomizedMacHandling controls how MAC selection treats randomized,
+// locally administered MAC addresses, which Wi-Fi privacy features change
+// from network to network.
+type RandomizedMacHandling string
+
+const (
+	// RandomizedMacPreferStable uses a randomized MAC only when no
+	// interface has a stable one.
+	RandomizedMacPreferStable RandomizedMacHandling = "prefer_stable"
+	// RandomizedMacExclude never uses a randomized MAC, leaving the
+	// hardware serial to identify the device when no MAC is stable.
+	RandomizedMacExclude RandomizedMacHandling = "exclude"
+	// RandomizedMacAllow treats randomized MACs like any other.
+	RandomizedMacAllow RandomizedMacHandling = "allow"
+)
+
+// MacSelection controls which network interface supplies the MAC address
+// used as a device identifier.
+type MacSelection struct {
+	// Allowlist restricts candidates to interfaces whose name starts with one
+	// of these prefixes. Earlier entries take priority. When empty, the
+	// platform default is used (en0/en1 on macOS, any interface elsewhere).
+	Allowlist []string
+	// Denylist excludes interfaces whose name starts with one of these
+	// prefixes, in addition to the platform default. Loopback interfaces
+	// are always excluded.
+	Denylist []string
+	// IncludeInactive also considers interfaces with no assigned address.
+	IncludeInactive bool
+	// Randomized controls how randomized MACs are treated; empty allows
+	// them.
+	Randomized RandomizedMacHandling
+	// Registered is the MAC the device is registered with. It is kept
+	// while an interface the lists allow still has it, whatever the other
+	// rules would choose, so that a change to them does not change the
+	// identity of a registered device.
+	Registered string
+	// Legacy selects as agents did before randomized MACs were handled,
+	// allowing them and without the default denylist, for a device
+	// registered before its MAC was recorded.
+	Legacy bool
+}
+
+// macCandidate is a network interface that may supply the device MAC.
+type macCandidate struct {
+	name         string
+	friendlyName string
+	mac          string
+}
+
+// defaultMacAllowlist returns the interface allowlist used when none is configured.
+func defaultMacAllowlist(platform Platform) []string {
+	if platform == PlatformMacOS {
+		return []string{"en0", "en1"}
+	}
+	return nil
+}
+
+// defaultMacDenylist returns the interface prefixes always excluded, in
+// addition to any configured denylist: on Linux, the bridges and virtual
+// Ethernet pairs of Docker and libvirt, whose MACs are generated per host
+// or per container.
+func defaultMacDenylist(platform Platform) []string {
+	if platform == PlatformLinux {
+		return []string{"docker", "br-", "virbr", "veth"}
+	}
+	return nil
+}
+
+// SetMacSelection sets the interface selection used for the MAC address.
+func (c *Client) SetMacSelection(selection MacSelection) {
+	c.macSelection = selection
+}
+
+// MacSelection returns the interface selection used for the MAC address.
+func (c *Client) MacSelection() MacSelection {
+	return c.macSelection
+}
+
+// getMacAddress returns the MAC address of the preferred interface, or an
+// empty string if no interface qualifies.
+func (c *Client) getMacAddress() (string, error) {
+	query := "SELECT interface, mac FROM interface_details"
+	if c.platform == PlatformWindows {
+		query = "SELECT interface, friendly_name, mac FROM interface_details WHERE physical_adapter=1"
+	}
+	if !c.macSelection.IncludeInactive {
+		joiner := " WHERE "
+		if strings.Contains(query, " WHERE ") {
+			joiner = " AND "
+		}
+		query += joiner + "interface IN (SELECT DISTINCT interface FROM interface_addresses)"
+	}
+
+	rows, err := c.RunQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("failed to query interfaces: %w", err)
+	}
+
+	candidates := make([]macCandidate, 0, len(rows))
+	for _, row := range rows {
+		candidate := macCandidate{}
+		candidate.name, _ = row["interface"].(string)
+		candidate.friendlyName, _ = row["friendly_name"].(string)
+		candidate.mac, _ = row["mac"].(string)
+		candidates = append(candidates, candidate)
+	}
+
+	allowlist := c.macSelection.Allowlist
+	if len(allowlist) == 0 {
+		allowlist = defaultMacAllowlist(c.platform)
+	}
+
+	denylist := c.macSelection.Denylist
+	if registered := c.macSelection.Registered; registered != "" {
+		var kept []macCandidate
+		for _, candidate := range candidates {
+			if strings.EqualFold(candidate.mac, registered) {
+				kept = append(kept, candidate)
+			}
+		}
+		if mac := selectMacAddress(kept, allowlist, denylist, RandomizedMacAllow); mac != "" {
+			return mac, nil
+		}
+	}
+
+	handling := c.macSelection.Randomized
+	if c.macSelection.Legacy {
+		handling = RandomizedMacAllow
+	} else {
+		denylist = append(defaultMacDenylist(c.platform), denylist...)
+	}
+	mac := selectMacAddress(candidates, allowlist, denylist, handling)
+	if registered := c.macSelection.Registered; registered != "" {
+		reported := mac
+		if reported == "" {
+			reported = "none"
+		}
+		log.Printf("Warning: no interface the MAC settings allow has the registered MAC address %s; reporting %s instead. Run 'drata-agent refresh-identifiers' to register it", registered, reported)
+	}
+	if randomized := selectMacAddress(candidates, allowlist, denylist, RandomizedMacAllow); randomized != mac {
+		if mac == "" {
+			c.logVerbose("Not reporting randomized MAC address %s, as no interface has a stable one; the device is identified by its serial (mac_randomization %s)", randomized, handling)
+		} else {
+			c.logVerbose("Using MAC address %s instead of randomized %s (mac_randomization %s)", mac, randomized, handling)
+		}
+	} else if isRandomizedMac(mac) {
+		c.logVerbose("MAC address %s is randomized and may change between networks; set mac_randomization to exclude to identify the device by its serial", mac)
+	}
+	return mac, nil
+}
+
+// collectMacAddress collects the MAC address used as a device identifier.
+func (c *Client) collectMacAddress(rawResults map[string]interface{}) {
+	if mac, err := c.getMacAddress(); err == nil && mac != "" {
+		rawResults["macAddress"] = map[string]interface{}{"mac": mac}
+	}
+}
+
+// selectMacAddress deterministically picks a MAC from the candidates.
+// Candidates are filtered by the allow and deny lists, and randomized MACs
+// as randomized says, then ordered by allowlist position and interface
+// name, after any stable MACs when randomized prefers them.
+func selectMacAddress(candidates []macCandidate, allowlist, denylist []string, randomized RandomizedMacHandling) string {
+	type ranked struct {
+		macCandidate
+		rank       int
+		randomized bool
+	}
+
+	var eligible []ranked
+	for _, candidate := range candidates {
+		if candidate.mac == "" || candidate.mac == "00:00:00:00:00:00" {
+			continue
+		}
+		if candidate.name == "lo" || strings.HasPrefix(candidate.name, "lo0") {
+			continue
+		}
+		if matchInterfacePrefix(candidate, denylist) >= 0 {
+			continue
+		}
+		isRandomized := randomized != RandomizedMacAllow && randomized != "" && isRandomizedMac(candidate.mac)
+		if isRandomized && randomized == RandomizedMacExclude {
+			continue
+		}
+
+		rank := 0
+		if len(allowlist) > 0 {
+			rank = matchInterfacePrefix(candidate, allowlist)
+			if rank < 0 {
+				continue
+			}
+		}
+		eligible = append(eligible, ranked{candidate, rank, isRandomized})
+	}
+
+	if len(eligible) == 0 {
+		return ""
+	}
+
+	sort.Slice(eligible, func(i, j int) bool {
+		if eligible[i].randomized != eligible[j].randomized {
+			return !eligible[i].randomized
+		}
+		if eligible[i].rank != eligible[j].rank {
+			return eligible[i].rank < eligible[j].rank
+		}
+		return eligible[i].name < eligible[j].name
+	})
+
+	return eligible[0].mac
+}
+
+// matchInterfacePrefix returns the index of the first prefix matching the
+// interface name (or Windows friendly name), or -1 if none match.
+func matchInterfacePrefix(candidate macCandidate, prefixes []string) int {
+	name := strings.ToLower(candidate.name)
+	friendlyName := strings.ToLower(candidate.friendlyName)
+	for i, prefix := range prefixes {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if prefix == "" {
+			continue
+		}
+		if strings.HasPrefix(name, prefix) || (friendlyName != "" && strings.HasPrefix(friendlyName, prefix)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isRandomizedMac reports whether mac is locally administered, as the
+// randomized addresses of Wi-Fi privacy features are: the second-lowest
+// bit of its first octet is set.
+func isRandomizedMac(mac string) bool {
+	if len(mac) < 2 {
+		return false
+	}
+	octet, err := strconv.ParseUint(mac[:2], 16, 8)
+	return err == nil && octet&0x02 != 0
+}
@@ -0,0 +1,120 @@
+package osquery
+
+import "testing"
+
+func TestSelectMacAddress(t *testing.T) {
+	candidates := []macCandidate{
+		{name: "lo", mac: "00:00:00:00:00:00"},
+		{name: "wlan0", mac: "aa:aa:aa:aa:aa:aa"},
+		{name: "eth0", mac: "bb:bb:bb:bb:bb:bb"},
+		{name: "docker0", mac: "cc:cc:cc:cc:cc:cc"},
+		{name: "en5", mac: "dd:dd:dd:dd:dd:dd"},
+		{name: "12", friendlyName: "Ethernet 2", mac: "ee:ee:ee:ee:ee:ee"},
+	}
+
+	tests := []struct {
+		name      string
+		allowlist []string
+		denylist  []string
+		expected  string
+	}{
+		{"sorted by name", nil, nil, "ee:ee:ee:ee:ee:ee"},
+		{"denylist", nil, []string{"1", "docker", "en"}, "bb:bb:bb:bb:bb:bb"},
+		{"allowlist priority", []string{"wlan", "eth"}, nil, "aa:aa:aa:aa:aa:aa"},
+		{"allowlist skips missing", []string{"en0", "en1", "en5"}, nil, "dd:dd:dd:dd:dd:dd"},
+		{"friendly name", []string{"ethernet"}, nil, "ee:ee:ee:ee:ee:ee"},
+		{"allow and deny", []string{"e"}, []string{"eth"}, "dd:dd:dd:dd:dd:dd"},
+		{"no match", []string{"bond"}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectMacAddress(candidates, tt.allowlist, tt.denylist, RandomizedMacAllow); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSelectMacAddressRandomized(t *testing.T) {
+	candidates := []macCandidate{
+		{name: "en0", mac: "5a:11:22:33:44:55"},
+		{name: "en1", mac: "3c:22:fb:00:11:22"},
+	}
+	randomizedOnly := []macCandidate{{name: "en0", mac: "5a:11:22:33:44:55"}}
+
+	tests := []struct {
+		name       string
+		candidates []macCandidate
+		allowlist  []string
+		randomized RandomizedMacHandling
+		expected   string
+	}{
+		{"allow keeps allowlist order", candidates, []string{"en0", "en1"}, RandomizedMacAllow, "5a:11:22:33:44:55"},
+		{"prefer stable over allowlist order", candidates, []string{"en0", "en1"}, RandomizedMacPreferStable, "3c:22:fb:00:11:22"},
+		{"prefer stable falls back", randomizedOnly, nil, RandomizedMacPreferStable, "5a:11:22:33:44:55"},
+		{"exclude", candidates, []string{"en0", "en1"}, RandomizedMacExclude, "3c:22:fb:00:11:22"},
+		{"exclude leaves none", randomizedOnly, nil, RandomizedMacExclude, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectMacAddress(tt.candidates, tt.allowlist, nil, tt.randomized); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIsRandomizedMac(t *testing.T) {
+	tests := map[string]bool{
+		"3c:22:fb:00:11:22": false,
+		"5a:11:22:33:44:55": true,
+		"02:42:ac:11:00:02": true,
+		"00-1A-2B-3C-4D-5E": false,
+		"":                  false,
+	}
+	for mac, expected := range tests {
+		if got := isRandomizedMac(mac); got != expected {
+			t.Errorf("isRandomizedMac(%q) = %v, expected %v", mac, got, expected)
+		}
+	}
+}
+
+func TestGetMacAddressRegistered(t *testing.T) {
+	runner := &fixtureRunner{fixture: collectorFixture{Queries: map[string]fixtureQuery{
+		"SELECT interface, mac FROM interface_details WHERE interface IN (SELECT DISTINCT interface FROM interface_addresses)": {Rows: []map[string]interface{}{
+			{"interface": "en0", "mac": "5a:11:22:33:44:55"},
+			{"interface": "en1", "mac": "3c:22:fb:00:11:22"},
+			{"interface": "br-1a2b3c", "mac": "02:42:0a:00:00:01"},
+			{"interface": "docker0", "mac": "12:42:0a:00:00:01"},
+		}},
+	}}}
+
+	tests := []struct {
+		name      string
+		selection MacSelection
+		expected  string
+	}{
+		{"new device prefers stable", MacSelection{Randomized: RandomizedMacPreferStable}, "3c:22:fb:00:11:22"},
+		{"registered MAC kept", MacSelection{Randomized: RandomizedMacPreferStable, Registered: "5A:11:22:33:44:55"}, "5a:11:22:33:44:55"},
+		{"registered MAC denied", MacSelection{Randomized: RandomizedMacPreferStable, Registered: "5a:11:22:33:44:55", Denylist: []string{"en0"}}, "3c:22:fb:00:11:22"},
+		{"registered MAC gone", MacSelection{Randomized: RandomizedMacExclude, Registered: "66:77:88:99:aa:bb"}, "3c:22:fb:00:11:22"},
+		{"registered bridge kept", MacSelection{Randomized: RandomizedMacPreferStable, Registered: "02:42:0a:00:00:01"}, "02:42:0a:00:00:01"},
+		{"default denylist", MacSelection{Randomized: RandomizedMacAllow}, "5a:11:22:33:44:55"},
+		{"legacy device allows randomized and bridges", MacSelection{Randomized: RandomizedMacPreferStable, Legacy: true}, "02:42:0a:00:00:01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{platform: PlatformLinux, runner: runner, macSelection: tt.selection}
+			got, err := c.getMacAddress()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -2,34 +2,22 @@ package osquery
 
 import "strings"
 
-// getMacOSSystemInfo collects macOS-specific system information.
-func (c *Client) getMacOSSystemInfo(version string) (*QueryResult, error) {
-	rawResults := make(map[string]interface{})
-
-	// OS Version
-	if result, err := c.queryFirst("SELECT name, version, platform FROM os_version"); err == nil && result != nil {
-		rawResults["osVersion"] = result
-	}
-
-	// Hardware Serial
-	if result, err := c.queryFirst("SELECT hardware_serial FROM system_info"); err == nil && result != nil {
-		rawResults["hwSerial"] = result
-	}
-
-	// Hardware Model
-	if result, err := c.queryFirst("SELECT hardware_model FROM system_info"); err == nil && result != nil {
-		rawResults["hwModel"] = result
-	}
-
-	// System Information
-	if result, err := c.queryFirst("SELECT board_serial, board_model, computer_name, hostname, local_hostname FROM system_info"); err == nil && result != nil {
-		rawResults["boardSerial"] = result["board_serial"]
-		rawResults["boardModel"] = result["board_model"]
-		rawResults["computerName"] = result["computer_name"]
-		rawResults["hostName"] = result["hostname"]
-		rawResults["localHostName"] = result["local_hostname"]
+// macOSChecks returns the macOS-specific checks in collection order.
+func macOSChecks() []check {
+	return []check{
+		{name: "diskEncryption", collect: (*Client).collectMacOSDiskEncryption},
+		{name: "firewall", collect: (*Client).collectMacOSFirewall},
+		{name: "appList", collect: (*Client).collectMacOSAppList},
+		{name: "browserExtensions", collect: (*Client).collectMacOSBrowserExtensions},
+		{name: "macAddress", collect: (*Client).collectMacAddress},
+		{name: "autoUpdate", collect: (*Client).collectMacOSAutoUpdate},
+		{name: "gatekeeper", collect: (*Client).collectMacOSGatekeeper},
+		{name: "screenLock", collect: (*Client).collectMacOSScreenLock},
 	}
+}
 
+// collectMacOSDiskEncryption collects root volume encryption and FileVault status.
+func (c *Client) collectMacOSDiskEncryption(rawResults map[string]interface{}) {
 	// HDD Encryption Status
 	if result, err := c.queryFirst("SELECT de.encrypted FROM mounts m JOIN disk_encryption de on de.name=m.device WHERE m.path ='/'"); err == nil && result != nil {
 		rawResults["hddEncryptionStatus"] = result
@@ -41,41 +29,42 @@ func (c *Client) getMacOSSystemInfo(version string) (*QueryResult, error) {
 			"commandResults": output,
 		}
 	}
+}
 
-	// Firewall Status
+// collectMacOSFirewall collects the application firewall state.
+func (c *Client) collectMacOSFirewall(rawResults map[string]interface{}) {
 	if result, err := c.queryFirst("SELECT global_state FROM alf"); err == nil && result != nil {
 		rawResults["firewallStatus"] = result
 	}
+}
 
-	// Application List
+// collectMacOSAppList collects installed applications.
+func (c *Client) collectMacOSAppList(rawResults map[string]interface{}) {
 	if result, err := c.RunQuery("SELECT name, bundle_short_version, info_string FROM apps"); err == nil {
 		rawResults["appList"] = result
 	}
+}
 
-	// Browser Extensions
-	extensions, _ := c.queryAll([]string{
-		"SELECT name FROM firefox_addons",
-		"SELECT name FROM chrome_extensions",
-		"SELECT name FROM safari_extensions",
-	})
-	rawResults["browserExtensions"] = extensions
-
-	// MAC Address
-	if result, err := c.queryFirst("SELECT mac FROM interface_details WHERE interface in (SELECT DISTINCT interface FROM interface_addresses WHERE interface IN ('en0', 'en1')) LIMIT 1"); err == nil && result != nil {
-		rawResults["macAddress"] = result
-	}
+// collectMacOSBrowserExtensions collects Firefox, Chrome, and Safari extensions.
+func (c *Client) collectMacOSBrowserExtensions(rawResults map[string]interface{}) {
+	rawResults["browserExtensions"] = c.browserExtensions([]extensionSource{firefoxExtensions, chromeExtensions, safariExtensions})
+}
 
-	// Auto Update
+// collectMacOSAutoUpdate collects whether automatic update checks are on.
+func (c *Client) collectMacOSAutoUpdate(rawResults map[string]interface{}) {
 	if output, err := c.RunCommand("softwareupdate --schedule"); err == nil {
 		value := "0"
-		if strings.Contains(strings.ToLower(output), "turned on") {
+		if isSoftwareUpdateScheduleOn(output) {
 			value = "1"
 		}
 		rawResults["autoUpdateEnabled"] = map[string]interface{}{
 			"value": value,
 		}
 	}
+}
 
+// collectMacOSGatekeeper collects Gatekeeper and XProtect settings.
+func (c *Client) collectMacOSGatekeeper(rawResults map[string]interface{}) {
 	// Gatekeeper
 	if result, err := c.queryFirst("SELECT assessments_enabled FROM gatekeeper"); err == nil && result != nil {
 		rawResults["gateKeeperEnabled"] = result
@@ -90,7 +79,10 @@ func (c *Client) getMacOSSystemInfo(version string) (*QueryResult, error) {
 		protectionSettings["xprotect"] = output
 	}
 	rawResults["protectionSettings"] = protectionSettings
+}
 
+// collectMacOSScreenLock collects screensaver and screen lock status and settings.
+func (c *Client) collectMacOSScreenLock(rawResults map[string]interface{}) {
 	// Screen Lock Status
 	screenLockStatus := make([]interface{}, 0)
 	if result, err := c.RunQuery("SELECT value FROM preferences WHERE domain='com.apple.screensaver' AND key='idleTime' UNION ALL SELECT value FROM managed_policies WHERE domain='com.apple.screensaver' AND name='idleTime'"); err == nil {
@@ -109,17 +101,46 @@ func (c *Client) getMacOSSystemInfo(version string) (*QueryResult, error) {
 	if output, err := c.RunCommand("pmset -g custom"); err == nil {
 		screenLockSettings["powerSettings"] = output
 	}
-	if result, err := c.queryFirst("SELECT enabled, grace_period FROM screenlock"); err == nil && result != nil {
-		screenLockSettings["lockDelay"] = result["grace_period"]
-		screenLockSettings["screenLockEnabled"] = result["enabled"] == "1"
+	screenlock, err := c.queryFirst("SELECT enabled, grace_period FROM screenlock")
+	if err == nil && screenlock != nil {
+		screenLockSettings["lockDelay"] = screenlock["grace_period"]
+		screenLockSettings["screenLockEnabled"] = screenlock["enabled"] == "1"
+	}
+
+	// Password on wake from sleep or the screensaver, which the screenlock
+	// table reads for the console user; the askForPassword preferences are
+	// the fallback, with a managed policy taking precedence
+	var askForPassword map[string]string
+	if rows, err := c.RunQuery(macOSAskForPasswordQuery); err == nil {
+		askForPassword = pivotResults(rows)
+	}
+	for _, key := range []string{"askForPassword", "askForPasswordDelay"} {
+		if value, ok := askForPassword[key]; ok {
+			screenLockSettings[key] = value
+		}
+	}
+	if screenlock != nil {
+		screenLockSettings["requirePasswordOnWake"] = screenlock["enabled"] == "1"
+	} else if value, ok := askForPassword["askForPassword"]; ok {
+		screenLockSettings["requirePasswordOnWake"] = value == "1" || value == "true"
 	}
 	rawResults["screenLockSettings"] = screenLockSettings
+}
 
-	return &QueryResult{
-		DrataAgentVersion: version,
-		Platform:          PlatformMacOS,
-		RawQueryResults:   rawResults,
-	}, nil
+// macOSAskForPasswordQuery reads the askForPassword settings, from a
+// managed policy where there is one and the current user's preferences
+// otherwise, as name and data rows.
+const macOSAskForPasswordQuery = `SELECT name, value AS data FROM managed_policies WHERE domain = 'com.apple.screensaver' AND name IN ('askForPassword', 'askForPasswordDelay')
+	UNION ALL SELECT key AS name, value AS data FROM preferences WHERE domain = 'com.apple.screensaver' AND key IN ('askForPassword', 'askForPasswordDelay') AND host = 'current'
+	AND key NOT IN (SELECT name FROM managed_policies WHERE domain = 'com.apple.screensaver')`
+
+// isSoftwareUpdateScheduleOn reports whether `softwareupdate --schedule`
+// output says automatic checking is enabled. Commands run in the C locale,
+// so the output is English: "Automatic checking for updates is turned on"
+// on older releases and "Automatic check is on" on newer ones.
+func isSoftwareUpdateScheduleOn(output string) bool {
+	output = strings.ToLower(output)
+	return strings.Contains(output, "turned on") || strings.Contains(output, " is on")
 }
 
 // getMacOSDeviceIdentifiers returns macOS device identifiers.
@@ -135,10 +156,8 @@ func (c *Client) getMacOSDeviceIdentifiers() (*AgentDeviceIdentifiers, error) {
 		}
 	}
 
-	if result, err := c.queryFirst("SELECT mac FROM interface_details WHERE interface in (SELECT DISTINCT interface FROM interface_addresses WHERE interface IN ('en0', 'en1')) LIMIT 1"); err == nil && result != nil {
-		if v, ok := result["mac"].(string); ok {
-			identifiers.MacAddress.Mac = v
-		}
+	if mac, err := c.getMacAddress(); err == nil {
+		identifiers.MacAddress.Mac = mac
 	}
 
 	return identifiers, nil
@@ -0,0 +1,119 @@
+package osquery
+
+import (
+	"sort"
+	"strings"
+)
+
+// intuneProviderID is the enrollment ProviderID used by Microsoft Intune.
+const intuneProviderID = "MS DM Server"
+
+// enrollmentsQuery reads the MDM enrollment entries Windows keeps under
+// HKLM\SOFTWARE\Microsoft\Enrollments, one subkey per enrollment.
+const enrollmentsQuery = `SELECT key, name, data FROM registry WHERE key LIKE 'HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Enrollments\%' AND name IN ('ProviderID', 'UPN', 'DiscoveryServiceFullURL', 'EnrollmentState')`
+
+// collectWindowsDeviceManagement collects whether the device is joined to
+// Azure AD or a domain and whether it is enrolled in MDM such as Intune.
+func (c *Client) collectWindowsDeviceManagement(rawResults map[string]interface{}) {
+	status := map[string]string{}
+	if output, err := c.RunCommand("dsregcmd /status"); err == nil {
+		status = parseDsregcmdStatus(output)
+	}
+
+	rawResults["domainJoinStatus"] = map[string]interface{}{
+		"azureAdJoined":    dsregBool(status, "AzureAdJoined"),
+		"domainJoined":     dsregBool(status, "DomainJoined"),
+		"workplaceJoined":  dsregBool(status, "WorkplaceJoined"),
+		"enterpriseJoined": dsregBool(status, "EnterpriseJoined"),
+		"domainName":       status["DomainName"],
+		"tenantName":       status["TenantName"],
+		"tenantId":         status["TenantId"],
+	}
+
+	var enrollments []map[string]interface{}
+	if rows, err := c.RunQuery(enrollmentsQuery); err == nil {
+		enrollments = windowsEnrollments(rows)
+	}
+	intune := false
+	for _, enrollment := range enrollments {
+		if enrollment["providerId"] == intuneProviderID {
+			intune = true
+		}
+	}
+	mdmURL := status["MdmUrl"]
+
+	rawResults["mdmStatus"] = map[string]interface{}{
+		"enrolled":    len(enrollments) > 0 || mdmURL != "",
+		"intune":      intune,
+		"mdmUrl":      mdmURL,
+		"enrollments": enrollments,
+	}
+}
+
+// parseDsregcmdStatus parses the "Name : Value" lines of `dsregcmd
+// /status` output. Section banners and blank lines are ignored; values may
+// themselves contain colons, such as URLs.
+func parseDsregcmdStatus(output string) map[string]string {
+	status := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(line, " : ")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, "|+") {
+			continue
+		}
+		status[name] = strings.TrimSpace(value)
+	}
+	return status
+}
+
+// dsregBool returns the YES/NO value of name as a bool, or nil if it is
+// missing or not a YES/NO value.
+func dsregBool(status map[string]string, name string) interface{} {
+	switch strings.ToUpper(status[name]) {
+	case "YES":
+		return true
+	case "NO":
+		return false
+	default:
+		return nil
+	}
+}
+
+// windowsEnrollments groups registry rows by enrollment subkey and returns
+// the enrollments that name an MDM provider, sorted by provider and UPN.
+func windowsEnrollments(rows []map[string]interface{}) []map[string]interface{} {
+	byKey := make(map[string]map[string]string)
+	for _, row := range rows {
+		key, _ := row["key"].(string)
+		name, _ := row["name"].(string)
+		data, _ := row["data"].(string)
+		if byKey[key] == nil {
+			byKey[key] = make(map[string]string)
+		}
+		byKey[key][name] = data
+	}
+
+	enrollments := make([]map[string]interface{}, 0)
+	for _, values := range byKey {
+		if values["ProviderID"] == "" {
+			continue
+		}
+		enrollments = append(enrollments, map[string]interface{}{
+			"providerId":   values["ProviderID"],
+			"upn":          values["UPN"],
+			"discoveryUrl": values["DiscoveryServiceFullURL"],
+			"state":        values["EnrollmentState"],
+		})
+	}
+	sort.Slice(enrollments, func(i, j int) bool {
+		a, b := enrollments[i], enrollments[j]
+		if a["providerId"] != b["providerId"] {
+			return a["providerId"].(string) < b["providerId"].(string)
+		}
+		return a["upn"].(string) < b["upn"].(string)
+	})
+	return enrollments
+}
@@ -2,13 +2,24 @@
 package osquery
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/drata/drata-agent-cli/internal/telemetry"
 )
 
 // Platform represents the operating system platform.
@@ -20,12 +31,148 @@ const (
 	PlatformLinux   Platform = "LINUX"
 )
 
+// SchemaVersion is the version of the payload format, sent as the
+// schemaVersion of each QueryResult so the server can tell how to parse it.
+// Bump it whenever a result's shape changes, such as a field being renamed,
+// removed, or changing type. Adding a check or a field does not need a bump.
+const SchemaVersion = 1
+
 // QueryResult represents the result of a system query.
 type QueryResult struct {
-	DrataAgentVersion string                 `json:"drataAgentVersion"`
-	Platform          Platform               `json:"platform"`
-	ManualRun         bool                   `json:"manualRun,omitempty"`
-	RawQueryResults   map[string]interface{} `json:"rawQueryResults"`
+	// SchemaVersion is the payload format, or 0 for a payload collected by
+	// an agent from before schema versions.
+	SchemaVersion     int      `json:"schemaVersion,omitempty"`
+	DrataAgentVersion string   `json:"drataAgentVersion"`
+	Platform          Platform `json:"platform"`
+	// ManualRun is set for syncs run from the command line, forced or not,
+	// and unset for the daemon's scheduled syncs.
+	ManualRun       bool                   `json:"manualRun,omitempty"`
+	RawQueryResults map[string]interface{} `json:"rawQueryResults"`
+	DeviceName      string                 `json:"deviceName,omitempty"`
+	AssetTag        string                 `json:"assetTag,omitempty"`
+	// Partial is set when the collection budget ran out before every check
+	// ran; SkippedChecks lists the checks that did not run.
+	Partial       bool     `json:"partial,omitempty"`
+	SkippedChecks []string `json:"skippedChecks,omitempty"`
+	// CheckErrors summarizes, for each check in which a query or command
+	// failed, the first failure, so a failed check can be told apart from
+	// one that does not apply or found nothing.
+	CheckErrors map[string]string `json:"checkErrors,omitempty"`
+	// EmptyChecks lists the checks that ran but produced no data. It is
+	// not uploaded.
+	EmptyChecks []string `json:"-"`
+	// UnavailableChecks gives the reason for each check that reported a
+	// result as not applicable, indeterminate, or short of privileges. It
+	// is not uploaded.
+	UnavailableChecks map[string]string `json:"-"`
+	// CollectionMs is how long collection took, CollectionBudgetMs the
+	// collection budget it ran under, and CheckDurationsMs how long each
+	// check that ran took, all in milliseconds.
+	CollectionMs       int64            `json:"collectionMs,omitempty"`
+	CollectionBudgetMs int64            `json:"collectionBudgetMs,omitempty"`
+	CheckDurationsMs   map[string]int64 `json:"checkDurationsMs,omitempty"`
+}
+
+// ParseQueryResult decodes a previously collected QueryResult, such as one
+// saved for upload from another host. Unknown fields are rejected, and the
+// platform and collected results must be present.
+func ParseQueryResult(data []byte) (*QueryResult, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var result QueryResult
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("not a collected payload: %w", err)
+	}
+	switch result.Platform {
+	case PlatformMacOS, PlatformWindows, PlatformLinux:
+	default:
+		return nil, fmt.Errorf("unsupported platform: %q", result.Platform)
+	}
+	if result.DrataAgentVersion == "" {
+		return nil, fmt.Errorf("drataAgentVersion is missing")
+	}
+	if len(result.RawQueryResults) == 0 {
+		return nil, fmt.Errorf("rawQueryResults is missing or empty")
+	}
+	return &result, nil
+}
+
+// volatileResultFields lists, per result, the fields that change without
+// the device's posture changing, such as login timestamps, the clock
+// offset, and when an update timer last fired. A field is a dotted path
+// into the result, applied to each entry of any list on the way.
+// ContentHash leaves them out.
+var volatileResultFields = map[string][]string{
+	"sessionInfo":        {"lastLogins"},
+	"timeSyncStatus":     {"offsetSeconds"},
+	"autoUpdateSettings": {"dnfAutomatic.lastRun", "unattendedUpgrades.lastRun"},
+}
+
+// volatileResults lists results that differ on every collection, such as
+// the running processes, and are left out of ContentHash entirely.
+var volatileResults = map[string]bool{
+	"processSnapshot": true,
+}
+
+// ContentHash returns a hash of what the result reports about the device:
+// the collected results, without volatile fields, and the schema and agent
+// versions, platform, device name, asset tag, and incomplete or failed
+// checks. It is equal for two collections that would tell Drata the same
+// thing.
+func (r *QueryResult) ContentHash() (string, error) {
+	// Round-trip through JSON, so typed results can be walked generically
+	// and the result itself is not modified
+	encoded, err := json.Marshal(r.RawQueryResults)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode payload: %w", err)
+	}
+	var rawQueryResults map[string]interface{}
+	if err := json.Unmarshal(encoded, &rawQueryResults); err != nil {
+		return "", fmt.Errorf("failed to encode payload: %w", err)
+	}
+	for name := range volatileResults {
+		delete(rawQueryResults, name)
+	}
+	for name, fields := range volatileResultFields {
+		for _, field := range fields {
+			removeResultField(rawQueryResults[name], strings.Split(field, "."))
+		}
+	}
+
+	// Maps are encoded with sorted keys, so equal content encodes equally
+	data, err := json.Marshal(struct {
+		SchemaVersion     int                    `json:"schemaVersion"`
+		DrataAgentVersion string                 `json:"drataAgentVersion"`
+		Platform          Platform               `json:"platform"`
+		DeviceName        string                 `json:"deviceName"`
+		AssetTag          string                 `json:"assetTag"`
+		SkippedChecks     []string               `json:"skippedChecks"`
+		CheckErrors       map[string]string      `json:"checkErrors"`
+		RawQueryResults   map[string]interface{} `json:"rawQueryResults"`
+	}{r.SchemaVersion, r.DrataAgentVersion, r.Platform, r.DeviceName, r.AssetTag, r.SkippedChecks, r.CheckErrors, rawQueryResults})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode payload: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// removeResultField deletes the field at path from a decoded JSON value,
+// from each entry of any list on the way.
+func removeResultField(value interface{}, path []string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(v, path[0])
+			return
+		}
+		removeResultField(v[path[0]], path[1:])
+	case []interface{}:
+		for _, entry := range v {
+			removeResultField(entry, path)
+		}
+	}
 }
 
 // AgentDeviceIdentifiers represents the device identifiers used for registration.
@@ -37,13 +184,112 @@ type AgentDeviceIdentifiers struct {
 	MacAddress struct {
 		Mac string `json:"mac,omitempty"`
 	} `json:"macAddress"`
+	DeviceName string `json:"deviceName,omitempty"`
+	AssetTag   string `json:"assetTag,omitempty"`
+}
+
+// DeviceLabels are names assigned to the device by its owner, sent with
+// registration and every sync so agent records can be matched to an asset
+// inventory when hardware identifiers are blank or duplicated.
+type DeviceLabels struct {
+	Name     string
+	AssetTag string
 }
 
 // Client provides osquery functionality.
 type Client struct {
-	binaryPath string
-	platform   Platform
-	verbose    bool
+	binaryPath         string
+	binaryVersion      string
+	platform           Platform
+	verbose            bool
+	macSelection       MacSelection
+	deviceLabels       DeviceLabels
+	appPolicy          AppPolicy
+	remoteAccessPolicy RemoteAccessPolicy
+	processSnapshot    ProcessSnapshot
+	checkFilter        CheckFilter
+	ctx                context.Context
+
+	// errorLog records failures against the check being collected.
+	errorLog *checkErrorLog
+
+	// maxFieldBytes caps the size of string values in collected results.
+	maxFieldBytes int
+
+	// maxOutputBytes caps the output read from each query or command.
+	maxOutputBytes int
+
+	markWSLNotApplicable     bool
+	markVirtualNotApplicable bool
+
+	// flags and flagfile are extra osqueryi arguments from configuration.
+	flags    []string
+	flagfile string
+
+	onlineEOLLookup bool
+
+	// collectionBudget bounds how long GetSystemInfo keeps starting checks.
+	collectionBudget time.Duration
+	// collectionTimeout is a deadline for GetSystemInfo's checks, after
+	// which queries and commands in flight are cancelled.
+	collectionTimeout time.Duration
+
+	// desktopDelegated is set when the root agent that started this
+	// collection found the desktop user, and desktopUnavailable is then
+	// why their settings cannot be read, if they cannot.
+	desktopDelegated   bool
+	desktopUnavailable string
+
+	// runner runs queries and commands in place of osqueryi and the shell
+	// when set, as tests do to replay recorded output.
+	runner queryRunner
+}
+
+// queryRunner runs the osquery queries and shell commands of a Client.
+type queryRunner interface {
+	// query returns osqueryi's JSON output for query.
+	query(ctx context.Context, query string) ([]byte, error)
+	// command returns the output and exit code of a shell command. A
+	// command that exits non-zero is not an error; its stderr is returned
+	// as errOutput.
+	command(ctx context.Context, command string) (output, errOutput []byte, exitCode int, err error)
+}
+
+// execRunner runs queries with osqueryi and commands with the platform
+// shell, bounded by the client's output limit.
+type execRunner struct {
+	c *Client
+}
+
+func (r execRunner) query(ctx context.Context, query string) ([]byte, error) {
+	output, err := r.c.commandOutput(ctx, fmt.Sprintf("query %q", query), func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, r.c.binaryPath, r.c.queryArgs(query)...)
+	})
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, fmt.Errorf("osquery error: %s", string(exitErr.Stderr))
+	}
+	return output, err
+}
+
+func (r execRunner) command(ctx context.Context, command string) ([]byte, []byte, int, error) {
+	output, err := r.c.commandOutput(ctx, fmt.Sprintf("command %q", command), func(ctx context.Context) *exec.Cmd {
+		return r.c.shellCommand(ctx, command)
+	})
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return output, exitErr.Stderr, exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	return output, nil, 0, nil
+}
+
+// queryRunner returns the runner for the client's queries and commands.
+func (c *Client) queryRunner() queryRunner {
+	if c.runner != nil {
+		return c.runner
+	}
+	return execRunner{c: c}
 }
 
 // NewClient creates a new osquery client.
@@ -53,26 +299,58 @@ func NewClient(binaryPath string) (*Client, error) {
 
 // NewClientWithVerbose creates a new osquery client with verbose option.
 func NewClientWithVerbose(binaryPath string, verbose bool) (*Client, error) {
+	return NewClientWithPreference(binaryPath, verbose, PreferVendored)
+}
+
+// NewClientWithPreference creates a new osquery client. When binaryPath is
+// empty, osqueryi is searched for in the order given by prefer.
+func NewClientWithPreference(binaryPath string, verbose bool, prefer BinaryPreference) (*Client, error) {
 	platform, err := detectPlatform()
 	if err != nil {
 		return nil, err
 	}
 
 	// If no binary path specified, try to find osqueryi
+	var version string
 	if binaryPath == "" {
-		binaryPath, err = findOsqueryBinary()
+		binaryPath, version, err = findOsqueryBinary(prefer)
 		if err != nil {
 			return nil, fmt.Errorf("osquery binary not found: %w", err)
 		}
+	} else {
+		version, _ = osqueryVersion(binaryPath)
 	}
 
 	return &Client{
-		binaryPath: binaryPath,
-		platform:   platform,
-		verbose:    verbose,
+		binaryPath:    binaryPath,
+		binaryVersion: version,
+		platform:      platform,
+		verbose:       verbose,
 	}, nil
 }
 
+// WithContext returns a shallow copy of the client whose queries and
+// commands run under ctx. Spans started for them are children of any span
+// in ctx, and cancelling ctx stops running processes.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	clone := *c
+	clone.ctx = ctx
+	return &clone
+}
+
+// context returns the client's context, defaulting to the background context.
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Context returns the context the client's queries and commands run under.
+func (c *Client) Context() context.Context {
+	return c.context()
+}
+
 // SetVerbose sets the verbose mode for the client.
 func (c *Client) SetVerbose(verbose bool) {
 	c.verbose = verbose
@@ -104,57 +382,283 @@ func detectPlatform() (Platform, error) {
 	}
 }
 
-// findOsqueryBinary attempts to find the osquery binary.
-func findOsqueryBinary() (string, error) {
-	binaryName := "osqueryi"
+// BinaryPreference selects which kind of osqueryi installation is preferred.
+type BinaryPreference string
+
+const (
+	// PreferVendored prefers the copy shipped with the Drata Agent.
+	PreferVendored BinaryPreference = "vendored"
+	// PreferSystem prefers a system-wide osquery installation.
+	PreferSystem BinaryPreference = "system"
+	// PreferPath prefers whatever osqueryi is first on PATH.
+	PreferPath BinaryPreference = "path"
+)
+
+// minOsqueryVersion is the oldest osquery whose tables the collectors rely on.
+const minOsqueryVersion = "5.0.0"
+
+// BundledVersion is the osquery version shipped with the Drata Agent, which
+// the collectors are tested against. Packaging sets it to the osqueryi it
+// bundles with -ldflags
+// "-X github.com/drata/drata-agent-cli/internal/osquery.BundledVersion=5.19.0".
+var BundledVersion = "5.19.0"
+
+// Sources of an osqueryi binary, reported by DiscoverBinary.
+const (
+	// BinarySourceConfig is a binary set with osquery_path.
+	BinarySourceConfig = "config"
+	// BinarySourceVendored is a copy shipped with the Drata Agent.
+	BinarySourceVendored = "vendored"
+	// BinarySourceSystem is a system-wide osquery installation.
+	BinarySourceSystem = "system"
+	// BinarySourcePath is the osqueryi found by looking it up on PATH.
+	BinarySourcePath = "PATH"
+)
+
+// BinaryCandidate is a location osqueryi is searched for.
+type BinaryCandidate struct {
+	Path   string `json:"path"`
+	Source string `json:"source"`
+}
+
+// osqueryBinaryName returns the file name of osqueryi on this platform.
+func osqueryBinaryName() string {
 	if runtime.GOOS == "windows" {
-		binaryName = "osqueryi.exe"
+		return "osqueryi.exe"
 	}
+	return "osqueryi"
+}
 
-	// Build list of paths to search
-	var searchPaths []string
+// osquerySearchPaths returns the locations findOsqueryBinary tries, in the
+// order given by prefer. The PATH lookup is included only when it finds a
+// binary.
+func osquerySearchPaths(prefer BinaryPreference) []BinaryCandidate {
+	binaryName := osqueryBinaryName()
 
-	// Add user home directory paths (important for Flatpak/immutable OS)
+	// Copies shipped with the Drata Agent
+	var vendoredPaths []string
 	if home := os.Getenv("HOME"); home != "" {
-		searchPaths = append(searchPaths,
-			filepath.Join(home, ".local", "bin", "osqueryi"),
+		vendoredPaths = append(vendoredPaths,
+			filepath.Join(home, ".drata-agent", "bin", binaryName),
 			filepath.Join(home, ".local", "lib", "drata-agent", "bin", "osqueryi"),
 		)
 	}
-
-	// Flatpak-specific paths
-	searchPaths = append(searchPaths,
-		"/app/bin/osqueryi",
+	vendoredPaths = append(vendoredPaths,
 		"/app/lib/drata-agent/bin/osqueryi",
+		"/usr/lib/drata-agent/bin/osqueryi",
+		"/usr/lib64/drata-agent/bin/osqueryi",
 	)
 
-	// Common system locations
-	commonPaths := []string{
+	// System-wide installations, including user home (important for
+	// Flatpak/immutable OS) and Flatpak paths
+	var systemPaths []string
+	if home := os.Getenv("HOME"); home != "" {
+		systemPaths = append(systemPaths, filepath.Join(home, ".local", "bin", "osqueryi"))
+	}
+	systemPaths = append(systemPaths,
+		"/app/bin/osqueryi",
 		"/usr/local/bin/osqueryi",
 		"/usr/bin/osqueryi",
 		"/opt/osquery/bin/osqueryi",
-		"/usr/lib/drata-agent/bin/osqueryi",
-		"/usr/lib64/drata-agent/bin/osqueryi",
 		"C:\\Program Files\\osquery\\osqueryi.exe",
 		"C:\\ProgramData\\osquery\\osqueryi.exe",
-	}
-	searchPaths = append(searchPaths, commonPaths...)
+	)
 
-	// First, try PATH
+	var pathPaths []string
 	if path, err := exec.LookPath(binaryName); err == nil {
-		return path, nil
+		pathPaths = append(pathPaths, path)
 	}
 
-	// Then check all configured paths
-	for _, path := range searchPaths {
-		if fileExists(path) {
-			return path, nil
+	vendored := tagCandidates(vendoredPaths, BinarySourceVendored)
+	system := tagCandidates(systemPaths, BinarySourceSystem)
+	onPath := tagCandidates(pathPaths, BinarySourcePath)
+	switch prefer {
+	case PreferSystem:
+		return concatCandidates(system, onPath, vendored)
+	case PreferPath:
+		return concatCandidates(onPath, vendored, system)
+	default:
+		return concatCandidates(vendored, onPath, system)
+	}
+}
+
+// tagCandidates returns paths as candidates from source.
+func tagCandidates(paths []string, source string) []BinaryCandidate {
+	candidates := make([]BinaryCandidate, len(paths))
+	for i, path := range paths {
+		candidates[i] = BinaryCandidate{Path: path, Source: source}
+	}
+	return candidates
+}
+
+// concatCandidates joins candidate lists in order.
+func concatCandidates(lists ...[]BinaryCandidate) []BinaryCandidate {
+	var candidates []BinaryCandidate
+	for _, list := range lists {
+		candidates = append(candidates, list...)
+	}
+	return candidates
+}
+
+// candidatePaths returns the paths of candidates, leaving out those from
+// skipSource when it is not empty.
+func candidatePaths(candidates []BinaryCandidate, skipSource string) []string {
+	var paths []string
+	for _, candidate := range candidates {
+		if skipSource == "" || candidate.Source != skipSource {
+			paths = append(paths, candidate.Path)
 		}
 	}
+	return paths
+}
+
+// findOsqueryBinary attempts to find the osquery binary, returning its path
+// and version. Candidates are tried in the order given by prefer, and the
+// first that runs a query and meets minOsqueryVersion wins. If none meets
+// it, the first working candidate is used. Candidates that exist but cannot
+// run a query are skipped, and listed in the error if none works.
+func findOsqueryBinary(prefer BinaryPreference) (path string, version string, err error) {
+	binaryName := osqueryBinaryName()
+	candidates := osquerySearchPaths(prefer)
+
+	path, version, broken := selectOsqueryBinary(candidatePaths(candidates, ""))
+	if path != "" {
+		return path, version, nil
+	}
+	if len(broken) > 0 {
+		return "", "", fmt.Errorf("no working %s found; these were found but could not run a query:\n  - %s",
+			binaryName, strings.Join(broken, "\n  - "))
+	}
 
 	// Build error message with all searched paths
-	return "", fmt.Errorf("%s not found in PATH or common locations. Searched paths:\n  - PATH lookup for '%s'\n  - %s",
-		binaryName, binaryName, strings.Join(searchPaths, "\n  - "))
+	return "", "", fmt.Errorf("%s not found in PATH or common locations. Searched paths:\n  - PATH lookup for '%s'\n  - %s",
+		binaryName, binaryName, strings.Join(candidatePaths(candidates, BinarySourcePath), "\n  - "))
+}
+
+// selectOsqueryBinary returns the first candidate that exists, runs a query,
+// and meets minOsqueryVersion, or else the first that exists and runs a
+// query. broken describes each candidate that exists but failed to run one.
+func selectOsqueryBinary(candidates []string) (path, version string, broken []string) {
+	path, version, probes := probeOsqueryCandidates(candidates)
+	for _, p := range probes {
+		if p.err != nil {
+			broken = append(broken, fmt.Sprintf("%s: %v", p.path, p.err))
+		}
+	}
+	return path, version, broken
+}
+
+// candidateProbe is the outcome of running the probe query with one
+// candidate osqueryi.
+type candidateProbe struct {
+	path    string
+	version string
+	err     error
+}
+
+// probeOsqueryCandidates selects a binary as selectOsqueryBinary does,
+// returning the probe of each existing candidate it tried, in order.
+// Candidates after the selected one are not tried.
+func probeOsqueryCandidates(candidates []string) (path, version string, probes []candidateProbe) {
+	var fallback, fallbackVersion string
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if seen[candidate] || !fileExists(candidate) {
+			continue
+		}
+		seen[candidate] = true
+
+		v, err := probeOsquery(candidate)
+		probes = append(probes, candidateProbe{path: candidate, version: v, err: err})
+		if err != nil {
+			continue
+		}
+		if versionAtLeast(v, minOsqueryVersion) {
+			return candidate, v, probes
+		}
+		if fallback == "" {
+			fallback, fallbackVersion = candidate, v
+		}
+	}
+	return fallback, fallbackVersion, probes
+}
+
+// probeTimeout bounds how long a candidate osqueryi may take to run the
+// probe query.
+const probeTimeout = 15 * time.Second
+
+// probeOsquery runs a query with the binary at path and returns the
+// version it reports, failing for a binary that exists but cannot run, such
+// as one built for another architecture or missing shared libraries. Tests
+// replace it.
+var probeOsquery = func(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, "--json", "SELECT version FROM osquery_info").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if stderr := strings.TrimSpace(string(exitErr.Stderr)); stderr != "" {
+				return "", fmt.Errorf("%w: %s", err, truncateString(strings.Join(strings.Fields(stderr), " "), maxCheckErrorLength))
+			}
+		}
+		return "", err
+	}
+
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(output, &rows); err != nil {
+		return "", fmt.Errorf("unexpected query output: %w", err)
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("query returned no rows")
+	}
+	version, _ := rows[0]["version"].(string)
+	return version, nil
+}
+
+// osqueryVersion runs the binary at path and returns its version.
+func osqueryVersion(path string) (string, error) {
+	output, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	// Output looks like "osqueryi version 5.10.2"
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty version output from %s", path)
+	}
+	return fields[len(fields)-1], nil
+}
+
+// versionAtLeast reports whether the dotted version v is at least min.
+// Non-numeric suffixes such as "-rc1" are ignored.
+func versionAtLeast(v, min string) bool {
+	have := strings.Split(v, ".")
+	want := strings.Split(min, ".")
+	for i := range want {
+		var h, w int
+		if i < len(have) {
+			h = leadingInt(have[i])
+		}
+		w = leadingInt(want[i])
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+// leadingInt parses the leading decimal digits of s, returning 0 if none.
+func leadingInt(s string) int {
+	n := 0
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			break
+		}
+		n = n*10 + int(r-'0')
+	}
+	return n
 }
 
 // fileExists checks if a file exists and is not a directory.
@@ -166,26 +670,39 @@ func fileExists(path string) bool {
 	return !info.IsDir()
 }
 
+// BinaryPath returns the path of the osqueryi binary in use.
+func (c *Client) BinaryPath() string {
+	return c.binaryPath
+}
+
+// BinaryVersion returns the version of the osqueryi binary in use, or an
+// empty string if it could not be determined.
+func (c *Client) BinaryVersion() string {
+	return c.binaryVersion
+}
+
 // GetPlatform returns the detected platform.
 func (c *Client) GetPlatform() Platform {
 	return c.platform
 }
 
 // RunQuery executes an osquery SQL query and returns the JSON result.
-func (c *Client) RunQuery(query string) ([]map[string]interface{}, error) {
+func (c *Client) RunQuery(query string) (result []map[string]interface{}, err error) {
+	ctx, span := telemetry.StartSpan(c.context(), "osquery.query", attribute.String("osquery.query", query))
+	defer func() {
+		span.SetAttributes(attribute.Int("osquery.rows", len(result)))
+		telemetry.EndSpan(span, err)
+	}()
+
+	defer func() { c.recordError(err) }()
+
 	c.logVerbose("Executing osquery: %s", query)
-	cmd := exec.Command(c.binaryPath, "--json", query)
-	output, err := cmd.Output()
+	output, err := c.queryRunner().query(ctx, query)
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			c.logVerbose("Query failed: %s", string(exitErr.Stderr))
-			return nil, fmt.Errorf("osquery error: %s", string(exitErr.Stderr))
-		}
 		c.logVerbose("Query failed: %v", err)
 		return nil, err
 	}
 
-	var result []map[string]interface{}
 	if err := json.Unmarshal(output, &result); err != nil {
 		c.logVerbose("Failed to parse output: %v", err)
 		return nil, fmt.Errorf("failed to parse osquery output: %w", err)
@@ -199,25 +716,19 @@ func (c *Client) RunQuery(query string) ([]map[string]interface{}, error) {
 // SECURITY NOTE: This method should only be called with trusted, predefined commands
 // from the platform-specific system query implementations (macos.go, linux.go, windows.go).
 // Commands are hardcoded system utilities and should never include user input.
-func (c *Client) RunCommand(command string) (string, error) {
-	c.logVerbose("Executing command: %s", command)
-	var cmd *exec.Cmd
+func (c *Client) RunCommand(command string) (_ string, err error) {
+	ctx, span := telemetry.StartSpan(c.context(), "osquery.command", attribute.String("command", command))
+	defer func() {
+		telemetry.EndSpan(span, err)
+		c.recordError(err)
+	}()
 
-	switch c.platform {
-	case PlatformWindows:
-		// Use UTF-8 code page for Windows
-		fullCmd := fmt.Sprintf("cmd /c chcp 65001>nul && %s", command)
-		cmd = exec.Command("cmd", "/c", fullCmd)
-	default:
-		cmd = exec.Command("sh", "-c", command)
+	c.logVerbose("Executing command: %s", command)
+	output, errOutput, exitCode, err := c.queryRunner().command(ctx, command)
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("command error: %s", string(errOutput))
 	}
-
-	output, err := cmd.Output()
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			c.logVerbose("Command failed: %s", string(exitErr.Stderr))
-			return "", fmt.Errorf("command error: %s", string(exitErr.Stderr))
-		}
 		c.logVerbose("Command failed: %v", err)
 		return "", err
 	}
@@ -227,32 +738,139 @@ func (c *Client) RunCommand(command string) (string, error) {
 	return result, nil
 }
 
-// GetSystemInfo collects comprehensive system information.
-func (c *Client) GetSystemInfo(version string) (*QueryResult, error) {
+// RunCommandStatus executes a shell command and returns its output and exit
+// code. Unlike RunCommand, a non-zero exit code is not an error, so callers
+// can rely on exit codes instead of parsing human-readable output.
+// The same SECURITY NOTE as RunCommand applies.
+func (c *Client) RunCommandStatus(command string) (_ string, exitCode int, err error) {
+	ctx, span := telemetry.StartSpan(c.context(), "osquery.command", attribute.String("command", command))
+	defer func() {
+		span.SetAttributes(attribute.Int("command.exit_code", exitCode))
+		telemetry.EndSpan(span, err)
+		c.recordError(err)
+	}()
+
+	c.logVerbose("Executing command: %s", command)
+	output, _, exitCode, err := c.queryRunner().command(ctx, command)
+	if err != nil {
+		c.logVerbose("Command failed: %v", err)
+		return "", -1, err
+	}
+	result := strings.TrimSpace(string(output))
+	if exitCode != 0 {
+		c.logVerbose("Command exited with code %d", exitCode)
+		return result, exitCode, nil
+	}
+
+	c.logVerbose("Command output length: %d chars", len(result))
+	return result, 0, nil
+}
+
+// shellCommand builds the platform shell invocation for command.
+func (c *Client) shellCommand(ctx context.Context, command string) *exec.Cmd {
 	switch c.platform {
-	case PlatformMacOS:
-		return c.getMacOSSystemInfo(version)
 	case PlatformWindows:
-		return c.getWindowsSystemInfo(version)
-	case PlatformLinux:
-		return c.getLinuxSystemInfo(version)
+		// Use UTF-8 code page for Windows
+		fullCmd := fmt.Sprintf("cmd /c chcp 65001>nul && %s", command)
+		return exec.CommandContext(ctx, "cmd", "/c", fullCmd)
 	default:
-		return nil, fmt.Errorf("unsupported platform: %s", c.platform)
+		// Force the C locale so output we parse is English regardless of
+		// the user's language settings
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = append(os.Environ(), "LC_ALL=C", "LANG=C", "LANGUAGE=C")
+		return cmd
 	}
 }
 
+// GetSystemInfo collects comprehensive system information.
+func (c *Client) GetSystemInfo(version string) (result *QueryResult, err error) {
+	ctx, span := telemetry.StartSpan(c.context(), "osquery.collect", attribute.String("platform", string(c.platform)))
+	defer func() { telemetry.EndSpan(span, err) }()
+	c = c.WithContext(ctx)
+
+	checks, err := platformChecks(c.platform)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the checks run under the timeout, so the environment is still
+	// detected once it has passed
+	collector := c
+	if c.collectionTimeout > 0 {
+		collectCtx, cancel := context.WithTimeout(ctx, c.collectionTimeout)
+		defer cancel()
+		collector = c.WithContext(collectCtx)
+	}
+
+	start := time.Now()
+	rawResults, skipped, empty, checkErrors, durations, resultKeys := collector.runChecks(checks)
+	checkDurations := make(map[string]int64, len(durations))
+	for name, d := range durations {
+		checkDurations[name] = d.Milliseconds()
+	}
+	if len(skipped) > 0 {
+		span.SetAttributes(attribute.StringSlice("osquery.skipped_checks", skipped))
+	}
+	wslMarked := c.markWSLNotApplicable && c.IsWSL()
+	if wslMarked {
+		c.applyWSLNotApplicable(rawResults)
+	}
+	// WSL is a VM too, but its not-applicable reason is more specific
+	env := c.DetectEnvironment()
+	rawResults["environment"] = env.result()
+	if c.markVirtualNotApplicable && env.Type != EnvironmentNone && !wslMarked {
+		c.applyVirtualNotApplicable(rawResults, env)
+	}
+	// Read before truncation, which may cut the reasons short
+	unavailable := unavailableChecks(rawResults, resultKeys)
+	if c.maxFieldBytes > 0 {
+		truncateValue(rawResults, c.maxFieldBytes)
+	}
+
+	return &QueryResult{
+		SchemaVersion:      SchemaVersion,
+		DrataAgentVersion:  version,
+		Platform:           c.platform,
+		RawQueryResults:    rawResults,
+		DeviceName:         c.deviceLabels.Name,
+		AssetTag:           c.deviceLabels.AssetTag,
+		Partial:            len(skipped) > 0,
+		SkippedChecks:      skipped,
+		CheckErrors:        checkErrors,
+		EmptyChecks:        empty,
+		UnavailableChecks:  unavailable,
+		CollectionMs:       time.Since(start).Milliseconds(),
+		CollectionBudgetMs: c.collectionBudget.Milliseconds(),
+		CheckDurationsMs:   checkDurations,
+	}, nil
+}
+
+// SetDeviceLabels sets the device name and asset tag sent with the device
+// identifiers and collected results.
+func (c *Client) SetDeviceLabels(labels DeviceLabels) {
+	c.deviceLabels = labels
+}
+
 // GetAgentDeviceIdentifiers returns the device identifiers for registration.
 func (c *Client) GetAgentDeviceIdentifiers() (*AgentDeviceIdentifiers, error) {
+	var identifiers *AgentDeviceIdentifiers
+	var err error
 	switch c.platform {
 	case PlatformMacOS:
-		return c.getMacOSDeviceIdentifiers()
+		identifiers, err = c.getMacOSDeviceIdentifiers()
 	case PlatformWindows:
-		return c.getWindowsDeviceIdentifiers()
+		identifiers, err = c.getWindowsDeviceIdentifiers()
 	case PlatformLinux:
-		return c.getLinuxDeviceIdentifiers()
+		identifiers, err = c.getLinuxDeviceIdentifiers()
 	default:
 		return nil, fmt.Errorf("unsupported platform: %s", c.platform)
 	}
+	if err != nil {
+		return nil, err
+	}
+	identifiers.DeviceName = c.deviceLabels.Name
+	identifiers.AssetTag = c.deviceLabels.AssetTag
+	return identifiers, nil
 }
 
 // GetDebugInfo returns debug information about the system.
@@ -280,6 +898,23 @@ func (c *Client) GetDebugInfo() (map[string]interface{}, error) {
 	return info, nil
 }
 
+// Uptime returns how long ago the machine booted, from osquery's uptime
+// table.
+func (c *Client) Uptime() (time.Duration, error) {
+	row, err := c.queryFirst("SELECT total_seconds FROM uptime")
+	if err != nil {
+		return 0, err
+	}
+	if row == nil {
+		return 0, fmt.Errorf("uptime query returned no rows")
+	}
+	seconds, err := strconv.ParseInt(fmt.Sprint(row["total_seconds"]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected uptime %v: %w", row["total_seconds"], err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
 // Helper function to get the first result from a query
 func (c *Client) queryFirst(query string) (map[string]interface{}, error) {
 	result, err := c.RunQuery(query)
@@ -291,18 +926,3 @@ func (c *Client) queryFirst(query string) (map[string]interface{}, error) {
 	}
 	return nil, nil
 }
-
-// Helper function to run multiple queries and flatten results
-func (c *Client) queryAll(queries []string) ([]map[string]interface{}, error) {
-	var allResults []map[string]interface{}
-
-	for _, query := range queries {
-		result, err := c.RunQuery(query)
-		if err != nil {
-			continue
-		}
-		allResults = append(allResults, result...)
-	}
-
-	return allResults, nil
-}
@@ -0,0 +1,1834 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommandStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	c := &Client{platform: PlatformLinux}
+	output, exitCode, err := c.RunCommandStatus("echo $LC_ALL; exit 3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", exitCode)
+	}
+	if output != "C" {
+		t.Errorf("expected commands to run in the C locale, got LC_ALL=%q", output)
+	}
+}
+
+func TestIsSoftwareUpdateScheduleOn(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected bool
+	}{
+		{"Automatic checking for updates is turned on", true},
+		{"Automatic checking for updates is turned off", false},
+		{"Automatic check is on", true},
+		{"Automatic check is off", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			if got := isSoftwareUpdateScheduleOn(tt.output); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIsWSLKernelVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected bool
+	}{
+		{"Linux version 5.15.153.1-microsoft-standard-WSL2 (root@941d701f84f1) (gcc (GCC) 12.2.0)", true},
+		{"Linux version 4.4.0-19041-Microsoft (Microsoft@Microsoft.com) (gcc version 5.4.0 (GCC) )", true},
+		{"Linux version 6.8.0-45-generic (buildd@lcy02-amd64-075) (x86_64-linux-gnu-gcc-13)", false},
+	}
+
+	for _, tt := range tests {
+		if got := isWSLKernelVersion(tt.version); got != tt.expected {
+			t.Errorf("isWSLKernelVersion(%q) = %v, expected %v", tt.version, got, tt.expected)
+		}
+	}
+}
+
+func TestDetectVirtualEnvironment(t *testing.T) {
+	cgroups := []struct {
+		content  string
+		expected string
+	}{
+		{"12:memory:/docker/3f4b2c1d\n11:cpu:/docker/3f4b2c1d\n", "docker"},
+		{"11:pids:/kubepods/besteffort/pod1234/abcd\n", "kubernetes"},
+		{"1:name=systemd:/machine.slice/libpod-5e6f.scope\n", "podman"},
+		{"0::/init.scope\n", ""},
+	}
+	for _, tt := range cgroups {
+		if got := containerFromCgroup(tt.content); got != tt.expected {
+			t.Errorf("containerFromCgroup(%q) = %q, expected %q", tt.content, got, tt.expected)
+		}
+	}
+
+	hardware := []struct {
+		vendor   string
+		model    string
+		expected string
+	}{
+		{"Amazon EC2", "m5.large", "Amazon EC2"},
+		{"Google", "Google Compute Engine", "Google Compute Engine"},
+		{"Microsoft Corporation", "Virtual Machine", "Microsoft Hyper-V"},
+		{"VMware, Inc.", "VMware Virtual Platform", "VMware"},
+		{"innotek GmbH", "VirtualBox", "VirtualBox"},
+		{"QEMU", "Standard PC (Q35 + ICH9, 2009)", "QEMU"},
+		{"Dell Inc.", "Latitude 7440", ""},
+		{"Apple Inc.", "MacBookPro18,3", ""},
+		{"Microsoft Corporation", "Surface Laptop 5", ""},
+	}
+	for _, tt := range hardware {
+		if got := matchVMSignature(tt.vendor, tt.model); got != tt.expected {
+			t.Errorf("matchVMSignature(%q, %q) = %q, expected %q", tt.vendor, tt.model, got, tt.expected)
+		}
+	}
+}
+
+func TestApplyVirtualNotApplicable(t *testing.T) {
+	c := &Client{platform: PlatformWindows}
+	c.SetCheckFilter(CheckFilter{Disabled: []string{"screenLock"}})
+	rawResults := map[string]interface{}{
+		"firewallStatus":   "on",
+		"screenLockStatus": "on",
+		"appList":          []string{},
+	}
+
+	c.applyVirtualNotApplicable(rawResults, Environment{Type: EnvironmentVM, Detail: "Amazon EC2"})
+	for _, control := range []string{"firewallStatus", "hddEncryptionStatus"} {
+		marker, ok := rawResults[control].(map[string]interface{})
+		if !ok || marker["notApplicable"] != true || !strings.Contains(marker["reason"].(string), "Amazon EC2") {
+			t.Errorf("%s = %v, want not applicable in Amazon EC2", control, rawResults[control])
+		}
+	}
+	if rawResults["screenLockStatus"] != "on" {
+		t.Errorf("disabled check was marked: %v", rawResults["screenLockStatus"])
+	}
+	if _, ok := rawResults["fileVaultEnabled"]; ok {
+		t.Error("macOS-only control was marked on Windows")
+	}
+}
+
+func TestCheckEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   CheckFilter
+		chk      check
+		expected bool
+	}{
+		{"default", CheckFilter{}, check{name: "sessionInfo"}, true},
+		{"default opt-in", CheckFilter{}, check{name: "extra", optIn: true}, false},
+		{"enabled", CheckFilter{Enabled: []string{"SESSIONINFO"}}, check{name: "sessionInfo"}, true},
+		{"not enabled", CheckFilter{Enabled: []string{"firewall"}}, check{name: "sessionInfo"}, false},
+		{"enabled opt-in", CheckFilter{Enabled: []string{"extra"}}, check{name: "extra", optIn: true}, true},
+		{"opted in", CheckFilter{OptIn: []string{"Extra"}}, check{name: "extra", optIn: true}, true},
+		{"opted in but not enabled", CheckFilter{Enabled: []string{"firewall"}, OptIn: []string{"extra"}}, check{name: "extra", optIn: true}, false},
+		{"disabled", CheckFilter{Disabled: []string{"sessionInfo"}}, check{name: "sessionInfo"}, false},
+		{"disabled wins", CheckFilter{Enabled: []string{"sessionInfo"}, Disabled: []string{"sessionInfo"}}, check{name: "sessionInfo"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{checkFilter: tt.filter}
+			if got := c.checkEnabled(tt.chk); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateCheckNames(t *testing.T) {
+	if err := ValidateCheckNames([]string{"sessionInfo", "FIREWALL"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ValidateCheckNames([]string{"sessionInfo", "bogus"}); err == nil {
+		t.Error("expected error for unknown check")
+	}
+}
+
+func TestNeedsRestartingResult(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		expected *bool
+	}{
+		{0, boolPtr(false)},
+		{1, boolPtr(true)},
+		{127, nil},
+	}
+
+	for _, tt := range tests {
+		got := needsRestartingResult(tt.exitCode)
+		if (got == nil) != (tt.expected == nil) || (got != nil && *got != *tt.expected) {
+			t.Errorf("needsRestartingResult(%d) = %v, expected %v", tt.exitCode, got, tt.expected)
+		}
+	}
+}
+
+func TestHasRestartRequiredUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected bool
+	}{
+		{"restart", "Software Update found the following new or updated software:\n* Label: macOS Sonoma 14.4.1-23E224\n\tTitle: macOS Sonoma 14.4.1, Version: 14.4.1, Size: 1234KiB, Recommended: YES, Action: restart,", true},
+		{"legacy restart", "   * Security Update 2021-001\n\tSecurity Update 2021-001 (1.0), 123K [recommended] [restart]", true},
+		{"no restart", "* Label: Safari17.4.1\n\tTitle: Safari, Version: 17.4.1, Size: 1234KiB, Recommended: YES,", false},
+		{"none", "No new software available.", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasRestartRequiredUpdate(tt.output); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		limit    int
+		expected string
+	}{
+		{"under limit", "hello", 10, "hello"},
+		{"at limit", "hello", 5, "hello"},
+		{"over limit", "hello world", 5, "hello...[truncated 6 bytes]"},
+		{"rune boundary", "aé", 2, "a...[truncated 2 bytes]"},
+		{"disabled", "hello world", 0, "hello world"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateString(tt.input, tt.limit); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTruncateValue(t *testing.T) {
+	rawResults := map[string]interface{}{
+		"screenLockSettings": map[string]interface{}{"powerSettings": "0123456789"},
+		"screenLockStatus":   []interface{}{map[string]string{"lockDelay": "0123456789"}},
+		"appList":            []map[string]interface{}{{"name": "0123456789"}},
+		"autoUpdateEnabled":  true,
+	}
+
+	truncateValue(rawResults, 4)
+
+	if got := rawResults["screenLockSettings"].(map[string]interface{})["powerSettings"]; got != "0123...[truncated 6 bytes]" {
+		t.Errorf("nested map not truncated: %q", got)
+	}
+	if got := rawResults["screenLockStatus"].([]interface{})[0].(map[string]string)["lockDelay"]; got != "0123...[truncated 6 bytes]" {
+		t.Errorf("string map in slice not truncated: %q", got)
+	}
+	if got := rawResults["appList"].([]map[string]interface{})[0]["name"]; got != "0123...[truncated 6 bytes]" {
+		t.Errorf("query rows not truncated: %q", got)
+	}
+	if rawResults["autoUpdateEnabled"] != true {
+		t.Error("non-string value changed")
+	}
+}
+
+func TestCountAuditRules(t *testing.T) {
+	content := `## First rule - delete all
+-D
+-b 8192
+-f 1
+
+# Watch identity files
+-w /etc/passwd -p wa -k identity
+-w /etc/shadow -p wa -k identity
+-a always,exit -F arch=b64 -S adjtimex -k time-change
+-e 2
+`
+	if got := countAuditRules(content); got != 3 {
+		t.Errorf("expected 3 rules, got %d", got)
+	}
+	if got := countAuditRules("-D\n## No rules\n"); got != 0 {
+		t.Errorf("expected 0 rules, got %d", got)
+	}
+}
+
+func TestFimToolPresent(t *testing.T) {
+	tools := make(map[string]fimTool)
+	for _, tool := range fimTools {
+		tools[tool.name] = tool
+	}
+
+	tests := []struct {
+		name      string
+		installed []string
+		files     []string
+		ossec     bool
+		wazuh     bool
+	}{
+		{"wazuh package", []string{"wazuh-agent"}, []string{"/var/ossec/etc/ossec.conf", "/var/ossec/bin/wazuh-control"}, false, true},
+		{"wazuh before 4.2 from source", nil, []string{"/var/ossec/etc/ossec.conf", "/var/ossec/bin/ossec-control", "/var/ossec/bin/wazuh-modulesd"}, false, true},
+		{"ossec from source", nil, []string{"/var/ossec/etc/ossec.conf", "/var/ossec/bin/ossec-control"}, true, false},
+		{"ossec package", []string{"ossec-hids-agent"}, []string{"/var/ossec/etc/ossec.conf", "/var/ossec/bin/ossec-control"}, true, false},
+		{"only the shared config", nil, []string{"/var/ossec/etc/ossec.conf"}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installed := make(map[string]bool)
+			for _, name := range tt.installed {
+				installed[name] = true
+			}
+			exists := func(path string) bool {
+				for _, file := range tt.files {
+					if file == path {
+						return true
+					}
+				}
+				return false
+			}
+			if got := fimToolPresent(tools["ossec"], installed, exists); got != tt.ossec {
+				t.Errorf("ossec present = %v, want %v", got, tt.ossec)
+			}
+			if got := fimToolPresent(tools["wazuh"], installed, exists); got != tt.wazuh {
+				t.Errorf("wazuh present = %v, want %v", got, tt.wazuh)
+			}
+		})
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version  string
+		min      string
+		expected bool
+	}{
+		{"5.10.2", "5.0.0", true},
+		{"5.0.0", "5.0.0", true},
+		{"4.9.0", "5.0.0", false},
+		{"10.0", "5.0.0", true},
+		{"5.1.0-rc1", "5.1.0", true},
+		{"", "5.0.0", false},
+	}
+
+	for _, tt := range tests {
+		if got := versionAtLeast(tt.version, tt.min); got != tt.expected {
+			t.Errorf("versionAtLeast(%q, %q) = %v, expected %v", tt.version, tt.min, got, tt.expected)
+		}
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	valid := []string{"--disable_events", "--config_path=/etc/osquery/osquery.conf", "--disable_tables=curl,curl_certificate"}
+	if err := ValidateFlags(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, flag := range []string{"disable_events", "--", "--extension=/tmp/evil.ext", "--extensions_autoload=/tmp/ext", "--flagfile=/tmp/other", "--csv"} {
+		if err := ValidateFlags([]string{flag}); err == nil {
+			t.Errorf("expected error for %q", flag)
+		}
+	}
+}
+
+func TestValidateFlagfile(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.flags")
+	if err := os.WriteFile(valid, []byte("# quiet\n--disable_events\n\n--config_path=/etc/osquery/osquery.conf\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateFlagfile(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	unsafe := filepath.Join(dir, "unsafe.flags")
+	if err := os.WriteFile(unsafe, []byte("--disable_events\n--extensions_autoload=/tmp/ext\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateFlagfile(unsafe); err == nil {
+		t.Error("expected error for unsafe flagfile")
+	}
+
+	if err := ValidateFlagfile(filepath.Join(dir, "missing.flags")); err == nil {
+		t.Error("expected error for missing flagfile")
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	c := &Client{}
+	c.SetFlags([]string{"--disable_events"}, "/etc/osquery/drata.flags")
+	got := strings.Join(c.queryArgs("SELECT 1"), " ")
+	want := "--flagfile=/etc/osquery/drata.flags --disable_events --json SELECT 1"
+	if got != want {
+		t.Errorf("queryArgs = %q, want %q", got, want)
+	}
+}
+
+func TestEOLCycle(t *testing.T) {
+	tests := []struct {
+		platform Platform
+		row      map[string]interface{}
+		product  string
+		cycle    string
+	}{
+		{PlatformMacOS, map[string]interface{}{"major": "14", "minor": "5"}, "macos", "14"},
+		{PlatformMacOS, map[string]interface{}{"major": "10", "minor": "15"}, "macos", "10.15"},
+		{PlatformWindows, map[string]interface{}{"name": "Microsoft Windows 11 Pro", "build": "22631"}, "windows", "22631"},
+		{PlatformWindows, map[string]interface{}{"name": "Microsoft Windows 11 Enterprise", "build": "22631"}, "windows-enterprise", "22631"},
+		{PlatformLinux, map[string]interface{}{"platform": "ubuntu", "major": "22", "minor": "4"}, "ubuntu", "22.04"},
+		{PlatformLinux, map[string]interface{}{"platform": "rocky", "major": "9", "minor": "3"}, "rocky-linux", "9"},
+		{PlatformLinux, map[string]interface{}{"platform": "arch"}, "arch", ""},
+	}
+
+	for _, tt := range tests {
+		product, cycle := eolCycle(tt.platform, tt.row)
+		if product != tt.product || cycle != tt.cycle {
+			t.Errorf("eolCycle(%s, %v) = %s %s, want %s %s", tt.platform, tt.row, product, cycle, tt.product, tt.cycle)
+		}
+	}
+}
+
+func TestEOLSupported(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		eol      string
+		expected *bool
+	}{
+		{"", boolPtr(true)},
+		{"2025-05-29", boolPtr(false)},
+		{"2025-06-01", boolPtr(true)},
+		{"2027-04-01", boolPtr(true)},
+		{"soon", nil},
+	}
+
+	for _, tt := range tests {
+		got := eolSupported(tt.eol, now)
+		if (got == nil) != (tt.expected == nil) || (got != nil && *got != *tt.expected) {
+			t.Errorf("eolSupported(%q) = %v, want %v", tt.eol, got, tt.expected)
+		}
+	}
+}
+
+func TestRunChecksBudget(t *testing.T) {
+	var ran []string
+	collect := func(name string, d time.Duration) func(*Client, map[string]interface{}) {
+		return func(c *Client, rawResults map[string]interface{}) {
+			time.Sleep(d)
+			ran = append(ran, name)
+			rawResults[name] = true
+		}
+	}
+	checks := []check{
+		{name: "slow", collect: collect("slow", 30*time.Millisecond)},
+		{name: "fast", collect: collect("fast", 0)},
+		{name: "other", collect: collect("other", 0)},
+	}
+
+	c := &Client{}
+	c.SetCollectionBudget(20 * time.Millisecond)
+	rawResults, skipped, _, _, durations, _ := c.runChecks(checks)
+	if len(ran) != 1 || rawResults["slow"] != true {
+		t.Errorf("expected only the first check to run, ran %v", ran)
+	}
+	if strings.Join(skipped, ",") != "fast,other" {
+		t.Errorf("skipped = %v, want [fast other]", skipped)
+	}
+	if len(durations) != 1 || durations["slow"] < 30*time.Millisecond {
+		t.Errorf("durations = %v, want only slow, of at least 30ms", durations)
+	}
+
+	ran = nil
+	c.SetCollectionBudget(0)
+	if _, skipped, _, _, _, _ := c.runChecks(checks); len(skipped) != 0 || len(ran) != 3 {
+		t.Errorf("expected every check to run without a budget, ran %v, skipped %v", ran, skipped)
+	}
+}
+
+func TestRunChecksTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	var ran []string
+	checks := []check{
+		{name: "hung", collect: func(c *Client, rawResults map[string]interface{}) {
+			ran = append(ran, "hung")
+			c.RunCommand("exec sleep 5")
+		}},
+		{name: "next", collect: func(c *Client, rawResults map[string]interface{}) {
+			ran = append(ran, "next")
+			rawResults["next"] = true
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := (&Client{platform: PlatformLinux}).WithContext(ctx)
+	start := time.Now()
+	_, skipped, empty, _, _, _ := c.runChecks(checks)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("hung command was not cancelled, took %s", elapsed)
+	}
+	if strings.Join(ran, ",") != "hung" {
+		t.Errorf("expected only the hung check to run, ran %v", ran)
+	}
+	if strings.Join(skipped, ",") != "hung,next" {
+		t.Errorf("skipped = %v, want [hung next]", skipped)
+	}
+	if len(empty) != 0 {
+		t.Errorf("empty = %v, want none for a check cut short", empty)
+	}
+}
+
+func TestTunnelInterfaces(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"interface": "en0", "address": "192.168.1.10"},
+		{"interface": "utun0", "address": "fe80::1%utun0"},
+		{"interface": "utun3", "address": "10.8.0.2"},
+		{"interface": "utun3", "address": "fd00::2"},
+		{"interface": "tailscale0", "address": "100.64.0.1"},
+		{"interface": "lo0", "address": "127.0.0.1"},
+		{"interface": "Ethernet 3", "friendly_name": "PANGP Virtual Ethernet Adapter", "address": "10.1.2.3"},
+	}
+
+	var names []string
+	for _, tunnel := range tunnelInterfaces(rows) {
+		names = append(names, tunnel.name)
+	}
+	if got := strings.Join(names, ","); got != "Ethernet 3,tailscale0,utun3" {
+		t.Errorf("tunnelInterfaces = %s, want Ethernet 3,tailscale0,utun3", got)
+	}
+}
+
+func TestVPNClientStatus(t *testing.T) {
+	client := vpnClient{name: "wireGuard", processes: []string{"wireguard-go"}, interfaces: []string{"wg", "utun"}}
+	tunnels := []macCandidate{{name: "wg0"}}
+
+	if status := vpnClientStatus(client, false, map[string]bool{}, tunnels); status != nil {
+		t.Errorf("expected nil for a client that is not installed, got %v", status)
+	}
+
+	status := vpnClientStatus(client, true, map[string]bool{}, tunnels)
+	if status["installed"] != true || status["running"] != false || status["connected"] != false {
+		t.Errorf("unexpected status for an installed client: %v", status)
+	}
+
+	status = vpnClientStatus(client, true, map[string]bool{"wireguard-go": true}, tunnels)
+	if status["running"] != true || status["connected"] != true {
+		t.Errorf("unexpected status for a connected client: %v", status)
+	}
+
+	status = vpnClientStatus(client, true, map[string]bool{"wireguard-go": true}, nil)
+	if status["connected"] != false {
+		t.Errorf("expected a client without a tunnel to be disconnected: %v", status)
+	}
+}
+
+func TestParseDsregcmdStatus(t *testing.T) {
+	output := `
++----------------------------------------------------------------------+
+| Device State                                                         |
++----------------------------------------------------------------------+
+
+             AzureAdJoined : YES
+          EnterpriseJoined : NO
+              DomainJoined : NO
+               Device Name : LAPTOP-1234
+
++----------------------------------------------------------------------+
+| Tenant Details                                                       |
++----------------------------------------------------------------------+
+
+                TenantName : Example Corp
+                    MdmUrl : https://enrollment.manage.microsoft.com/enrollmentserver/discovery.svc
+`
+	status := parseDsregcmdStatus(strings.ReplaceAll(output, "\n", "\r\n"))
+
+	if status["AzureAdJoined"] != "YES" || status["Device Name"] != "LAPTOP-1234" || status["TenantName"] != "Example Corp" {
+		t.Errorf("unexpected status: %v", status)
+	}
+	if status["MdmUrl"] != "https://enrollment.manage.microsoft.com/enrollmentserver/discovery.svc" {
+		t.Errorf("MdmUrl = %q", status["MdmUrl"])
+	}
+	if dsregBool(status, "AzureAdJoined") != true || dsregBool(status, "DomainJoined") != false || dsregBool(status, "WorkplaceJoined") != nil {
+		t.Errorf("unexpected join state: %v", status)
+	}
+}
+
+func TestWindowsEnrollments(t *testing.T) {
+	const base = `HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Enrollments\`
+	rows := []map[string]interface{}{
+		{"key": base + "A1", "name": "ProviderID", "data": "MS DM Server"},
+		{"key": base + "A1", "name": "UPN", "data": "user@example.com"},
+		{"key": base + "B2", "name": "EnrollmentState", "data": "1"},
+	}
+
+	enrollments := windowsEnrollments(rows)
+	if len(enrollments) != 1 || enrollments[0]["providerId"] != intuneProviderID || enrollments[0]["upn"] != "user@example.com" {
+		t.Errorf("unexpected enrollments: %v", enrollments)
+	}
+}
+
+func TestNormalizeBrowserExtensions(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"browser_type": "edge", "name": "uBlock Origin", "identifier": "odfafepnkmbhccpbejgmiehpchacaeak", "version": "1.60.0", "state": "1"},
+		{"name": "Legacy", "identifier": "", "version": ""},
+		{"name": "", "identifier": "nameless"},
+	}
+
+	extensions := normalizeBrowserExtensions(rows, "chrome")
+	if len(extensions) != 2 {
+		t.Fatalf("got %d extensions, want 2: %v", len(extensions), extensions)
+	}
+	want := map[string]interface{}{
+		"browser":    "edge",
+		"name":       "uBlock Origin",
+		"identifier": "odfafepnkmbhccpbejgmiehpchacaeak",
+		"version":    "1.60.0",
+		"enabled":    true,
+	}
+	if len(extensions[0]) != len(want) {
+		t.Errorf("extension = %v, want %v", extensions[0], want)
+	}
+	for key, value := range want {
+		if extensions[0][key] != value {
+			t.Errorf("extension[%q] = %v, want %v", key, extensions[0][key], value)
+		}
+	}
+	// Fields a table does not report are omitted rather than empty
+	if len(extensions[1]) != 2 || extensions[1]["browser"] != "chrome" || extensions[1]["name"] != "Legacy" {
+		t.Errorf("extension = %v, want only browser and name", extensions[1])
+	}
+
+	firefox := normalizeBrowserExtensions([]map[string]interface{}{{"name": "Dark Reader", "active": "0"}}, "firefox")
+	if firefox[0]["enabled"] != false {
+		t.Errorf("enabled = %v, want false", firefox[0]["enabled"])
+	}
+}
+
+func TestDedupeBrowserExtensions(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"name": "Password Manager", "identifier": "pm", "version": "2.0", "state": "0"},
+		{"name": "Password Manager", "identifier": "pm", "version": "2.0", "state": "1"},
+		{"name": "Password Manager", "identifier": "pm", "version": "1.9", "state": "1"},
+		{"browser_type": "brave", "name": "Password Manager", "identifier": "pm", "version": "2.0"},
+		{"name": "Adblock", "version": "3.1"},
+		{"name": "Adblock", "version": "3.1"},
+	}
+
+	extensions := dedupeBrowserExtensions(normalizeBrowserExtensions(rows, "chrome"))
+	var got []string
+	for _, extension := range extensions {
+		version, _ := extension["version"].(string)
+		got = append(got, extension["browser"].(string)+"/"+extension["name"].(string)+"/"+version)
+	}
+	want := []string{"brave/Password Manager/2.0", "chrome/Adblock/3.1", "chrome/Password Manager/1.9", "chrome/Password Manager/2.0"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Enabled in any profile counts as enabled
+	if extensions[3]["enabled"] != true {
+		t.Errorf("enabled = %v, want true", extensions[3]["enabled"])
+	}
+}
+
+func TestMissingChecks(t *testing.T) {
+	checks := []check{
+		{name: "firewall", collect: func(c *Client, rawResults map[string]interface{}) { rawResults["firewallStatus"] = "on" }},
+		{name: "diskEncryption", collect: func(c *Client, rawResults map[string]interface{}) {}},
+	}
+	c := &Client{platform: PlatformMacOS}
+	_, _, empty, _, _, _ := c.runChecks(checks)
+	if strings.Join(empty, ",") != "diskEncryption" {
+		t.Fatalf("empty = %v, want [diskEncryption]", empty)
+	}
+
+	c.SetCheckFilter(CheckFilter{Disabled: []string{"screenLock"}})
+	result := &QueryResult{EmptyChecks: empty, SkippedChecks: []string{"autoUpdate"}}
+	missing := c.MissingChecks(result, []string{"firewall", "DiskEncryption", "screenLock", "autoUpdate", "fileIntegrityMonitoring"})
+	if strings.Join(missing, ",") != "diskEncryption,screenLock,autoUpdate" {
+		t.Errorf("missing = %v, want [diskEncryption screenLock autoUpdate]", missing)
+	}
+}
+
+func TestMergeScreenSaverRows(t *testing.T) {
+	const root = `HKEY_USERS\S-1-5-21-1`
+	policyKey := root + `\SOFTWARE\Policies\Microsoft\Windows\Control Panel\Desktop`
+	rows := []map[string]interface{}{
+		{"key": policyKey, "name": "ScreenSaveTimeOut", "data": "600"},
+		{"key": root + `\Control Panel\Desktop`, "name": "ScreenSaveTimeOut", "data": "1800"},
+		{"key": root + `\Control Panel\Desktop`, "name": "ScreenSaverIsSecure", "data": "1"},
+	}
+
+	settings := mergeScreenSaverRows(rows, policyKey)
+	if settings["ScreenSaveTimeOut"] != "600" || settings["ScreenSaverIsSecure"] != "1" || len(settings) != 2 {
+		t.Errorf("unexpected settings: %v", settings)
+	}
+}
+
+func TestOrderProfiles(t *testing.T) {
+	profiles := map[string]string{
+		"S-1-5-21-300": `C:\Users\carol`,
+		"S-1-5-21-100": `C:\Users\alice`,
+		"S-1-5-21-200": `C:\Users\bob`,
+	}
+
+	if got := strings.Join(orderProfiles(profiles, "S-1-5-21-200"), ","); got != "S-1-5-21-200,S-1-5-21-100,S-1-5-21-300" {
+		t.Errorf("orderProfiles = %s", got)
+	}
+	if got := strings.Join(orderProfiles(profiles, ""), ","); got != "S-1-5-21-100,S-1-5-21-200,S-1-5-21-300" {
+		t.Errorf("orderProfiles without last user = %s", got)
+	}
+}
+
+func TestProfileScreenSaverSettings(t *testing.T) {
+	originalRunning := processRunning
+	t.Cleanup(func() { processRunning = originalRunning })
+	processRunning = func(pid int) bool { return pid == os.Getpid() || pid == 200 }
+
+	loaded := profileHiveMountName(os.Getpid(), "S-1-5-21-100")
+	desktop := func(root string) string {
+		return `SELECT key, name, data FROM registry WHERE key IN ('` + root + `\SOFTWARE\Policies\Microsoft\Windows\Control Panel\Desktop', '` + root + `\Control Panel\Desktop') AND name IN ('ScreenSaveTimeOut', 'ScreenSaverIsSecure', 'ScreenSaveActive', 'DelayLockInterval')`
+	}
+	runner := &countingRunner{
+		fixtureRunner: &fixtureRunner{fixture: collectorFixture{
+			Queries: map[string]fixtureQuery{
+				`SELECT key, data FROM registry WHERE key LIKE '` + profileListKey + `\S-1-5-21-%' AND name = 'ProfileImagePath'`: {Rows: []map[string]interface{}{
+					{"key": profileListKey + `\S-1-5-21-100`, "data": `C:\Users\alice`},
+					{"key": profileListKey + `\S-1-5-21-200`, "data": `C:\Users\bob`},
+				}},
+				`SELECT data FROM registry WHERE key = '` + lastLoggedOnUserKey + `' AND name = 'LastLoggedOnUserSID'`: {Rows: []map[string]interface{}{{"data": "S-1-5-21-200"}}},
+				`SELECT path FROM registry WHERE key = 'HKEY_USERS' AND path LIKE 'HKEY_USERS\DrataAgentProfile-%'`: {Rows: []map[string]interface{}{
+					{"path": `HKEY_USERS\DrataAgentProfile-100-S-1-5-21-100`},
+					{"path": `HKEY_USERS\DrataAgentProfile-200-S-1-5-21-100`},
+				}},
+				desktop(`HKEY_USERS\S-1-5-21-100`): {},
+				desktop(`HKEY_USERS\S-1-5-21-200`): {Rows: []map[string]interface{}{{"key": `HKEY_USERS\S-1-5-21-200\Control Panel\Desktop`, "name": "ScreenSaveTimeOut", "data": "900"}}},
+				desktop(`HKEY_USERS\` + loaded):    {Rows: []map[string]interface{}{{"key": `HKEY_USERS\` + loaded + `\Control Panel\Desktop`, "name": "ScreenSaveTimeOut", "data": "300"}}},
+			},
+			Commands: map[string]fixtureCommand{
+				`reg unload HKU\DrataAgentProfile-100-S-1-5-21-100 >NUL 2>&1`:       {},
+				`reg load HKU\` + loaded + ` "C:\Users\alice\NTUSER.DAT" >NUL 2>&1`: {},
+				`reg unload HKU\` + loaded + ` >NUL 2>&1`:                           {},
+			},
+		}},
+		commands: make(map[string]int),
+	}
+	c := &Client{platform: PlatformWindows, runner: runner}
+
+	found := c.profileScreenSaverSettings()
+	if len(found) != 2 || found[0].sid != "S-1-5-21-200" || found[0].settings["ScreenSaveTimeOut"] != "900" || found[1].sid != "S-1-5-21-100" || found[1].settings["ScreenSaveTimeOut"] != "300" {
+		t.Errorf("unexpected profiles: %+v", found)
+	}
+	if len(runner.unrecorded) > 0 {
+		t.Errorf("unexpected queries or commands: %v", runner.unrecorded)
+	}
+	if runner.commands[`reg unload HKU\`+loaded+` >NUL 2>&1`] != 1 {
+		t.Error("expected the loaded hive to be unloaded")
+	}
+	if runner.commands[`reg unload HKU\DrataAgentProfile-100-S-1-5-21-100 >NUL 2>&1`] != 1 {
+		t.Error("expected the hive left by a stopped agent to be unloaded")
+	}
+}
+
+func TestProfileHiveMountPID(t *testing.T) {
+	if pid, ok := profileHiveMountPID(profileHiveMountName(4321, "S-1-5-21-1-2-3-1001")); !ok || pid != 4321 {
+		t.Errorf("profileHiveMountPID = %d, %v, want 4321", pid, ok)
+	}
+	for _, name := range []string{"DrataAgentProfile", "DrataAgentProfile-x-S-1-5-21-100", "S-1-5-21-100"} {
+		if _, ok := profileHiveMountPID(name); ok {
+			t.Errorf("profileHiveMountPID(%q) matched", name)
+		}
+	}
+}
+
+func TestWindowsFullBuild(t *testing.T) {
+	if got := windowsFullBuild("22631", "4317"); got != "22631.4317" {
+		t.Errorf("windowsFullBuild = %q, want 22631.4317", got)
+	}
+	if got := windowsFullBuild("22631", ""); got != "" {
+		t.Errorf("windowsFullBuild without UBR = %q, want empty", got)
+	}
+}
+
+func TestParseSudoersPrincipals(t *testing.T) {
+	content := `# User privilege specification
+Defaults	env_reset
+Defaults:alice !requiretty
+User_Alias ADMINS = alice, bob
+root	ALL=(ALL:ALL) ALL
+%sudo	ALL=(ALL:ALL) ALL
+@includedir /etc/sudoers.d
+deploy,ci ALL=(root) NOPASSWD: /usr/bin/systemctl
+%sudo ALL=(ALL) NOPASSWD: ALL`
+
+	got := parseSudoersPrincipals(content)
+	expected := []string{"%sudo", "ci", "deploy", "root"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseSudoersPrincipals() = %v, expected %v", got, expected)
+	}
+}
+
+func TestParseGroupMembers(t *testing.T) {
+	output := "sudo:x:27:alice,bob\nwheel:x:10:\nadmin:x:115:bob,carol"
+
+	got := parseGroupMembers(output)
+	expected := []string{"alice", "bob", "carol"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseGroupMembers() = %v, expected %v", got, expected)
+	}
+	if got := parseGroupMembers(""); got == nil || len(got) != 0 {
+		t.Errorf("parseGroupMembers(\"\") = %v, expected an empty list", got)
+	}
+}
+
+func TestHasLoginShell(t *testing.T) {
+	tests := []struct {
+		entry    string
+		expected bool
+	}{
+		{"guest:x:1001:1001::/home/guest:/bin/bash", true},
+		{"guest:x:1001:1001::/home/guest:/usr/sbin/nologin", false},
+		{"guest:x:1001:1001::/home/guest:/bin/false", false},
+		{"guest:x:1001", false},
+	}
+
+	for _, tt := range tests {
+		if got := hasLoginShell(tt.entry); got != tt.expected {
+			t.Errorf("hasLoginShell(%q) = %v, expected %v", tt.entry, got, tt.expected)
+		}
+	}
+}
+
+func TestParseNetUserActive(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected *bool
+	}{
+		{"User name                    Guest\r\nAccount active               No\r\n", boolPtr(false)},
+		{"User name                    Guest\r\nAccount active               Yes\r\n", boolPtr(true)},
+		{"Benutzername                 Gast\r\nKonto aktiv                  Nein\r\n", nil},
+	}
+
+	for _, tt := range tests {
+		got := parseNetUserActive(tt.output)
+		if (got == nil) != (tt.expected == nil) || (got != nil && *got != *tt.expected) {
+			t.Errorf("parseNetUserActive(%q) = %v, expected %v", tt.output, got, tt.expected)
+		}
+	}
+}
+
+func TestRunChecksErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	checks := []check{
+		{name: "failing", collect: func(c *Client, rawResults map[string]interface{}) {
+			c.RunCommand("echo 'first   failure' >&2; exit 3")
+			c.RunCommand("exit 4")
+		}},
+		{name: "fallback", collect: func(c *Client, rawResults map[string]interface{}) {
+			if _, err := c.withoutErrorLog().RunCommand("exit 1"); err != nil {
+				rawResults["fallback"], _ = c.RunCommand("echo ok")
+			}
+		}},
+		{name: "passing", collect: func(c *Client, rawResults map[string]interface{}) {
+			c.RunCommandStatus("exit 1")
+		}},
+	}
+
+	c := &Client{platform: PlatformLinux}
+	rawResults, _, empty, checkErrors, _, _ := c.runChecks(checks)
+	if rawResults["fallback"] != "ok" {
+		t.Errorf("fallback result = %v, want ok", rawResults["fallback"])
+	}
+	if strings.Join(empty, ",") != "failing,passing" {
+		t.Errorf("empty = %v, want [failing passing]", empty)
+	}
+	expected := map[string]string{"failing": "command error: first failure (and 1 more)"}
+	if !reflect.DeepEqual(checkErrors, expected) {
+		t.Errorf("checkErrors = %v, want %v", checkErrors, expected)
+	}
+}
+
+func TestParseQueryResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"valid", `{"drataAgentVersion":"3.8.0","platform":"LINUX","rawQueryResults":{"osVersion":{"name":"Ubuntu"}},"checkErrors":{"firewall":"failed"}}`, false},
+		{"schema version", `{"schemaVersion":1,"drataAgentVersion":"3.9.0","platform":"LINUX","rawQueryResults":{"osVersion":{"name":"Ubuntu"}},"checkErrors":{"firewall":"failed"}}`, false},
+		{"unknown platform", `{"drataAgentVersion":"3.8.0","platform":"BEOS","rawQueryResults":{"osVersion":{}}}`, true},
+		{"no results", `{"drataAgentVersion":"3.8.0","platform":"MACOS","rawQueryResults":{}}`, true},
+		{"no version", `{"platform":"WINDOWS","rawQueryResults":{"osVersion":{}}}`, true},
+		{"unknown field", `{"drataAgentVersion":"3.8.0","platform":"LINUX","rawQueryResults":{"osVersion":{}},"uuid":"x"}`, true},
+		{"not json", `osVersion: Ubuntu`, true},
+	}
+
+	for _, tt := range tests {
+		result, err := ParseQueryResult([]byte(tt.data))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ParseQueryResult() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && result.CheckErrors["firewall"] != "failed" {
+			t.Errorf("%s: checkErrors not decoded: %v", tt.name, result.CheckErrors)
+		}
+	}
+}
+
+func TestEvaluateAppPolicy(t *testing.T) {
+	names := []string{"Slack.app", "CrowdStrike Falcon Sensor", "1Password 7"}
+
+	result := evaluateAppPolicy(AppPolicy{
+		Required:   []string{"crowdstrike falcon", "Zoom"},
+		Prohibited: []string{"TeamViewer"},
+	}, names)
+	want := map[string]interface{}{
+		"required":   map[string]bool{"crowdstrike falcon": true, "Zoom": false},
+		"prohibited": map[string]bool{"TeamViewer": false},
+		"passed":     false,
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("substring match: got %v, want %v", result, want)
+	}
+
+	result = evaluateAppPolicy(AppPolicy{
+		Required:   []string{"slack", "1Password"},
+		ExactMatch: true,
+	}, names)
+	want = map[string]interface{}{
+		"required":   map[string]bool{"slack": true, "1Password": false},
+		"prohibited": map[string]bool{},
+		"passed":     false,
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("exact match: got %v, want %v", result, want)
+	}
+
+	result = evaluateAppPolicy(AppPolicy{Required: []string{"Slack"}, Prohibited: []string{"Dropbox"}}, names)
+	if result["passed"] != true {
+		t.Errorf("expected policy to pass, got %v", result)
+	}
+}
+
+func TestEvaluateRemoteAccess(t *testing.T) {
+	policy := RemoteAccessPolicy{Sanctioned: []string{"SSH"}, Prohibited: []string{"teamviewer", "Parsec"}}
+	tools := remoteAccessToolsFor(policy)
+	if last := tools[len(tools)-1]; last.name != "Parsec" || len(last.processes) != 2 {
+		t.Fatalf("expected a tool for the unknown policy entry, got %+v", last)
+	}
+
+	result := evaluateRemoteAccess(policy, tools, remoteAccessEvidence{
+		apps:    []string{"TeamViewer 15", "Slack"},
+		running: map[string]bool{"sshd": true, "AnyDesk": true},
+		sockets: []listeningSocket{
+			{process: "sshd", port: 22},
+			{process: "launchd", port: 5900},
+			{process: "AnyDesk", port: 7070},
+			{process: "AnyDesk", port: 50001},
+			{process: "nginx", port: 443},
+			{process: "java", port: 5938},
+			{port: 3389},
+			{process: "chrome-remote-d", path: "/opt/google/chrome-remote-desktop/chrome-remote-desktop-host", port: 35000},
+		},
+		enabled: map[string]bool{"rdp": false},
+	})
+
+	entries := make(map[string]map[string]interface{})
+	for _, entry := range result["tools"].([]map[string]interface{}) {
+		entries[entry["name"].(string)] = entry
+	}
+	if len(entries) != 6 {
+		t.Errorf("expected ssh, rdp, vnc, teamViewer, anyDesk, and chromeRemoteDesktop, got %v", result["tools"])
+	}
+	want := map[string]map[string]interface{}{
+		"ssh":                 {"inbound": true, "enabled": true, "running": true, "listening": true, "listeningPorts": []int{22}, "policy": "sanctioned"},
+		"rdp":                 {"inbound": true, "enabled": false, "listening": true, "listeningPorts": []int{3389}, "policy": "unreviewed"},
+		"vnc":                 {"enabled": true, "running": false, "listening": true, "listeningPorts": []int{5900}},
+		"teamViewer":          {"inbound": false, "installed": true, "running": false, "listening": false, "policy": "prohibited"},
+		"anyDesk":             {"installed": false, "running": true, "listeningPorts": []int{7070, 50001}, "policy": "unreviewed"},
+		"chromeRemoteDesktop": {"running": false, "listening": true, "listeningPorts": []int{35000}},
+	}
+	for name, fields := range want {
+		for key, value := range fields {
+			if got := entries[name][key]; !reflect.DeepEqual(got, value) {
+				t.Errorf("%s %s: got %v, want %v", name, key, got, value)
+			}
+		}
+	}
+
+	if got := result["prohibitedFound"]; !reflect.DeepEqual(got, []string{"teamViewer"}) {
+		t.Errorf("prohibitedFound: got %v", got)
+	}
+	if got := result["unsanctionedFound"]; !reflect.DeepEqual(got, []string{"rdp", "vnc", "teamViewer", "anyDesk", "chromeRemoteDesktop"}) {
+		t.Errorf("unsanctionedFound: got %v", got)
+	}
+	if result["passed"] != false {
+		t.Errorf("expected a prohibited tool to fail, got %v", result["passed"])
+	}
+
+	result = evaluateRemoteAccess(RemoteAccessPolicy{}, remoteAccessTools, remoteAccessEvidence{})
+	if len(result["tools"].([]map[string]interface{})) != 3 || result["passed"] != true {
+		t.Errorf("expected only the inbound services on a clean system, got %v", result)
+	}
+}
+
+func TestProcessSnapshotQuery(t *testing.T) {
+	query := processSnapshotQuery(ProcessSnapshot{MaxRows: 50}, []string{"/opt/osquery/osqueryi", "/usr/local/bin/it's-me", ""})
+	want := "SELECT name, path, COUNT(*) AS instances FROM processes WHERE path != '' AND path != '/opt/osquery/osqueryi' AND path != '/usr/local/bin/it''s-me' GROUP BY path ORDER BY path LIMIT 50"
+	if query != want {
+		t.Errorf("got %q, want %q", query, want)
+	}
+
+	query = processSnapshotQuery(ProcessSnapshot{MaxRows: 50, Hashes: true}, nil)
+	if !strings.HasPrefix(query, "SELECT p.name, p.path, p.instances, h.sha256 FROM (SELECT name") || !strings.Contains(query, "LIMIT 50) p LEFT JOIN hash h") {
+		t.Errorf("expected the hash join over the limited rows, got %q", query)
+	}
+
+	processes := parseProcessSnapshot([]map[string]interface{}{
+		{"name": "sshd", "path": "/usr/sbin/sshd", "instances": "3", "sha256": "abc"},
+		{"name": "bash", "path": "/usr/bin/bash", "instances": "2", "sha256": ""},
+	})
+	wantProcesses := []map[string]interface{}{
+		{"name": "sshd", "path": "/usr/sbin/sshd", "instances": 3, "sha256": "abc"},
+		{"name": "bash", "path": "/usr/bin/bash", "instances": 2},
+	}
+	if !reflect.DeepEqual(processes, wantProcesses) {
+		t.Errorf("got %v, want %v", processes, wantProcesses)
+	}
+}
+
+func TestSelectOsqueryBinary(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken")
+	old := filepath.Join(dir, "old")
+	current := filepath.Join(dir, "current")
+	for _, path := range []string{broken, old, current} {
+		if err := os.WriteFile(path, nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := filepath.Join(dir, "missing")
+
+	versions := map[string]string{old: "4.9.0", current: "5.19.0"}
+	original := probeOsquery
+	probeOsquery = func(path string) (string, error) {
+		if version, ok := versions[path]; ok {
+			return version, nil
+		}
+		return "", errors.New("exec format error")
+	}
+	defer func() { probeOsquery = original }()
+
+	// A broken binary does not shadow a working one behind it
+	path, version, brokenFound := selectOsqueryBinary([]string{missing, broken, old, current})
+	if path != current || version != "5.19.0" {
+		t.Errorf("expected %s at 5.19.0, got %s at %s", current, path, version)
+	}
+	if len(brokenFound) != 1 || !strings.HasPrefix(brokenFound[0], broken+": ") {
+		t.Errorf("expected %s reported broken, got %v", broken, brokenFound)
+	}
+
+	// A working binary below the minimum version is used when nothing newer works
+	if path, _, _ := selectOsqueryBinary([]string{broken, old}); path != old {
+		t.Errorf("expected fallback to %s, got %s", old, path)
+	}
+
+	// Nothing works
+	if path, _, brokenFound := selectOsqueryBinary([]string{missing, broken, broken}); path != "" || len(brokenFound) != 1 {
+		t.Errorf("expected no binary and one broken candidate, got %q, %v", path, brokenFound)
+	}
+}
+
+func TestDiscoverBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("search paths under HOME differ on Windows")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PATH", t.TempDir())
+	vendored := filepath.Join(home, ".drata-agent", "bin", "osqueryi")
+	system := filepath.Join(home, ".local", "bin", "osqueryi")
+	for _, path := range []string{vendored, system} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	original := probeOsquery
+	probeOsquery = func(path string) (string, error) {
+		if path == system {
+			return "5.19.0", nil
+		}
+		return "", errors.New("exec format error")
+	}
+	defer func() { probeOsquery = original }()
+
+	d := DiscoverBinary("", PreferVendored)
+	if d.Selected != system || d.SelectedVersion != "5.19.0" || d.SelectedSource != BinarySourceSystem {
+		t.Errorf("expected %s at 5.19.0 from system, got %s at %s from %s", system, d.Selected, d.SelectedVersion, d.SelectedSource)
+	}
+	if d.PathLookupFound {
+		t.Error("expected the PATH lookup to find nothing")
+	}
+	statuses := make(map[string]CandidateResult)
+	for _, c := range d.Candidates {
+		statuses[c.Path] = c
+	}
+	if c := statuses[vendored]; c.Status != CandidateBroken || c.Source != BinarySourceVendored || c.Error == "" {
+		t.Errorf("expected the vendored binary reported broken, got %+v", c)
+	}
+	if c := statuses[system]; c.Status != CandidateSelected {
+		t.Errorf("expected the system binary selected, got %+v", c)
+	}
+	if d.Candidates[0].Path != vendored {
+		t.Errorf("expected the vendored binary searched first, got %s", d.Candidates[0].Path)
+	}
+
+	// A configured binary skips the search
+	d = DiscoverBinary(vendored, PreferSystem)
+	if d.Selected != vendored || d.SelectedSource != BinarySourceConfig || d.SelectedError == "" {
+		t.Errorf("expected the configured binary reported broken, got %+v", d)
+	}
+	if d.Candidates[0].Path != system || d.Candidates[0].Status != CandidateNotTried {
+		t.Errorf("expected the system binary listed first and not tried, got %+v", d.Candidates[0])
+	}
+}
+
+func TestParseFirmware(t *testing.T) {
+	fwupd := `{"Devices": [
+		{"Name": "System Firmware", "Releases": [{"Version": "1.18.0"}]},
+		{"Name": "UEFI dbx", "Releases": []},
+		{"Name": "Thunderbolt Controller"}
+	]}`
+	updates, ok := parseFwupdUpdates(fwupd)
+	if !ok || !reflect.DeepEqual(updates, []string{"System Firmware"}) {
+		t.Errorf("expected System Firmware update, got %v, %v", updates, ok)
+	}
+	if updates, ok := parseFwupdUpdates(`{"Devices": []}`); !ok || len(updates) != 0 {
+		t.Errorf("expected no updates, got %v, %v", updates, ok)
+	}
+	if _, ok := parseFwupdUpdates("No updatable devices"); ok {
+		t.Error("expected non-JSON output to be rejected")
+	}
+
+	profiler := `{"SPHardwareDataType": [{"boot_rom_version": "10151.121.1", "machine_model": "Mac14,2"}]}`
+	if got := parseBootROMVersion(profiler); got != "10151.121.1" {
+		t.Errorf("expected boot ROM 10151.121.1, got %q", got)
+	}
+	if got := parseBootROMVersion(`{}`); got != "" {
+		t.Errorf("expected no boot ROM, got %q", got)
+	}
+
+	bios := `{"Manufacturer": "LENOVO", "SMBIOSBIOSVersion": "N32ET86W (1.62 )", "ReleaseDate": "2023-08-01"}`
+	vendor, version, date := parseWin32BIOS(bios)
+	if vendor != "LENOVO" || version != "N32ET86W (1.62 )" || date != "2023-08-01" {
+		t.Errorf("unexpected BIOS: %q, %q, %q", vendor, version, date)
+	}
+}
+
+func TestParsePasswordPolicy(t *testing.T) {
+	value := func(n *int) interface{} { return intOrNull(n) }
+
+	netAccounts := `Force user logoff how long after time expires?:       Never
+Minimum password age (days):                          0
+Maximum password age (days):                          Unlimited
+Minimum password length:                              12
+Length of password history maintained:                None
+Lockout threshold:                                    5
+Lockout duration (minutes):                           30
+Lockout observation window (minutes):                 30
+Computer role:                                        WORKSTATION
+The command completed successfully.`
+	policy, ok := parseNetAccounts(netAccounts)
+	if !ok || value(policy.minLength) != 12 || value(policy.maxAgeDays) != 0 || value(policy.lockoutThreshold) != 5 || value(policy.lockoutDurationMinutes) != 30 {
+		t.Errorf("unexpected net accounts policy: %v, %v, %v, %v", value(policy.minLength), value(policy.maxAgeDays), value(policy.lockoutThreshold), value(policy.lockoutDurationMinutes))
+	}
+	if _, ok := parseNetAccounts("Longueur minimale du mot de passe : 12"); ok {
+		t.Error("expected localized net accounts output to be rejected")
+	}
+
+	pwpolicy := `Getting global account policies
+<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>policyCategoryAuthentication</key>
+	<array>
+		<dict>
+			<key>policyContent</key>
+			<string>(policyAttributeFailedAuthentications &lt; policyAttributeMaximumFailedAuthentications)</string>
+			<key>policyParameters</key>
+			<dict>
+				<key>autoEnableInSeconds</key>
+				<integer>900</integer>
+				<key>policyAttributeMaximumFailedAuthentications</key>
+				<integer>10</integer>
+			</dict>
+		</dict>
+	</array>
+	<key>policyCategoryPasswordContent</key>
+	<array>
+		<dict>
+			<key>policyContent</key>
+			<string>policyAttributePassword matches '.{8,}+'</string>
+		</dict>
+	</array>
+</dict>
+</plist>`
+	policy = parsePwpolicy(pwpolicy)
+	if value(policy.minLength) != 8 || value(policy.maxAgeDays) != 0 || value(policy.lockoutThreshold) != 10 || value(policy.lockoutDurationMinutes) != 15 {
+		t.Errorf("unexpected pwpolicy policy: %v, %v, %v, %v", value(policy.minLength), value(policy.maxAgeDays), value(policy.lockoutThreshold), value(policy.lockoutDurationMinutes))
+	}
+	if policy := parsePwpolicy("No global policies are set."); value(policy.minLength) != 0 || value(policy.lockoutThreshold) != 0 {
+		t.Errorf("expected no policy to be unenforced, got %v, %v", value(policy.minLength), value(policy.lockoutThreshold))
+	}
+
+	pam := `# here are the per-package modules
+auth	required	pam_faillock.so preauth deny=5 unlock_time=1800
+auth	[success=1 default=ignore]	pam_unix.so nullok
+auth	sufficient	pam_sss.so use_first_pass
+#password	requisite	pam_pwquality.so retry=3 minlen=20
+password	requisite	pam_pwquality.so retry=3 minlen=14
+password	[success=1 default=ignore]	pam_unix.so obscure yescrypt minlen=8`
+	parsed := parsePAMPasswordPolicy(pam)
+	if value(parsed.minLength) != 14 || value(parsed.lockoutThreshold) != 5 || value(parsed.lockoutDurationMinutes) != 30 {
+		t.Errorf("unexpected PAM policy: %v, %v, %v", value(parsed.minLength), value(parsed.lockoutThreshold), value(parsed.lockoutDurationMinutes))
+	}
+	if !parsed.pwquality || !parsed.faillock || parsed.directoryManaged == nil || !*parsed.directoryManaged {
+		t.Errorf("expected pwquality, faillock, and directory management, got %+v", parsed)
+	}
+
+	loginDefs := "# PASS_MAX_DAYS 30\nPASS_MAX_DAYS\t90\nPASS_MIN_DAYS\t0\n"
+	if got := value(parseLoginDefsMaxDays(loginDefs)); got != 90 {
+		t.Errorf("expected PASS_MAX_DAYS 90, got %v", got)
+	}
+	if got := value(parseLoginDefsMaxDays("PASS_MAX_DAYS\t99999\n")); got != 0 {
+		t.Errorf("expected the default PASS_MAX_DAYS to mean never, got %v", got)
+	}
+	if got := parseLoginDefsMaxDays("UMASK 022\n"); got != nil {
+		t.Errorf("expected no PASS_MAX_DAYS, got %v", *got)
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	c := &Client{platform: PlatformLinux}
+	c.SetMaxOutputBytes(1024)
+
+	// Output under the limit is returned as usual
+	if output, err := c.RunCommand("printf hello"); err != nil || output != "hello" {
+		t.Errorf("expected hello, got %q, %v", output, err)
+	}
+
+	// A command that never stops writing is killed
+	start := time.Now()
+	_, err := c.RunCommand("yes")
+	if !errors.Is(err, ErrOutputTooLarge) {
+		t.Errorf("expected ErrOutputTooLarge, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the command to be killed promptly, took %s", elapsed)
+	}
+	if _, _, err := c.RunCommandStatus("head -c 4096 /dev/zero"); !errors.Is(err, ErrOutputTooLarge) {
+		t.Errorf("expected ErrOutputTooLarge from RunCommandStatus, got %v", err)
+	}
+
+	// Standard error is still reported for failed commands
+	if _, err := c.RunCommand("echo broken >&2; exit 1"); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected stderr in error, got %v", err)
+	}
+}
+
+func TestParseAuditLogging(t *testing.T) {
+	status := "enabled 1\nfailure 1\npid 812\nrate_limit 0\nbacklog_limit 8192\n"
+	if enabled, ok := parseAuditctlEnabled(status); !ok || !enabled {
+		t.Errorf("expected kernel auditing enabled, got %v, %v", enabled, ok)
+	}
+	if enabled, ok := parseAuditctlEnabled("enabled 0\nfailure 1\n"); !ok || enabled {
+		t.Errorf("expected kernel auditing disabled, got %v, %v", enabled, ok)
+	}
+	if _, ok := parseAuditctlEnabled("You must be root to run this program."); ok {
+		t.Error("expected an error message not to parse")
+	}
+
+	if got := countAuditRules("No rules"); got != 0 {
+		t.Errorf("expected no rules, got %d", got)
+	}
+	rules := "-w /etc/passwd -p wa -k identity\n-a always,exit -F arch=b64 -S execve -k exec\n"
+	if got := countAuditRules(rules); got != 2 {
+		t.Errorf("expected 2 rules, got %d", got)
+	}
+
+	control := "#\n# $P4: audit_control\n#\ndir:/var/audit\nflags:lo,aa, ad\nminfree:5\nnaflags:lo,aa\n"
+	if got := parseAuditControlFlags(control); !reflect.DeepEqual(got, []string{"lo", "aa", "ad"}) {
+		t.Errorf("unexpected audit_control flags: %v", got)
+	}
+	if got := parseAuditControlFlags("flags:\n"); len(got) != 0 {
+		t.Errorf("expected no flags, got %v", got)
+	}
+
+	auditpol := "System audit policy\r\n" +
+		"Category/Subcategory                      Setting\r\n" +
+		"System\r\n" +
+		"  Security System Extension               No Auditing\r\n" +
+		"  System Integrity                        Success and Failure\r\n" +
+		"Logon/Logoff\r\n" +
+		"  Logon                                   Success\r\n" +
+		"  Logoff                                  Success\r\n" +
+		"Object Access\r\n" +
+		"  File System                             No Auditing\r\n"
+	categories, audited, total := parseAuditpol(auditpol)
+	if !reflect.DeepEqual(categories, []string{"System", "Logon/Logoff"}) || audited != 3 || total != 5 {
+		t.Errorf("unexpected auditpol summary: %v, %d of %d", categories, audited, total)
+	}
+	if _, _, total := parseAuditpol("Stratégie d'audit système\n  Ouvrir la session    Succès\n"); total != 0 {
+		t.Errorf("expected localized auditpol output not to parse, got %d subcategories", total)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	result := func(lastLogin string, idleDelay int) *QueryResult {
+		return &QueryResult{
+			DrataAgentVersion: "1.0.0",
+			Platform:          PlatformLinux,
+			RawQueryResults: map[string]interface{}{
+				"screenLockSettings": map[string]interface{}{"idleDelaySeconds": idleDelay},
+				"sessionInfo": map[string]interface{}{
+					"currentUser": "alice",
+					"lastLogins":  []interface{}{map[string]interface{}{"user": "alice", "time": lastLogin}},
+				},
+			},
+		}
+	}
+	hash := func(r *QueryResult) string {
+		h, err := r.ContentHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	base := hash(result("1700000000", 300))
+	if got := hash(result("1700000000", 300)); got != base {
+		t.Error("expected equal results to hash equally")
+	}
+	if got := hash(result("1700086400", 300)); got != base {
+		t.Error("expected a new login time not to change the hash")
+	}
+	if got := hash(result("1700000000", 600)); got == base {
+		t.Error("expected a changed setting to change the hash")
+	}
+	changedVersion := result("1700000000", 300)
+	changedVersion.DrataAgentVersion = "1.0.1"
+	if got := hash(changedVersion); got == base {
+		t.Error("expected a new agent version to change the hash")
+	}
+
+	// The volatile field is left out of the hash, not the payload
+	r := result("1700000000", 300)
+	hash(r)
+	if _, ok := r.RawQueryResults["sessionInfo"].(map[string]interface{})["lastLogins"]; !ok {
+		t.Error("expected hashing not to modify the result")
+	}
+}
+
+func TestParseLocaleInfo(t *testing.T) {
+	value := func(b *bool) interface{} { return boolOrNull(b) }
+
+	show := "Timezone=Europe/London\nLocalRTC=no\nCanNTP=yes\nNTP=yes\nNTPSynchronized=no\nTimeUSec=Mon 2024-01-01 10:00:00 GMT\n"
+	tz, enabled, synced := parseTimedatectl(show)
+	if tz != "Europe/London" || value(enabled) != true || value(synced) != false {
+		t.Errorf("unexpected timedatectl show: %q, %v, %v", tz, value(enabled), value(synced))
+	}
+
+	status := `               Local time: Mon 2024-01-01 05:00:00 EST
+           Universal time: Mon 2024-01-01 10:00:00 UTC
+                 Time zone: America/New_York (EST, -0500)
+System clock synchronized: yes
+              NTP service: active
+          RTC in local TZ: no`
+	tz, enabled, synced = parseTimedatectl(status)
+	if tz != "America/New_York" || value(enabled) != true || value(synced) != true {
+		t.Errorf("unexpected timedatectl status: %q, %v, %v", tz, value(enabled), value(synced))
+	}
+	oldStatus := "     Time zone: UTC (UTC, +0000)\n   NTP enabled: no\nNTP synchronized: no\n"
+	tz, enabled, synced = parseTimedatectl(oldStatus)
+	if tz != "UTC" || value(enabled) != false || value(synced) != false {
+		t.Errorf("unexpected older timedatectl status: %q, %v, %v", tz, value(enabled), value(synced))
+	}
+	if _, enabled, _ := parseTimedatectl("NTP service: n/a\n"); enabled != nil {
+		t.Errorf("expected no time sync service to be unknown, got %v", *enabled)
+	}
+
+	if got := parseLocaleConf("# comment\nLANG=\"en_GB.UTF-8\"\nLC_TIME=C\n"); got != "en_GB.UTF-8" {
+		t.Errorf("unexpected locale: %q", got)
+	}
+	if got := timezoneFromZoneinfoPath("/var/db/timezone/zoneinfo/Europe/Paris"); got != "Europe/Paris" {
+		t.Errorf("unexpected time zone: %q", got)
+	}
+	if got := timezoneFromZoneinfoPath("/etc/custom-localtime"); got != "" {
+		t.Errorf("expected no time zone, got %q", got)
+	}
+}
+
+func TestParseTimeSync(t *testing.T) {
+	tracking := parseChronyTracking(`Reference ID    : A29FC87B (time.cloudflare.com)
+Stratum         : 4
+System time     : 0.000250000 seconds slow of NTP time
+Leap status     : Normal`)
+	if !tracking.synchronized || tracking.source != "time.cloudflare.com" || tracking.offsetSeconds == nil || *tracking.offsetSeconds != -0.00025 {
+		t.Errorf("unexpected chrony tracking: %+v", tracking)
+	}
+	if tracking := parseChronyTracking("Reference ID    : 00000000 ()\nLeap status     : Not synchronised\n"); tracking.synchronized {
+		t.Error("expected chrony not synchronised")
+	}
+
+	if got := parseNTPConfServer("# ntp.conf\nserver time.euro.apple.com.\n"); got != "time.euro.apple.com" {
+		t.Errorf("unexpected ntp.conf server: %q", got)
+	}
+	if offset, ok := parseSntpOffset("+0.001816 +/- 0.024368 time.apple.com 17.253.34.253"); !ok || offset != 0.001816 {
+		t.Errorf("unexpected sntp offset: %v, %v", offset, ok)
+	}
+	if offset, ok := parseSntpOffset("-2.5 +/- 0.03 time.apple.com 17.253.34.253"); !ok || offset != -2.5 {
+		t.Errorf("unexpected negative sntp offset: %v, %v", offset, ok)
+	}
+	if _, ok := parseSntpOffset("sntp: cannot resolve time.apple.com"); ok {
+		t.Error("expected an sntp error not to parse")
+	}
+
+	w32tm := "Leap Indicator: 0(no warning)\r\nStratum: 4 (secondary reference - syncd by (S)NTP)\r\nPhase Offset: 0.0001234s\r\nSource: time.windows.com,0x9\r\nPoll Interval: 10 (1024s)\r\n"
+	status, ok := parseW32tmStatus(w32tm)
+	if !ok || !status.synchronized || status.source != "time.windows.com,0x9" || status.offsetSeconds == nil || *status.offsetSeconds != 0.0001234 {
+		t.Errorf("unexpected w32tm status: %+v, %v", status, ok)
+	}
+	unsynced := "Leap Indicator: 3(not synchronized)\r\nSource: Free-running System Clock\r\n"
+	if status, ok := parseW32tmStatus(unsynced); !ok || status.synchronized || status.source != "Free-running System Clock" {
+		t.Errorf("unexpected unsynchronized w32tm status: %+v, %v", status, ok)
+	}
+	if _, ok := parseW32tmStatus("Indicateur de saut : 0\r\n"); ok {
+		t.Error("expected localized w32tm output not to parse")
+	}
+}
+
+func TestParseUpdateHistory(t *testing.T) {
+	apt := "Start-Date: 2024-05-01  10:00:00\n" +
+		"Commandline: /usr/bin/unattended-upgrade\n" +
+		"Upgrade: libssl3:amd64 (3.0.2-0ubuntu1.14, 3.0.2-0ubuntu1.15), openssl:amd64 (3.0.2-0ubuntu1.14, 3.0.2-0ubuntu1.15)\n" +
+		"End-Date: 2024-05-01  10:00:05\n\n" +
+		"Start-Date: 2024-05-02  09:30:00\n" +
+		"Commandline: apt install htop\n" +
+		"Install: htop:amd64 (3.0.5-7build2)\n" +
+		"End-Date: 2024-05-02  09:30:02\n\n" +
+		"Start-Date: 2024-05-03  08:00:00\n" +
+		"Commandline: apt upgrade\n" +
+		"Upgrade: curl:amd64 (7.81.0-1ubuntu1.15, 7.81.0-1ubuntu1.16)\n" +
+		"End-Date: 2024-05-03  08:00:04\n"
+	updates := parseAptHistory(apt, time.UTC, true)
+	if len(updates) != 3 {
+		t.Fatalf("expected 3 upgraded packages, got %+v", updates)
+	}
+	if u := updates[0]; u.name != "libssl3" || u.version != "3.0.2-0ubuntu1.15" || u.updateType != updateTypeSecurity || u.installedAt.Format(updateDateLayout) != "2024-05-01" {
+		t.Errorf("unexpected unattended upgrade: %+v", u)
+	}
+	if u := updates[2]; u.name != "curl" || u.updateType != updateTypeUpdate {
+		t.Errorf("unexpected manual upgrade: %+v", u)
+	}
+	if u := parseAptHistory(apt, time.UTC, false)[0]; u.updateType != updateTypeUpdate {
+		t.Errorf("expected an unattended upgrade from any origin not to be a security update: %+v", u)
+	}
+
+	transactions := parseRPMHistoryList("ID     | Command line             | Date and time    | Action(s)      | Altered\n"+
+		"--------------------------------------------------------------------------------\n"+
+		"     7 | upgrade -y               | 2024-05-01 10:00 | I, U           |   12\n"+
+		"     6 | install htop             | 2024-04-30 09:00 | Install        |    1\n"+
+		"     5 | update openssl           | 2024-04-29 08:00 | Upgrade        |    2\n", time.UTC)
+	if len(transactions) != 2 || transactions[0].id != 7 || transactions[1].id != 5 || transactions[0].startedAt.Format(updateDateLayout) != "2024-05-01" {
+		t.Errorf("unexpected upgrade transactions: %+v", transactions)
+	}
+	installedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	dnfInfo := "Transaction ID : 7\nPackages Altered:\n    Install  htop-3.3.0-1.el9.x86_64          @epel\n" +
+		"    Upgrade  openssl-1:3.0.7-25.el9.x86_64    @baseos\n    Upgraded openssl-1:3.0.7-24.el9.x86_64    @@System\n"
+	if rpm := parseRPMHistoryUpgrades(dnfInfo, installedAt); len(rpm) != 1 || rpm[0].name != "openssl" || rpm[0].version != "3.0.7-25.el9" || !rpm[0].installedAt.Equal(installedAt) {
+		t.Errorf("unexpected dnf upgrades: %+v", rpm)
+	}
+	yumInfo := "Packages Altered:\n    Updated openssl-1:1.0.2k-19.el7.x86_64 @base\n    Update          1:1.0.2k-21.el7_9.x86_64 @updates\n    Install bash-4.2.46-35.el7_9.x86_64 @updates\n"
+	if rpm := parseRPMHistoryUpgrades(yumInfo, installedAt); len(rpm) != 1 || rpm[0].name != "openssl" || rpm[0].version != "1.0.2k-21.el7_9" {
+		t.Errorf("unexpected yum upgrades: %+v", rpm)
+	}
+
+	ubuntu := `Unattended-Upgrade::Allowed-Origins "";
+Unattended-Upgrade::Allowed-Origins:: "${distro_id}:${distro_codename}";
+Unattended-Upgrade::Allowed-Origins:: "${distro_id}:${distro_codename}-security";
+Unattended-Upgrade::Allowed-Origins:: "${distro_id}ESMApps:${distro_codename}-apps-security";
+`
+	if !unattendedUpgradesSecurityOnly(ubuntu) {
+		t.Error("expected Ubuntu's default origins to be security only")
+	}
+	if unattendedUpgradesSecurityOnly(ubuntu + `Unattended-Upgrade::Allowed-Origins:: "${distro_id}:${distro_codename}-updates";` + "\n") {
+		t.Error("expected the updates pocket not to be security only")
+	}
+	debian := `Unattended-Upgrade::Origins-Pattern:: "origin=Debian,codename=${distro_codename},label=Debian";
+Unattended-Upgrade::Origins-Pattern:: "origin=Debian,codename=${distro_codename},label=Debian-Security";
+`
+	if unattendedUpgradesSecurityOnly(debian) || unattendedUpgradesSecurityOnly("") {
+		t.Error("expected Debian's default origins and no origins not to be security only")
+	}
+	advisories := "RHSA-2024:1234 Important/Sec. openssl-1:3.0.7-25.el9.x86_64\nRHSA-2024:1300 Moderate/Sec.  glibc-2.34-100.el9.x86_64\n"
+	want := map[string]bool{"openssl-3.0.7-25.el9": true, "glibc-2.34-100.el9": true}
+	if got := parseSecurityAdvisoryPackages(advisories); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected advisory packages %v, got %v", want, got)
+	}
+
+	history := "Display Name                                       Version    Date\n" +
+		"------------                                       -------    ----\n" +
+		"macOS Sonoma 14.4.1                                14.4.1     04/02/2024, 10:15:32\n" +
+		"XProtectPlistConfigData                            2178       03/27/2024, 08:41:02\n" +
+		"Safari                                             17.4       le 27/03/2024 à 08:41\n"
+	mac, unparsed := parseSoftwareUpdateHistory(history)
+	if len(mac) != 2 || unparsed != 1 {
+		t.Fatalf("expected 2 updates and 1 unparsed, got %+v, %d", mac, unparsed)
+	}
+	if mac[0].name != "macOS Sonoma 14.4.1" || mac[0].updateType != updateTypeUpdate || mac[0].installedAt.Format(updateDateLayout) != "2024-04-02" {
+		t.Errorf("unexpected macOS update: %+v", mac[0])
+	}
+	if mac[1].updateType != updateTypeSecurity {
+		t.Errorf("expected XProtect to be a security update, got %+v", mac[1])
+	}
+
+	hotfixes := `[{"HotFixID": "KB5036893", "Description": "Security Update", "InstalledOn": "2024-04-10"},
+		{"HotFixID": "KB5011048", "Description": "Update", "InstalledOn": "2024-03-01"},
+		{"HotFixID": "KB5000000", "Description": "Update", "InstalledOn": null}]`
+	windows, ok := parseHotFixes(hotfixes)
+	if !ok || len(windows) != 2 || windows[0].name != "KB5036893" || windows[0].updateType != updateTypeSecurity || windows[1].updateType != updateTypeUpdate {
+		t.Errorf("unexpected hotfixes: %+v, %v", windows, ok)
+	}
+	if _, ok := parseHotFixes("Get-HotFix : Access denied"); ok {
+		t.Error("expected non-JSON Get-HotFix output to be rejected")
+	}
+}
+
+func TestParseAutoUpdateSettings(t *testing.T) {
+	aptPeriodic := "APT::Periodic \"\";\nAPT::Periodic::Update-Package-Lists \"1\";\nAPT::Periodic::Unattended-Upgrade \"7\";\nAPT::Periodic::Download-Upgradeable-Packages \"always\";\n"
+	want := map[string]int{"Update-Package-Lists": 1, "Unattended-Upgrade": 7}
+	if got := parseAptPeriodic(aptPeriodic); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	boot := func() (time.Time, bool) { return time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true }
+	show := "LoadState=loaded\nUnitFileState=enabled\nTimersCalendar={ OnCalendar=*-*-* 06:00:00 ; next_elapse=@1714629600 }\nLastTriggerUSec=@1714543954\nLastTriggerUSecMonotonic=6h 12min 34.123456s\n"
+	timer, ok := parseSystemdTimer(show, boot)
+	if !ok || !timer.enabled || timer.schedule != "*-*-* 06:00:00" || timer.lastRun != "2024-05-01T06:12:34Z" {
+		t.Errorf("unexpected timer: %+v, %v", timer, ok)
+	}
+	// Before systemd 251, LastTriggerUSec is in the local time zone
+	monotonic := "LoadState=loaded\nUnitFileState=enabled\nLastTriggerUSec=Wed 2024-05-01 08:12:34 CEST\nLastTriggerUSecMonotonic=6h 12min 34.123456s\n"
+	if timer, ok := parseSystemdTimer(monotonic, boot); !ok || timer.lastRun != "2024-05-01T06:12:34Z" {
+		t.Errorf("unexpected timer read since boot: %+v, %v", timer, ok)
+	}
+	neverRun := "LoadState=loaded\nUnitFileState=disabled\nTimersCalendar=\nLastTriggerUSec=n/a\nLastTriggerUSecMonotonic=0\n"
+	if timer, ok := parseSystemdTimer(neverRun, boot); !ok || timer.enabled || timer.schedule != "" || timer.lastRun != "" {
+		t.Errorf("unexpected disabled timer: %+v, %v", timer, ok)
+	}
+	if _, ok := parseSystemdTimer("LoadState=not-found\nUnitFileState=\n", boot); ok {
+		t.Error("expected a missing unit not to be reported")
+	}
+	if span, ok := parseSystemdTimespan("1d 2h 3min 4.5s"); !ok || span != 26*time.Hour+3*time.Minute+4500*time.Millisecond {
+		t.Errorf("unexpected timespan: %v, %v", span, ok)
+	}
+	if span, ok := parseSystemdTimespan("1500000"); !ok || span != 1500*time.Millisecond {
+		t.Errorf("unexpected timespan in microseconds: %v, %v", span, ok)
+	}
+	if _, ok := parseSystemdTimespan("soon"); ok {
+		t.Error("expected an invalid timespan to be rejected")
+	}
+
+	conf := "[commands]\n# upgrade_type = default\nupgrade_type = security\napply_updates = yes\n\n[emitters]\napply_updates = no\n"
+	values := parseINIValues(conf, "commands")
+	if values["upgrade_type"] != "security" || values["apply_updates"] != "yes" {
+		t.Errorf("unexpected dnf-automatic settings: %v", values)
+	}
+}
+
+func TestIncompleteChecks(t *testing.T) {
+	rawResults := map[string]interface{}{
+		"screenLockStatus":   map[string]interface{}{"indeterminate": true, "reason": "no desktop session"},
+		"screenLockSettings": map[string]interface{}{"indeterminate": true, "reason": "no desktop session"},
+		"firewallStatus":     map[string]interface{}{"notApplicable": true},
+		"auditLogging":       map[string]interface{}{"insufficientPrivileges": true},
+		"timeSyncStatus":     map[string]interface{}{"insufficientPrivileges": false, "synchronized": true},
+		"osVersion":          map[string]interface{}{"name": "Ubuntu"},
+	}
+	resultKeys := map[string][]string{
+		"screenLock":     {"screenLockStatus", "screenLockSettings"},
+		"firewall":       {"firewallStatus"},
+		"auditLogging":   {"auditLogging"},
+		"timeSyncStatus": {"timeSyncStatus"},
+		"osVersion":      {"osVersion"},
+	}
+	unavailable := unavailableChecks(rawResults, resultKeys)
+	want := map[string]string{
+		"screenLock":   "no desktop session",
+		"firewall":     "not applicable",
+		"auditLogging": "insufficient privileges; run the agent as root or Administrator",
+	}
+	if !reflect.DeepEqual(unavailable, want) {
+		t.Errorf("unavailable = %v, want %v", unavailable, want)
+	}
+
+	// An error only makes a check incomplete when its result is missing
+	result := &QueryResult{
+		SkippedChecks:     []string{"appList"},
+		EmptyChecks:       []string{"antivirus", "encryption"},
+		CheckErrors:       map[string]string{"antivirus": "command error: not found", "firewall": "command error: ufw", "screenLock": "command error: logname"},
+		UnavailableChecks: map[string]string{"screenLock": "no desktop session"},
+	}
+	want = map[string]string{
+		"appList":    "skipped, collection budget spent",
+		"antivirus":  "command error: not found",
+		"encryption": "produced no data",
+		"screenLock": "no desktop session",
+	}
+	if got := result.IncompleteChecks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("IncompleteChecks() = %v, want %v", got, want)
+	}
+}
+
+func TestParseConsoleLock(t *testing.T) {
+	powercfg := "Power Setting GUID: 0e796bdb-100d-47d6-a2d5-f7d2daa51f51  (Require a password on wakeup)\r\n" +
+		"  Possible Setting Index: 000\r\n  Possible Setting Friendly Name: No\r\n" +
+		"  Possible Setting Index: 001\r\n  Possible Setting Friendly Name: Yes\r\n" +
+		"Current AC Power Setting Index: 0x00000001\r\nCurrent DC Power Setting Index: 0x00000000\r\n"
+	lock := parsePowercfgConsoleLock(powercfg)
+	if lock.ac == nil || !*lock.ac || lock.dc == nil || *lock.dc {
+		t.Errorf("expected AC on and DC off, got %v, %v", lock.ac, lock.dc)
+	}
+	if lock := parsePowercfgConsoleLock("Invalid Parameters -- try \"/?\" for help"); lock.ac != nil || lock.dc != nil {
+		t.Errorf("expected unknown console lock, got %+v", lock)
+	}
+
+	policy := parseConsoleLockPolicy(map[string]string{"ACSettingIndex": "1"})
+	if policy.ac == nil || !*policy.ac || policy.dc != nil {
+		t.Errorf("expected only the AC policy, got %+v", policy)
+	}
+}
+
+func TestDeriveAgentID(t *testing.T) {
+	identifiers := &AgentDeviceIdentifiers{}
+	identifiers.HWSerial.HardwareSerial = "To Be Filled By O.E.M."
+	identifiers.HWSerial.BoardSerial = "BOARD123"
+	identifiers.MacAddress.Mac = "00:11:22:33:44:55"
+
+	id, source := DeriveAgentID(identifiers, "device-uuid")
+	if source != AgentIDSourceBoardSerial || len(id) != 32 {
+		t.Errorf("expected a board serial ID, got %q from %q", id, source)
+	}
+	if again, _ := DeriveAgentID(identifiers, ""); again != id {
+		t.Errorf("expected the same ID from the same serial, got %q and %q", id, again)
+	}
+
+	identifiers.HWSerial.BoardSerial = ""
+	if _, source := DeriveAgentID(identifiers, "device-uuid"); source != AgentIDSourceMac {
+		t.Errorf("expected the MAC address, got %q", source)
+	}
+	if _, source := DeriveAgentID(nil, "device-uuid"); source != AgentIDSourceDeviceUUID {
+		t.Errorf("expected the device UUID, got %q", source)
+	}
+	if id, source := DeriveAgentID(nil, ""); id != "" || source != "" {
+		t.Errorf("expected no ID, got %q from %q", id, source)
+	}
+}
+
+func TestQueryResultDeviceIdentifiers(t *testing.T) {
+	result := &QueryResult{
+		RawQueryResults: map[string]interface{}{
+			"hwSerial":    map[string]interface{}{"hardware_serial": "C02XYZ"},
+			"boardSerial": "BOARD123",
+			"macAddress":  map[string]interface{}{"mac": "00:11:22:33:44:55"},
+		},
+		DeviceName: "ops-laptop-42",
+	}
+	identifiers := result.DeviceIdentifiers()
+	if identifiers.HWSerial.HardwareSerial != "C02XYZ" || identifiers.HWSerial.BoardSerial != "BOARD123" ||
+		identifiers.MacAddress.Mac != "00:11:22:33:44:55" || identifiers.DeviceName != "ops-laptop-42" {
+		t.Errorf("unexpected identifiers: %+v", identifiers)
+	}
+
+	// Checks that were not collected leave their identifiers blank
+	identifiers = (&QueryResult{RawQueryResults: map[string]interface{}{}}).DeviceIdentifiers()
+	if _, source := DeriveAgentID(identifiers, "device-uuid"); source != AgentIDSourceDeviceUUID {
+		t.Errorf("expected the device UUID without collected identifiers, got %q", source)
+	}
+}
+
+// countingRunner replays a fixture and counts the commands run.
+type countingRunner struct {
+	*fixtureRunner
+	commands map[string]int
+}
+
+func (r *countingRunner) command(ctx context.Context, command string) ([]byte, []byte, int, error) {
+	r.commands[command]++
+	return r.fixtureRunner.command(ctx, command)
+}
+
+func TestLocaleInfoReusesTimeSync(t *testing.T) {
+	runner := &countingRunner{
+		fixtureRunner: &fixtureRunner{fixture: collectorFixture{Commands: map[string]fixtureCommand{
+			"systemsetup -getusingnetworktime":  {Output: "Network Time: On\n"},
+			"systemsetup -getnetworktimeserver": {Output: "Network Time Server: time.apple.com\n"},
+			"sntp -t 5 time.apple.com":          {Output: "+0.012345 +/- 0.020000 time.apple.com 17.253.4.125\n"},
+		}}},
+		commands: make(map[string]int),
+	}
+	c := &Client{platform: PlatformMacOS, runner: runner, checkFilter: CheckFilter{Enabled: []string{"timeSyncStatus", "localeInfo"}}}
+	checks, err := platformChecks(PlatformMacOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawResults, _, _, _, _, _ := c.runChecks(checks)
+
+	for command, runs := range runner.commands {
+		if runs > 1 {
+			t.Errorf("%s ran %d times, want once", command, runs)
+		}
+	}
+	locale, _ := rawResults["localeInfo"].(map[string]interface{})
+	if locale["timeSource"] != "time.apple.com" || locale["timeSyncEnabled"] != true {
+		t.Errorf("expected the time sync state in localeInfo, got %v", locale)
+	}
+}
+
+func TestContentHashRealisticPayload(t *testing.T) {
+	// A Linux payload as collected, with what differs between two
+	// collections on an unchanged machine as placeholders
+	const payload = `{
+		"schemaVersion": 2,
+		"drataAgentVersion": "3.9.9-cli",
+		"platform": "LINUX",
+		"deviceName": "ops-laptop-42",
+		"rawQueryResults": {
+			"osVersion": {"name": "Ubuntu", "version": "22.04.4 LTS (Jammy Jellyfish)", "major": "22", "minor": "4"},
+			"hwSerial": {"hardware_serial": "PF3ABCDE"},
+			"firewallStatus": {"passed": true, "status": "active", "type": "ufw"},
+			"diskEncryption": [{"name": "/dev/nvme0n1p3", "encrypted": 1, "type": "LUKS2"}],
+			"screenLockSettings": {"screenLockEnabled": true, "idleDelaySeconds": 300, "requirePasswordOnWake": true},
+			"sessionInfo": {
+				"currentUser": "alice",
+				"loggedInUsers": ["alice"],
+				"lastLogins": [{"user": "alice", "time": "%[1]s"}, {"user": "root", "time": "1700000000"}],
+				"userLoggedIn": true
+			},
+			"timeSyncStatus": {"enabled": true, "synchronized": true, "source": "ntp.ubuntu.com", "offsetSeconds": %[2]s, "insufficientPrivileges": false, "notes": null},
+			"localeInfo": {"timezone": "Europe/London", "locale": "en_GB.UTF-8", "timeSyncEnabled": true, "timeSynchronized": true, "timeSource": "ntp.ubuntu.com", "insufficientPrivileges": false, "notes": null},
+			"autoUpdateSettings": [
+				{"gnomeSoftwareDownloadUpdates": "true"},
+				{"unattendedUpgrades": {"installed": true, "enabled": %[4]s, "intervalDays": 1, "updatePackageListsDays": 1, "lastRun": "%[3]s"}}
+			],
+			"processSnapshot": {"processes": [{"name": "firefox", "path": "/usr/lib/firefox/firefox"}, {"name": "%[5]s", "path": "/usr/bin/%[5]s"}], "totalExecutables": 212, "truncated": false, "hashes": false}
+		}
+	}`
+	hash := func(login, offset, lastRun, enabled, process string) string {
+		t.Helper()
+		result, err := ParseQueryResult([]byte(fmt.Sprintf(payload, login, offset, lastRun, enabled, process)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := result.ContentHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	base := hash("1712000000", "0.0021", "2024-04-01T06:12:00Z", "true", "bash")
+	if got := hash("1712086400", "-0.0134", "2024-04-02T06:09:00Z", "true", "vim"); got != base {
+		t.Error("expected a new login, clock offset, timer run, and processes not to change the hash")
+	}
+	if got := hash("1712000000", "0.0021", "2024-04-01T06:12:00Z", "false", "bash"); got == base {
+		t.Error("expected disabling unattended upgrades to change the hash")
+	}
+}
+
+func TestGraphicalSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties string
+		want       string
+	}{
+		{"active wayland", "Name=alice\nType=wayland\nActive=yes\nRemote=no\n", "alice"},
+		{"active x11", "Name=bob\nType=x11\nActive=yes\nRemote=no\n", "bob"},
+		{"greeter in the background", "Name=gdm\nType=wayland\nActive=no\nRemote=no\n", ""},
+		{"ssh login", "Name=admin\nType=tty\nActive=yes\nRemote=yes\n", ""},
+		{"remote desktop", "Name=carol\nType=x11\nActive=yes\nRemote=yes\n", ""},
+		{"root", "Name=root\nType=x11\nActive=yes\nRemote=no\n", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := graphicalSession(tt.properties); got != tt.want {
+				t.Errorf("graphicalSession() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetDesktopSession(t *testing.T) {
+	current, err := osuser.Current()
+	if err != nil {
+		t.Skipf("current user unknown: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		user        string
+		unavailable string
+		want        string
+	}{
+		{"collecting as the desktop user", current.Username, "", ""},
+		{"collecting as another user", "alice", "", "collecting as " + current.Username + ", which cannot read the settings of desktop user alice"},
+		{"no desktop session", "", "nobody is logged in", "nobody is logged in"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{platform: PlatformLinux}
+			c.SetDesktopSession(tt.user, tt.unavailable)
+			if got := c.desktopSessionUnavailable(); got != tt.want {
+				t.Errorf("desktopSessionUnavailable() = %q, want %q", got, tt.want)
+			}
+			if _, err := c.runGsettingsCommand("get org.gnome.desktop.session idle-delay"); tt.want != "" && (err == nil || err.Error() != tt.want) {
+				t.Errorf("runGsettingsCommand() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
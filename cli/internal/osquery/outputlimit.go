@@ -0,0 +1,97 @@
+package osquery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+)
+
+// ErrOutputTooLarge is returned for a query or command whose output
+// exceeded the limit set with SetMaxOutputBytes.
+var ErrOutputTooLarge = errors.New("output exceeded max_query_output_bytes")
+
+// maxStderrBytes caps how much of a process's standard error is kept for
+// error messages.
+const maxStderrBytes = 64 << 10
+
+// outputWaitDelay bounds how long a killed process's children may hold its
+// output open before the process's pipes are closed.
+const outputWaitDelay = 2 * time.Second
+
+// SetMaxOutputBytes sets the size above which the output of a query or
+// command fails it with ErrOutputTooLarge. Zero disables the limit.
+func (c *Client) SetMaxOutputBytes(limit int) {
+	c.maxOutputBytes = limit
+}
+
+// limitedBuffer collects output up to limit bytes. Once a write would go
+// past it, the buffer calls stop and fails every write.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	exceeded bool
+	stop     func()
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.exceeded || (b.limit > 0 && b.buf.Len()+len(p) > b.limit) {
+		if !b.exceeded {
+			b.exceeded = true
+			if b.stop != nil {
+				b.stop()
+			}
+		}
+		return 0, ErrOutputTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+// truncatingBuffer keeps the first limit bytes written and discards the
+// rest, so a noisy process cannot grow it without bound.
+type truncatingBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *truncatingBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// commandOutput runs the command built by newCmd like exec.Cmd.Output, but
+// kills it and returns an error wrapping ErrOutputTooLarge once its output
+// exceeds the client's limit, so a runaway query cannot exhaust memory. As
+// with Output, an *exec.ExitError carries the process's standard error.
+// description names the query or command in the overrun warning.
+func (c *Client) commandOutput(ctx context.Context, description string, newCmd func(ctx context.Context) *exec.Cmd) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := newCmd(ctx)
+	stdout := &limitedBuffer{limit: c.maxOutputBytes, stop: cancel}
+	stderr := &truncatingBuffer{limit: maxStderrBytes}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = outputWaitDelay
+
+	err := cmd.Run()
+	if stdout.exceeded {
+		log.Printf("Warning: killed %s after its output exceeded %d bytes (max_query_output_bytes)", description, c.maxOutputBytes)
+		return nil, fmt.Errorf("%w (%d bytes)", ErrOutputTooLarge, c.maxOutputBytes)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitErr.Stderr = stderr.buf.Bytes()
+	}
+	return stdout.buf.Bytes(), err
+}
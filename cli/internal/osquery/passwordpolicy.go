@@ -0,0 +1,414 @@
+package osquery
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Linux password policy sources. The PAM stacks differ by distribution:
+// common-* on Debian and Ubuntu, system-auth and password-auth on Red Hat
+// and SUSE derivatives.
+const (
+	loginDefsFile     = "/etc/login.defs"
+	pwqualityConfFile = "/etc/security/pwquality.conf"
+	faillockConfFile  = "/etc/security/faillock.conf"
+)
+
+var linuxPAMFiles = []string{
+	"/etc/pam.d/common-auth",
+	"/etc/pam.d/common-password",
+	"/etc/pam.d/system-auth",
+	"/etc/pam.d/password-auth",
+}
+
+// pam_faillock locks an account after 3 failures for 10 minutes unless
+// configured otherwise.
+const (
+	faillockDefaultDeny          = 3
+	faillockDefaultUnlockSeconds = 600
+)
+
+// linuxNeverExpiresDays is the PASS_MAX_DAYS value, 99999 by default, from
+// which passwords are treated as never expiring.
+const linuxNeverExpiresDays = 99999
+
+// passwordPolicy is the result of the passwordPolicy check. Values that
+// could not be read are nil and reported as null. A maxAgeDays or
+// lockoutThreshold of 0 means passwords never expire or accounts are never
+// locked out.
+type passwordPolicy struct {
+	minLength              *int
+	maxAgeDays             *int
+	lockoutThreshold       *int
+	lockoutDurationMinutes *int
+	// directoryManaged is set when accounts are managed by a directory,
+	// such as Active Directory or SSSD, whose password policy applies to
+	// directory accounts instead of the local one reported here.
+	directoryManaged *bool
+	source           string
+	notes            []string
+}
+
+// collectPasswordPolicy collects the local password complexity, expiry,
+// and account lockout policy. Values that cannot be read are null, with a
+// note, rather than guessed.
+func (c *Client) collectPasswordPolicy(rawResults map[string]interface{}) {
+	var policy passwordPolicy
+	switch c.platform {
+	case PlatformLinux:
+		policy = c.linuxPasswordPolicy()
+	case PlatformMacOS:
+		policy = c.macOSPasswordPolicy()
+	case PlatformWindows:
+		policy = c.windowsPasswordPolicy()
+	default:
+		return
+	}
+	// A lockout duration is meaningless without a lockout threshold
+	if policy.lockoutThreshold != nil && *policy.lockoutThreshold == 0 {
+		policy.lockoutDurationMinutes = nil
+	}
+
+	result := map[string]interface{}{
+		"minLength":              intOrNull(policy.minLength),
+		"maxAgeDays":             intOrNull(policy.maxAgeDays),
+		"lockoutThreshold":       intOrNull(policy.lockoutThreshold),
+		"lockoutDurationMinutes": intOrNull(policy.lockoutDurationMinutes),
+		"directoryManaged":       nil,
+		"source":                 policy.source,
+		"notes":                  policy.notes,
+	}
+	if policy.directoryManaged != nil {
+		result["directoryManaged"] = *policy.directoryManaged
+	}
+	rawResults["passwordPolicy"] = result
+}
+
+// windowsPasswordPolicy reads the local policy from `net accounts`, and
+// whether the machine is joined to a domain, whose policy applies to domain
+// accounts.
+func (c *Client) windowsPasswordPolicy() passwordPolicy {
+	policy := passwordPolicy{source: "net accounts"}
+	if output, err := c.RunCommand("net accounts"); err == nil {
+		parsed, ok := parseNetAccounts(output)
+		if ok {
+			parsed.source = policy.source
+			policy = parsed
+		} else {
+			policy.notes = append(policy.notes, "could not read the policy from net accounts output, which may be in another language")
+		}
+	} else {
+		policy.notes = append(policy.notes, "failed to run net accounts")
+	}
+
+	output, err := c.RunCommand(`powershell -NoProfile -Command "(Get-CimInstance Win32_ComputerSystem).PartOfDomain"`)
+	if err == nil {
+		switch strings.TrimSpace(output) {
+		case "True":
+			policy.directoryManaged = boolPtr(true)
+			policy.notes = append(policy.notes, "joined to a domain; the domain password policy applies to domain accounts")
+		case "False":
+			policy.directoryManaged = boolPtr(false)
+		}
+	}
+	return policy
+}
+
+// parseNetAccounts reads the password length, age, and lockout lines of
+// `net accounts` output. ok is false when none are found, as when Windows
+// is displaying another language.
+func parseNetAccounts(output string) (policy passwordPolicy, ok bool) {
+	for _, line := range strings.Split(output, "\n") {
+		label, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		var field **int
+		switch strings.TrimSpace(label) {
+		case "Minimum password length":
+			field = &policy.minLength
+		case "Maximum password age (days)":
+			field = &policy.maxAgeDays
+		case "Lockout threshold":
+			field = &policy.lockoutThreshold
+		case "Lockout duration (minutes)":
+			field = &policy.lockoutDurationMinutes
+		default:
+			continue
+		}
+		ok = true
+		// Unlimited age and a Never threshold mean no expiry and no lockout
+		if value == "Unlimited" || value == "Never" {
+			*field = intPtr(0)
+		} else if n, err := strconv.Atoi(value); err == nil {
+			*field = intPtr(n)
+		}
+	}
+	return policy, ok
+}
+
+// macOSPasswordPolicy reads the global account policies from pwpolicy,
+// which include those installed by configuration profiles, and whether
+// the Mac is bound to Active Directory.
+func (c *Client) macOSPasswordPolicy() passwordPolicy {
+	policy := passwordPolicy{source: "pwpolicy"}
+	output, err := c.RunCommand("pwpolicy -getaccountpolicies 2>/dev/null")
+	if err != nil {
+		policy.notes = append(policy.notes, "failed to read account policies with pwpolicy")
+	} else {
+		parsed := parsePwpolicy(output)
+		parsed.source = policy.source
+		policy = parsed
+	}
+
+	// dsconfigad prints nothing when the Mac is not bound
+	if output, err := c.RunCommand("dsconfigad -show 2>/dev/null"); err == nil {
+		policy.directoryManaged = boolPtr(strings.Contains(output, "Active Directory Domain"))
+		if *policy.directoryManaged {
+			policy.notes = append(policy.notes, "bound to Active Directory; the domain password policy applies to mobile and network accounts")
+		}
+	}
+	return policy
+}
+
+var (
+	// plistIntegerPattern matches an integer value and its key in a plist.
+	plistIntegerPattern = regexp.MustCompile(`<key>(\w+)</key>\s*<integer>(-?\d+)</integer>`)
+	// pwpolicyLengthPattern matches the minimum length in a password
+	// content policy such as "policyAttributePassword matches '.{8,}+'".
+	pwpolicyLengthPattern = regexp.MustCompile(`policyAttributePassword matches '\.\{(\d+),`)
+)
+
+// parsePwpolicy reads the minimum length, expiry, and lockout from
+// `pwpolicy -getaccountpolicies` output. Policies that are not set are
+// reported as unenforced: no minimum length, expiry, or lockout.
+func parsePwpolicy(output string) passwordPolicy {
+	var policy passwordPolicy
+	for _, match := range plistIntegerPattern.FindAllStringSubmatch(output, -1) {
+		n, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		switch match[1] {
+		case "minimumLength", "minChars":
+			policy.minLength = intPtr(n)
+		case "policyAttributeExpiresEveryNDays":
+			policy.maxAgeDays = intPtr(n)
+		case "policyAttributeMaximumFailedAuthentications", "maxFailedLoginAttempts":
+			policy.lockoutThreshold = intPtr(n)
+		case "autoEnableInSeconds":
+			policy.lockoutDurationMinutes = intPtr(n / 60)
+		}
+	}
+	if policy.minLength == nil {
+		if match := pwpolicyLengthPattern.FindStringSubmatch(output); match != nil {
+			n, _ := strconv.Atoi(match[1])
+			policy.minLength = intPtr(n)
+		}
+	}
+
+	if policy.minLength == nil {
+		policy.minLength = intPtr(0)
+	}
+	if policy.maxAgeDays == nil {
+		policy.maxAgeDays = intPtr(0)
+	}
+	if policy.lockoutThreshold == nil {
+		policy.lockoutThreshold = intPtr(0)
+	}
+	return policy
+}
+
+// linuxPasswordPolicy reads the minimum length and lockout from the PAM
+// stack and the modules' configuration files, and the maximum age from
+// /etc/login.defs, which applies to accounts as they are created.
+func (c *Client) linuxPasswordPolicy() passwordPolicy {
+	policy := passwordPolicy{source: "pam"}
+
+	var stack []string
+	for _, path := range linuxPAMFiles {
+		if content, err := os.ReadFile(path); err == nil {
+			stack = append(stack, string(content))
+		}
+	}
+	if len(stack) == 0 {
+		policy.notes = append(policy.notes, "no PAM configuration found; password length and lockout unknown")
+	} else {
+		pam := parsePAMPasswordPolicy(strings.Join(stack, "\n"))
+		policy.minLength = pam.minLength
+		policy.lockoutThreshold = pam.lockoutThreshold
+		policy.lockoutDurationMinutes = pam.lockoutDurationMinutes
+		policy.directoryManaged = pam.directoryManaged
+
+		// pam_pwquality and pam_faillock read their defaults from their
+		// own configuration files
+		if policy.minLength == nil && pam.pwquality {
+			if minLength := readConfInt(pwqualityConfFile, "minlen"); minLength != nil {
+				policy.minLength = minLength
+			}
+		}
+		if pam.faillock {
+			if policy.lockoutThreshold == nil {
+				policy.lockoutThreshold = readConfInt(faillockConfFile, "deny")
+			}
+			if policy.lockoutThreshold == nil {
+				policy.lockoutThreshold = intPtr(faillockDefaultDeny)
+			}
+			if policy.lockoutDurationMinutes == nil {
+				seconds := readConfInt(faillockConfFile, "unlock_time")
+				if seconds == nil {
+					seconds = intPtr(faillockDefaultUnlockSeconds)
+				}
+				policy.lockoutDurationMinutes = intPtr(*seconds / 60)
+			}
+		}
+		if policy.minLength == nil {
+			policy.notes = append(policy.notes, "no minimum password length is configured in PAM; the module defaults apply")
+		}
+		// Without pam_faillock or a pam_tally2 deny option, nothing locks
+		// accounts out
+		if policy.lockoutThreshold == nil {
+			policy.lockoutThreshold = intPtr(0)
+		}
+		if pam.directoryManaged != nil && *pam.directoryManaged {
+			policy.notes = append(policy.notes, "accounts are also managed by SSSD or winbind; the directory password policy applies to directory accounts")
+		}
+	}
+
+	if content, err := os.ReadFile(loginDefsFile); err == nil {
+		policy.maxAgeDays = parseLoginDefsMaxDays(string(content))
+	}
+	if policy.maxAgeDays == nil {
+		policy.notes = append(policy.notes, "PASS_MAX_DAYS not found in /etc/login.defs; maximum password age unknown")
+	}
+	return policy
+}
+
+// pamPasswordPolicy is what the PAM stack itself configures.
+type pamPasswordPolicy struct {
+	minLength              *int
+	lockoutThreshold       *int
+	lockoutDurationMinutes *int
+	directoryManaged       *bool
+	// pwquality and faillock are set when those modules are in the stack.
+	pwquality bool
+	faillock  bool
+}
+
+// parsePAMPasswordPolicy reads the minlen option of the password quality
+// modules and the deny and unlock_time options of the lockout modules from
+// PAM configuration, and whether SSSD or winbind authenticate accounts.
+// Commented lines are skipped.
+func parsePAMPasswordPolicy(content string) pamPasswordPolicy {
+	policy := pamPasswordPolicy{directoryManaged: boolPtr(false)}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var module string
+		var args []string
+		for i, field := range fields {
+			if strings.HasSuffix(field, ".so") {
+				module = strings.TrimSuffix(field[strings.LastIndex(field, "/")+1:], ".so")
+				args = fields[i+1:]
+				break
+			}
+		}
+
+		switch module {
+		case "pam_pwquality", "pam_cracklib", "pam_unix":
+			if module == "pam_pwquality" {
+				policy.pwquality = true
+			}
+			if n := pamOptionInt(args, "minlen"); n != nil && (policy.minLength == nil || *n > *policy.minLength) {
+				policy.minLength = n
+			}
+		case "pam_faillock", "pam_tally2":
+			if module == "pam_faillock" {
+				policy.faillock = true
+			}
+			if n := pamOptionInt(args, "deny"); n != nil {
+				policy.lockoutThreshold = n
+			}
+			if n := pamOptionInt(args, "unlock_time"); n != nil {
+				policy.lockoutDurationMinutes = intPtr(*n / 60)
+			}
+		case "pam_sss", "pam_winbind":
+			policy.directoryManaged = boolPtr(true)
+		}
+	}
+	return policy
+}
+
+// pamOptionInt returns the integer value of a name=value module option.
+func pamOptionInt(args []string, name string) *int {
+	for _, arg := range args {
+		if value, ok := strings.CutPrefix(arg, name+"="); ok {
+			if n, err := strconv.Atoi(value); err == nil {
+				return intPtr(n)
+			}
+		}
+	}
+	return nil
+}
+
+// readConfInt returns the integer value of a "name = value" setting in a
+// configuration file, or nil if it is not set.
+func readConfInt(path, name string) *int {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var value *int
+	for _, line := range strings.Split(string(content), "\n") {
+		key, raw, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.HasPrefix(key, "#") || strings.TrimSpace(key) != name {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(raw)); err == nil {
+			value = intPtr(n)
+		}
+	}
+	return value
+}
+
+// parseLoginDefsMaxDays returns PASS_MAX_DAYS from /etc/login.defs, with 0
+// for the never-expiring default, or nil if it is not set.
+func parseLoginDefsMaxDays(content string) *int {
+	var days *int
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "PASS_MAX_DAYS" {
+			continue
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		if n < 0 || n >= linuxNeverExpiresDays {
+			n = 0
+		}
+		days = intPtr(n)
+	}
+	return days
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+// intOrNull returns nil for a nil pointer, so unknown values are reported
+// as null.
+func intOrNull(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
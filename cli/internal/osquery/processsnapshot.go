@@ -0,0 +1,99 @@
+package osquery
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ProcessSnapshot configures the opt-in processSnapshot check.
+type ProcessSnapshot struct {
+	// MaxRows caps how many executables are reported.
+	MaxRows int
+	// Hashes adds the SHA-256 of each executable, which reads every one
+	// from disk.
+	Hashes bool
+}
+
+// SetProcessSnapshot sets what the processSnapshot check reports.
+func (c *Client) SetProcessSnapshot(snapshot ProcessSnapshot) {
+	c.processSnapshot = snapshot
+}
+
+// collectProcessSnapshot collects the executables of the running
+// processes, one row per executable with how many processes run it, so
+// that they can be correlated with threat intelligence. Process IDs and
+// command lines are left out: IDs change on every run, and command lines
+// can hold secrets. Kernel threads, which have no executable, and the
+// agent's own processes are excluded.
+func (c *Client) collectProcessSnapshot(rawResults map[string]interface{}) {
+	exclude := []string{c.binaryPath}
+	if self, err := os.Executable(); err == nil {
+		exclude = append(exclude, self)
+	}
+
+	result, err := c.queryFirst("SELECT COUNT(DISTINCT path) AS total FROM processes WHERE " + processSnapshotFilter(exclude))
+	if err != nil || result == nil {
+		return
+	}
+	total, _ := strconv.Atoi(fmt.Sprint(result["total"]))
+
+	rows, err := c.RunQuery(processSnapshotQuery(c.processSnapshot, exclude))
+	if err != nil {
+		return
+	}
+	rawResults["processSnapshot"] = map[string]interface{}{
+		"processes":        parseProcessSnapshot(rows),
+		"totalExecutables": total,
+		"truncated":        c.processSnapshot.MaxRows > 0 && total > c.processSnapshot.MaxRows,
+		"hashes":           c.processSnapshot.Hashes,
+	}
+}
+
+// processSnapshotFilter returns the WHERE condition that drops processes
+// without an executable and those running the excluded paths.
+func processSnapshotFilter(exclude []string) string {
+	condition := "path != ''"
+	for _, path := range exclude {
+		if path != "" {
+			condition += " AND path != '" + strings.ReplaceAll(path, "'", "''") + "'"
+		}
+	}
+	return condition
+}
+
+// processSnapshotQuery returns the query for the distinct executables of
+// the running processes, ordered by path and limited to MaxRows, joining
+// the hash table when hashes are requested.
+func processSnapshotQuery(snapshot ProcessSnapshot, exclude []string) string {
+	query := "SELECT name, path, COUNT(*) AS instances FROM processes WHERE " + processSnapshotFilter(exclude) + " GROUP BY path ORDER BY path"
+	if snapshot.MaxRows > 0 {
+		query += " LIMIT " + strconv.Itoa(snapshot.MaxRows)
+	}
+	if snapshot.Hashes {
+		// Hash only the executables that are reported
+		query = "SELECT p.name, p.path, p.instances, h.sha256 FROM (" + query + ") p LEFT JOIN hash h ON h.path = p.path ORDER BY p.path"
+	}
+	return query
+}
+
+// parseProcessSnapshot converts process snapshot rows into the reported
+// form, with instances as a number and sha256 only where it was read.
+func parseProcessSnapshot(rows []map[string]interface{}) []map[string]interface{} {
+	processes := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		process := map[string]interface{}{
+			"name": row["name"],
+			"path": row["path"],
+		}
+		if instances, err := strconv.Atoi(fmt.Sprint(row["instances"])); err == nil {
+			process["instances"] = instances
+		}
+		if hash, ok := row["sha256"].(string); ok && hash != "" {
+			process["sha256"] = hash
+		}
+		processes = append(processes, process)
+	}
+	return processes
+}
@@ -0,0 +1,133 @@
+package osquery
+
+import (
+	"os"
+	"strings"
+)
+
+// debianRebootRequiredFile is created by update-notifier when an installed
+// update needs a reboot to take effect.
+const debianRebootRequiredFile = "/var/run/reboot-required"
+
+// windowsRebootKeys are registry keys that exist only while a reboot is
+// pending, with the source reported for each.
+var windowsRebootKeys = []struct {
+	key    string
+	source string
+}{
+	{`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Component Based Servicing\RebootPending`, "CBS RebootPending"},
+	{`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\WindowsUpdate\Auto Update\RebootRequired`, "WindowsUpdate RebootRequired"},
+}
+
+// collectRebootRequired collects whether installed updates are waiting on a
+// reboot. The result is unknown (null) rather than false when the platform
+// tooling needed to tell is missing.
+func (c *Client) collectRebootRequired(rawResults map[string]interface{}) {
+	var required *bool
+	var source string
+	switch c.platform {
+	case PlatformLinux:
+		required, source = c.linuxRebootRequired()
+	case PlatformMacOS:
+		required, source = c.macOSRebootRequired()
+	case PlatformWindows:
+		required, source = c.windowsRebootRequired()
+	}
+
+	result := map[string]interface{}{
+		"rebootRequired": nil,
+		"source":         source,
+	}
+	if required != nil {
+		result["rebootRequired"] = *required
+	}
+	rawResults["rebootRequired"] = result
+}
+
+// linuxRebootRequired checks needs-restarting on RPM-based distros and the
+// reboot-required marker file elsewhere.
+func (c *Client) linuxRebootRequired() (*bool, string) {
+	if c.isRPMBasedDistro() {
+		const source = "needs-restarting"
+		_, exitCode, err := c.RunCommandStatus("needs-restarting -r")
+		if err != nil {
+			return nil, source
+		}
+		return needsRestartingResult(exitCode), source
+	}
+
+	_, err := os.Stat(debianRebootRequiredFile)
+	if err == nil {
+		return boolPtr(true), debianRebootRequiredFile
+	}
+	if os.IsNotExist(err) {
+		return boolPtr(false), debianRebootRequiredFile
+	}
+	return nil, debianRebootRequiredFile
+}
+
+// needsRestartingResult interprets the exit code of `needs-restarting -r`,
+// which exits 1 when a reboot is required and 0 when it is not. Any other
+// code, such as 127 when dnf-utils is not installed, means unknown.
+func needsRestartingResult(exitCode int) *bool {
+	switch exitCode {
+	case 0:
+		return boolPtr(false)
+	case 1:
+		return boolPtr(true)
+	default:
+		return nil
+	}
+}
+
+// macOSRebootRequired checks the cached software update list for updates
+// that have been staged and need a restart to install.
+func (c *Client) macOSRebootRequired() (*bool, string) {
+	const source = "softwareupdate"
+	output, err := c.RunCommand("softwareupdate --list --no-scan 2>&1")
+	if err != nil {
+		return nil, source
+	}
+	return boolPtr(hasRestartRequiredUpdate(output)), source
+}
+
+// hasRestartRequiredUpdate reports whether `softwareupdate --list` output
+// contains an update that requires a restart.
+func hasRestartRequiredUpdate(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.ToLower(line)
+		if strings.Contains(line, "action: restart") || strings.Contains(line, "[restart]") {
+			return true
+		}
+	}
+	return false
+}
+
+// windowsRebootRequired checks the registry keys Windows sets while a
+// reboot is pending.
+func (c *Client) windowsRebootRequired() (*bool, string) {
+	for _, k := range windowsRebootKeys {
+		_, exitCode, err := c.RunCommandStatus(`reg query "` + k.key + `" >NUL 2>&1`)
+		if err != nil {
+			return nil, k.source
+		}
+		if exitCode == 0 {
+			return boolPtr(true), k.source
+		}
+	}
+	return boolPtr(false), "registry"
+}
+
+// boolPtr returns a pointer to b.
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// boolOrNull returns *b, or nil for a nil b, for a result field that may be
+// unknown.
+func boolOrNull(b *bool) interface{} {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
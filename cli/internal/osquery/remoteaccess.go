@@ -0,0 +1,344 @@
+package osquery
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Policies of a tool in the remoteAccess check.
+const (
+	remoteAccessSanctioned = "sanctioned"
+	remoteAccessProhibited = "prohibited"
+	remoteAccessUnreviewed = "unreviewed"
+)
+
+// remoteAccessTool describes how to detect a remote-access tool.
+type remoteAccessTool struct {
+	name string
+	// inbound tools are services that accept remote logins, reported
+	// whether or not they are found.
+	inbound bool
+	// apps are parts of the tool's names in the app inventory.
+	apps []string
+	// processes are the names of the tool's service or host processes.
+	processes []string
+	// ports are the TCP ports the tool listens on by default. A socket on
+	// one of them counts only when one of the tool's processes or
+	// portHosts listens on it, or, for an inbound tool, when the process
+	// is unknown, so that another service on the same port is not taken
+	// for the tool.
+	ports []int
+	// portHosts are processes that listen on the tool's ports on its
+	// behalf, as launchd and svchost do.
+	portHosts []string
+}
+
+// remoteAccessTools lists the remote-access tools that are detected.
+var remoteAccessTools = []remoteAccessTool{
+	{
+		name:    "ssh",
+		inbound: true,
+		apps:    []string{"openssh-server"},
+		// sshd on Linux and Windows; launchd listens for it on macOS
+		processes: []string{"sshd", "sshd.exe"},
+		ports:     []int{22},
+		portHosts: []string{"launchd"},
+	},
+	{
+		name:    "rdp",
+		inbound: true,
+		apps:    []string{"xrdp"},
+		// Remote Desktop Services runs in svchost on Windows
+		processes: []string{"xrdp"},
+		ports:     []int{3389},
+		portHosts: []string{"svchost.exe"},
+	},
+	{
+		name:      "vnc",
+		inbound:   true,
+		apps:      []string{"vnc server", "tightvnc", "ultravnc", "tigervnc-server", "x11vnc"},
+		processes: []string{"Xvnc", "x11vnc", "vncserver", "vncserver-x11", "winvnc.exe", "tvnserver.exe", "screensharingd"},
+		ports:     []int{5900},
+		portHosts: []string{"launchd"},
+	},
+	{
+		name:      "teamViewer",
+		apps:      []string{"teamviewer"},
+		processes: []string{"TeamViewer", "teamviewerd", "TeamViewer.exe", "TeamViewer_Service.exe"},
+		ports:     []int{5938},
+	},
+	{
+		name:      "anyDesk",
+		apps:      []string{"anydesk"},
+		processes: []string{"AnyDesk", "anydesk", "AnyDesk.exe"},
+		ports:     []int{7070},
+	},
+	{
+		name:      "chromeRemoteDesktop",
+		apps:      []string{"chrome remote desktop"},
+		processes: []string{"remoting_host.exe", "chrome-remote-desktop-host", "remoting_me2me_host"},
+	},
+	{
+		name:      "splashtop",
+		apps:      []string{"splashtop"},
+		processes: []string{"Splashtop Streamer", "SRService.exe", "SRServer.exe"},
+	},
+	{
+		name:      "logMeIn",
+		apps:      []string{"logmein"},
+		processes: []string{"LogMeIn", "LogMeIn.exe", "LMIGuardianSvc.exe"},
+	},
+	{
+		name:      "screenConnect",
+		apps:      []string{"screenconnect", "connectwise control"},
+		processes: []string{"ScreenConnect.ClientService.exe", "ScreenConnect.WindowsClient.exe", "connectwisecontrol-client"},
+	},
+	{
+		name:      "rustDesk",
+		apps:      []string{"rustdesk"},
+		processes: []string{"RustDesk", "rustdesk", "rustdesk.exe"},
+		ports:     []int{21118},
+	},
+}
+
+// RemoteAccessPolicy lists the remote-access tools the remoteAccess check
+// reports as sanctioned or prohibited. Entries name a detected tool, such
+// as ssh or teamViewer, ignoring case; other entries are detected as apps
+// or processes of that name.
+type RemoteAccessPolicy struct {
+	Sanctioned []string
+	Prohibited []string
+}
+
+// SetRemoteAccessPolicy sets how the remoteAccess check classifies tools.
+func (c *Client) SetRemoteAccessPolicy(policy RemoteAccessPolicy) {
+	c.remoteAccessPolicy = policy
+}
+
+// listeningSocket is a TCP port listening on a non-loopback address, and
+// the name and path of the process listening, where they are known.
+type listeningSocket struct {
+	process string
+	path    string
+	port    int
+}
+
+// remoteAccessEvidence is what the remoteAccess check reads from the
+// system.
+type remoteAccessEvidence struct {
+	apps    []string
+	running map[string]bool
+	sockets []listeningSocket
+	// enabled overrides whether an inbound tool is enabled where the
+	// platform reports it directly, as for Remote Desktop on Windows.
+	enabled map[string]bool
+}
+
+// collectRemoteAccess detects remote-access tools, installed or running,
+// and whether inbound SSH, RDP, and VNC are enabled and listening, and
+// classifies each as the remote access policy says.
+func (c *Client) collectRemoteAccess(rawResults map[string]interface{}) {
+	tools := remoteAccessToolsFor(c.remoteAccessPolicy)
+
+	var processes []string
+	for _, tool := range tools {
+		processes = append(processes, tool.processes...)
+	}
+	evidence := remoteAccessEvidence{
+		running: c.runningProcesses(processes),
+		sockets: c.listeningSockets(),
+		enabled: make(map[string]bool),
+	}
+	// An unreadable inventory leaves installed false rather than failing
+	// the check, since processes and ports still show tools in use
+	evidence.apps, _ = c.installedAppNames()
+	if c.platform == PlatformWindows {
+		if result, err := c.queryFirst(`SELECT data FROM registry WHERE path = 'HKEY_LOCAL_MACHINE\SYSTEM\CurrentControlSet\Control\Terminal Server\fDenyTSConnections'`); err == nil && result != nil {
+			evidence.enabled["rdp"] = result["data"] == "0"
+		}
+	}
+
+	result := evaluateRemoteAccess(c.remoteAccessPolicy, tools, evidence)
+	result["insufficientPrivileges"] = false
+	result["notes"] = []string(nil)
+	// Without root, osquery cannot see which processes own other users'
+	// sockets on macOS
+	if c.platform == PlatformMacOS && os.Geteuid() != 0 {
+		result["insufficientPrivileges"] = true
+		result["notes"] = []string{"the processes listening on ports need root; run the agent as root to report them"}
+	}
+	rawResults["remoteAccess"] = result
+}
+
+// remoteAccessToolsFor returns the known remote-access tools followed by a
+// tool for each policy entry that names none of them, detected by app and
+// process name.
+func remoteAccessToolsFor(policy RemoteAccessPolicy) []remoteAccessTool {
+	tools := append([]remoteAccessTool{}, remoteAccessTools...)
+	for _, entry := range append(append([]string{}, policy.Sanctioned...), policy.Prohibited...) {
+		known := false
+		for _, tool := range tools {
+			if strings.EqualFold(tool.name, entry) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			tools = append(tools, remoteAccessTool{
+				name:      entry,
+				apps:      []string{entry},
+				processes: []string{entry, entry + ".exe"},
+			})
+		}
+	}
+	return tools
+}
+
+// listeningSockets returns the TCP ports listening on non-loopback
+// addresses, with the process listening on each where osquery can see it.
+func (c *Client) listeningSockets() []listeningSocket {
+	rows, err := c.RunQuery("SELECT DISTINCT p.name, p.path, l.port FROM listening_ports l LEFT JOIN processes p ON p.pid = l.pid WHERE l.protocol = 6 AND l.port > 0 AND l.address NOT IN ('127.0.0.1', '::1')")
+	if err != nil {
+		return nil
+	}
+	sockets := make([]listeningSocket, 0, len(rows))
+	for _, row := range rows {
+		port, err := strconv.Atoi(fmt.Sprint(row["port"]))
+		if err != nil {
+			continue
+		}
+		process, _ := row["name"].(string)
+		path, _ := row["path"].(string)
+		sockets = append(sockets, listeningSocket{process: process, path: path, port: port})
+	}
+	return sockets
+}
+
+// evaluateRemoteAccess reports each inbound tool, and each other tool that
+// is installed, running, or listening, with its policy. A tool listens
+// when toolListeningPorts finds a listening socket of it, and
+// an inbound tool is enabled when it is running or listening unless the
+// evidence says otherwise. passed is true when no prohibited tool is
+// found.
+func evaluateRemoteAccess(policy RemoteAccessPolicy, tools []remoteAccessTool, evidence remoteAccessEvidence) map[string]interface{} {
+	reported := []map[string]interface{}{}
+	prohibitedFound := []string{}
+	unsanctionedFound := []string{}
+	for _, tool := range tools {
+		installed := false
+		for _, app := range tool.apps {
+			if appInstalled(app, evidence.apps, false) {
+				installed = true
+				break
+			}
+		}
+		running := false
+		for _, process := range tool.processes {
+			if evidence.running[process] {
+				running = true
+				break
+			}
+		}
+		ports := toolListeningPorts(tool, evidence.sockets)
+		listening := len(ports) > 0
+
+		found := installed || running || listening
+		entry := map[string]interface{}{
+			"name":           tool.name,
+			"inbound":        tool.inbound,
+			"installed":      installed,
+			"running":        running,
+			"listening":      listening,
+			"listeningPorts": ports,
+		}
+		if tool.inbound {
+			enabled, ok := evidence.enabled[tool.name]
+			if !ok {
+				enabled = running || listening
+			}
+			entry["enabled"] = enabled
+			found = enabled || listening
+		} else if !found {
+			continue
+		}
+
+		toolPolicy := remoteAccessToolPolicy(policy, tool.name)
+		entry["policy"] = toolPolicy
+		reported = append(reported, entry)
+		if !found {
+			continue
+		}
+		if toolPolicy == remoteAccessProhibited {
+			prohibitedFound = append(prohibitedFound, tool.name)
+		}
+		if toolPolicy != remoteAccessSanctioned {
+			unsanctionedFound = append(unsanctionedFound, tool.name)
+		}
+	}
+
+	return map[string]interface{}{
+		"tools":             reported,
+		"prohibitedFound":   prohibitedFound,
+		"unsanctionedFound": unsanctionedFound,
+		"passed":            len(prohibitedFound) == 0,
+	}
+}
+
+// toolListeningPorts returns the ports in sockets that one of tool's
+// processes listens on, or that are its default ports with a process
+// the tool accepts listening, in order.
+func toolListeningPorts(tool remoteAccessTool, sockets []listeningSocket) []int {
+	seen := make(map[int]bool)
+	ports := []int{}
+	for _, socket := range sockets {
+		match := socketProcessIs(socket, tool.processes)
+		for _, port := range tool.ports {
+			if socket.port != port {
+				continue
+			}
+			unknown := socket.process == "" && socket.path == ""
+			if socketProcessIs(socket, tool.portHosts) || (tool.inbound && unknown) {
+				match = true
+			}
+		}
+		if match && !seen[socket.port] {
+			seen[socket.port] = true
+			ports = append(ports, socket.port)
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// socketProcessIs reports whether the process listening on socket has one
+// of names, ignoring case, as its name or the last element of its path.
+// The path catches names the process table truncates, as Linux does past
+// 15 characters.
+func socketProcessIs(socket listeningSocket, names []string) bool {
+	base := socket.path[strings.LastIndexAny(socket.path, `/\`)+1:]
+	for _, name := range names {
+		if (socket.process != "" && strings.EqualFold(socket.process, name)) || (base != "" && strings.EqualFold(base, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAccessToolPolicy returns whether policy sanctions or prohibits the
+// named tool, ignoring case. A tool listed as both is prohibited.
+func remoteAccessToolPolicy(policy RemoteAccessPolicy, name string) string {
+	for _, entry := range policy.Prohibited {
+		if strings.EqualFold(entry, name) {
+			return remoteAccessProhibited
+		}
+	}
+	for _, entry := range policy.Sanctioned {
+		if strings.EqualFold(entry, name) {
+			return remoteAccessSanctioned
+		}
+	}
+	return remoteAccessUnreviewed
+}
@@ -0,0 +1,85 @@
+package osquery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxLastLogins limits how many recent logins are reported.
+const maxLastLogins = 10
+
+// collectSessionInfo collects the current interactive user, the users with
+// active sessions and recent login times. Only usernames and timestamps are
+// reported; no terminals, hosts or command history.
+func (c *Client) collectSessionInfo(rawResults map[string]interface{}) {
+	sessionInfo := make(map[string]interface{})
+
+	currentUser := c.getConsoleUser()
+	sessionInfo["currentUser"] = currentUser
+
+	loggedInUsers := make([]string, 0)
+	seen := make(map[string]bool)
+	if result, err := c.RunQuery(c.loggedInUsersQuery()); err == nil {
+		for _, row := range result {
+			user, _ := row["user"].(string)
+			if user != "" && !seen[user] {
+				seen[user] = true
+				loggedInUsers = append(loggedInUsers, user)
+			}
+		}
+	}
+	sessionInfo["loggedInUsers"] = loggedInUsers
+
+	lastLogins := make([]interface{}, 0)
+	if result, err := c.RunQuery(c.lastLoginsQuery()); err == nil {
+		for _, row := range result {
+			lastLogins = append(lastLogins, map[string]interface{}{
+				"user": row["user"],
+				"time": row["time"],
+			})
+		}
+	}
+	sessionInfo["lastLogins"] = lastLogins
+
+	sessionInfo["userLoggedIn"] = currentUser != "" || len(loggedInUsers) > 0
+	rawResults["sessionInfo"] = sessionInfo
+}
+
+// getConsoleUser returns the user of the interactive console session, or
+// an empty string if nobody is logged in at the console.
+func (c *Client) getConsoleUser() string {
+	switch c.platform {
+	case PlatformLinux:
+		return c.getDesktopSessionUser()
+	case PlatformMacOS:
+		// /dev/console is owned by root while loginwindow has no user
+		if output, err := c.RunCommand("stat -f %Su /dev/console"); err == nil {
+			if user := strings.TrimSpace(output); user != "root" {
+				return user
+			}
+		}
+	case PlatformWindows:
+		if result, err := c.queryFirst("SELECT user FROM logged_in_users WHERE type = 'active' AND tty = 'Console'"); err == nil && result != nil {
+			user, _ := result["user"].(string)
+			return user
+		}
+	}
+	return ""
+}
+
+// loggedInUsersQuery returns the query listing users with interactive sessions.
+func (c *Client) loggedInUsersQuery() string {
+	if c.platform == PlatformWindows {
+		return "SELECT user FROM logged_in_users WHERE type = 'active'"
+	}
+	return "SELECT user FROM logged_in_users WHERE type = 'user'"
+}
+
+// lastLoginsQuery returns the query listing the most recent interactive logins.
+func (c *Client) lastLoginsQuery() string {
+	if c.platform == PlatformWindows {
+		return fmt.Sprintf("SELECT user, logon_time AS time FROM logon_sessions WHERE logon_type IN ('Interactive', 'RemoteInteractive', 'CachedInteractive') ORDER BY logon_time DESC LIMIT %d", maxLastLogins)
+	}
+	// Type 7 is USER_PROCESS, an interactive login
+	return fmt.Sprintf("SELECT username AS user, time FROM last WHERE type = 7 ORDER BY time DESC LIMIT %d", maxLastLogins)
+}
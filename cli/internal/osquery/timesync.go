@@ -0,0 +1,324 @@
+package osquery
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// linuxTimedatectlCommand reads the clock settings. timedatectl show needs
+// systemd 239 or later; status works on older ones.
+const linuxTimedatectlCommand = "timedatectl show 2>/dev/null || timedatectl status"
+
+// maxSynchronizedOffsetSeconds is the largest offset from a time server at
+// which a clock whose sync state is only known from its offset, as on
+// macOS, counts as synchronized.
+const maxSynchronizedOffsetSeconds = 1.0
+
+// macOSDefaultTimeServer is the time server macOS uses unless another is
+// configured.
+const macOSDefaultTimeServer = "time.apple.com"
+
+// windowsUnsyncedTimeSources are the w32tm sources reported when the clock
+// is not synchronized with any time server.
+var windowsUnsyncedTimeSources = []string{"Local CMOS Clock", "Free-running System Clock"}
+
+// timeSync is the result of the timeSyncStatus check.
+type timeSync struct {
+	// enabled is whether a time sync service such as NTP is on, and
+	// synchronized whether the clock agrees with its time source. Either is
+	// nil when it could not be read.
+	enabled      *bool
+	synchronized *bool
+	// source names the configured time server or service, where the
+	// platform reports it.
+	source string
+	// offsetSeconds is how far the clock is ahead of its time source, where
+	// the platform reports it; negative when it is behind.
+	offsetSeconds *float64
+	// insufficientPrivileges is set when a source could not be read with
+	// the agent's privileges, so its part of the result is missing rather
+	// than false.
+	insufficientPrivileges bool
+	notes                  []string
+}
+
+// collectTimeSyncStatus collects whether the clock is synchronized with a
+// time source, and which.
+func (c *Client) collectTimeSyncStatus(rawResults map[string]interface{}) {
+	rawResults["timeSyncStatus"] = timeSyncResult(c.timeSyncState())
+}
+
+// timeSyncResult returns sync as the timeSyncStatus result.
+func timeSyncResult(sync timeSync) map[string]interface{} {
+	result := map[string]interface{}{
+		"enabled":                boolOrNull(sync.enabled),
+		"synchronized":           boolOrNull(sync.synchronized),
+		"source":                 nullIfEmpty(sync.source),
+		"offsetSeconds":          nil,
+		"insufficientPrivileges": sync.insufficientPrivileges,
+		"notes":                  sync.notes,
+	}
+	if sync.offsetSeconds != nil {
+		result["offsetSeconds"] = *sync.offsetSeconds
+	}
+	return result
+}
+
+// timeSyncState reads the time sync state for the platform.
+func (c *Client) timeSyncState() timeSync {
+	switch c.platform {
+	case PlatformLinux:
+		return c.linuxTimeSync()
+	case PlatformMacOS:
+		return c.macOSTimeSync()
+	case PlatformWindows:
+		return c.windowsTimeSync()
+	}
+	return timeSync{}
+}
+
+// linuxTimeSync reads the NTP state from timedatectl, and the time server
+// from systemd-timesyncd or chrony. chrony also gives the clock offset, and
+// the NTP state on systems without systemd.
+func (c *Client) linuxTimeSync() timeSync {
+	var sync timeSync
+
+	if output, err := c.withoutErrorLog().RunCommand(linuxTimedatectlCommand); err == nil {
+		_, sync.enabled, sync.synchronized = parseTimedatectl(output)
+	}
+	if output, err := c.withoutErrorLog().RunCommand("timedatectl show-timesync -p ServerName --value"); err == nil {
+		sync.source = output
+	}
+
+	// chronyc tracking fails when chronyd is not running
+	output, exitCode, err := c.RunCommandStatus("chronyc tracking 2>/dev/null")
+	switch {
+	case err != nil || exitCode == 127:
+		if sync.enabled == nil {
+			sync.notes = append(sync.notes, "neither timedatectl nor chrony is available; time sync state unknown")
+		}
+	case exitCode != 0:
+		if sync.enabled == nil {
+			sync.enabled = boolPtr(false)
+		}
+	default:
+		tracking := parseChronyTracking(output)
+		if sync.enabled == nil {
+			sync.enabled = boolPtr(true)
+		}
+		if sync.synchronized == nil {
+			sync.synchronized = boolPtr(tracking.synchronized)
+		}
+		if sync.source == "" {
+			sync.source = tracking.source
+		}
+		sync.offsetSeconds = tracking.offsetSeconds
+	}
+	return sync
+}
+
+// chronyTracking is what `chronyc tracking` reports.
+type chronyTracking struct {
+	synchronized  bool
+	source        string
+	offsetSeconds *float64
+}
+
+// parseChronyTracking reads `chronyc tracking` output: the clock is
+// synchronized unless the leap status says otherwise, the source is the
+// name after the reference ID, and the system time line gives the offset.
+func parseChronyTracking(output string) chronyTracking {
+	var tracking chronyTracking
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Reference ID":
+			// "A29FC87B (time.cloudflare.com)"
+			if _, name, ok := strings.Cut(value, "("); ok {
+				tracking.source = strings.TrimSuffix(name, ")")
+			}
+		case "System time":
+			// "0.000012345 seconds fast of NTP time"
+			fields := strings.Fields(value)
+			if len(fields) >= 3 {
+				if offset, err := strconv.ParseFloat(fields[0], 64); err == nil {
+					if fields[2] == "slow" {
+						offset = -offset
+					}
+					tracking.offsetSeconds = &offset
+				}
+			}
+		case "Leap status":
+			tracking.synchronized = value != "Not synchronised"
+		}
+	}
+	return tracking
+}
+
+// macOSTimeSync reads the time server from systemsetup as root, or from
+// /etc/ntp.conf otherwise, and measures the clock's offset from it with
+// sntp, which decides whether it is synchronized. Whether network time is
+// on needs root.
+func (c *Client) macOSTimeSync() timeSync {
+	var sync timeSync
+
+	if os.Geteuid() == 0 {
+		if output, err := c.RunCommand("systemsetup -getusingnetworktime"); err == nil {
+			// "Network Time: On"
+			_, value, _ := strings.Cut(output, ":")
+			switch strings.TrimSpace(value) {
+			case "On":
+				sync.enabled = boolPtr(true)
+			case "Off":
+				sync.enabled = boolPtr(false)
+			}
+		}
+		if output, err := c.withoutErrorLog().RunCommand("systemsetup -getnetworktimeserver"); err == nil {
+			// "Network Time Server: time.apple.com"
+			_, server, _ := strings.Cut(output, ":")
+			sync.source = strings.TrimSpace(server)
+		}
+	} else {
+		sync.insufficientPrivileges = true
+		sync.notes = append(sync.notes, "whether network time is on needs root; run the agent as root to report it")
+	}
+	if sync.source == "" {
+		if content, err := os.ReadFile("/etc/ntp.conf"); err == nil {
+			sync.source = parseNTPConfServer(string(content))
+		}
+	}
+	server := sync.source
+	if server == "" {
+		server = macOSDefaultTimeServer
+	}
+
+	if output, err := c.withoutErrorLog().RunCommand("sntp -t 5 " + server); err == nil {
+		if offset, ok := parseSntpOffset(output); ok {
+			sync.offsetSeconds = &offset
+			sync.synchronized = boolPtr(math.Abs(offset) <= maxSynchronizedOffsetSeconds)
+		}
+	}
+	if sync.synchronized == nil {
+		sync.notes = append(sync.notes, "could not reach "+server+" with sntp to measure the clock offset")
+	}
+	return sync
+}
+
+// parseNTPConfServer returns the first server in an ntp.conf, or an empty
+// string.
+func parseNTPConfServer(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "server" {
+			return strings.TrimSuffix(fields[1], ".")
+		}
+	}
+	return ""
+}
+
+// parseSntpOffset returns the offset in sntp output such as
+// "+0.001816 +/- 0.024368 time.apple.com 17.253.34.253", which is the
+// field before "+/-".
+func parseSntpOffset(output string) (float64, bool) {
+	fields := strings.Fields(output)
+	for i := 1; i < len(fields); i++ {
+		if fields[i] == "+/-" {
+			offset, err := strconv.ParseFloat(fields[i-1], 64)
+			return offset, err == nil
+		}
+	}
+	return 0, false
+}
+
+// windowsTimeSync reads whether the Windows Time service is running, and
+// its source, sync state, and offset from w32tm.
+func (c *Client) windowsTimeSync() timeSync {
+	var sync timeSync
+
+	running := false
+	if output, err := c.RunCommand("sc query w32time"); err == nil {
+		running = strings.Contains(output, "RUNNING")
+		sync.enabled = boolPtr(running)
+	}
+	if !running {
+		if sync.enabled != nil {
+			sync.synchronized = boolPtr(false)
+		}
+		return sync
+	}
+
+	output, exitCode, err := c.RunCommandStatus("w32tm /query /status /verbose")
+	switch {
+	case err != nil:
+		sync.notes = append(sync.notes, "failed to run w32tm")
+	case exitCode != 0:
+		sync.insufficientPrivileges = true
+		sync.notes = append(sync.notes, "w32tm needs an elevated agent; run it as an administrator or SYSTEM to report time synchronization")
+	default:
+		status, ok := parseW32tmStatus(output)
+		if !ok {
+			sync.notes = append(sync.notes, "could not read w32tm output, which may be in another language")
+			break
+		}
+		sync.source = status.source
+		sync.synchronized = boolPtr(status.synchronized)
+		sync.offsetSeconds = status.offsetSeconds
+		// A running service with no time server is not syncing
+		for _, unsynced := range windowsUnsyncedTimeSources {
+			if status.source == unsynced {
+				sync.enabled = boolPtr(false)
+			}
+		}
+	}
+	return sync
+}
+
+// w32tmStatus is what `w32tm /query /status /verbose` reports.
+type w32tmStatus struct {
+	source        string
+	synchronized  bool
+	offsetSeconds *float64
+}
+
+// parseW32tmStatus reads `w32tm /query /status /verbose` output. The clock
+// is synchronized when the source is a time server and the leap indicator
+// is not 3, which means unsynchronized. The phase offset gives the offset.
+func parseW32tmStatus(output string) (w32tmStatus, bool) {
+	var status w32tmStatus
+	found := false
+	leap := ""
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Source":
+			status.source, found = value, true
+		case "Leap Indicator":
+			leap = value
+		case "Phase Offset":
+			// "0.0001234s"
+			if offset, err := strconv.ParseFloat(strings.TrimSuffix(value, "s"), 64); err == nil {
+				status.offsetSeconds = &offset
+			}
+		}
+	}
+	if !found {
+		return w32tmStatus{}, false
+	}
+	status.synchronized = !strings.HasPrefix(leap, "3")
+	for _, unsynced := range windowsUnsyncedTimeSources {
+		if status.source == unsynced {
+			status.synchronized = false
+		}
+	}
+	return status, true
+}
@@ -0,0 +1,56 @@
+package osquery
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// SetMaxFieldBytes sets the size above which string values in collected
+// results are truncated. Zero disables truncation.
+func (c *Client) SetMaxFieldBytes(limit int) {
+	c.maxFieldBytes = limit
+}
+
+// truncateValue returns value with every string longer than limit bytes,
+// at any depth, cut to limit bytes and marked with the number of bytes
+// dropped. Maps and slices are modified in place.
+func truncateValue(value interface{}, limit int) interface{} {
+	switch v := value.(type) {
+	case string:
+		return truncateString(v, limit)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = truncateValue(item, limit)
+		}
+	case map[string]string:
+		for key, item := range v {
+			v[key] = truncateString(item, limit)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = truncateValue(item, limit)
+		}
+	case []map[string]interface{}:
+		for _, item := range v {
+			truncateValue(item, limit)
+		}
+	case []string:
+		for i, item := range v {
+			v[i] = truncateString(item, limit)
+		}
+	}
+	return value
+}
+
+// truncateString cuts s to at most limit bytes without splitting a UTF-8
+// sequence and appends a marker recording how many bytes were dropped.
+func truncateString(s string, limit int) string {
+	if limit <= 0 || len(s) <= limit {
+		return s
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", s[:cut], len(s)-cut)
+}
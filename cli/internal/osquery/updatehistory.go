@@ -0,0 +1,480 @@
+package osquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxUpdateHistory is how many of the most recently installed updates the
+// updateHistory check lists.
+const maxUpdateHistory = 25
+
+// updateDateLayout is the layout of the dates in updateHistory.
+const updateDateLayout = "2006-01-02"
+
+// Update types in updateHistory.
+const (
+	updateTypeSecurity = "security"
+	updateTypeUpdate   = "update"
+)
+
+// aptHistoryLog is apt's log of package operations on Debian and Ubuntu.
+const aptHistoryLog = "/var/log/apt/history.log"
+
+// installedUpdate is one installed update in updateHistory.
+type installedUpdate struct {
+	installedAt time.Time
+	name        string
+	version     string
+	updateType  string
+}
+
+// updateHistory is the result of the updateHistory check.
+type updateHistory struct {
+	source  string
+	updates []installedUpdate
+	// known is false when the history could not be read at all.
+	known bool
+	notes []string
+}
+
+// collectUpdateHistory collects the most recently installed updates, with
+// the date of the latest one and of the latest security update, from
+// Get-HotFix on Windows, softwareupdate on macOS, and the package manager on
+// Linux. Dates are null when the history cannot be read, with a note.
+func (c *Client) collectUpdateHistory(rawResults map[string]interface{}) {
+	var history updateHistory
+	switch c.platform {
+	case PlatformLinux:
+		history = c.linuxUpdateHistory()
+	case PlatformMacOS:
+		history = c.macOSUpdateHistory()
+	case PlatformWindows:
+		history = c.windowsUpdateHistory()
+	default:
+		return
+	}
+
+	// Newest first, keeping the first of any updates installed together
+	sort.SliceStable(history.updates, func(i, j int) bool {
+		return history.updates[i].installedAt.After(history.updates[j].installedAt)
+	})
+	var lastUpdate, lastSecurityUpdate string
+	for _, update := range history.updates {
+		if lastUpdate == "" {
+			lastUpdate = update.installedAt.Format(updateDateLayout)
+		}
+		if lastSecurityUpdate == "" && update.updateType == updateTypeSecurity {
+			lastSecurityUpdate = update.installedAt.Format(updateDateLayout)
+		}
+	}
+
+	updates := make([]interface{}, 0, maxUpdateHistory)
+	for _, update := range history.updates {
+		if len(updates) == maxUpdateHistory {
+			break
+		}
+		updates = append(updates, map[string]interface{}{
+			"date":    update.installedAt.Format(updateDateLayout),
+			"name":    update.name,
+			"version": nullIfEmpty(update.version),
+			"type":    update.updateType,
+		})
+	}
+	if history.known && len(history.updates) == 0 {
+		history.notes = append(history.notes, "no installed updates found in "+history.source)
+	}
+
+	rawResults["updateHistory"] = map[string]interface{}{
+		"source":                 history.source,
+		"lastUpdateDate":         nullIfEmpty(lastUpdate),
+		"lastSecurityUpdateDate": nullIfEmpty(lastSecurityUpdate),
+		"totalUpdates":           len(history.updates),
+		"updates":                updates,
+		"notes":                  history.notes,
+	}
+}
+
+// linuxUpdateHistory reads dnf's (or yum's) transaction history and
+// installed security advisories on RPM-based distros, and apt's history
+// log elsewhere.
+func (c *Client) linuxUpdateHistory() updateHistory {
+	if c.isRPMBasedDistro() {
+		return c.rpmUpdateHistory()
+	}
+
+	history := updateHistory{source: aptHistoryLog}
+	data, err := os.ReadFile(aptHistoryLog)
+	if err != nil {
+		history.notes = append(history.notes, "apt history log not readable; apt may not be the package manager")
+		return history
+	}
+	history.known = true
+	securityOnly := false
+	if output, err := c.withoutErrorLog().RunCommand("apt-config dump Unattended-Upgrade"); err == nil {
+		securityOnly = unattendedUpgradesSecurityOnly(output)
+	}
+	history.updates = parseAptHistory(string(data), time.Local, securityOnly)
+	history.notes = append(history.notes, "only updates in the current apt history log are listed")
+	if securityOnly {
+		history.notes = append(history.notes, "security updates are those installed by unattended-upgrades, which only installs from security origins")
+	} else {
+		history.notes = append(history.notes, "no updates are marked as security updates, as apt does not log where they came from and unattended-upgrades is not limited to security origins")
+	}
+	return history
+}
+
+// maxUpdateTransactions is how many of the most recent upgrade
+// transactions rpmUpdateHistory reads the packages of.
+const maxUpdateTransactions = 10
+
+// rpmUpdateHistory lists the packages upgraded by the most recent dnf (or
+// yum) transactions, marking as security updates those that dnf (or yum)
+// lists for installed security advisories. Packages newly installed are
+// not updates and are left out. Advisories are read from the metadata
+// cache, without refreshing it.
+func (c *Client) rpmUpdateHistory() updateHistory {
+	history := updateHistory{source: "dnf history"}
+	manager := "dnf"
+	output, err := c.withoutErrorLog().RunCommand("dnf -q history list")
+	if err != nil {
+		manager = "yum"
+		history.source = "yum history"
+		if output, err = c.RunCommand("yum -q history list"); err != nil {
+			history.notes = append(history.notes, "transaction history not available from dnf or yum")
+			return history
+		}
+	}
+	history.known = true
+	transactions := parseRPMHistoryList(output, time.Local)
+	if len(transactions) > maxUpdateTransactions {
+		transactions = transactions[:maxUpdateTransactions]
+		history.notes = append(history.notes, fmt.Sprintf("only the packages of the %d most recent upgrade transactions are listed", maxUpdateTransactions))
+	}
+	for _, transaction := range transactions {
+		info, err := c.RunCommand(fmt.Sprintf("%s -q history info %d", manager, transaction.id))
+		if err != nil {
+			history.notes = append(history.notes, fmt.Sprintf("packages of %s transaction %d not available", manager, transaction.id))
+			continue
+		}
+		history.updates = append(history.updates, parseRPMHistoryUpgrades(info, transaction.startedAt)...)
+	}
+
+	advisories, err := c.withoutErrorLog().RunCommand("dnf -C -q updateinfo list --installed --security 2>/dev/null || yum -C -q updateinfo list installed security 2>/dev/null")
+	if err != nil {
+		history.notes = append(history.notes, "installed security advisories not available from dnf or yum; no updates are marked as security updates")
+		return history
+	}
+	history.source += ", updateinfo"
+	security := parseSecurityAdvisoryPackages(advisories)
+	for i, update := range history.updates {
+		if security[update.name+"-"+update.version] {
+			history.updates[i].updateType = updateTypeSecurity
+		}
+	}
+	return history
+}
+
+// unattendedUpgradesSecurityOnly reports whether `apt-config dump
+// Unattended-Upgrade` output limits unattended-upgrades to security
+// origins. Ubuntu's release pocket, "${distro_id}:${distro_codename}", is
+// allowed by default but does not change after release, so it does not
+// count against this.
+func unattendedUpgradesSecurityOnly(output string) bool {
+	origins := 0
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || (key != "Unattended-Upgrade::Allowed-Origins::" && key != "Unattended-Upgrade::Origins-Pattern::") {
+			continue
+		}
+		value = strings.Trim(strings.TrimSuffix(value, ";"), `"`)
+		if value == "" || value == "${distro_id}:${distro_codename}" {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(value), "security") {
+			return false
+		}
+		origins++
+	}
+	return origins > 0
+}
+
+// parseAptHistory returns the packages upgraded in apt's history log, with
+// the start date of the run that upgraded them, in loc. Packages newly
+// installed rather than upgraded are not updates and are left out. Runs by
+// unattended-upgrades are marked as security updates when securityOnly
+// says it only installs from security origins.
+func parseAptHistory(log string, loc *time.Location, securityOnly bool) []installedUpdate {
+	var updates []installedUpdate
+	for _, entry := range strings.Split(log, "\n\n") {
+		var installedAt time.Time
+		var upgraded string
+		updateType := updateTypeUpdate
+		for _, line := range strings.Split(entry, "\n") {
+			key, value, ok := strings.Cut(line, ": ")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "Start-Date":
+				// The date and time are separated by two spaces
+				installedAt, _ = time.ParseInLocation("2006-01-02 15:04:05", strings.Join(strings.Fields(value), " "), loc)
+			case "Commandline":
+				if securityOnly && strings.Contains(value, "unattended-upgrade") {
+					updateType = updateTypeSecurity
+				}
+			case "Upgrade":
+				upgraded = value
+			}
+		}
+		if installedAt.IsZero() || upgraded == "" {
+			continue
+		}
+		for _, match := range aptPackagePattern.FindAllStringSubmatch(upgraded, -1) {
+			name, _, _ := strings.Cut(match[1], ":")
+			// The versions are the old and new ones, and the new one is kept
+			versions := strings.Split(match[2], ",")
+			updates = append(updates, installedUpdate{
+				installedAt: installedAt,
+				name:        name,
+				version:     strings.TrimSpace(versions[len(versions)-1]),
+				updateType:  updateType,
+			})
+		}
+	}
+	return updates
+}
+
+// aptPackagePattern matches a package in an apt history log line, as
+// "name:arch (old, new)", capturing the name and the versions.
+var aptPackagePattern = regexp.MustCompile(`([^\s,()]+) \(([^)]*)\)`)
+
+// rpmTransaction is an upgrade transaction in dnf's or yum's history.
+type rpmTransaction struct {
+	id        int
+	startedAt time.Time
+}
+
+// rpmUpgradeActions are the actions dnf and yum list for a transaction that
+// upgraded packages, in full or abbreviated when it did several things.
+var rpmUpgradeActions = map[string]bool{"Upgrade": true, "Update": true, "U": true}
+
+// parseRPMHistoryList returns the transactions that upgraded packages in
+// `dnf history list` (or yum's) output, newest first, whose lines are
+// "ID | Command line | Date and time | Action(s) | Altered", with the
+// date and time in loc.
+func parseRPMHistoryList(output string, loc *time.Location) []rpmTransaction {
+	var transactions []rpmTransaction
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 5 {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil {
+			continue
+		}
+		startedAt, err := time.ParseInLocation("2006-01-02 15:04", strings.TrimSpace(fields[2]), loc)
+		if err != nil {
+			continue
+		}
+		for _, action := range strings.Split(fields[3], ",") {
+			if rpmUpgradeActions[strings.TrimSpace(action)] {
+				transactions = append(transactions, rpmTransaction{id: id, startedAt: startedAt})
+				break
+			}
+		}
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].id > transactions[j].id
+	})
+	return transactions
+}
+
+// parseRPMHistoryUpgrades returns the packages upgraded in `dnf history
+// info` output, listed as "Upgrade name-[epoch:]version-release.arch", or
+// in yum's, where an "Updated" line with the old package is followed by an
+// "Update" line with only the new [epoch:]version-release.arch.
+func parseRPMHistoryUpgrades(output string, installedAt time.Time) []installedUpdate {
+	var updates []installedUpdate
+	var updatedName string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		nevra := fields[1]
+		switch fields[0] {
+		case "Updated":
+			if match := rpmNEVRAPattern.FindStringSubmatch(nevra); match != nil {
+				updatedName = match[1]
+			}
+			continue
+		case "Update":
+			if updatedName == "" {
+				continue
+			}
+			nevra = updatedName + "-" + nevra
+			updatedName = ""
+		case "Upgrade":
+		default:
+			continue
+		}
+		if match := rpmNEVRAPattern.FindStringSubmatch(nevra); match != nil {
+			updates = append(updates, installedUpdate{
+				installedAt: installedAt,
+				name:        match[1],
+				version:     match[2] + "-" + match[3],
+				updateType:  updateTypeUpdate,
+			})
+		}
+	}
+	return updates
+}
+
+// rpmNEVRAPattern matches a package as dnf lists it in an advisory,
+// name-[epoch:]version-release.arch, capturing the name, version, and
+// release.
+var rpmNEVRAPattern = regexp.MustCompile(`^(.+)-(?:\d+:)?([^-]+)-([^-]+)\.[^.]+$`)
+
+// parseSecurityAdvisoryPackages returns the packages, as
+// name-version-release, in `dnf updateinfo list --installed --security`
+// output, whose lines are an advisory ID, its severity, and the package.
+func parseSecurityAdvisoryPackages(output string) map[string]bool {
+	packages := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		if match := rpmNEVRAPattern.FindStringSubmatch(fields[2]); match != nil {
+			packages[match[1]+"-"+match[2]+"-"+match[3]] = true
+		}
+	}
+	return packages
+}
+
+// macOSSecurityUpdateNames are substrings of the names macOS gives security
+// updates, including the background XProtect and MRT data updates.
+var macOSSecurityUpdateNames = []string{"security", "xprotect", "mrt", "gatekeeper"}
+
+// macOSUpdateHistory reads the updates softwareupdate has installed,
+// including background security data updates.
+func (c *Client) macOSUpdateHistory() updateHistory {
+	history := updateHistory{source: "softwareupdate"}
+	output, err := c.RunCommand("softwareupdate --history --all")
+	if err != nil {
+		history.notes = append(history.notes, "softwareupdate history not available")
+		return history
+	}
+	history.known = true
+	var unparsed int
+	history.updates, unparsed = parseSoftwareUpdateHistory(output)
+	if unparsed > 0 {
+		history.notes = append(history.notes, fmt.Sprintf("%d updates with an unrecognized date format were left out", unparsed))
+	}
+	return history
+}
+
+// softwareUpdateDateLayouts are the layouts of the dates in softwareupdate
+// history, which follow the system's region format.
+var softwareUpdateDateLayouts = []string{"01/02/2006, 15:04:05", "2006-01-02, 15:04:05", "02.01.2006, 15:04:05"}
+
+// parseSoftwareUpdateHistory parses `softwareupdate --history` output, a
+// table of names, versions, and install dates separated by runs of spaces.
+// It also returns how many updates were left out because their date could
+// not be parsed.
+func parseSoftwareUpdateHistory(output string) ([]installedUpdate, int) {
+	var updates []installedUpdate
+	var unparsed int
+	for _, line := range strings.Split(output, "\n") {
+		columns := columnSeparator.Split(strings.TrimSpace(line), -1)
+		if len(columns) != 3 || columns[0] == "Display Name" || strings.HasPrefix(columns[0], "---") {
+			continue
+		}
+		update := installedUpdate{name: columns[0], version: columns[1], updateType: updateTypeUpdate}
+		for _, layout := range softwareUpdateDateLayouts {
+			if installedAt, err := time.ParseInLocation(layout, columns[2], time.Local); err == nil {
+				update.installedAt = installedAt
+				break
+			}
+		}
+		if update.installedAt.IsZero() {
+			unparsed++
+			continue
+		}
+		name := strings.ToLower(update.name)
+		for _, marker := range macOSSecurityUpdateNames {
+			if strings.Contains(name, marker) {
+				update.updateType = updateTypeSecurity
+				break
+			}
+		}
+		updates = append(updates, update)
+	}
+	return updates, unparsed
+}
+
+// columnSeparator separates the columns of a table printed with runs of
+// spaces.
+var columnSeparator = regexp.MustCompile(`\s{2,}`)
+
+// windowsHotFixCommand prints the installed hotfixes as JSON, with the
+// install date as yyyy-MM-dd rather than PowerShell's /Date(...)/ form.
+const windowsHotFixCommand = `powershell -NoProfile -Command "ConvertTo-Json @(Get-HotFix | ` +
+	`Select-Object HotFixID, Description, @{n='InstalledOn';e={if ($_.InstalledOn) {$_.InstalledOn.ToString('yyyy-MM-dd')}}})"`
+
+// windowsUpdateHistory reads the installed updates from Get-HotFix, which
+// lists the updates installed through Windows Update and component-based
+// servicing. Updates without an install date are left out.
+func (c *Client) windowsUpdateHistory() updateHistory {
+	history := updateHistory{source: "Get-HotFix"}
+	output, err := c.RunCommand(windowsHotFixCommand)
+	if err != nil {
+		history.notes = append(history.notes, "installed hotfixes not available from Get-HotFix")
+		return history
+	}
+	updates, ok := parseHotFixes(output)
+	if !ok {
+		history.notes = append(history.notes, "Get-HotFix output not recognized")
+		return history
+	}
+	history.known = true
+	history.updates = updates
+	return history
+}
+
+// parseHotFixes parses the JSON printed by windowsHotFixCommand. Hotfixes
+// described as security updates are marked as such.
+func parseHotFixes(output string) ([]installedUpdate, bool) {
+	var hotfixes []struct {
+		HotFixID    string `json:"HotFixID"`
+		Description string `json:"Description"`
+		InstalledOn string `json:"InstalledOn"`
+	}
+	if strings.TrimSpace(output) == "" {
+		return nil, true
+	}
+	if err := json.Unmarshal([]byte(output), &hotfixes); err != nil {
+		return nil, false
+	}
+	var updates []installedUpdate
+	for _, hotfix := range hotfixes {
+		installedAt, err := time.ParseInLocation(updateDateLayout, hotfix.InstalledOn, time.Local)
+		if err != nil {
+			continue
+		}
+		update := installedUpdate{installedAt: installedAt, name: hotfix.HotFixID, updateType: updateTypeUpdate}
+		if strings.EqualFold(hotfix.Description, "Security Update") {
+			update.updateType = updateTypeSecurity
+		}
+		updates = append(updates, update)
+	}
+	return updates, true
+}
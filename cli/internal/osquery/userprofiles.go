@@ -0,0 +1,191 @@
+package osquery
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// profileListKey holds one subkey per user profile, named by SID, with the
+// profile directory in ProfileImagePath.
+const profileListKey = `HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Windows NT\CurrentVersion\ProfileList`
+
+// lastLoggedOnUserKey records the SID of the last user to log on
+// interactively.
+const lastLoggedOnUserKey = `HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Windows\CurrentVersion\Authentication\LogonUI`
+
+// profileHiveMountPrefix starts the names under HKEY_USERS where profile
+// hives are temporarily loaded. Each load gets its own name, made unique
+// by the agent's process ID and the profile's SID, so that concurrent runs
+// cannot unload each other's hive.
+const profileHiveMountPrefix = "DrataAgentProfile"
+
+// profileScreenSaver is the screen saver settings of one user profile.
+type profileScreenSaver struct {
+	sid      string
+	settings map[string]string
+}
+
+// screenSaverValueNames are the screen saver values read from a user hive.
+var screenSaverValueNames = []string{"ScreenSaveTimeOut", "ScreenSaverIsSecure", "ScreenSaveActive", "DelayLockInterval"}
+
+// profileScreenSaverSettings reads screen saver settings from user profile
+// hives when no interactive session is logged on, as when the agent runs as
+// a service. It returns every profile with settings, in the order of
+// orderProfiles. Hives of users that are not logged on are loaded for the
+// duration of the read, after unloading any left loaded by an earlier run
+// that was stopped during its read.
+func (c *Client) profileScreenSaverSettings() []profileScreenSaver {
+	rows, err := c.RunQuery(`SELECT key, data FROM registry WHERE key LIKE '` + profileListKey + `\S-1-5-21-%' AND name = 'ProfileImagePath'`)
+	if err != nil {
+		return nil
+	}
+	profiles := make(map[string]string)
+	for _, row := range rows {
+		key, _ := row["key"].(string)
+		path, _ := row["data"].(string)
+		profiles[key[strings.LastIndex(key, `\`)+1:]] = path
+	}
+
+	lastSID := ""
+	if result, err := c.queryFirst(`SELECT data FROM registry WHERE key = '` + lastLoggedOnUserKey + `' AND name = 'LastLoggedOnUserSID'`); err == nil && result != nil {
+		lastSID, _ = result["data"].(string)
+	}
+
+	c.unloadStaleProfileHives()
+	var found []profileScreenSaver
+	for _, sid := range orderProfiles(profiles, lastSID) {
+		settings := c.hiveScreenSaverSettings(`HKEY_USERS\` + sid)
+		if len(settings) == 0 {
+			settings = c.unloadedHiveScreenSaverSettings(sid, profiles[sid])
+		}
+		if len(settings) > 0 {
+			found = append(found, profileScreenSaver{sid: sid, settings: settings})
+		}
+	}
+	return found
+}
+
+// unloadStaleProfileHives unloads profile hives left loaded under
+// HKEY_USERS by an agent that was stopped before it unloaded them, which
+// would otherwise keep the users' NTUSER.DAT locked. Hives of agents that
+// are still running are left alone.
+func (c *Client) unloadStaleProfileHives() {
+	rows, err := c.withoutErrorLog().RunQuery(`SELECT path FROM registry WHERE key = 'HKEY_USERS' AND path LIKE 'HKEY_USERS\` + profileHiveMountPrefix + `-%'`)
+	if err != nil {
+		return
+	}
+	for _, row := range rows {
+		path, _ := row["path"].(string)
+		name := path[strings.LastIndex(path, `\`)+1:]
+		if pid, ok := profileHiveMountPID(name); ok && !processRunning(pid) {
+			c.RunCommandStatus(`reg unload HKU\` + name + ` >NUL 2>&1`)
+		}
+	}
+}
+
+// processRunning reports whether a process with the ID pid exists. Tests
+// replace it.
+var processRunning = func(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	// On Windows, FindProcess opens the process and fails if there is none
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
+
+// hiveScreenSaverSettings reads the screen saver values from the user hive
+// at root. Group Policy values take precedence over the user's own.
+func (c *Client) hiveScreenSaverSettings(root string) map[string]string {
+	policyKey := root + `\SOFTWARE\Policies\Microsoft\Windows\Control Panel\Desktop`
+	userKey := root + `\Control Panel\Desktop`
+	rows, err := c.RunQuery(`SELECT key, name, data FROM registry WHERE key IN ('` + policyKey + `', '` + userKey + `') AND name IN ('` + strings.Join(screenSaverValueNames, "', '") + `')`)
+	if err != nil {
+		return nil
+	}
+	return mergeScreenSaverRows(rows, policyKey)
+}
+
+// unloadedHiveScreenSaverSettings loads the NTUSER.DAT hive in profileDir
+// under a name of its own, reads its screen saver values and unloads it
+// again. Loading fails, and nothing is read, if the hive is already loaded
+// or in use.
+func (c *Client) unloadedHiveScreenSaverSettings(sid, profileDir string) map[string]string {
+	if profileDir == "" {
+		return nil
+	}
+	name := profileHiveMountName(os.Getpid(), sid)
+	if _, exitCode, err := c.RunCommandStatus(`reg load HKU\` + name + ` "` + profileDir + `\NTUSER.DAT" >NUL 2>&1`); err != nil || exitCode != 0 {
+		return nil
+	}
+	defer c.RunCommandStatus(`reg unload HKU\` + name + ` >NUL 2>&1`)
+	return c.hiveScreenSaverSettings(`HKEY_USERS\` + name)
+}
+
+// profileHiveMountName returns the name under HKEY_USERS that the agent
+// process pid loads the hive of the profile sid at.
+func profileHiveMountName(pid int, sid string) string {
+	return fmt.Sprintf("%s-%d-%s", profileHiveMountPrefix, pid, sid)
+}
+
+// profileHiveMountPID returns the ID of the agent process that loaded a
+// hive at name, or false if name was not made by profileHiveMountName.
+func profileHiveMountPID(name string) (int, bool) {
+	rest, ok := strings.CutPrefix(name, profileHiveMountPrefix+"-")
+	if !ok {
+		return 0, false
+	}
+	pid, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(pid)
+	return id, err == nil
+}
+
+// mergeScreenSaverRows pivots registry rows into value names and data,
+// preferring rows under policyKey over the user's own settings.
+func mergeScreenSaverRows(rows []map[string]interface{}, policyKey string) map[string]string {
+	settings := make(map[string]string)
+	policy := make(map[string]bool)
+	for _, row := range rows {
+		key, _ := row["key"].(string)
+		name, _ := row["name"].(string)
+		data, ok := row["data"].(string)
+		if !ok || name == "" {
+			continue
+		}
+		isPolicy := strings.EqualFold(key, policyKey)
+		if policy[name] && !isPolicy {
+			continue
+		}
+		settings[name] = data
+		policy[name] = isPolicy
+	}
+	return settings
+}
+
+// orderProfiles returns the profile SIDs to read screen lock settings from:
+// the last user to log on interactively first, then the rest by SID.
+func orderProfiles(profiles map[string]string, lastSID string) []string {
+	sids := make([]string, 0, len(profiles))
+	for sid := range profiles {
+		if !strings.EqualFold(sid, lastSID) {
+			sids = append(sids, sid)
+		}
+	}
+	sort.Strings(sids)
+	for sid := range profiles {
+		if strings.EqualFold(sid, lastSID) {
+			sids = append([]string{sid}, sids...)
+		}
+	}
+	return sids
+}
@@ -0,0 +1,225 @@
+package osquery
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// vpnClient describes how to detect a VPN client.
+type vpnClient struct {
+	name string
+	// processes are the names of the client's daemon or tunnel processes.
+	processes []string
+	// paths are files present once the client is installed.
+	paths map[Platform][]string
+	// interfaces are name or Windows friendly-name prefixes of the tunnel
+	// interfaces the client creates.
+	interfaces []string
+}
+
+// vpnClients lists the VPN clients that are detected.
+var vpnClients = []vpnClient{
+	{
+		name:      "ciscoAnyConnect",
+		processes: []string{"vpnagentd", "vpnagent.exe"},
+		paths: map[Platform][]string{
+			PlatformMacOS:   {"/opt/cisco/anyconnect/bin/vpn", "/opt/cisco/secureclient/bin/vpn"},
+			PlatformLinux:   {"/opt/cisco/anyconnect/bin/vpn", "/opt/cisco/secureclient/bin/vpn"},
+			PlatformWindows: {`C:\Program Files (x86)\Cisco\Cisco AnyConnect Secure Mobility Client\vpnagent.exe`, `C:\Program Files (x86)\Cisco\Cisco Secure Client\vpnagent.exe`},
+		},
+		interfaces: []string{"cscotun", "utun", "Cisco AnyConnect", "Cisco Secure Client"},
+	},
+	{
+		name:      "globalProtect",
+		processes: []string{"PanGPS", "PanGPS.exe"},
+		paths: map[Platform][]string{
+			PlatformMacOS:   {"/Applications/GlobalProtect.app"},
+			PlatformLinux:   {"/opt/paloaltonetworks/globalprotect/PanGPS"},
+			PlatformWindows: {`C:\Program Files\Palo Alto Networks\GlobalProtect\PanGPS.exe`},
+		},
+		interfaces: []string{"gpd", "utun", "PANGP"},
+	},
+	{
+		name:      "openVPN",
+		processes: []string{"openvpn", "openvpn.exe", "OpenVPNConnect.exe"},
+		paths: map[Platform][]string{
+			PlatformMacOS:   {"/Applications/OpenVPN Connect/OpenVPN Connect.app", "/Applications/OpenVPN Connect.app"},
+			PlatformLinux:   {"/usr/sbin/openvpn"},
+			PlatformWindows: {`C:\Program Files\OpenVPN\bin\openvpn.exe`, `C:\Program Files\OpenVPN Connect\OpenVPNConnect.exe`},
+		},
+		interfaces: []string{"tun", "utun", "OpenVPN", "TAP-Windows"},
+	},
+	{
+		name:      "wireGuard",
+		processes: []string{"wireguard-go", "wireguard.exe"},
+		paths: map[Platform][]string{
+			PlatformMacOS:   {"/Applications/WireGuard.app"},
+			PlatformLinux:   {"/usr/bin/wg"},
+			PlatformWindows: {`C:\Program Files\WireGuard\wireguard.exe`},
+		},
+		interfaces: []string{"wg", "utun", "WireGuard"},
+	},
+	{
+		name:      "tailscale",
+		processes: []string{"tailscaled", "tailscaled.exe", "io.tailscale.ipn.macsys.network-extension"},
+		paths: map[Platform][]string{
+			PlatformMacOS:   {"/Applications/Tailscale.app"},
+			PlatformLinux:   {"/usr/sbin/tailscaled", "/usr/bin/tailscale"},
+			PlatformWindows: {`C:\Program Files\Tailscale\tailscale.exe`},
+		},
+		interfaces: []string{"tailscale", "utun", "Tailscale"},
+	},
+	{
+		name:      "zscaler",
+		processes: []string{"ZscalerTunnel", "ZSATunnel.exe", "zstunnel"},
+		paths: map[Platform][]string{
+			PlatformMacOS:   {"/Applications/Zscaler/Zscaler.app"},
+			PlatformLinux:   {"/opt/zscaler/bin/zstunnel"},
+			PlatformWindows: {`C:\Program Files\Zscaler\ZSATunnel\ZSATunnel.exe`, `C:\Program Files (x86)\Zscaler\ZSATunnel\ZSATunnel.exe`},
+		},
+		interfaces: []string{"zcctun", "utun", "Zscaler"},
+	},
+}
+
+// genericTunnelPrefixes match tunnel interfaces not tied to one client.
+var genericTunnelPrefixes = []string{"tun", "utun", "wg", "ppp", "ipsec", "tap"}
+
+// collectVPNStatus detects installed VPN clients and whether each is
+// running with an active tunnel interface.
+func (c *Client) collectVPNStatus(rawResults map[string]interface{}) {
+	running := c.runningProcesses(vpnProcessNames())
+	tunnels := c.activeTunnelInterfaces()
+
+	clients := make(map[string]interface{})
+	installed := false
+	connected := false
+	for _, client := range vpnClients {
+		status := vpnClientStatus(client, anyFileExists(client.paths[c.platform]), running, tunnels)
+		if status == nil {
+			continue
+		}
+		clients[client.name] = status
+		installed = true
+		if status["connected"] == true {
+			connected = true
+		}
+	}
+
+	tunnelNames := make([]string, 0, len(tunnels))
+	for _, tunnel := range tunnels {
+		tunnelNames = append(tunnelNames, tunnel.name)
+	}
+
+	rawResults["vpnStatus"] = map[string]interface{}{
+		"clients":          clients,
+		"installed":        installed,
+		"connected":        connected,
+		"tunnelInterfaces": tunnelNames,
+	}
+}
+
+// vpnClientStatus reports whether client is installed, running and
+// connected, or nil if there is no sign of it. A client counts as connected
+// when it is running and one of its tunnel interfaces has an address.
+func vpnClientStatus(client vpnClient, installed bool, running map[string]bool, tunnels []macCandidate) map[string]interface{} {
+	isRunning := false
+	for _, process := range client.processes {
+		if running[process] {
+			isRunning = true
+			break
+		}
+	}
+	if !installed && !isRunning {
+		return nil
+	}
+
+	connected := false
+	if isRunning {
+		for _, tunnel := range tunnels {
+			if matchInterfacePrefix(tunnel, client.interfaces) >= 0 {
+				connected = true
+				break
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"installed": installed || isRunning,
+		"running":   isRunning,
+		"connected": connected,
+	}
+}
+
+// runningProcesses returns which of names are running processes.
+func (c *Client) runningProcesses(names []string) map[string]bool {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "'" + strings.ReplaceAll(name, "'", "''") + "'"
+	}
+
+	running := make(map[string]bool)
+	if result, err := c.RunQuery("SELECT DISTINCT name FROM processes WHERE name IN (" + strings.Join(quoted, ", ") + ")"); err == nil {
+		for _, row := range result {
+			if name, ok := row["name"].(string); ok {
+				running[name] = true
+			}
+		}
+	}
+	return running
+}
+
+// activeTunnelInterfaces returns the tunnel interfaces with a routable
+// address, using the same interface enumeration as MAC selection.
+func (c *Client) activeTunnelInterfaces() []macCandidate {
+	query := "SELECT d.interface, a.address FROM interface_details d JOIN interface_addresses a ON a.interface = d.interface"
+	if c.platform == PlatformWindows {
+		query = "SELECT d.interface, d.friendly_name, a.address FROM interface_details d JOIN interface_addresses a ON a.interface = d.interface"
+	}
+	rows, err := c.RunQuery(query)
+	if err != nil {
+		return nil
+	}
+	return tunnelInterfaces(rows)
+}
+
+// tunnelInterfaces returns the interfaces in rows that look like VPN
+// tunnels and have a routable address. Link-local addresses are ignored
+// since macOS assigns them to system utun interfaces.
+func tunnelInterfaces(rows []map[string]interface{}) []macCandidate {
+	prefixes := append([]string{}, genericTunnelPrefixes...)
+	for _, client := range vpnClients {
+		prefixes = append(prefixes, client.interfaces...)
+	}
+
+	seen := make(map[string]bool)
+	var tunnels []macCandidate
+	for _, row := range rows {
+		candidate := macCandidate{}
+		candidate.name, _ = row["interface"].(string)
+		candidate.friendlyName, _ = row["friendly_name"].(string)
+		address, _ := row["address"].(string)
+
+		ip := net.ParseIP(strings.Split(address, "%")[0])
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if seen[candidate.name] || matchInterfacePrefix(candidate, prefixes) < 0 {
+			continue
+		}
+		seen[candidate.name] = true
+		tunnels = append(tunnels, candidate)
+	}
+
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].name < tunnels[j].name })
+	return tunnels
+}
+
+// vpnProcessNames returns the process names of every VPN client.
+func vpnProcessNames() []string {
+	var names []string
+	for _, client := range vpnClients {
+		names = append(names, client.processes...)
+	}
+	return names
+}
@@ -1,65 +1,55 @@
 package osquery
 
 import (
+	"regexp"
+	"strconv"
 	"strings"
 )
 
-// getWindowsSystemInfo collects Windows-specific system information.
-func (c *Client) getWindowsSystemInfo(version string) (*QueryResult, error) {
-	rawResults := make(map[string]interface{})
-
-	// OS Version
-	if result, err := c.queryFirst("SELECT name, version, platform FROM os_version"); err == nil && result != nil {
-		rawResults["osVersion"] = result
-	}
-
-	// Hardware Serial
-	if result, err := c.queryFirst("SELECT hardware_serial FROM system_info"); err == nil && result != nil {
-		rawResults["hwSerial"] = result
-	}
-
-	// Hardware Model
-	if result, err := c.queryFirst("SELECT hardware_model FROM system_info"); err == nil && result != nil {
-		rawResults["hwModel"] = result
-	}
-
-	// System Information
-	if result, err := c.queryFirst("SELECT board_serial, board_model, computer_name, hostname, local_hostname FROM system_info"); err == nil && result != nil {
-		rawResults["boardSerial"] = result["board_serial"]
-		rawResults["boardModel"] = result["board_model"]
-		rawResults["computerName"] = result["computer_name"]
-		rawResults["hostName"] = result["hostname"]
-		rawResults["localHostName"] = result["local_hostname"]
+// windowsChecks returns the Windows-specific checks in collection order.
+func windowsChecks() []check {
+	return []check{
+		{name: "firewall", collect: (*Client).collectWindowsFirewall},
+		{name: "appList", collect: (*Client).collectWindowsAppList},
+		{name: "browserExtensions", collect: (*Client).collectWindowsBrowserExtensions},
+		{name: "macAddress", collect: (*Client).collectMacAddress},
+		{name: "autoUpdate", collect: (*Client).collectWindowsAutoUpdate},
+		{name: "screenLock", collect: (*Client).collectWindowsScreenLock},
+		{name: "antivirus", collect: (*Client).collectWindowsAntivirus},
+		{name: "diskEncryption", collect: (*Client).collectWindowsDiskEncryption},
+		{name: "deviceManagement", collect: (*Client).collectWindowsDeviceManagement},
 	}
+}
 
-	// Firewall Status
+// collectWindowsFirewall collects firewall state from Security Center.
+func (c *Client) collectWindowsFirewall(rawResults map[string]interface{}) {
 	if result, err := c.queryFirst("SELECT firewall FROM windows_security_center"); err == nil && result != nil {
 		rawResults["firewallStatus"] = result
 	}
+}
 
-	// Application List
+// collectWindowsAppList collects installed programs.
+func (c *Client) collectWindowsAppList(rawResults map[string]interface{}) {
 	if result, err := c.RunQuery("SELECT name, version FROM programs"); err == nil {
 		rawResults["appList"] = result
 	}
+}
 
-	// Browser Extensions
-	extensions, _ := c.queryAll([]string{
-		"SELECT name FROM firefox_addons",
-		"SELECT name FROM chrome_extensions",
-		"SELECT name FROM ie_extensions",
-	})
-	rawResults["browserExtensions"] = extensions
-
-	// MAC Address
-	if result, err := c.queryFirst("SELECT mac FROM interface_details WHERE physical_adapter=1"); err == nil && result != nil {
-		rawResults["macAddress"] = result
-	}
+// collectWindowsBrowserExtensions collects Firefox, Chrome, and IE extensions.
+func (c *Client) collectWindowsBrowserExtensions(rawResults map[string]interface{}) {
+	rawResults["browserExtensions"] = c.browserExtensions([]extensionSource{firefoxExtensions, chromeExtensions, ieExtensions})
+}
 
-	// Auto Update
+// collectWindowsAutoUpdate collects automatic update health from Security Center.
+func (c *Client) collectWindowsAutoUpdate(rawResults map[string]interface{}) {
 	if result, err := c.queryFirst("SELECT IIF(autoupdate == 'Good', 1, 0) AS autoUpdateEnabled FROM windows_security_center"); err == nil && result != nil {
 		rawResults["autoUpdateEnabled"] = result["autoUpdateEnabled"] == "1"
 	}
+}
 
+// collectWindowsScreenLock collects power-scheme lock status and the
+// screensaver and inactivity policy settings.
+func (c *Client) collectWindowsScreenLock(rawResults map[string]interface{}) {
 	// Screen Lock Status
 	if output, err := c.RunCommand("powercfg /QH SCHEME_CURRENT SUB_VIDEO VIDEOCONLOCK 2> NUL && powercfg /QH SCHEME_CURRENT SUB_NONE CONSOLELOCK 2> NUL && powercfg /QH SCHEME_CURRENT SUB_SLEEP STANDBYIDLE 2> NUL"); err == nil {
 		rawResults["screenLockStatus"] = map[string]interface{}{
@@ -67,21 +57,6 @@ func (c *Client) getWindowsSystemInfo(version string) (*QueryResult, error) {
 		}
 	}
 
-	// Windows AV Status
-	if result, err := c.queryFirst("SELECT antivirus FROM windows_security_center LIMIT 1"); err == nil && result != nil {
-		rawResults["winAvStatus"] = result
-	}
-
-	// Windows Services List (filtered for AV services)
-	if result, err := c.RunQuery("SELECT name, description, status, start_type FROM services"); err == nil {
-		rawResults["winServicesList"] = result
-	}
-
-	// HDD Encryption Status (BitLocker)
-	if output, err := c.RunCommand("powershell -NoProfile -command (New-Object -ComObject Shell.Application).NameSpace((Get-ChildItem Env:SystemDrive).Value).Self.ExtendedProperty('System.Volume.BitLockerProtection')"); err == nil {
-		rawResults["hddEncryptionStatus"] = strings.TrimSpace(output)
-	}
-
 	// Screen Lock Settings
 	screenLockSettings := make(map[string]interface{})
 
@@ -100,33 +75,141 @@ func (c *Client) getWindowsSystemInfo(version string) (*QueryResult, error) {
 	SELECT COALESCE(pname, uname) AS name, COALESCE(pdata, udata) AS data FROM policy_setting
 	FULL JOIN user_setting ON pname = uname`
 
+	var settings map[string]string
 	if result, err := c.RunQuery(screenSaverQuery); err == nil {
-		settings := pivotResults(result)
-		if screenSaverIsSecure, ok := settings["ScreenSaverIsSecure"]; ok {
-			if screenSaveActive, ok := settings["ScreenSaveActive"]; ok {
-				screenLockSettings["screenLockEnabled"] = screenSaverIsSecure == "1" && screenSaveActive == "1"
+		settings = pivotResults(result)
+	}
+	// Without an interactive session, as when running as a service, read
+	// the profile hives instead, reporting the first profile's settings and
+	// each profile's under profiles
+	if len(settings) == 0 {
+		if found := c.profileScreenSaverSettings(); len(found) > 0 {
+			settings = found[0].settings
+			screenLockSettings["profileSid"] = found[0].sid
+			profiles := make([]interface{}, 0, len(found))
+			for _, profile := range found {
+				entry := map[string]interface{}{"sid": profile.sid}
+				addScreenSaverSettings(entry, profile.settings)
+				profiles = append(profiles, entry)
 			}
-		}
-		if screenSaveTimeOut, ok := settings["ScreenSaveTimeOut"]; ok {
-			screenLockSettings["screenSaverIdleWait"] = screenSaveTimeOut
-		}
-		if delayLockInterval, ok := settings["DelayLockInterval"]; ok {
-			screenLockSettings["lockDelay"] = delayLockInterval
+			screenLockSettings["profiles"] = profiles
 		}
 	}
+	addScreenSaverSettings(screenLockSettings, settings)
 
 	// Machine inactivity limit policy
 	if result, err := c.queryFirst("SELECT data FROM registry WHERE path = 'HKEY_LOCAL_MACHINE\\SOFTWARE\\Microsoft\\Windows\\CurrentVersion\\Policies\\System\\InactivityTimeoutSecs' COLLATE NOCASE"); err == nil && result != nil {
 		screenLockSettings["machineInactivityLimit"] = result["data"]
 	}
 
+	// Password on wake from sleep, from the power policy if set and the
+	// current power scheme otherwise
+	wake := windowsConsoleLock{}
+	if result, err := c.RunQuery(windowsConsoleLockPolicyQuery); err == nil {
+		wake = parseConsoleLockPolicy(pivotResults(result))
+	}
+	if wake.ac == nil || wake.dc == nil {
+		if output, err := c.RunCommand("powercfg /QH SCHEME_CURRENT SUB_NONE CONSOLELOCK"); err == nil {
+			scheme := parsePowercfgConsoleLock(output)
+			if wake.ac == nil {
+				wake.ac = scheme.ac
+			}
+			if wake.dc == nil {
+				wake.dc = scheme.dc
+			}
+		}
+	}
+	if wake.ac != nil {
+		screenLockSettings["consoleLockAC"] = *wake.ac
+	}
+	if wake.dc != nil {
+		screenLockSettings["consoleLockDC"] = *wake.dc
+	}
+	if wake.ac != nil && wake.dc != nil {
+		screenLockSettings["requirePasswordOnWake"] = *wake.ac && *wake.dc
+	}
+
 	rawResults["screenLockSettings"] = screenLockSettings
+}
+
+// addScreenSaverSettings adds the screen lock fields derived from screen
+// saver values, pivoted by name, to screenLockSettings.
+func addScreenSaverSettings(screenLockSettings map[string]interface{}, settings map[string]string) {
+	if screenSaverIsSecure, ok := settings["ScreenSaverIsSecure"]; ok {
+		if screenSaveActive, ok := settings["ScreenSaveActive"]; ok {
+			screenLockSettings["screenLockEnabled"] = screenSaverIsSecure == "1" && screenSaveActive == "1"
+		}
+	}
+	if screenSaveTimeOut, ok := settings["ScreenSaveTimeOut"]; ok {
+		screenLockSettings["screenSaverIdleWait"] = screenSaveTimeOut
+	}
+	if delayLockInterval, ok := settings["DelayLockInterval"]; ok {
+		screenLockSettings["lockDelay"] = delayLockInterval
+	}
+}
+
+// windowsConsoleLockPolicyQuery reads the "Require a password when a
+// computer wakes" group policy, for plugged in (AC) and on battery (DC).
+const windowsConsoleLockPolicyQuery = "SELECT name, data FROM registry WHERE key = 'HKEY_LOCAL_MACHINE\\SOFTWARE\\Policies\\Microsoft\\Power\\PowerSettings\\0e796bdb-100d-47d6-a2d5-f7d2daa51f51' COLLATE NOCASE"
+
+// windowsConsoleLock is whether a password is required on wake, plugged in
+// and on battery; either is nil when unknown.
+type windowsConsoleLock struct {
+	ac *bool
+	dc *bool
+}
+
+// parseConsoleLockPolicy reads the AC and DC setting indexes of the
+// console lock policy, pivoted by name.
+func parseConsoleLockPolicy(settings map[string]string) windowsConsoleLock {
+	var lock windowsConsoleLock
+	if value, ok := settings["ACSettingIndex"]; ok {
+		lock.ac = boolPtr(value == "1")
+	}
+	if value, ok := settings["DCSettingIndex"]; ok {
+		lock.dc = boolPtr(value == "1")
+	}
+	return lock
+}
+
+// powercfgIndexPattern matches a power setting index in powercfg output.
+var powercfgIndexPattern = regexp.MustCompile(`0x[0-9a-fA-F]{8}`)
+
+// parsePowercfgConsoleLock reads the current AC and DC indexes of
+// CONSOLELOCK from `powercfg /QH`, which prints them last. The labels are
+// localized, so the indexes are found by their hex form alone.
+func parsePowercfgConsoleLock(output string) windowsConsoleLock {
+	indexes := powercfgIndexPattern.FindAllString(output, -1)
+	if len(indexes) < 2 {
+		return windowsConsoleLock{}
+	}
+	ac, acErr := strconv.ParseUint(indexes[len(indexes)-2][2:], 16, 32)
+	dc, dcErr := strconv.ParseUint(indexes[len(indexes)-1][2:], 16, 32)
+	if acErr != nil || dcErr != nil {
+		return windowsConsoleLock{}
+	}
+	return windowsConsoleLock{ac: boolPtr(ac == 1), dc: boolPtr(dc == 1)}
+}
+
+// collectWindowsAntivirus collects antivirus status and the services list
+// matched against known AV services.
+func (c *Client) collectWindowsAntivirus(rawResults map[string]interface{}) {
+	// Windows AV Status
+	if result, err := c.queryFirst("SELECT antivirus FROM windows_security_center LIMIT 1"); err == nil && result != nil {
+		rawResults["winAvStatus"] = result
+	}
+
+	// Windows Services List (filtered for AV services)
+	if result, err := c.RunQuery("SELECT name, description, status, start_type FROM services"); err == nil {
+		rawResults["winServicesList"] = result
+	}
+}
 
-	return &QueryResult{
-		DrataAgentVersion: version,
-		Platform:          PlatformWindows,
-		RawQueryResults:   rawResults,
-	}, nil
+// collectWindowsDiskEncryption collects BitLocker protection of the system drive.
+func (c *Client) collectWindowsDiskEncryption(rawResults map[string]interface{}) {
+	if output, err := c.RunCommand("powershell -NoProfile -command (New-Object -ComObject Shell.Application).NameSpace((Get-ChildItem Env:SystemDrive).Value).Self.ExtendedProperty('System.Volume.BitLockerProtection')"); err == nil {
+		rawResults["hddEncryptionStatus"] = strings.TrimSpace(output)
+	}
 }
 
 // getWindowsDeviceIdentifiers returns Windows device identifiers.
@@ -142,10 +225,8 @@ func (c *Client) getWindowsDeviceIdentifiers() (*AgentDeviceIdentifiers, error)
 		}
 	}
 
-	if result, err := c.queryFirst("SELECT mac FROM interface_details WHERE physical_adapter=1"); err == nil && result != nil {
-		if v, ok := result["mac"].(string); ok {
-			identifiers.MacAddress.Mac = v
-		}
+	if mac, err := c.getMacAddress(); err == nil {
+		identifiers.MacAddress.Mac = mac
 	}
 
 	return identifiers, nil
@@ -0,0 +1,69 @@
+package osquery
+
+import (
+	"os"
+	"strings"
+)
+
+// wslNotApplicableReason explains why controls are not applicable under WSL.
+const wslNotApplicableReason = "running under Windows Subsystem for Linux; this control reflects the Linux VM, not the Windows host. Install the Windows agent on the host instead"
+
+// wslAffectedControls maps the Linux checks that describe the WSL VM rather
+// than the Windows host to the results they produce, which are meaningless
+// under WSL.
+var wslAffectedControls = map[string][]string{
+	"firewall":         {"firewallStatus"},
+	"antivirus":        {"antivirusStatus"},
+	"autoUpdate":       {"autoUpdateEnabled", "autoUpdateSettings"},
+	"screenLock":       {"screenLockStatus", "screenLockSettings"},
+	"locationServices": {"locationServices"},
+	"vpnStatus":        {"vpnStatus"},
+}
+
+// IsWSL reports whether the agent is running under Windows Subsystem for Linux.
+func (c *Client) IsWSL() bool {
+	if c.platform != PlatformLinux {
+		return false
+	}
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	data, err := os.ReadFile("/proc/version")
+	if err != nil {
+		return false
+	}
+	return isWSLKernelVersion(string(data))
+}
+
+// isWSLKernelVersion reports whether a /proc/version string belongs to a
+// WSL kernel, which Microsoft builds identify with "microsoft".
+func isWSLKernelVersion(version string) bool {
+	return strings.Contains(strings.ToLower(version), "microsoft")
+}
+
+// SetMarkWSLNotApplicable sets whether controls that are meaningless under
+// WSL are reported as not applicable instead of collected.
+func (c *Client) SetMarkWSLNotApplicable(mark bool) {
+	c.markWSLNotApplicable = mark
+}
+
+// applyWSLNotApplicable replaces the results of enabled WSL-affected checks
+// with not-applicable markers and records the WSL environment.
+func (c *Client) applyWSLNotApplicable(rawResults map[string]interface{}) {
+	for _, chk := range linuxChecks() {
+		controls, ok := wslAffectedControls[chk.name]
+		if !ok || !c.checkEnabled(chk) {
+			continue
+		}
+		for _, control := range controls {
+			rawResults[control] = map[string]interface{}{
+				"notApplicable": true,
+				"reason":        wslNotApplicableReason,
+			}
+		}
+	}
+	rawResults["wsl"] = map[string]interface{}{
+		"detected": true,
+		"distro":   os.Getenv("WSL_DISTRO_NAME"),
+	}
+}
@@ -4,29 +4,47 @@ package scheduler
 import (
 	"context"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"log/slog"
+	"runtime/debug"
 	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
-// Scheduler manages periodic tasks.
+// Scheduler manages periodic tasks. Runs of jobs with the same ID, whether
+// scheduled, one-off or immediate, never overlap.
 type Scheduler struct {
 	cron    *cron.Cron
 	jobs    map[string]cron.EntryID
+	once    map[string]cron.EntryID
+	locks   map[string]*sync.Mutex
 	mu      sync.RWMutex
 	running bool
+	logger  *slog.Logger
 }
 
-// NewScheduler creates a new scheduler.
+// NewScheduler creates a new scheduler that logs to slog.Default.
 func NewScheduler() *Scheduler {
 	return &Scheduler{
-		cron: cron.New(cron.WithSeconds()),
-		jobs: make(map[string]cron.EntryID),
+		cron:   cron.New(cron.WithSeconds()),
+		jobs:   make(map[string]cron.EntryID),
+		once:   make(map[string]cron.EntryID),
+		locks:  make(map[string]*sync.Mutex),
+		logger: slog.Default(),
 	}
 }
 
+// SetLogger sets the logger for the scheduler's messages. Routine messages,
+// such as a job being scheduled, are logged at debug level; job panics are
+// logged at error level.
+func (s *Scheduler) SetLogger(logger *slog.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = logger
+}
+
 // ScheduleJob schedules a job to run at a specified interval.
 func (s *Scheduler) ScheduleJob(id string, intervalHours int, action func()) error {
 	s.mu.Lock()
@@ -37,21 +55,40 @@ func (s *Scheduler) ScheduleJob(id string, intervalHours int, action func()) err
 		s.cron.Remove(entryID)
 	}
 
-	// Create cron expression for hourly interval
-	// Run every N hours at the start of the hour
-	cronExpr := fmt.Sprintf("0 0 */%d * * *", intervalHours)
-
-	entryID, err := s.cron.AddFunc(cronExpr, action)
+	entryID, err := s.cron.AddFunc(hourlyCronExpr(intervalHours), s.serialize(id, recoverWith(s.logger, id, action, nil)))
 	if err != nil {
 		return fmt.Errorf("failed to schedule job %s: %w", id, err)
 	}
 
 	s.jobs[id] = entryID
-	log.Printf("Scheduled job '%s' to run every %d hours", id, intervalHours)
+	s.logger.Debug("Scheduled job", "job", id, "intervalHours", intervalHours)
 
 	return nil
 }
 
+// hourlyCronExpr returns the cron expression for running every N hours at
+// the start of the hour.
+func hourlyCronExpr(intervalHours int) string {
+	return fmt.Sprintf("0 0 */%d * * *", intervalHours)
+}
+
+// NextRunForInterval returns when a job scheduled with ScheduleJob every
+// intervalHours would next run after from, or an error if the interval
+// cannot be scheduled.
+func NextRunForInterval(intervalHours int, from time.Time) (time.Time, error) {
+	if intervalHours < 1 {
+		return time.Time{}, fmt.Errorf("interval must be at least 1 hour, got %d", intervalHours)
+	}
+
+	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+	schedule, err := parser.Parse(hourlyCronExpr(intervalHours))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid interval %d: %w", intervalHours, err)
+	}
+
+	return schedule.Next(from), nil
+}
+
 // ScheduleJobWithMinutes schedules a job to run at a specified interval in minutes.
 func (s *Scheduler) ScheduleJobWithMinutes(id string, intervalMinutes int, action func()) error {
 	s.mu.Lock()
@@ -65,17 +102,82 @@ func (s *Scheduler) ScheduleJobWithMinutes(id string, intervalMinutes int, actio
 	// Create cron expression for minute interval
 	cronExpr := fmt.Sprintf("0 */%d * * * *", intervalMinutes)
 
-	entryID, err := s.cron.AddFunc(cronExpr, action)
+	entryID, err := s.cron.AddFunc(cronExpr, s.serialize(id, recoverWith(s.logger, id, action, nil)))
 	if err != nil {
 		return fmt.Errorf("failed to schedule job %s: %w", id, err)
 	}
 
 	s.jobs[id] = entryID
-	log.Printf("Scheduled job '%s' to run every %d minutes", id, intervalMinutes)
+	s.logger.Debug("Scheduled job", "job", id, "intervalMinutes", intervalMinutes)
 
 	return nil
 }
 
+// SpreadDelay returns a delay between minSeconds and maxSeconds, inclusive,
+// picked from deviceID so that the same device always gets the same delay
+// and a fleet of devices spreads evenly across the range. If maxSeconds is
+// not above minSeconds, it returns minSeconds.
+func SpreadDelay(deviceID string, minSeconds, maxSeconds int) time.Duration {
+	if maxSeconds <= minSeconds {
+		return time.Duration(minSeconds) * time.Second
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(deviceID))
+	offset := h.Sum64() % uint64(maxSeconds-minSeconds+1)
+	return time.Duration(minSeconds+int(offset)) * time.Second
+}
+
+// RunOnceAt runs action once at the given time as job id, unless a one-off
+// run of id is already pending, in which case it does nothing. Like
+// scheduled runs, it is dropped if the scheduler is stopped first, and a
+// run in progress holds up the context Stop returns. It reports whether the
+// run was scheduled.
+func (s *Scheduler) RunOnceAt(id string, at time.Time, action func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entryID, exists := s.once[id]; exists {
+		if !s.cron.Entry(entryID).Next.IsZero() {
+			return false
+		}
+		s.cron.Remove(entryID)
+	}
+
+	s.once[id] = s.cron.Schedule(onceSchedule{at: at}, cron.FuncJob(s.serialize(id, recoverWith(s.logger, id, action, nil))))
+	s.logger.Debug("Scheduled one-off run", "job", id, "at", at)
+	return true
+}
+
+// onceSchedule is a cron schedule that fires once, at at.
+type onceSchedule struct {
+	at time.Time
+}
+
+// Next returns at until it has passed, then the zero time, which cron
+// treats as never.
+func (o onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(o.at) {
+		return o.at
+	}
+	return time.Time{}
+}
+
+// serialize wraps action so that it never runs at the same time as another
+// run of job id. s.mu must be held.
+func (s *Scheduler) serialize(id string, action func()) func() {
+	lock, exists := s.locks[id]
+	if !exists {
+		lock = &sync.Mutex{}
+		s.locks[id] = lock
+	}
+	return func() {
+		lock.Lock()
+		defer lock.Unlock()
+		action()
+	}
+}
+
 // RemoveJob removes a scheduled job.
 func (s *Scheduler) RemoveJob(id string) {
 	s.mu.Lock()
@@ -84,7 +186,7 @@ func (s *Scheduler) RemoveJob(id string) {
 	if entryID, exists := s.jobs[id]; exists {
 		s.cron.Remove(entryID)
 		delete(s.jobs, id)
-		log.Printf("Removed job '%s'", id)
+		s.logger.Debug("Removed job", "job", id)
 	}
 }
 
@@ -96,7 +198,7 @@ func (s *Scheduler) Start() {
 	if !s.running {
 		s.cron.Start()
 		s.running = true
-		log.Println("Scheduler started")
+		s.logger.Debug("Scheduler started")
 	}
 }
 
@@ -108,17 +210,46 @@ func (s *Scheduler) Stop() context.Context {
 	if s.running {
 		ctx := s.cron.Stop()
 		s.running = false
-		log.Println("Scheduler stopped")
+		s.logger.Debug("Scheduler stopped")
 		return ctx
 	}
 
 	return context.Background()
 }
 
-// RunJobNow runs a job immediately in addition to its scheduled runs.
+// RunJobNow runs a job immediately in addition to its scheduled runs,
+// waiting for any run of the job in progress to finish first.
 func (s *Scheduler) RunJobNow(id string, action func()) {
-	log.Printf("Running job '%s' immediately", id)
-	action()
+	s.mu.Lock()
+	logger := s.logger
+	run := s.serialize(id, recoverWith(logger, id, action, nil))
+	s.mu.Unlock()
+
+	logger.Debug("Running job immediately", "job", id)
+	run()
+}
+
+// Recover wraps action so that a panic is logged to slog.Default with its
+// stack trace and passed to onPanic, if non-nil, instead of crashing the
+// process. Scheduled jobs are always wrapped; callers wrap actions they also
+// run themselves.
+func Recover(id string, action func(), onPanic func(recovered interface{})) func() {
+	return recoverWith(slog.Default(), id, action, onPanic)
+}
+
+// recoverWith is Recover logging to logger.
+func recoverWith(logger *slog.Logger, id string, action func(), onPanic func(recovered interface{})) func() {
+	return func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("Job panicked", "job", id, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+				if onPanic != nil {
+					onPanic(r)
+				}
+			}
+		}()
+		action()
+	}
 }
 
 // GetNextRun returns the next scheduled run time for a job.
@@ -1,6 +1,10 @@
 package scheduler
 
 import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -110,6 +114,111 @@ func TestRunJobNow(t *testing.T) {
 	}
 }
 
+func TestSpreadDelay(t *testing.T) {
+	if got := SpreadDelay("device", 30, 30); got != 30*time.Second {
+		t.Errorf("expected the minimum for an empty range, got %s", got)
+	}
+	if got := SpreadDelay("device", 30, 10); got != 30*time.Second {
+		t.Errorf("expected the minimum for an inverted range, got %s", got)
+	}
+
+	if SpreadDelay("device-1", 10, 60) != SpreadDelay("device-1", 10, 60) {
+		t.Error("expected the same delay for the same device")
+	}
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 200; i++ {
+		delay := SpreadDelay(fmt.Sprintf("device-%d", i), 10, 60)
+		if delay < 10*time.Second || delay > 60*time.Second {
+			t.Fatalf("delay %s outside 10s-60s", delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 25 {
+		t.Errorf("expected devices to spread across the range, got %d distinct delays", len(seen))
+	}
+}
+
+func TestRunOnceAt(t *testing.T) {
+	s := NewScheduler()
+	s.Start()
+
+	ran := make(chan struct{}, 2)
+	action := func() { ran <- struct{}{} }
+
+	if !s.RunOnceAt("sync", time.Now().Add(50*time.Millisecond), action) {
+		t.Fatal("expected the run to be scheduled")
+	}
+	if s.RunOnceAt("sync", time.Now().Add(50*time.Millisecond), action) {
+		t.Error("expected a second run to share the pending one")
+	}
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatal("one-off run did not run")
+	}
+
+	// Once it has run, another can be scheduled; a run in progress of the
+	// same job holds it up
+	release := make(chan struct{})
+	go s.RunJobNow("sync", func() { <-release })
+	time.Sleep(10 * time.Millisecond)
+	if !s.RunOnceAt("sync", time.Now().Add(10*time.Millisecond), action) {
+		t.Fatal("expected the run to be scheduled after the last one ran")
+	}
+	select {
+	case <-ran:
+		t.Fatal("one-off run overlapped a run in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatal("one-off run did not run after the run in progress")
+	}
+
+	// Stopping drops a pending run
+	s.RunOnceAt("sync", time.Now().Add(50*time.Millisecond), action)
+	<-s.Stop().Done()
+	select {
+	case <-ran:
+		t.Error("pending one-off run ran after Stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var recovered interface{}
+	var runs int32
+	action := Recover("panicky-job", func() {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			var m map[string]int
+			m["boom"]++
+		}
+	}, func(r interface{}) { recovered = r })
+
+	// The first run panics; the process must survive and report it
+	action()
+	if recovered == nil {
+		t.Fatal("expected panic to be passed to onPanic")
+	}
+
+	// Later runs still execute normally
+	recovered = nil
+	action()
+	if atomic.LoadInt32(&runs) != 2 {
+		t.Errorf("expected 2 runs, got %d", runs)
+	}
+	if recovered != nil {
+		t.Errorf("unexpected panic: %v", recovered)
+	}
+
+	// Jobs run by the scheduler are protected without a handler
+	s := NewScheduler()
+	s.RunJobNow("panicky-job", func() { panic("boom") })
+}
+
 func TestScheduleJobWithMinutes(t *testing.T) {
 	s := NewScheduler()
 
@@ -143,3 +252,42 @@ func TestReplaceExistingJob(t *testing.T) {
 		t.Error("still expected 1 job after replacement")
 	}
 }
+
+func TestNextRunForInterval(t *testing.T) {
+	from := time.Date(2024, 1, 1, 3, 30, 0, 0, time.Local)
+
+	next, err := NextRunForInterval(2, from)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := time.Date(2024, 1, 1, 4, 0, 0, 0, time.Local)
+	if !next.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, next)
+	}
+
+	if _, err := NextRunForInterval(0, from); err == nil {
+		t.Error("expected error for zero interval")
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewScheduler()
+	s.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	// Routine messages are debug level and dropped at info level
+	if err := s.ScheduleJob("quiet-job", 1, func() {}); err != nil {
+		t.Fatalf("failed to schedule job: %v", err)
+	}
+	s.Start()
+	s.Stop()
+	if buf.Len() != 0 {
+		t.Errorf("expected no info-level output, got %q", buf.String())
+	}
+
+	// Panics are errors and use the injected logger's format
+	s.RunJobNow("panicky-job", func() { panic("boom") })
+	if !strings.Contains(buf.String(), `"level":"ERROR"`) || !strings.Contains(buf.String(), `"job":"panicky-job"`) {
+		t.Errorf("expected a JSON error record for the panic, got %q", buf.String())
+	}
+}
@@ -0,0 +1,47 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup("", "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	shutdown()
+}
+
+func TestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	ctx, parent := StartSpan(context.Background(), "sync")
+	_, child := StartSpan(ctx, "osquery.query")
+	EndSpan(child, errors.New("query failed"))
+	EndSpan(parent, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Parent().SpanID() != spans[1].SpanContext().SpanID() {
+		t.Error("query span should be a child of the sync span")
+	}
+	if spans[0].Status().Code != codes.Error {
+		t.Error("failed span should have error status")
+	}
+	if spans[1].Status().Code == codes.Error {
+		t.Error("successful span should not have error status")
+	}
+}